@@ -0,0 +1,42 @@
+package promptweaver
+
+import (
+	"slices"
+	"strings"
+)
+
+// ancestry is the stack of unregistered tags open outside sections, as written, for
+// EventBase.Ancestry. It is kept best-effort and never affects what is emitted.
+type ancestry struct {
+	names []string
+}
+
+// pushAncestor records an unregistered opening tag outside sections.
+func (p *parser) pushAncestor(tok Token) {
+	if p.ancestry != nil {
+		p.ancestry.names = append(p.ancestry.names, tok.Name)
+	}
+}
+
+// popAncestor pops the innermost open tag named like the closing tag tok, with any opened
+// inside it. A closing tag that matches none leaves the stack alone.
+func (p *parser) popAncestor(tok Token) {
+	if p.ancestry == nil {
+		return
+	}
+	names := p.ancestry.names
+	for i := len(names) - 1; i >= 0; i-- {
+		if strings.EqualFold(names[i], tok.Name) {
+			p.ancestry.names = names[:i]
+			return
+		}
+	}
+}
+
+// ancestors returns a copy of the open tags, outermost first, or nil if there are none.
+func (p *parser) ancestors() []string {
+	if p.ancestry == nil || len(p.ancestry.names) == 0 {
+		return nil
+	}
+	return slices.Clone(p.ancestry.names)
+}
@@ -0,0 +1,50 @@
+package promptweaver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Attach_Ancestry_Of_Unknown_Wrappers(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	input := "<Response><ToolCalls><think>a</think></ToolCalls><think>b</think>" +
+		"<wrap><inner></WRAP><think>c</think></stray><think>d</think></Response><think>e</think>"
+
+	run := func(opts ...Option) ([]SectionEvent, int) {
+		var errs int
+		opts = append(opts, WithErrorHandler(func(error) bool { errs++; return true }))
+		rec := &recorderSink{}
+		for _, chunk := range []int{1, len(input)} {
+			rec.events = nil
+			if err := NewEngineWithOptions(reg, opts...).ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+				t.Fatalf("ProcessStream error: %v", err)
+			}
+		}
+		var out []SectionEvent
+		for _, ev := range rec.events {
+			out = append(out, ev.(SectionEvent))
+		}
+		return out, errs
+	}
+
+	got, errs := run(WithAncestry(true))
+	want := map[string]string{"a": "Response/ToolCalls", "b": "Response", "c": "Response", "d": "Response", "e": ""}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d sections, got %d", len(want), len(got))
+	}
+	for _, ev := range got {
+		if path := strings.Join(ev.Ancestry, "/"); path != want[ev.Content] {
+			t.Errorf("section %q: expected ancestry %q, got %q", ev.Content, want[ev.Content], path)
+		}
+	}
+
+	plain, plainErrs := run()
+	for i := range got {
+		got[i].Ancestry = nil
+	}
+	if !reflect.DeepEqual(got, plain) || errs != plainErrs {
+		t.Fatalf("expected tracking to leave events and errors alone, got %d errors, want %d", errs, plainErrs)
+	}
+}
@@ -0,0 +1,136 @@
+package promptweaver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UnknownAttrPolicy decides what happens to attributes of a section's opening tag that its
+// plugin does not list in SectionPlugin.KnownAttrs.
+type UnknownAttrPolicy int
+
+const (
+	// AllowUnknownAttrs passes every attribute through. This is the default.
+	AllowUnknownAttrs UnknownAttrPolicy = iota
+
+	// WarnUnknownAttrs keeps the attributes and reports them to EngineOptions.AttrWarnings.
+	WarnUnknownAttrs
+
+	// StripUnknownAttrs removes the attributes before anything else sees them.
+	StripUnknownAttrs
+
+	// ErrorUnknownAttrs reports an AttributeValidationError at the opening tag, subject to
+	// the RecoveryMode and ErrorHandler. Once recovered from, the section is skipped the way
+	// a failed OnOpen skips it.
+	ErrorUnknownAttrs
+)
+
+// AttributeValidationError reports attributes written in a section's opening tag that its
+// plugin does not know (see SectionPlugin.UnknownAttrs). Pos is the start of the tag.
+type AttributeValidationError struct {
+	ParseError
+	SectionName string
+	Attrs       []string   // the unknown attribute names, sorted
+	AttrPos     []Position // start of each attribute's key, in the order of Attrs
+}
+
+// ErrorDetails returns the error as structured data. Attribute and AttrPos are those of the
+// first unknown name.
+func (e *AttributeValidationError) ErrorDetails() ErrorInfo {
+	info := e.info("attribute_validation")
+	info.Section, info.Attrs = e.SectionName, e.Attrs
+	if len(e.Attrs) > 0 {
+		info.Attribute = e.Attrs[0]
+	}
+	if len(e.AttrPos) > 0 {
+		info.AttrPos = &e.AttrPos[0]
+	}
+	return info
+}
+
+// unknownAttrs returns the attributes of tok, which opens a section of plugin, that the
+// plugin does not know, sorted, and removes them from tok.Attrs under StripUnknownAttrs.
+// The plugin's RawUntil attribute and the keys of its AttrDefaults count as known.
+func unknownAttrs(plugin SectionPlugin, tok Token) []string {
+	if plugin.UnknownAttrs == AllowUnknownAttrs || len(tok.Attrs) == 0 {
+		return nil
+	}
+	var unknown []string
+	for k := range tok.Attrs {
+		if !knownAttr(plugin, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	if plugin.UnknownAttrs == StripUnknownAttrs {
+		for _, k := range unknown {
+			delete(tok.Attrs, k)
+		}
+		return nil
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// knownAttr reports whether plugin knows the attribute k, ignoring case.
+func knownAttr(plugin SectionPlugin, k string) bool {
+	if plugin.RawUntil != "" && strings.EqualFold(k, plugin.RawUntil) {
+		return true
+	}
+	for _, name := range plugin.KnownAttrs {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	for name := range plugin.AttrDefaults {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// attrPositions returns where each of keys starts in tok by scanning the tag again, so that
+// only tags with attributes to report pay for tracking them.
+func (p *parser) attrPositions(tok Token, keys []string) []Position {
+	at := map[string]int{} // kept here, as the scanner forgets it once the tag is done
+	s := tagScanner{keepCase: p.tz.tag.keepCase, keyPos: at}
+	s.scan([]byte(tok.Text), tok.Start, "")
+	out := make([]Position, len(keys))
+	for i, k := range keys {
+		out[i] = advance(tok.Start, []byte(tok.Text[:at[k]]))
+	}
+	return out
+}
+
+// checkAttrs reports unknown, the attributes of el's opening tag tok that plugin does not
+// know: to the AttrWarnings handler under WarnUnknownAttrs, and through the error handling
+// under ErrorUnknownAttrs, in which case el is aborted. Suppressed and rescued sections are
+// not checked.
+func (p *parser) checkAttrs(plugin SectionPlugin, el *element, tok Token, unknown []string) (bool, error) {
+	if len(unknown) == 0 || el.suppress || p.rescued != nil {
+		return false, nil
+	}
+	quoted := make([]string, len(unknown))
+	for i, k := range unknown {
+		quoted[i] = fmt.Sprintf("%q", k)
+	}
+	err := &AttributeValidationError{
+		ParseError: newParseError(el.start,
+			fmt.Sprintf("unknown attributes %s in <%s>", strings.Join(quoted, ", "), el.name),
+			p.tz.lastContent),
+		SectionName: el.canon,
+		Attrs:       unknown,
+		AttrPos:     p.attrPositions(tok, unknown),
+	}
+	if plugin.UnknownAttrs == WarnUnknownAttrs {
+		if p.onAttrWarning != nil {
+			p.onAttrWarning(err)
+		}
+		return false, nil
+	}
+	if rerr := p.recover(err); rerr != nil {
+		return true, rerr
+	}
+	return true, p.closed(el, p.pos, false, err)
+}
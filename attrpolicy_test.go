@@ -0,0 +1,164 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hallucinatedAttrs = `<create-file path="a.go" priority="high" emoji="🚀">package a</create-file>` +
+	`<create-file path="b.go" mode="0600" Emoji="x"/>` +
+	`<note>ok</note>`
+
+func attrPolicyRegistry(policy UnknownAttrPolicy) *Registry {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{
+		Name:         "create-file",
+		KnownAttrs:   []string{"Path"},
+		AttrDefaults: map[string]string{"mode": "0644"},
+		UnknownAttrs: policy,
+	})
+	reg.Register(SectionPlugin{Name: "note"})
+	return reg
+}
+
+func sectionAttrsOf(events []Event) []map[string]string {
+	var out []map[string]string
+	for _, ev := range events {
+		if sev, ok := AsSection(ev); ok {
+			out = append(out, sev.Attrs)
+		}
+	}
+	return out
+}
+
+func Test_UnknownAttrs_Allow_Should_Pass_Everything_Through(t *testing.T) {
+	rec := &recorderSink{}
+	if err := NewEngine(attrPolicyRegistry(AllowUnknownAttrs)).ProcessStream(strings.NewReader(hallucinatedAttrs), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	attrs := sectionAttrsOf(rec.events)
+	if len(attrs) != 3 || attrs[0]["priority"] != "high" || attrs[1]["emoji"] != "x" {
+		t.Fatalf("attrs = %v", attrs)
+	}
+}
+
+func Test_UnknownAttrs_Warn_Should_Report_And_Keep_Them(t *testing.T) {
+	var warnings []*AttributeValidationError
+	rec := &recorderSink{}
+	en := NewEngineWithOptions(attrPolicyRegistry(WarnUnknownAttrs),
+		WithAttrWarnings(func(err *AttributeValidationError) { warnings = append(warnings, err) }))
+	if err := en.ProcessStream(strings.NewReader(hallucinatedAttrs), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	attrs := sectionAttrsOf(rec.events)
+	if len(attrs) != 3 || attrs[0]["priority"] != "high" || attrs[0]["emoji"] != "🚀" {
+		t.Fatalf("attrs = %v", attrs)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("warnings = %v", warnings)
+	}
+	if got := warnings[0].Attrs; !reflect.DeepEqual(got, []string{"emoji", "priority"}) {
+		t.Fatalf("first warning names %v", got)
+	}
+	if warnings[0].SectionName != "create-file" || warnings[0].Pos.Offset != 0 {
+		t.Fatalf("first warning = %+v", warnings[0])
+	}
+	// mode is known through AttrDefaults.
+	if got := warnings[1].Attrs; !reflect.DeepEqual(got, []string{"emoji"}) {
+		t.Fatalf("self-closing warning names %v", got)
+	}
+}
+
+func Test_UnknownAttrs_Strip_Should_Remove_Them_Before_Emission(t *testing.T) {
+	var opened []map[string]string
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{
+		Name:         "create-file",
+		KnownAttrs:   []string{"path"},
+		UnknownAttrs: StripUnknownAttrs,
+		OnOpen: func(_ string, attrs map[string]string, _ Position) error {
+			opened = append(opened, attrs)
+			return nil
+		},
+	})
+	reg.Register(SectionPlugin{Name: "note"})
+
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(hallucinatedAttrs), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	want := []map[string]string{{"path": "a.go"}, {"path": "b.go"}}
+	if got := sectionAttrsOf(rec.events); len(got) != 3 || !reflect.DeepEqual(got[:2], want) {
+		t.Fatalf("attrs = %v, want %v then note's", got, want)
+	}
+	if !reflect.DeepEqual(opened, want) {
+		t.Fatalf("OnOpen saw %v", opened)
+	}
+}
+
+func Test_UnknownAttrs_Error_Should_Follow_The_Recovery_Mode(t *testing.T) {
+	rec := &recorderSink{}
+	err := NewEngine(attrPolicyRegistry(ErrorUnknownAttrs)).ProcessStream(strings.NewReader(hallucinatedAttrs), rec)
+	var ave *AttributeValidationError
+	if !errors.As(err, &ave) {
+		t.Fatalf("strict mode should stop with an AttributeValidationError, got %v", err)
+	}
+	if ave.SectionName != "create-file" || !reflect.DeepEqual(ave.Attrs, []string{"emoji", "priority"}) {
+		t.Fatalf("error = %+v", ave)
+	}
+	if info := ave.ErrorDetails(); info.Kind != "attribute_validation" || info.Attribute != "emoji" || info.Section != "create-file" {
+		t.Fatalf("ErrorDetails = %+v", info)
+	}
+	for i, name := range ave.Attrs {
+		want := strings.Index(hallucinatedAttrs, name+"=")
+		if pos := ave.AttrPos[i]; pos.Offset != int64(want) || pos.Column != want+1 {
+			t.Fatalf("%s at %s, want offset %d", name, pos, want)
+		}
+	}
+	b, err := json.Marshal(ave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); !strings.Contains(s, `"kind":"attribute_validation"`) || !strings.Contains(s, `"section":"create-file"`) ||
+		!strings.Contains(s, `"attrs":["emoji","priority"]`) || !strings.Contains(s, `"attr_position":{"line":1,"column":42,"offset":41}`) {
+		t.Fatalf("JSON = %s", b)
+	}
+	if len(rec.events) != 0 {
+		t.Fatalf("no section should be emitted before the error, got %v", rec.events)
+	}
+
+	var recovered []error
+	rec = &recorderSink{}
+	en := NewEngineWithOptions(attrPolicyRegistry(ErrorUnknownAttrs), WithErrorHandler(func(err error) bool {
+		recovered = append(recovered, err)
+		return true
+	}))
+	if err := en.ProcessStream(strings.NewReader(hallucinatedAttrs+`<create-file path="c.go">package c</create-file>`), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(recovered) != 2 {
+		t.Fatalf("recovered %v, want one error per offending tag, self-closing included", recovered)
+	}
+	var names []string
+	for _, ev := range rec.events {
+		if sev, ok := AsSection(ev); ok {
+			names = append(names, sev.Name+":"+sev.Content)
+		}
+	}
+	// The rejected bodies are skipped, not leaked as text or other sections.
+	if got, want := strings.Join(names, ","), "note:ok,create-file:package c"; got != want {
+		t.Fatalf("sections = %q, want %q", got, want)
+	}
+}
+
+func Test_UnknownAttrs_Should_Not_Check_Suppressed_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file", UnknownAttrs: ErrorUnknownAttrs, Suppress: true})
+	err := NewEngine(reg).ProcessStream(strings.NewReader(`<create-file emoji="x">body</create-file>`), &recorderSink{})
+	if err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+}
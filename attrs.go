@@ -0,0 +1,91 @@
+package promptweaver
+
+import (
+	"maps"
+	"sort"
+	"strings"
+)
+
+// Attr returns the value of the attribute name, ignoring case, so lookups work whether or
+// not the engine preserves attribute case (see EngineOptions.PreserveAttrCase). An exact
+// match wins over other spellings.
+func (ev SectionEvent) Attr(name string) (string, bool) { return lookupAttr(ev.Attrs, name) }
+
+// Render writes ev back as a tag: <name key="value">content</name>, or <name key="value"/>
+// when there is no content. Keys keep the case they are stored in and are sorted; braced
+// values are written as they were read.
+func (ev SectionEvent) Render() string {
+	var b strings.Builder
+	b.WriteString("<" + ev.Name)
+	keys := make([]string, 0, len(ev.Attrs))
+	for k := range ev.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := ev.Attrs[k]
+		switch {
+		case strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}"):
+			b.WriteString(" " + k + "=" + v)
+		case strings.Contains(v, `"`):
+			b.WriteString(" " + k + "='" + v + "'")
+		default:
+			b.WriteString(" " + k + `="` + v + `"`)
+		}
+	}
+	if ev.Content == "" {
+		b.WriteString("/>")
+		return b.String()
+	}
+	b.WriteString(">" + ev.Content + "</" + ev.Name + ">")
+	return b.String()
+}
+
+// lookupAttr finds name in attrs ignoring case: the exact key first, then the lowercased
+// key, then the first other spelling in sorted order.
+func lookupAttr(attrs map[string]string, name string) (string, bool) {
+	return lookupFold(attrs, name)
+}
+
+// lookupFold is lookupAttr for maps of any value type.
+func lookupFold[V any](m map[string]V, name string) (V, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	if v, ok := m[strings.ToLower(name)]; ok {
+		return v, true
+	}
+	match := ""
+	for k := range m {
+		if strings.EqualFold(k, name) && (match == "" || k < match) {
+			match = k
+		}
+	}
+	if match == "" {
+		var zero V
+		return zero, false
+	}
+	return m[match], true
+}
+
+// resolveDefaultAttrs keys EngineOptions.DefaultAttrs by canonical name, merging the
+// defaults given under different names of one section.
+func resolveDefaultAttrs(reg *Registry, defaults map[string]map[string]string) map[string]map[string]string {
+	if len(defaults) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names) // a clash between names resolves the same way every time
+	out := make(map[string]map[string]string, len(defaults))
+	for _, name := range names {
+		c := canonicalOrLower(reg, name)
+		if out[c] == nil {
+			out[c] = map[string]string{}
+		}
+		maps.Copy(out[c], defaults[name])
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func Test_Engine_Should_Preserve_Attribute_Case_When_Asked(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "button"})
+	input := `<button onClick={handle} Content-Type="text/html" id='x'>Go</button>`
+
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	ev := rec.events[0].(SectionEvent)
+	if _, ok := ev.Attrs["onclick"]; !ok {
+		t.Fatalf("keys must be lowercased by default, got %v", ev.Attrs)
+	}
+
+	rec = &recorderSink{}
+	en := NewEngineWithOptions(reg, WithPreserveAttrCase(true))
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	ev = rec.events[0].(SectionEvent)
+	if ev.Attrs["onClick"] != "{handle}" || ev.Attrs["Content-Type"] != "text/html" {
+		t.Fatalf("keys must keep their case, got %v", ev.Attrs)
+	}
+	if v, ok := ev.Attr("content-type"); !ok || v != "text/html" {
+		t.Fatalf("Attr must ignore case, got %q %v", v, ok)
+	}
+	if _, ok := ev.Attr("missing"); ok {
+		t.Fatal("Attr must report missing attributes")
+	}
+	if got := ev.Render(); got != `<button Content-Type="text/html" id="x" onClick={handle}>Go</button>` {
+		t.Fatalf("unexpected rendering %s", got)
+	}
+}
+
+func Test_SectionEvent_Render_Should_Round_Trip(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "result"})
+	ev := SectionEvent{Name: "result", Attrs: map[string]string{"id": "3", "note": `say "hi"`}}
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(ev.Render()), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	back := rec.events[0].(SectionEvent)
+	if back.Attrs["id"] != "3" || back.Attrs["note"] != `say "hi"` || back.Content != "" {
+		t.Fatalf("round trip of %s failed: %+v", ev.Render(), back)
+	}
+}
+
+func Test_Engine_Should_Fill_In_Attribute_Defaults(t *testing.T) {
+	reg := NewRegistry()
+	var opened []string
+	reg.Register(SectionPlugin{
+		Name:         "write-file",
+		AttrDefaults: map[string]string{"mode": "0644", "Overwrite": "false"},
+		OnOpen: func(_ string, attrs map[string]string, _ Position) error {
+			opened = append(opened, attrs["mode"])
+			return nil
+		},
+	})
+	en := NewEngineWithOptions(reg, WithFenceSectionMapping("write-file", ""))
+	en.RegisterValidator("write-file", RequiredAttrs("overwrite"))
+	input := "<write-file path=\"a\" mode=\"\">x</write-file><write-file path=\"b\" overwrite=\"true\"/>\n```go file=c.go\nx\n```\n"
+
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	want := []struct {
+		mode, overwrite, defaulted string
+	}{
+		{"", "false", "[overwrite]"},
+		{"0644", "true", "[mode]"},
+		{"0644", "false", "[mode overwrite]"},
+	}
+	if len(rec.events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(rec.events))
+	}
+	for i, w := range want {
+		ev := rec.events[i].(SectionEvent)
+		if ev.Attrs["mode"] != w.mode || ev.Attrs["overwrite"] != w.overwrite || fmt.Sprint(ev.DefaultedAttrs) != w.defaulted {
+			t.Errorf("event %d: attrs %v, defaulted %v", i, ev.Attrs, ev.DefaultedAttrs)
+		}
+	}
+	if fmt.Sprint(opened) != "[ 0644]" {
+		t.Fatalf("OnOpen saw modes %q", opened)
+	}
+}
+
+func Test_Engine_Should_Fill_In_Engine_Default_Attrs(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}, AttrDefaults: map[string]string{"branch": "main", "mode": "0644"}})
+	opts := []Option{
+		WithDefaultAttrs("create-file", map[string]string{"project": "acme", "branch": "feat-x"}),
+		WithDefaultAttrs("write-file", map[string]string{"Owner": "bot"}),
+	}
+	input := "<create-file path=\"a\" project=\"other\">x</create-file><write-file path=\"b\" owner=\"me\" branch=\"\"/>"
+
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(reg, opts...).ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	want := []string{
+		"map[branch:feat-x mode:0644 owner:bot path:a project:other] [branch mode owner]",
+		"map[branch: mode:0644 owner:me path:b project:acme] [mode project]",
+	}
+	for i, ev := range rec.events {
+		sev := ev.(SectionEvent)
+		if got := fmt.Sprint(sev.Attrs, " ", sev.DefaultedAttrs); got != want[i] {
+			t.Errorf("event %d: got %s, want %s", i, got, want[i])
+		}
+	}
+
+	// Defaults satisfy RequiredAttrs unless it asks for attributes written in the tag.
+	en := NewEngineWithOptions(reg, opts...)
+	en.RegisterValidator("write-file", RequiredAttrs("project"))
+	if err := en.ProcessStream(ReaderFromString(`<write-file path="c">x</write-file>`), NewHandlerSink()); err != nil {
+		t.Fatalf("expected the default project to count, got %v", err)
+	}
+	en = NewEngineWithOptions(reg, opts...)
+	en.RegisterValidator("write-file", &RequiredAttrsValidator{Names: []string{"project"}, Written: true})
+	var ve *ValidationError
+	if err := en.ProcessStream(ReaderFromString(`<write-file path="c">x</write-file>`), NewHandlerSink()); !errors.As(err, &ve) {
+		t.Fatalf("expected a ValidationError for the defaulted project, got %v", err)
+	}
+
+	// A repeated attribute is still reported, and its written value is kept.
+	var we *WellFormednessError
+	rec = &recorderSink{}
+	en = NewEngineWithOptions(reg, append(opts, WithWellFormed(true), WithContinueMode(), WithErrorHandler(func(err error) bool {
+		errors.As(err, &we)
+		return true
+	}))...)
+	if err := en.ProcessStream(ReaderFromString(`<write-file project="a" project="b">x</write-file>`), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if we == nil || we.Attr != "project" || len(rec.events) != 1 || slices.Contains(rec.events[0].(SectionEvent).DefaultedAttrs, "project") {
+		t.Fatalf("expected the duplicate project reported and not defaulted, got %v and %v", we, rec.events)
+	}
+}
@@ -0,0 +1,165 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrSectionNotSeen is returned by AwaitSink.WaitFor and Engine.ExtractFirst when the stream
+// ended without the section.
+var ErrSectionNotSeen = errors.New("section not seen")
+
+// StreamEndSink is an EventSink that wants to know when a stream is over. The engine calls
+// OnStreamEnd once per ProcessStream call, after the last event, with the error the call
+// returns (nil for a clean end).
+type StreamEndSink interface {
+	EventSink
+	OnStreamEnd(err error)
+}
+
+// StreamStartSink is an EventSink that wants to know when a stream begins, e.g. to keep the
+// events of each stream apart when the sink is reused. The engine calls OnStreamStart once
+// per stream, before its first event, with the stream's metadata (see WithStreamMeta; a
+// Demux adds the choice). Every stream that starts also ends (see StreamEndSink).
+type StreamStartSink interface {
+	EventSink
+	OnStreamStart(meta StreamMeta)
+}
+
+// AwaitSink lets other goroutines block until a section arrives, while the stream keeps
+// going. Every event is passed on to Next, if set. An AwaitSink serves a single stream. The
+// zero value is ready to use.
+//
+//	await := promptweaver.NewAwaitSink(handlers)
+//	go engine.ProcessStream(r, await)
+//	plan, err := await.WaitFor(ctx, "plan")
+type AwaitSink struct {
+	Next EventSink
+
+	mu      sync.Mutex
+	first   map[string]SectionEvent  // first event per canonical name
+	arrived map[string]chan struct{} // closed when first[name] is set
+	ended   chan struct{}            // closed when the stream is over
+	err     error                    // the stream's error, once ended
+}
+
+// NewAwaitSink creates an AwaitSink that forwards events to next, which may be nil.
+func NewAwaitSink(next EventSink) *AwaitSink {
+	s := &AwaitSink{Next: next}
+	s.init()
+	return s
+}
+
+// init makes the maps and channel of a zero-value AwaitSink. s.mu must be held, unless s is
+// not shared yet.
+func (s *AwaitSink) init() {
+	if s.ended == nil {
+		s.first, s.arrived, s.ended = map[string]SectionEvent{}, map[string]chan struct{}{}, make(chan struct{})
+	}
+}
+
+// OnEvent implements EventSink.
+func (s *AwaitSink) OnEvent(ev Event) {
+	s.record(ev)
+	if s.Next != nil {
+		s.Next.OnEvent(ev)
+	}
+}
+
+// OnEventContext implements ContextSink, so a context-aware Next keeps its context.
+func (s *AwaitSink) OnEventContext(ctx context.Context, ev Event) error {
+	s.record(ev)
+	if s.Next != nil {
+		return deliver(ctx, s.Next, ev)
+	}
+	return nil
+}
+
+func (s *AwaitSink) record(ev Event) {
+	sev, ok := ev.(SectionEvent)
+	if !ok {
+		return
+	}
+	name := strings.ToLower(sev.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if _, seen := s.first[name]; !seen {
+		s.first[name] = sev
+		close(s.arrival(name))
+	}
+}
+
+// OnStreamStart implements StreamStartSink by telling Next, if it wants to know.
+func (s *AwaitSink) OnStreamStart(meta StreamMeta) {
+	if ss, ok := s.Next.(StreamStartSink); ok {
+		ss.OnStreamStart(meta)
+	}
+}
+
+// OnStreamEnd implements StreamEndSink, releasing waiters whose section never came.
+func (s *AwaitSink) OnStreamEnd(err error) {
+	s.mu.Lock()
+	s.init()
+	select {
+	case <-s.ended:
+	default:
+		s.err = err
+		close(s.ended)
+	}
+	s.mu.Unlock()
+	if es, ok := s.Next.(StreamEndSink); ok {
+		es.OnStreamEnd(err)
+	}
+}
+
+// WaitFor blocks until the first section named name (canonical name) has been emitted and
+// returns it. It fails with ErrSectionNotSeen, wrapping the stream's error if there was one,
+// when the stream ends first, and with ctx.Err() when ctx is done first. Any number of
+// goroutines may wait, for the same or different sections.
+func (s *AwaitSink) WaitFor(ctx context.Context, name string) (SectionEvent, error) {
+	name = strings.ToLower(name)
+	s.mu.Lock()
+	s.init()
+	arrived, ended := s.arrival(name), s.ended
+	s.mu.Unlock()
+
+	select {
+	case <-arrived:
+		return s.event(name), nil
+	case <-ended:
+		select {
+		case <-arrived: // emitted just before the end
+			return s.event(name), nil
+		default:
+		}
+		s.mu.Lock()
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return SectionEvent{}, fmt.Errorf("%w: %q: %w", ErrSectionNotSeen, name, err)
+		}
+		return SectionEvent{}, fmt.Errorf("%w: %q", ErrSectionNotSeen, name)
+	case <-ctx.Done():
+		return SectionEvent{}, ctx.Err()
+	}
+}
+
+// arrival returns the channel closed when name arrives. s.mu must be held.
+func (s *AwaitSink) arrival(name string) chan struct{} {
+	ch, ok := s.arrived[name]
+	if !ok {
+		ch = make(chan struct{})
+		s.arrived[name] = ch
+	}
+	return ch
+}
+
+func (s *AwaitSink) event(name string) SectionEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.first[name]
+}
@@ -0,0 +1,167 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_AwaitSink_Should_Release_All_Waiters(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "plan"})
+	reg.Register(SectionPlugin{Name: "step"})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	pr, pw := io.Pipe()
+	handlers := NewHandlerSink()
+	var steps []string
+	handlers.RegisterHandler("step", func(ev SectionEvent) { steps = append(steps, ev.Content) })
+	await := NewAwaitSink(handlers)
+
+	type result struct {
+		name string
+		ev   SectionEvent
+		err  error
+	}
+	names := []string{"plan", "plan", "PLAN", "step", "summary", "summary"}
+	results := make(chan result, len(names))
+	var ready sync.WaitGroup
+	for _, name := range names {
+		ready.Add(1)
+		go func() {
+			ready.Done()
+			ev, err := await.WaitFor(context.Background(), name)
+			results <- result{name, ev, err}
+		}()
+	}
+	ready.Wait()
+
+	done := make(chan error, 1)
+	go func() { done <- NewEngine(reg).ProcessStream(pr, await) }()
+
+	_, _ = io.WriteString(pw, "<plan>route a</plan>")
+	for range 3 {
+		if r := <-results; r.err != nil || r.ev.Content != "route a" {
+			t.Fatalf("plan waiter: %+v", r)
+		}
+	}
+	_, _ = io.WriteString(pw, "<step>1</step><step>2</step>")
+	if r := <-results; r.name != "step" || r.ev.Content != "1" {
+		t.Fatalf("step waiter must get the first step: %+v", r)
+	}
+	_ = pw.Close()
+
+	for range 2 {
+		if r := <-results; !errors.Is(r.err, ErrSectionNotSeen) {
+			t.Fatalf("summary waiter: expected ErrSectionNotSeen, got %+v", r)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("events must still reach Next, got %v", steps)
+	}
+	if ev, err := await.WaitFor(context.Background(), "plan"); err != nil || ev.Content != "route a" {
+		t.Fatalf("late waiter: %+v, %v", ev, err)
+	}
+}
+
+func Test_AwaitSink_Should_Honor_Context_And_Stream_Errors(t *testing.T) {
+	await := NewAwaitSink(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := await.WaitFor(ctx, "plan"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "plan"})
+	_ = NewEngine(reg).ProcessStream(ReaderFromString("</oops>"), await)
+	_, err := await.WaitFor(context.Background(), "plan")
+	var unmatched *UnmatchedTagError
+	if !errors.Is(err, ErrSectionNotSeen) || !errors.As(err, &unmatched) {
+		t.Fatalf("expected ErrSectionNotSeen wrapping the parse error, got %v", err)
+	}
+}
+
+// streamRecorder keeps the events of each stream apart.
+type streamRecorder struct {
+	streams [][]Event
+	ends    []error
+}
+
+func (r *streamRecorder) OnStreamStart(StreamMeta) { r.streams = append(r.streams, nil) }
+func (r *streamRecorder) OnEvent(ev Event) {
+	r.streams[len(r.streams)-1] = append(r.streams[len(r.streams)-1], ev)
+}
+func (r *streamRecorder) OnStreamEnd(err error) { r.ends = append(r.ends, err) }
+
+func Test_AwaitSink_Zero_Value_Should_Be_Usable(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "plan"})
+	rec := &recorderSink{}
+
+	await := &AwaitSink{Next: rec}
+	waited := make(chan error, 1)
+	go func() {
+		ev, err := await.WaitFor(context.Background(), "plan")
+		if err == nil && ev.Content != "p" {
+			err = fmt.Errorf("got %+v", ev)
+		}
+		waited <- err
+	}()
+	if err := NewEngine(reg).ProcessStream(strings.NewReader("<plan>p</plan>"), await); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if err := <-waited; err != nil || len(rec.events) != 1 {
+		t.Fatalf("WaitFor: %v, forwarded %d events", err, len(rec.events))
+	}
+
+	// Waiting on a fresh zero value is fine too; it ends with the stream.
+	await = &AwaitSink{}
+	await.OnStreamEnd(nil)
+	if _, err := await.WaitFor(context.Background(), "plan"); !errors.Is(err, ErrSectionNotSeen) {
+		t.Fatalf("expected ErrSectionNotSeen, got %v", err)
+	}
+}
+
+func Test_Sinks_Should_See_Stream_Boundaries(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngineWithOptions(reg, WithStreamMeta(StreamMeta{"request": "r1"}), WithMaxEvents(2))
+
+	var log []string
+	sink := NewHandlerSink()
+	sink.RegisterStreamStartHandler(func(meta StreamMeta) { log = append(log, "start "+meta["request"]) })
+	sink.RegisterHandler("summary", func(ev SectionEvent) { log = append(log, ev.Content) })
+	sink.RegisterStreamEndHandler(func(err error) { log = append(log, fmt.Sprintf("end %v", err != nil)) })
+	rec := &streamRecorder{}
+
+	inputs := []string{`<summary>a</summary>`, `<summary>b</summary><summary>c</summary><summary>d</summary>`, ``}
+	for _, in := range inputs {
+		_ = en.ProcessStream(strings.NewReader(in), sink)
+		_ = en.ProcessStream(strings.NewReader(in), rec)
+	}
+	if got := strings.Join(log, "|"); got != "start r1|a|end false|start r1|b|c|end true|start r1|end false" {
+		t.Fatalf("unexpected handler log %q", got)
+	}
+	// The failed stream ends exactly once, like the others.
+	if len(rec.streams) != 3 || len(rec.streams[0]) != 1 || len(rec.streams[1]) != 2 || len(rec.streams[2]) != 0 ||
+		len(rec.ends) != 3 || rec.ends[1] == nil {
+		t.Fatalf("unexpected segments %v, ends %v", rec.streams, rec.ends)
+	}
+
+	// A reused BufferSink only ever holds the latest stream.
+	buf := NewBufferSink(0)
+	_ = en.ProcessStream(strings.NewReader(inputs[1]), buf)
+	_ = en.ProcessStream(strings.NewReader(inputs[0]), buf)
+	if len(buf.Events()) != 1 || buf.FlushTo(&recorderSink{}) != nil {
+		t.Fatalf("buffer mixed streams: %+v", buf.Events())
+	}
+}
@@ -0,0 +1,90 @@
+package promptweaver
+
+import "strings"
+
+// BudgetAction is what a ByteBudgetHandler decides for a section that went over budget.
+type BudgetAction int
+
+const (
+	// BudgetContinue reads the section to the end as usual.
+	BudgetContinue BudgetAction = iota
+	// BudgetTruncate keeps the content up to the budget and counts the rest, as
+	// SectionPlugin.TruncateAt does: the event is Truncated and gets the TruncationMarker.
+	BudgetTruncate
+	// BudgetAbort stops the stream with a ByteBudgetError.
+	BudgetAbort
+)
+
+// ByteBudgetHandler decides what happens to a section once the body bytes read for its
+// name in the stream, this section's included, exceed the budget. It is called once per
+// section, as the bytes arrive.
+type ByteBudgetHandler func(name string, used, budget int) BudgetAction
+
+// byteBudget charges section bodies to their names.
+type byteBudget struct {
+	limits   map[string]int       // EngineOptions.ByteBudgets by canonical name
+	onExceed ByteBudgetHandler    // nil truncates
+	used     map[string]int       // body bytes read per canonical name
+	onUsage  func(map[string]int) // told used at the end of the stream
+}
+
+func newByteBudget(reg *Registry, options EngineOptions) *byteBudget {
+	if len(options.ByteBudgets) == 0 && options.ByteUsageHandler == nil {
+		return nil
+	}
+	b := &byteBudget{onExceed: options.ByteBudgetHandler, used: map[string]int{}, onUsage: options.ByteUsageHandler}
+	for name, n := range options.ByteBudgets {
+		if b.limits == nil {
+			b.limits = map[string]int{}
+		}
+		b.limits[canonicalOrLower(reg, name)] = n
+	}
+	return b
+}
+
+// charge counts n body bytes of el, already buffered or counted, and applies the budget
+// the first time the section goes over it.
+func (p *parser) charge(el *element, n int) error {
+	b := p.budget
+	if b == nil {
+		return nil
+	}
+	name := strings.ToLower(el.canon)
+	b.used[name] += n
+	limit, ok := b.limits[name]
+	if !ok || el.overBudget || b.used[name] <= limit {
+		return nil
+	}
+	el.overBudget = true
+	action := BudgetTruncate
+	if b.onExceed != nil {
+		action = b.onExceed(name, b.used[name], limit)
+	}
+	switch action {
+	case BudgetTruncate:
+		if el.suppress {
+			return nil
+		}
+		// The bytes earlier sections of this name used come off the allowance.
+		allowed := max(limit-(b.used[name]-el.size()), 0)
+		if body := el.body.String(); len(body) > allowed {
+			kept := clipRunes(body, 0, allowed)
+			el.body.Reset()
+			el.body.WriteString(kept)
+			el.skipped += len(body) - len(kept)
+		}
+		// From here on keep counts instead of buffering.
+		el.truncAt = max(el.body.Len(), 1)
+	case BudgetAbort:
+		return NewByteBudgetError(p.pos, el.canon, el.start, b.used[name], limit, p.tz.lastContent)
+	}
+	return nil
+}
+
+// reportByteUsage hands the bytes read per section name to the ByteUsageHandler.
+func (p *parser) reportByteUsage() {
+	if p.budget == nil || p.budget.onUsage == nil {
+		return
+	}
+	p.budget.onUsage(p.budget.used)
+}
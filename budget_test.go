@@ -0,0 +1,76 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Apply_Byte_Budgets_Across_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}, TruncationMarker: "…"})
+	reg.Register(SectionPlugin{Name: "think"})
+	input := `<write-file>aaaaaa</write-file><think>tttttttt</think><create-file>bbbbbbbb</create-file><write-file>cc</write-file>`
+
+	type call struct {
+		name         string
+		used, budget int
+	}
+	var calls []call
+	var usage map[string]int
+	rec := &recorderSink{}
+	err := NewEngineWithOptions(reg,
+		WithByteBudget(map[string]int{"Create-File": 10}, func(name string, used, budget int) BudgetAction {
+			calls = append(calls, call{name, used, budget})
+			return BudgetTruncate
+		}),
+		WithByteUsageHandler(func(u map[string]int) { usage = u }),
+	).ProcessStream(strings.NewReader(input), rec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 6 bytes used by the first section leave 4 for the second; the third has none left.
+	var got []SectionEvent
+	var contents []string
+	for _, ev := range rec.events {
+		sev, _ := AsSection(ev)
+		got, contents = append(got, sev), append(contents, sev.Content)
+	}
+	if strings.Join(contents, "|") != "aaaaaa|tttttttt|bbbb…|…" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+	if !got[2].Truncated || got[2].OriginalSize != 8 || !got[3].Truncated || got[1].Truncated {
+		t.Fatalf("unexpected truncation flags: %+v", got)
+	}
+	if len(calls) != 2 || calls[0] != (call{"write-file", 14, 10}) || calls[1] != (call{"write-file", 16, 10}) {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+	if usage["write-file"] != 16 || usage["think"] != 8 {
+		t.Fatalf("unexpected usage %v", usage)
+	}
+}
+
+func Test_Engine_Should_Abort_Mid_Section_When_Over_Byte_Budget(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file"})
+	var usage map[string]int
+	rec := &recorderSink{}
+	err := NewEngineWithOptions(reg,
+		WithByteBudget(map[string]int{"write-file": 5}, func(string, int, int) BudgetAction { return BudgetAbort }),
+		WithByteUsageHandler(func(u map[string]int) { usage = u }),
+	).ProcessStream(&chunkedReader{data: []byte(`<write-file>abcdefghij</write-file>`), chunk: 4}, rec)
+	// The closing tag starts at offset 22.
+	var be *ByteBudgetError
+	if !errors.As(err, &be) || be.SectionName != "write-file" || be.Used <= 5 || be.Budget != 5 || be.Pos.Offset >= 22 {
+		t.Fatalf("expected a byte budget error before the closing tag, got %v", err)
+	}
+	if ctx := be.Render(); ctx == "" || !strings.HasSuffix(be.Error(), "\nContext: "+ctx) {
+		t.Fatalf("Error() must render the snippets, got %q", be.Error())
+	}
+	if len(rec.events) != 0 || usage["write-file"] != be.Used {
+		t.Fatalf("got %d events and usage %v", len(rec.events), usage)
+	}
+	if b, ok := ErrorToJSON(err); !ok || !strings.Contains(string(b), `"kind":"byte_budget"`) {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+}
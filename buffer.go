@@ -0,0 +1,129 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrBufferFull is returned when a BufferSink would exceed its limit.
+var ErrBufferFull = errors.New("buffer sink full")
+
+// BufferSink holds a stream's events so they can be handed on together once the stream is
+// known to be good. With StrictMode and the ErrorPartial EOF policy, a stream that fails or
+// ends inside a section leaves the buffer unflushable, which gives all-or-nothing delivery:
+//
+//	buf := promptweaver.NewBufferSink(1 << 20)
+//	engine := promptweaver.NewEngineWithOptions(reg, promptweaver.WithEOFPolicy(promptweaver.ErrorPartial))
+//	if err := engine.ProcessStream(r, buf); err == nil {
+//		err = buf.FlushTo(db)
+//	}
+//
+// With the default EmitPartial policy a section cut off by EOF is emitted, and buffered,
+// like any other. A buffer that overflowed is never flushed, even if the engine recovered
+// from the ErrBufferFull.
+type BufferSink struct {
+	limit  int // content and attribute bytes; zero means unlimited
+	size   int
+	events []Event
+	err    error // the stream's error, set by OnStreamEnd
+	full   error // the first ErrBufferFull of the stream, which makes it unflushable
+
+	applyRevisions bool // a SupersededEvent removes the section it names
+}
+
+// NewBufferSink creates a BufferSink that holds up to limit bytes of event content and
+// attribute values. Zero means unlimited.
+func NewBufferSink(limit int) *BufferSink { return &BufferSink{limit: limit} }
+
+// OnEvent implements EventSink. Events over the limit are dropped; engines deliver through
+// OnEventContext, which reports them.
+func (s *BufferSink) OnEvent(ev Event) { _ = s.OnEventContext(context.Background(), ev) }
+
+// OnEventContext implements ContextSink. An event that would exceed the limit is not stored
+// and ErrBufferFull goes through the engine's error handling.
+func (s *BufferSink) OnEventContext(_ context.Context, ev Event) error {
+	if sup, ok := ev.(SupersededEvent); ok && s.applyRevisions {
+		s.supersede(sup.Superseded)
+		return nil
+	}
+	n := eventSize(ev)
+	if s.limit > 0 && s.size+n > s.limit {
+		err := fmt.Errorf("%w: %d of %d bytes used, event needs %d", ErrBufferFull, s.size, s.limit, n)
+		if s.full == nil {
+			s.full = err
+		}
+		return err
+	}
+	s.size += n
+	s.events = append(s.events, ev)
+	return nil
+}
+
+// OnStreamStart implements StreamStartSink by resetting the buffer, so that it only ever
+// holds one stream: events not flushed before the next stream starts are dropped.
+func (s *BufferSink) OnStreamStart(StreamMeta) { s.Reset() }
+
+// OnStreamEnd implements StreamEndSink by remembering how the stream ended.
+func (s *BufferSink) OnStreamEnd(err error) { s.err = err }
+
+// SetApplyRevisions makes the buffer keep only the final version of revised sections (see
+// EngineOptions.RevisionAttr): a SupersededEvent removes the section it names, and is not
+// itself buffered. The revision keeps its Supersedes field.
+func (s *BufferSink) SetApplyRevisions(apply bool) { s.applyRevisions = apply }
+
+// supersede removes the section with the given Seq, if it is buffered.
+func (s *BufferSink) supersede(seq int64) {
+	for i, ev := range s.events {
+		if sev, ok := ev.(SectionEvent); ok && sev.Seq == seq {
+			s.size -= eventSize(ev)
+			s.events = slices.Delete(s.events, i, i+1)
+			return
+		}
+	}
+}
+
+// Events returns the buffered events in emission order.
+func (s *BufferSink) Events() []Event { return s.events }
+
+// Reset empties the buffer and forgets the last stream's outcome.
+func (s *BufferSink) Reset() { s.events, s.size, s.err, s.full = nil, 0, nil, nil }
+
+// FlushTo delivers the buffered events to next, in order, and empties the buffer. It delivers
+// nothing and returns the stream's error if the stream failed, or an ErrBufferFull if the
+// buffer overflowed. If next is a ContextSink and fails, FlushTo stops and returns that
+// error, keeping the events next has not accepted, starting with the one it failed on, so
+// that a retry delivers each event once.
+func (s *BufferSink) FlushTo(next EventSink) error {
+	if s.err != nil {
+		return fmt.Errorf("buffered stream failed: %w", s.err)
+	}
+	if s.full != nil {
+		return fmt.Errorf("buffered stream incomplete: %w", s.full)
+	}
+	for i, ev := range s.events {
+		if err := deliver(context.Background(), next, ev); err != nil {
+			s.events = s.events[i:]
+			return err
+		}
+		s.size -= eventSize(ev)
+	}
+	s.Reset()
+	return nil
+}
+
+// eventSize is the content size an event counts against a BufferSink limit.
+func eventSize(ev Event) int {
+	switch e := ev.(type) {
+	case SectionEvent:
+		n := len(e.Content)
+		for k, v := range e.Attrs {
+			n += len(k) + len(v)
+		}
+		return n
+	case CodeBlockEvent:
+		return len(e.Content)
+	}
+	return 0
+}
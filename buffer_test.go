@@ -0,0 +1,119 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_BufferSink_Should_Flush_Only_Clean_Streams(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "row"})
+	en := NewEngineWithOptions(reg, WithEOFPolicy(ErrorPartial))
+	buf := NewBufferSink(0)
+
+	if err := en.ProcessStream(ReaderFromString("<row>1</row><row>2</row>"), buf); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	out := &recorderSink{}
+	if err := buf.FlushTo(out); err != nil || len(out.events) != 2 || len(buf.Events()) != 0 {
+		t.Fatalf("expected 2 flushed events and an empty buffer, got %v, %+v", err, out.events)
+	}
+
+	// A truncated final section fails the stream, so nothing is flushed.
+	err := en.ProcessStream(ReaderFromString("<row>1</row><row>2"), buf)
+	var unclosed *UnclosedSectionError
+	if !errors.As(err, &unclosed) {
+		t.Fatalf("expected UnclosedSectionError, got %v", err)
+	}
+	out = &recorderSink{}
+	if err := buf.FlushTo(out); !errors.As(err, &unclosed) || len(out.events) != 0 {
+		t.Fatalf("flush after a failed stream must deliver nothing, got %v, %+v", err, out.events)
+	}
+	if len(buf.Events()) != 1 {
+		t.Fatalf("the truncated section must not be buffered, got %+v", buf.Events())
+	}
+	buf.Reset()
+	if len(buf.Events()) != 0 || buf.FlushTo(out) != nil {
+		t.Fatal("Reset must clear events and the failed outcome")
+	}
+}
+
+func Test_BufferSink_Should_Enforce_Limit_Through_Error_Handling(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "row"})
+	buf := NewBufferSink(5)
+
+	err := NewEngine(reg).ProcessStream(ReaderFromString("<row>abc</row><row>def</row>"), buf)
+	if !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("expected ErrBufferFull, got %v", err)
+	}
+	if len(buf.Events()) != 1 {
+		t.Fatalf("expected the first row only, got %+v", buf.Events())
+	}
+}
+
+func Test_BufferSink_Should_Not_Flush_After_A_Recovered_Overflow(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "row"})
+	buf := NewBufferSink(5)
+	en := NewEngineWithOptions(reg, WithContinueMode())
+
+	if err := en.ProcessStream(ReaderFromString("<row>abc</row><row>def</row>"), buf); err != nil {
+		t.Fatalf("the overflow should be recovered from, got %v", err)
+	}
+	rec := &recorderSink{}
+	if err := buf.FlushTo(rec); !errors.Is(err, ErrBufferFull) || len(rec.events) != 0 {
+		t.Fatalf("FlushTo = %v, delivered %+v", err, rec.events)
+	}
+	if len(buf.Events()) != 1 {
+		t.Fatalf("the buffer should keep what fit, got %+v", buf.Events())
+	}
+
+	if err := en.ProcessStream(ReaderFromString("<row>abc</row>"), buf); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if err := buf.FlushTo(rec); err != nil || len(rec.events) != 1 {
+		t.Fatalf("the next stream should flush, got %v and %+v", err, rec.events)
+	}
+}
+
+// failOnceSink records events and fails the first delivery of the event at index failAt.
+type failOnceSink struct {
+	recorderSink
+	failAt int
+	failed bool
+}
+
+func (s *failOnceSink) OnEventContext(_ context.Context, ev Event) error {
+	if !s.failed && len(s.events) == s.failAt {
+		s.failed = true
+		return errors.New("commit failed")
+	}
+	s.OnEvent(ev)
+	return nil
+}
+
+func Test_BufferSink_Should_Not_Redeliver_After_A_Failed_Flush(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "row"})
+	buf := NewBufferSink(0)
+	if err := NewEngine(reg).ProcessStream(ReaderFromString("<row>1</row><row>2</row><row>3</row>"), buf); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	out := &failOnceSink{failAt: 1}
+	if err := buf.FlushTo(out); err == nil || len(out.events) != 1 || len(buf.Events()) != 2 {
+		t.Fatalf("expected the flush to stop at the second event, got %v, %d delivered, %d kept", err, len(out.events), len(buf.Events()))
+	}
+	if err := buf.FlushTo(out); err != nil {
+		t.Fatalf("retry error: %v", err)
+	}
+	var got []string
+	for _, ev := range out.events {
+		got = append(got, ev.(SectionEvent).Content)
+	}
+	if len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" || len(buf.Events()) != 0 {
+		t.Fatalf("expected each row delivered once, got %q", got)
+	}
+}
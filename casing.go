@@ -0,0 +1,43 @@
+package promptweaver
+
+// RegisteredName returns the name of the plugin registered for name, which may be the
+// canonical name or an alias, in the casing it was registered with (e.g. "WriteFile").
+func (r *Registry) RegisteredName(name string) (string, bool) {
+	p, ok := r.Plugin(name)
+	return p.Name, ok
+}
+
+// registeredCase gives the sections in ev the casing their plugins were registered with,
+// for EngineOptions.OriginalNameCasing. The parser works with canonical names until then.
+func (p *parser) registeredCase(ev Event) Event {
+	if !p.originalCase {
+		return ev
+	}
+	rename := func(name string) string {
+		if n, ok := p.reg.RegisteredName(name); ok {
+			return n
+		}
+		return name
+	}
+	switch e := ev.(type) {
+	case SectionEvent:
+		e.Name = rename(e.Name)
+		return e
+	case PairedEvent:
+		e.Open.Name, e.Close.Name = rename(e.Open.Name), rename(e.Close.Name)
+		return e
+	case SectionClosedEvent:
+		e.Name = rename(e.Name)
+		return e
+	case IntentEvent:
+		e.Name = rename(e.Name)
+		return e
+	case VetoedEvent:
+		e.Name = rename(e.Name)
+		return e
+	case SupersededEvent:
+		e.Name, e.By.Name = rename(e.Name), rename(e.By.Name)
+		return e
+	}
+	return ev
+}
@@ -0,0 +1,58 @@
+package promptweaver
+
+import (
+	"context"
+	"io"
+)
+
+// ChanReader returns a reader over the strings received from ch, such as the deltas of a
+// streaming RPC. It blocks until the next string arrives and reports io.EOF once ch is
+// closed.
+func ChanReader(ch <-chan string) io.Reader { return &chanReader{ch: ch} }
+
+type chanReader struct {
+	ch  <-chan string
+	buf string // rest of the last string received
+}
+
+// Read implements io.Reader.
+func (r *chanReader) Read(p []byte) (int, error) {
+	for r.buf == "" {
+		s, ok := <-r.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		r.buf = s
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// ProcessChan parses the strings received from ch as one stream. Each is parsed as it
+// arrives, so senders block only while the parser works; once ch is closed the stream ends
+// as at EOF. If ctx is done first, ctx.Err() is returned and sections still open are not
+// emitted.
+func (e *Engine) ProcessChan(ctx context.Context, ch <-chan string, sink EventSink) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := e.checkSink(sink); err != nil {
+		return err
+	}
+	s := e.startStream(ctx, sink, e.options, e.validators)
+	defer func() { err = s.end(err) }()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case delta, ok := <-ch:
+			if !ok {
+				return s.close()
+			}
+			if err := s.push([]byte(delta)); err != nil {
+				return err
+			}
+		}
+	}
+}
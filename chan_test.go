@@ -0,0 +1,71 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func Test_ProcessChan_Should_Parse_Deltas_Split_Mid_Token(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file"})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for _, delta := range []string{"Intro <wri", "te-file pa", `th="a.go">pack`, "age a\n</write", "-file><summary>do", "ne</sum", "mary>"} {
+			ch <- delta
+		}
+	}()
+
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessChan(context.Background(), ch, rec); err != nil {
+		t.Fatalf("ProcessChan error: %v", err)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(rec.events))
+	}
+	if ev := rec.events[0].(SectionEvent); ev.Attrs["path"] != "a.go" || ev.Content != "package a\n" {
+		t.Fatalf("unexpected first event %+v", ev)
+	}
+	if ev := rec.events[1].(SectionEvent); ev.Content != "done" {
+		t.Fatalf("unexpected second event %+v", ev)
+	}
+}
+
+func Test_ProcessChan_Should_Stop_When_Context_Is_Cancelled(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan string)
+	go func() {
+		ch <- "<summary>partial"
+		cancel()
+	}()
+
+	rec := &recorderSink{}
+	err := NewEngine(reg).ProcessChan(ctx, ch, rec)
+	if !errors.Is(err, context.Canceled) || len(rec.events) != 0 {
+		t.Fatalf("got %v with %d events", err, len(rec.events))
+	}
+}
+
+func Test_ChanReader_Should_Read_Until_The_Channel_Closes(t *testing.T) {
+	ch := make(chan string, 4)
+	for _, s := range []string{"ab", "", "cdef"} {
+		ch <- s
+	}
+	close(ch)
+	r := ChanReader(ch)
+	small := make([]byte, 3)
+	n, _ := r.Read(small)
+	if string(small[:n]) != "ab" {
+		t.Fatalf("first read %q", small[:n])
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "cdef" {
+		t.Fatalf("rest %q, %v", rest, err)
+	}
+}
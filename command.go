@@ -0,0 +1,111 @@
+package promptweaver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ParseCommand splits a command line into words the way a POSIX shell would, without
+// running any of it: words are separated by blanks and newlines, single quotes keep
+// everything up to the next one, double quotes keep everything but a backslash before $, `,
+// " or \, and a backslash outside quotes keeps the next character (a backslash-newline
+// joins lines). Nothing is expanded, globbed or treated as an operator: "a;b" and "$HOME"
+// are words as written. Run argv directly, not through a shell.
+//
+// An unterminated quote or a trailing backslash is a *ContentSyntaxError at its place in
+// content.
+func ParseCommand(content string) (argv []string, err error) {
+	words, _, err := splitCommand(content)
+	return words, err
+}
+
+// splitCommand is ParseCommand that also returns where each word starts in content.
+func splitCommand(s string) (words []string, starts []int, err error) {
+	var word strings.Builder
+	inWord := false
+	start := func(i int) {
+		if !inWord {
+			inWord = true
+			starts = append(starts, i)
+		}
+	}
+	fail := func(i int, msg string) error {
+		return &ContentSyntaxError{Pos: advance(Position{Line: 1, Column: 1}, []byte(s[:i])), Message: msg}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\r', '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\\':
+			if i+1 == len(s) {
+				return nil, nil, fail(i, "command ends in an escape")
+			}
+			i++
+			if s[i] != '\n' {
+				start(i - 1)
+				word.WriteByte(s[i])
+			}
+		case '\'':
+			start(i)
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, nil, fail(i, "unterminated single quote in command")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += 1 + end
+		case '"':
+			start(i)
+			open := i
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, nil, fail(open, "unterminated double quote in command")
+			}
+		default:
+			start(i)
+			word.WriteByte(c)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, starts, nil
+}
+
+// CommandValidator rejects command sections that do not parse (see ParseCommand) or whose
+// command, the first word, is not allowed. Empty commands pass. Build with
+// CommandAllowlistValidator.
+type CommandValidator struct {
+	Allowed []string // commands as they must be written, e.g. "go" or "/usr/bin/git"
+}
+
+// CommandAllowlistValidator returns a validator allowing only the given commands.
+func CommandAllowlistValidator(allowed []string) *CommandValidator {
+	return &CommandValidator{Allowed: allowed}
+}
+
+// Validate implements Validator. A command that is not allowed is a *ContentSyntaxError
+// at its first character.
+func (v *CommandValidator) Validate(sectionName, content string, pos Position) error {
+	argv, starts, err := splitCommand(content)
+	if err != nil || len(argv) == 0 || slices.Contains(v.Allowed, argv[0]) {
+		return err
+	}
+	return &ContentSyntaxError{
+		Pos:     advance(Position{Line: 1, Column: 1}, []byte(content[:starts[0]])),
+		Message: fmt.Sprintf("command %q is not allowed", argv[0]),
+	}
+}
@@ -0,0 +1,61 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_ParseCommand_Should_Split_Words_Like_A_Shell(t *testing.T) {
+	cases := map[string][]string{
+		`go test ./...`:                 {"go", "test", "./..."},
+		"  git\tcommit -m 'fix: a b'\n": {"git", "commit", "-m", "fix: a b"},
+		`echo "say \"hi\" \$HOME \n"`:   {"echo", `say "hi" $HOME \n`},
+		`a\ b c\\d '' x"y"'z'`:          {"a b", `c\d`, "", "xyz"},
+		"ls \\\n-la":                    {"ls", "-la"},
+		`rm -rf *; echo $HOME`:          {"rm", "-rf", "*;", "echo", "$HOME"},
+		"":                              nil,
+	}
+	for in, want := range cases {
+		got, err := ParseCommand(in)
+		if err != nil || fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("ParseCommand(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for in, offset := range map[string]int64{`echo 'abc`: 5, "ls\n\"x": 3, `ls \`: 3} {
+		_, err := ParseCommand(in)
+		var cse *ContentSyntaxError
+		if !errors.As(err, &cse) || cse.Pos.Offset != offset {
+			t.Errorf("ParseCommand(%q): expected an error at offset %d, got %v", in, offset, err)
+		}
+	}
+}
+
+func Test_Engine_Should_Attach_Argv_And_Check_The_Allowlist(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "run-command", Command: true})
+	en := NewEngine(reg)
+	en.RegisterValidator("run-command", CommandAllowlistValidator([]string{"go", "git"}))
+
+	sink, got := newSinkCatcher("run-command")
+	if err := en.ProcessStream(strings.NewReader(`<run-command>go test -run 'Test A'</run-command>`), sink); err != nil {
+		t.Fatal(err)
+	}
+	if len(*got) != 1 || fmt.Sprintf("%q", (*got)[0].Argv) != `["go" "test" "-run" "Test A"]` {
+		t.Fatalf("unexpected events %+v", *got)
+	}
+
+	// Errors point into the body: the quote opens at offset 17, and rm starts at 16.
+	for input, offset := range map[string]int64{
+		`<run-command>git 'log</run-command>`:     17,
+		"<run-command>\n  rm -rf /</run-command>": 16,
+	} {
+		err := en.ProcessStream(strings.NewReader(input), NewHandlerSink())
+		var ve *ValidationError
+		if !errors.As(err, &ve) || ve.Pos.Offset != offset {
+			t.Errorf("%q: expected a ValidationError at offset %d, got %v", input, offset, err)
+		}
+	}
+}
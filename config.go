@@ -0,0 +1,339 @@
+package promptweaver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigDescription is a snapshot of what decides how an engine parses: its policies,
+// limits, sections, validators and enabled features. Everything is sorted, so two engines
+// configured alike describe themselves identically, in any process. Per-stream values
+// (StreamMeta, ExpectedLength) and I/O destinations are left out; callbacks are listed
+// only as present.
+type ConfigDescription struct {
+	Policies   map[string]string   `json:"policies"`
+	Limits     map[string]string   `json:"limits,omitempty"`
+	Sections   []SectionConfig     `json:"sections"`
+	Validators map[string][]string `json:"validators,omitempty"` // section -> descriptions, in run order
+	Features   []string            `json:"features,omitempty"`
+	Handlers   []string            `json:"handlers,omitempty"`    // callbacks that are set
+	SubParsers map[string]string   `json:"sub_parsers,omitempty"` // section -> inner engine fingerprint
+}
+
+// SectionConfig describes one registered plugin.
+type SectionConfig struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Options []string `json:"options,omitempty"` // non-zero plugin fields, e.g. "truncate_at=65536"
+}
+
+// DescribeConfig returns a description of the engine's configuration, e.g. to attach to a
+// bug report.
+func (e *Engine) DescribeConfig() ConfigDescription {
+	o := e.options
+	d := ConfigDescription{
+		Policies: map[string]string{
+			"recovery_mode":     recoveryModeName(o.RecoveryMode),
+			"eof_policy":        eofPolicyName(o.EOFPolicy),
+			"unknown_variables": unknownVariablesName(o.UnknownVariables),
+		},
+		Limits:     map[string]string{},
+		Validators: map[string][]string{},
+	}
+	if o.SectionTimeout > 0 {
+		d.Limits["section_timeout"] = o.SectionTimeout.String()
+	}
+	if o.MaxStreamBytes > 0 {
+		d.Limits["max_stream_bytes"] = strconv.FormatInt(o.MaxStreamBytes, 10)
+	}
+	if o.MaxEvents > 0 {
+		d.Limits["max_events"] = strconv.Itoa(o.MaxEvents)
+	}
+	if o.MaxAttrs != 0 {
+		d.Limits["max_attrs"] = strconv.Itoa(o.MaxAttrs)
+	}
+	if o.MaxAttrNameLen != 0 {
+		d.Limits["max_attr_name_len"] = strconv.Itoa(o.MaxAttrNameLen)
+	}
+	if o.MaxLookahead != 0 {
+		d.Limits["max_lookahead"] = strconv.Itoa(o.MaxLookahead)
+	}
+	if o.MemoryLimit > 0 {
+		d.Limits["memory"] = strconv.FormatInt(o.MemoryLimit, 10)
+	}
+	if o.MaxSkippedBytes != 0 {
+		d.Limits["max_skipped_bytes"] = strconv.Itoa(o.MaxSkippedBytes)
+	}
+	if o.MaxEmptyReads != 0 {
+		d.Limits["max_empty_reads"] = strconv.Itoa(o.MaxEmptyReads)
+	}
+
+	for _, p := range e.reg.List() {
+		d.Sections = append(d.Sections, describeSection(p))
+	}
+	for section, vs := range e.validators.validators {
+		for _, v := range vs {
+			d.Validators[section] = append(d.Validators[section], describeValidator(v))
+		}
+	}
+
+	d.Features = describeFeatures(o)
+	for name, set := range map[string]bool{
+		"error":               o.ErrorHandler != nil,
+		"unknown_tag":         o.UnknownTagHandler != nil,
+		"suppress":            o.SuppressHandler != nil,
+		"unpaired":            o.UnpairedHandler != nil,
+		"progress":            o.ProgressHandler != nil,
+		"reference":           o.ReferenceResolver != nil,
+		"reference_warnings":  o.ReferenceWarnings != nil,
+		"dangling_references": o.DanglingReferenceHandler != nil,
+		"slow_handler":        o.OnSlowHandler != nil,
+		"handler_stats":       o.HandlerStatsHandler != nil,
+		"clock":               o.Clock != nil,
+		"memory_gauge":        o.MemoryGauge != nil,
+		"truncation":          o.TruncationHandler != nil,
+		"byte_budget":         o.ByteBudgetHandler != nil,
+		"byte_usage":          o.ByteUsageHandler != nil,
+		"truncated_sections":  o.TruncatedSectionsHandler != nil,
+		"revision_warnings":   o.RevisionWarnings != nil,
+		"token_tap":           o.TokenTap != nil,
+		"attr_warnings":       o.AttrWarnings != nil,
+	} {
+		if set {
+			d.Handlers = append(d.Handlers, name)
+		}
+	}
+	sort.Strings(d.Handlers)
+
+	if len(o.SubParsers) > 0 {
+		d.SubParsers = map[string]string{}
+		for section, sp := range o.SubParsers {
+			fp := ""
+			if sp.Engine != nil {
+				fp = sp.Engine.ConfigFingerprint()
+			}
+			d.SubParsers[canonicalOrLower(e.reg, section)] = fp
+		}
+	}
+	return d
+}
+
+// ConfigFingerprint returns a stable hash of DescribeConfig, hex-encoded. Engines with the
+// same configuration have the same fingerprint across processes and restarts.
+func (e *Engine) ConfigFingerprint() string {
+	// json.Marshal sorts map keys, and the description sorts its lists.
+	b, err := json.Marshal(e.DescribeConfig())
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func describeSection(p SectionPlugin) SectionConfig {
+	sc := SectionConfig{Name: strings.ToLower(p.Name)}
+	for _, a := range p.Aliases {
+		if a != "" {
+			sc.Aliases = append(sc.Aliases, strings.ToLower(a))
+		}
+	}
+	sort.Strings(sc.Aliases)
+	flag := func(set bool, name string) {
+		if set {
+			sc.Options = append(sc.Options, name)
+		}
+	}
+	flag(p.NormalizeEmpty, "normalize_empty")
+	flag(p.RejectEmpty, "reject_empty")
+	flag(p.ParseFencesInBody, "parse_fences_in_body")
+	flag(p.IncludeRawEnvelope, "include_raw_envelope")
+	flag(p.NoVariables, "no_variables")
+	flag(p.StrictBody, "strict_body")
+	flag(p.Suppress, "suppress")
+	flag(p.TruncateAt > 0, "truncate_at="+strconv.Itoa(p.TruncateAt))
+	flag(p.TruncationMarker != "", "truncation_marker="+strconv.Quote(p.TruncationMarker))
+	flag(p.RawUntil != "", "raw_until="+p.RawUntil)
+	flag(p.RawDelimiter, "raw_delimiter")
+	flag(p.OnOpen != nil, "on_open")
+	flag(p.Command, "command")
+	flag(p.EmitIntent, "emit_intent")
+	flag(p.LanguageDetector != nil, "language_detector")
+	if p.UnknownAttrs != AllowUnknownAttrs {
+		known := make([]string, len(p.KnownAttrs))
+		for i, k := range p.KnownAttrs {
+			known[i] = strings.ToLower(k)
+		}
+		sort.Strings(known)
+		flag(true, "unknown_attrs="+unknownAttrsName(p.UnknownAttrs)+"("+strings.Join(known, ",")+")")
+	}
+	flag(len(p.ContentTransforms) > 0, "content_transforms="+strconv.Itoa(len(p.ContentTransforms)))
+	keys := make([]string, 0, len(p.AttrDefaults))
+	for k := range p.AttrDefaults {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sc.Options = append(sc.Options, "attr_default "+strings.ToLower(k)+"="+strconv.Quote(p.AttrDefaults[k]))
+	}
+	return sc
+}
+
+// describeValidator names a validator: by its String method if it has one, by what it
+// checks for the package's own validators, by its type otherwise.
+func describeValidator(v Validator) string {
+	switch v := v.(type) {
+	case fmt.Stringer:
+		return v.String()
+	case *RegexValidator:
+		return fmt.Sprintf("regex %q", v.Pattern.String())
+	case *JSONSchemaValidator:
+		return "json_schema"
+	case *RequiredAttrsValidator:
+		return "required_attrs " + strings.Join(v.Names, ",")
+	case *SafePathValidator:
+		return "safe_path " + v.Attr
+	case *CommandValidator:
+		return "command_allowlist " + strings.Join(v.Allowed, ",")
+	case *whereValidator:
+		keys := make([]string, 0, len(v.match))
+		for k := range v.match {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		conds := make([]string, len(keys))
+		for i, k := range keys {
+			conds[i] = k + "=" + strconv.Quote(v.match[k])
+		}
+		return describeValidator(v.inner) + " where " + strings.Join(conds, ",")
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func describeFeatures(o EngineOptions) []string {
+	var fs []string
+	add := func(set bool, f string) {
+		if set {
+			fs = append(fs, f)
+		}
+	}
+	add(o.CodeBlocks, "code_blocks")
+	add(o.LenientFences, "lenient_fences")
+	add(o.FenceMapping.Section != "", "fence_mapping="+o.FenceMapping.Section+":"+o.FenceMapping.PathAttr)
+	add(o.IncludeRawEnvelope, "raw_envelope")
+	for name, value := range o.Variables {
+		// Values may be secrets, so only a hash of each is described.
+		sum := sha256.Sum256([]byte(value))
+		fs = append(fs, "variable "+name+" sha256:"+hex.EncodeToString(sum[:8]))
+	}
+	for _, s := range o.SuppressedSections {
+		fs = append(fs, "suppressed "+strings.ToLower(s))
+	}
+	for _, cs := range o.ContextSections {
+		inherit := append([]string(nil), cs.Inherit...)
+		sort.Strings(inherit)
+		fs = append(fs, "context_section "+strings.ToLower(cs.Name)+"("+strings.Join(inherit, ",")+")")
+	}
+	add(o.ContextAttrPrefix != "", "context_attr_prefix="+o.ContextAttrPrefix)
+	for name, attrs := range o.DefaultAttrs {
+		for k, v := range attrs {
+			fs = append(fs, "default_attr "+strings.ToLower(name)+"."+strings.ToLower(k)+"="+strconv.Quote(v))
+		}
+	}
+	for name, n := range o.ByteBudgets {
+		fs = append(fs, "byte_budget "+strings.ToLower(name)+"="+strconv.Itoa(n))
+	}
+	for _, pg := range o.Pairings {
+		fs = append(fs, "pairing "+strings.ToLower(pg.Open)+"/"+strings.ToLower(pg.Close)+" by "+strings.ToLower(pg.Attr))
+	}
+	for _, r := range o.References {
+		fs = append(fs, "reference "+strings.ToLower(r.Use)+"."+strings.ToLower(r.UseAttr)+" -> "+strings.ToLower(r.Def)+"."+strings.ToLower(r.DefAttr))
+	}
+	add(o.ContentSniffing, "content_sniffing")
+	add(o.LanguageDetector != nil, "language_detection")
+	add(o.InlineCodeAwareness, "inline_code")
+	add(o.BalancedSameName, "balanced_same_name")
+	add(o.OriginalNameCasing, "original_name_casing")
+	add(o.RevisionAttr != "", "revisions="+strings.ToLower(o.RevisionAttr))
+	add(o.RegistrySync != RegistryLive, "registry_sync="+registrySyncName(o.RegistrySync))
+	add(o.EscapePrefix != 0, "escape_prefix="+strconv.Quote(string(rune(o.EscapePrefix))))
+	add(o.OrphanRescue, "orphan_rescue")
+	add(o.PreserveAttrCase, "preserve_attr_case")
+	add(o.StreamDigest != nil, "stream_digest")
+	add(o.ReadRetry.MaxAttempts > 0, "read_retry="+strconv.Itoa(o.ReadRetry.MaxAttempts))
+	add(o.RawCapture != nil, "raw_capture")
+	add(o.TimingCapture != nil, "timing_capture")
+	add(o.ExpectedLength > 0, "progress")
+	add(o.HandlerTiming > 0, "handler_timing="+o.HandlerTiming.String())
+	add(o.WellFormed, "well_formed")
+	add(o.Ancestry, "ancestry")
+	add(o.PlainText != nil, "plain_text")
+	add(o.SectionClosedEvents, "section_closed_events")
+	sort.Strings(fs)
+	return fs
+}
+
+func recoveryModeName(m RecoveryMode) string {
+	switch m {
+	case StrictMode:
+		return "strict"
+	case ContinueMode:
+		return "continue"
+	}
+	return strconv.Itoa(int(m))
+}
+
+func eofPolicyName(p EOFPolicy) string {
+	switch p {
+	case EmitPartial:
+		return "emit_partial"
+	case DropPartial:
+		return "drop_partial"
+	case ErrorPartial:
+		return "error_partial"
+	}
+	return strconv.Itoa(int(p))
+}
+
+func unknownVariablesName(p UnknownVariablePolicy) string {
+	switch p {
+	case KeepUnknownVariables:
+		return "keep"
+	case EmptyUnknownVariables:
+		return "empty"
+	case ErrorUnknownVariables:
+		return "error"
+	}
+	return strconv.Itoa(int(p))
+}
+
+func registrySyncName(p RegistrySync) string {
+	switch p {
+	case RegistryLive:
+		return "live"
+	case RegistrySnapshot:
+		return "snapshot"
+	case RegistryFailOnChange:
+		return "fail_on_change"
+	}
+	return strconv.Itoa(int(p))
+}
+
+func unknownAttrsName(p UnknownAttrPolicy) string {
+	switch p {
+	case AllowUnknownAttrs:
+		return "allow"
+	case WarnUnknownAttrs:
+		return "warn"
+	case StripUnknownAttrs:
+		return "strip"
+	case ErrorUnknownAttrs:
+		return "error"
+	}
+	return strconv.Itoa(int(p))
+}
@@ -0,0 +1,73 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Fingerprint_Configuration_Stably(t *testing.T) {
+	build := func(reverse bool, opts ...Option) *Engine {
+		plugins := []SectionPlugin{
+			{Name: "think", NormalizeEmpty: true},
+			{Name: "write-file", Aliases: []string{"create-file", "file"}, TruncateAt: 1 << 16},
+			{Name: "summary", StrictBody: true},
+		}
+		if reverse {
+			for i, j := 0, len(plugins)-1; i < j; i, j = i+1, j-1 {
+				plugins[i], plugins[j] = plugins[j], plugins[i]
+			}
+			plugins[1].Aliases = []string{"file", "create-file"}
+		}
+		reg := NewRegistry()
+		for _, p := range plugins {
+			reg.Register(p)
+		}
+		opts = append([]Option{WithContinueMode(), WithVariables(map[string]string{"a": "1", "b": "2", "c": "3"})}, opts...)
+		en := NewEngineWithOptions(reg, opts...)
+		en.RegisterValidator("file", SafePath("path"))
+		en.RegisterValidatorWhere("summary", map[string]string{"lang": "en", "tone": "dry"}, RequiredAttrs("id"))
+		return en
+	}
+
+	a, b := build(false), build(true)
+	if a.ConfigFingerprint() != b.ConfigFingerprint() || len(a.ConfigFingerprint()) != 64 {
+		t.Fatalf("fingerprints differ: %s vs %s", a.ConfigFingerprint(), b.ConfigFingerprint())
+	}
+	if c := build(false, WithMaxEvents(10)); c.ConfigFingerprint() == a.ConfigFingerprint() {
+		t.Fatalf("a changed limit kept the fingerprint")
+	}
+	if f := build(false, WithRegistrySync(RegistrySnapshot)).DescribeConfig().Features; !strings.Contains(strings.Join(f, " "), "registry_sync=snapshot") {
+		t.Fatalf("registry sync should be named, got %q", f)
+	}
+
+	d := a.DescribeConfig()
+	if d.Policies["recovery_mode"] != "continue" || d.Sections[2].Name != "write-file" ||
+		strings.Join(d.Sections[2].Aliases, ",") != "create-file,file" || d.Sections[2].Options[0] != "truncate_at=65536" {
+		t.Fatalf("unexpected description %+v", d)
+	}
+	if got := d.Validators["summary"]; len(got) != 1 || got[0] != `required_attrs id where lang="en",tone="dry"` {
+		t.Fatalf("unexpected validators %q", got)
+	}
+	if _, err := json.Marshal(d); err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+}
+
+func Test_DescribeConfig_Should_Not_Reveal_Variable_Values(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "run"})
+	a := NewEngineWithOptions(reg, WithVariables(map[string]string{"api_token": "s3cr3t-value"}))
+	b := NewEngineWithOptions(reg, WithVariables(map[string]string{"api_token": "other-value"}))
+
+	data, err := json.Marshal(a.DescribeConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t") || !strings.Contains(string(data), "variable api_token sha256:") {
+		t.Fatalf("description = %s", data)
+	}
+	if a.ConfigFingerprint() == b.ConfigFingerprint() {
+		t.Fatal("different values should still give different fingerprints")
+	}
+}
@@ -0,0 +1,101 @@
+package promptweaver
+
+import (
+	"slices"
+	"strings"
+)
+
+// ContextSection designates a wrapper tag, such as <project root="apps/web">, whose
+// attributes apply to the sections inside it. A context section emits no event of its own
+// and its body is parsed as usual.
+type ContextSection struct {
+	// Name is the wrapper's tag name, or a registered alias of it. The wrapper does not
+	// need to be registered.
+	Name string
+
+	// Inherit lists the attributes passed on to inner sections. Empty passes all of them.
+	Inherit []string
+}
+
+// contextFrame is an open context section.
+type contextFrame struct {
+	name  string // canonical (or lowercased) wrapper name
+	attrs map[string]string
+}
+
+// resolveContextSections keys context sections by canonical name.
+func resolveContextSections(reg *Registry, sections []ContextSection) map[string]ContextSection {
+	if len(sections) == 0 {
+		return nil
+	}
+	out := make(map[string]ContextSection, len(sections))
+	for _, cs := range sections {
+		inherit := make([]string, len(cs.Inherit))
+		for i, k := range cs.Inherit {
+			inherit[i] = strings.ToLower(k) // attribute keys are lowercased
+		}
+		cs.Inherit = inherit
+		out[canonicalOrLower(reg, cs.Name)] = cs
+	}
+	return out
+}
+
+func canonicalOrLower(reg *Registry, name string) string {
+	if c, ok := reg.Canonical(name); ok {
+		return c
+	}
+	return strings.ToLower(name)
+}
+
+// contextTag handles the tags of context sections: an opening tag pushes its attributes and
+// a closing tag pops the innermost open wrapper of that name. It reports whether tok was one.
+func (p *parser) contextTag(tok Token) bool {
+	if p.contexts == nil || (tok.Kind != TokenOpen && tok.Kind != TokenClose && tok.Kind != TokenSelfClose) {
+		return false
+	}
+	name := canonicalOrLower(p.reg, tok.Name)
+	cs, ok := p.contexts[name]
+	if !ok {
+		return false
+	}
+	switch tok.Kind {
+	case TokenOpen:
+		attrs := map[string]string{}
+		for k, v := range tok.Attrs {
+			if len(cs.Inherit) == 0 || slices.Contains(cs.Inherit, strings.ToLower(k)) {
+				attrs[k] = v
+			}
+		}
+		p.contextStack = append(p.contextStack, contextFrame{name: name, attrs: attrs})
+	case TokenClose:
+		for i := len(p.contextStack) - 1; i >= 0; i-- {
+			if p.contextStack[i].name == name {
+				p.contextStack = p.contextStack[:i]
+				return true
+			}
+		}
+		return false // nothing to pop: an unmatched closer
+	}
+	return true
+}
+
+// inheritAttrs merges the attributes of the open context sections into attrs, innermost
+// first. A section's own attribute wins over an inherited one under the same key, unless
+// a ContextAttrPrefix puts inherited attributes under keys of their own.
+func (p *parser) inheritAttrs(attrs map[string]string) map[string]string {
+	if len(p.contextStack) == 0 {
+		return attrs
+	}
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	for i := len(p.contextStack) - 1; i >= 0; i-- {
+		for k, v := range p.contextStack[i].attrs {
+			k = p.contextPrefix + k
+			if _, ok := lookupAttr(attrs, k); !ok {
+				attrs[k] = v
+			}
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,57 @@
+package promptweaver
+
+import (
+	"errors"
+	"maps"
+	"testing"
+)
+
+func Test_Engine_Should_Inherit_Attributes_From_Context_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "project"}) // a registered wrapper is still a context
+
+	input := `<project root="apps/web" owner="ui">` +
+		`<create-file path="src/a.ts">A</create-file>` +
+		`<project root="apps/web/sub"><create-file path="b.ts" owner="me"/></project>` +
+		`<create-file path="c.ts" root="custom">C</create-file>` +
+		`</project>` +
+		`<create-file path="d.ts">D</create-file>`
+
+	en := NewEngineWithOptions(reg, WithContextSection("project", "root", "Owner"))
+	for _, chunk := range []int{1, 7, len(input)} {
+		rec := &recorderSink{}
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 4 {
+			t.Fatalf("chunk %d: expected 4 create-file events and no project events, got %+v", chunk, rec.events)
+		}
+		want := []map[string]string{
+			{"path": "src/a.ts", "root": "apps/web", "owner": "ui"},
+			{"path": "b.ts", "root": "apps/web/sub", "owner": "me"},
+			{"path": "c.ts", "root": "custom", "owner": "ui"},
+			{"path": "d.ts"},
+		}
+		for i, ev := range rec.events {
+			if got := ev.(SectionEvent).Attrs; !maps.Equal(got, want[i]) {
+				t.Fatalf("chunk %d: event %d attrs %v, want %v", chunk, i, got, want[i])
+			}
+		}
+	}
+
+	rec := &recorderSink{}
+	en = NewEngineWithOptions(reg, WithContextSection("project"), WithContextAttrPrefix("_ctx_"))
+	if err := en.ProcessStream(ReaderFromString(`<project root="r"><create-file root="own">x</create-file>`), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if got := rec.events[0].(SectionEvent).Attrs; !maps.Equal(got, map[string]string{"root": "own", "_ctx_root": "r"}) {
+		t.Fatalf("unexpected prefixed attrs %v", got)
+	}
+
+	err := en.ProcessStream(ReaderFromString(`</project>`), NewHandlerSink())
+	var unmatched *UnmatchedTagError
+	if !errors.As(err, &unmatched) {
+		t.Fatalf("a wrapper closer with nothing open is unmatched, got %v", err)
+	}
+}
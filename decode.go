@@ -0,0 +1,64 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMissingAttribute is returned by DecodeAttrJSON when the section has no such attribute.
+var ErrMissingAttribute = errors.New("missing attribute")
+
+// DecodeAttrJSON decodes the JSON held by attribute name into v. Both spellings work:
+// config='{"replicas":3}' (escaped quotes inside the value are unescaped) and the braced
+// config={{"replicas":3}}, whose outer braces are stripped.
+func (ev SectionEvent) DecodeAttrJSON(name string, v any) error {
+	val, ok := ev.Attr(name)
+	if !ok {
+		return fmt.Errorf("section %q: %w %q", ev.Name, ErrMissingAttribute, name)
+	}
+	if err := decodeJSON(attrJSONCandidates(val), v); err != nil {
+		return fmt.Errorf("section %q, attribute %q: %w", ev.Name, name, err)
+	}
+	return nil
+}
+
+// DecodeContentJSON decodes the section body, which must be a single JSON value, into v.
+func (ev SectionEvent) DecodeContentJSON(v any) error {
+	if err := decodeJSON([]string{trimJSON(ev.Content)}, v); err != nil {
+		return fmt.Errorf("section %q content: %w", ev.Name, err)
+	}
+	return nil
+}
+
+// attrJSONCandidates lists the readings of an attribute value that may hold its JSON, most
+// literal first. Quoted and braced values are indistinguishable once parsed, so a value like
+// {"a":1} is tried as is before its braces are stripped.
+func attrJSONCandidates(val string) []string {
+	val = trimJSON(val)
+	unescaped := strings.NewReplacer(`\"`, `"`, `\'`, `'`).Replace(val)
+	out := []string{val, unescaped}
+	for _, c := range []string{val, unescaped} {
+		if len(c) >= 2 && c[0] == '{' && c[len(c)-1] == '}' {
+			out = append(out, trimJSON(c[1:len(c)-1]))
+		}
+	}
+	return out
+}
+
+// decodeJSON unmarshals the first valid candidate into v. If none is valid, the error is
+// the one for the first candidate.
+func decodeJSON(candidates []string, v any) error {
+	for _, c := range candidates {
+		if json.Valid([]byte(c)) {
+			return json.Unmarshal([]byte(c), v)
+		}
+	}
+	return json.Unmarshal([]byte(candidates[0]), v)
+}
+
+// trimJSON drops surrounding whitespace and a UTF-8 byte order mark.
+func trimJSON(s string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "\uFEFF"))
+}
@@ -0,0 +1,62 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type deployConfig struct {
+	Region   string `json:"region"`
+	Replicas int    `json:"replicas"`
+}
+
+func Test_SectionEvent_Should_Decode_Attr_JSON_In_Every_Spelling(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "deploy"})
+	input := `<deploy a='{"region":"eu","replicas":3}' b="{\"region\":\"eu\",\"replicas\":3}" ` +
+		`c={ {"region": "eu", "replicas": 3} } d={"region":"eu","replicas":3} e=' ` + "\uFEFF" + `{"region":"eu","replicas":3} '/>`
+	sink, got := newSinkCatcher("deploy")
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	ev := (*got)[0]
+	for _, name := range []string{"a", "B", "c", "d", "e"} {
+		var cfg deployConfig
+		if err := ev.DecodeAttrJSON(name, &cfg); err != nil {
+			t.Fatalf("attr %s: %v", name, err)
+		}
+		if cfg != (deployConfig{Region: "eu", Replicas: 3}) {
+			t.Fatalf("attr %s: got %+v", name, cfg)
+		}
+	}
+
+	var n int
+	if err := (SectionEvent{Attrs: map[string]string{"n": "{42}"}}).DecodeAttrJSON("n", &n); err != nil || n != 42 {
+		t.Fatalf("braced scalar: %d, %v", n, err)
+	}
+}
+
+func Test_SectionEvent_Should_Report_Decode_Errors_With_Context(t *testing.T) {
+	ev := SectionEvent{Name: "deploy", Attrs: map[string]string{"config": "{region: eu}"}}
+	var cfg deployConfig
+	err := ev.DecodeAttrJSON("config", &cfg)
+	if err == nil || !strings.Contains(err.Error(), `"deploy"`) || !strings.Contains(err.Error(), `"config"`) {
+		t.Fatalf("expected an error naming section and attribute, got %v", err)
+	}
+	if err := ev.DecodeAttrJSON("other", &cfg); !errors.Is(err, ErrMissingAttribute) {
+		t.Fatalf("expected ErrMissingAttribute, got %v", err)
+	}
+}
+
+func Test_SectionEvent_Should_Decode_Content_JSON(t *testing.T) {
+	ev := SectionEvent{Name: "json", Content: "\uFEFF\n  {\"region\": \"us\", \"replicas\": 1}\n"}
+	var cfg deployConfig
+	if err := ev.DecodeContentJSON(&cfg); err != nil || cfg.Region != "us" || cfg.Replicas != 1 {
+		t.Fatalf("got %+v, %v", cfg, err)
+	}
+	ev.Content = "{nope"
+	if err := ev.DecodeContentJSON(&cfg); err == nil || !strings.Contains(err.Error(), `"json"`) {
+		t.Fatalf("expected an error naming the section, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ChoiceStreamMetaKey is the StreamMeta key under which Demux records the choice index.
+const ChoiceStreamMetaKey = "choice"
+
+// ErrDemuxAborted ends the other choices of a Demux built with DemuxFailFast once one fails.
+var ErrDemuxAborted = errors.New("demux aborted")
+
+// ChoiceError is an error from one choice of a Demux.
+type ChoiceError struct {
+	Choice int
+	Err    error
+}
+
+func (e *ChoiceError) Error() string { return fmt.Sprintf("choice %d: %v", e.Choice, e.Err) }
+
+func (e *ChoiceError) Unwrap() error { return e.Err }
+
+// Demux parses several interleaved streams, such as the choices of an n>1 completion, with
+// one engine. Each choice has its own parser, sink and error state, and its events carry the
+// choice index in StreamMeta[ChoiceStreamMetaKey]. It is safe for concurrent use.
+type Demux struct {
+	engine   *Engine
+	sinks    func(choice int) EventSink
+	failFast bool
+
+	mu      sync.Mutex
+	streams map[int]*stream
+	errs    map[int]error // choices that have ended, with their error (nil when closed cleanly)
+}
+
+// DemuxOption configures a Demux.
+type DemuxOption func(*Demux)
+
+// DemuxFailFast ends every other choice, with ErrDemuxAborted, as soon as one fails.
+// By default choices fail independently.
+func DemuxFailFast() DemuxOption {
+	return func(d *Demux) { d.failFast = true }
+}
+
+// NewDemux returns a Demux that parses with engine and sends the events of each choice to
+// the sink sinkFactory returns for it, asked once, when the choice is first fed.
+func NewDemux(engine *Engine, sinkFactory func(choice int) EventSink, opts ...DemuxOption) *Demux {
+	d := &Demux{engine: engine, sinks: sinkFactory, streams: map[int]*stream{}, errs: map[int]error{}}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Feed parses the next bytes of a choice. Once a choice has failed, Feed returns its error
+// and ignores the data.
+func (d *Demux) Feed(choice int, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err, done := d.errs[choice]; done {
+		if err == nil {
+			return &ChoiceError{Choice: choice, Err: errors.New("fed after CloseAll")}
+		}
+		return err
+	}
+	s, err := d.stream(choice)
+	if err == nil {
+		err = s.push(data)
+	}
+	if err != nil {
+		return d.fail(choice, s, err)
+	}
+	return nil
+}
+
+// Inject delivers ev to a choice as if its tag had been parsed at this point of the stream
+// (see Engine.Inject), so it takes the next Seq and goes through the choice's pairings and
+// references along with the parsed sections. A section still open in the parsed input is
+// delivered after it. Errors end the choice as Feed's do.
+func (d *Demux) Inject(choice int, ev SectionEvent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err, done := d.errs[choice]; done {
+		if err == nil {
+			return &ChoiceError{Choice: choice, Err: errors.New("injected after CloseAll")}
+		}
+		return err
+	}
+	s, err := d.stream(choice)
+	if err == nil {
+		err = s.p.inject(ev)
+	}
+	if err != nil {
+		return d.fail(choice, s, err)
+	}
+	return nil
+}
+
+// FlushText emits the text of a choice held for a PlainText section now, as a section of
+// its own, instead of waiting for the next tag (see EngineOptions.PlainText). Bytes the
+// parser cannot classify yet, such as a trailing "<" that may start a tag, stay held. It
+// does nothing without PlainText or before the choice is first fed. Errors end the choice
+// as Feed's do.
+func (d *Demux) FlushText(choice int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err, done := d.errs[choice]; done {
+		if err == nil {
+			return &ChoiceError{Choice: choice, Err: errors.New("flushed after CloseAll")}
+		}
+		return err
+	}
+	s, ok := d.streams[choice]
+	if !ok {
+		return nil
+	}
+	if err := s.p.flushPlainText(); err != nil {
+		return d.fail(choice, s, err)
+	}
+	return nil
+}
+
+// CloseAll ends every choice that is still open, emitting what EOF emits, and returns the
+// errors of all failed choices, in choice order, joined.
+func (d *Demux) CloseAll() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, choice := range d.open() {
+		s := d.streams[choice]
+		delete(d.streams, choice)
+		if err := s.end(s.close()); err != nil {
+			d.errs[choice] = &ChoiceError{Choice: choice, Err: err}
+		} else {
+			d.errs[choice] = nil
+		}
+	}
+	choices := make([]int, 0, len(d.errs))
+	for choice := range d.errs {
+		choices = append(choices, choice)
+	}
+	sort.Ints(choices)
+	var errs []error
+	for _, choice := range choices {
+		if d.errs[choice] != nil {
+			errs = append(errs, d.errs[choice])
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stream returns the parser of a choice, starting it on first use.
+func (d *Demux) stream(choice int) (*stream, error) {
+	if s, ok := d.streams[choice]; ok {
+		return s, nil
+	}
+	sink := d.sinks(choice)
+	if err := d.engine.checkSink(sink); err != nil {
+		return nil, err
+	}
+	options := d.engine.options
+	meta := make(StreamMeta, len(options.StreamMeta)+1)
+	for k, v := range options.StreamMeta {
+		meta[k] = v
+	}
+	meta[ChoiceStreamMetaKey] = strconv.Itoa(choice)
+	options.StreamMeta = meta
+	s := d.engine.startStream(context.Background(), sink, options, d.engine.validators)
+	d.streams[choice] = s
+	return s, nil
+}
+
+// fail ends a choice with err and, with DemuxFailFast, every other open choice too.
+func (d *Demux) fail(choice int, s *stream, err error) error {
+	if s != nil {
+		delete(d.streams, choice)
+		err = s.end(err)
+	}
+	cerr := &ChoiceError{Choice: choice, Err: err}
+	d.errs[choice] = cerr
+	if d.failFast {
+		for _, other := range d.open() {
+			o := d.streams[other]
+			delete(d.streams, other)
+			d.errs[other] = &ChoiceError{Choice: other, Err: o.end(ErrDemuxAborted)}
+		}
+	}
+	return cerr
+}
+
+// open lists the open choices in order.
+func (d *Demux) open() []int {
+	out := make([]int, 0, len(d.streams))
+	for choice := range d.streams {
+		out = append(out, choice)
+	}
+	sort.Ints(out)
+	return out
+}
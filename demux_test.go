@@ -0,0 +1,80 @@
+package promptweaver
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func Test_Demux_Should_Parse_Interleaved_Choices_Independently(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngineWithOptions(reg, WithStreamMeta(StreamMeta{"request": "r1"}))
+	sinks := map[int]*recorderSink{}
+	d := NewDemux(en, func(choice int) EventSink {
+		sinks[choice] = &recorderSink{}
+		return sinks[choice]
+	})
+
+	feeds := []struct {
+		choice int
+		data   string
+	}{
+		{0, "<summ"}, {1, "<summary>b"}, {2, "</nope>"}, {0, "ary>a</summary>"}, {2, "<summary>c</summary>"},
+		{1, "ee</summary><summary>left open"},
+	}
+	var failed error
+	for _, f := range feeds {
+		if err := d.Feed(f.choice, []byte(f.data)); err != nil {
+			failed = err
+		}
+	}
+	var cerr *ChoiceError
+	var unmatched *UnmatchedTagError
+	if !errors.As(failed, &cerr) || cerr.Choice != 2 || !errors.As(failed, &unmatched) {
+		t.Fatalf("expected choice 2 to fail alone, got %v", failed)
+	}
+	if err := d.CloseAll(); !errors.As(err, &cerr) || cerr.Choice != 2 {
+		t.Fatalf("CloseAll must report the failed choice, got %v", err)
+	}
+
+	want := map[int][]string{0: {"a"}, 1: {"bee", "left open"}, 2: nil}
+	for choice, contents := range want {
+		evs := sinks[choice].events
+		if len(evs) != len(contents) {
+			t.Fatalf("choice %d: expected %d events, got %+v", choice, len(contents), evs)
+		}
+		for i, ev := range evs {
+			sev := ev.(SectionEvent)
+			if sev.Content != contents[i] || sev.StreamMeta[ChoiceStreamMetaKey] != strconv.Itoa(choice) || sev.StreamMeta["request"] != "r1" {
+				t.Fatalf("choice %d: unexpected event %+v", choice, sev)
+			}
+		}
+	}
+	if err := d.Feed(0, []byte("more")); err == nil {
+		t.Fatal("feeding a closed choice must fail")
+	}
+}
+
+func Test_Demux_Should_Abort_All_Choices_When_Fail_Fast(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	ends := map[int]error{}
+	d := NewDemux(NewEngine(reg), func(choice int) EventSink {
+		return &endRecorder{onEnd: func(err error) { ends[choice] = err }}
+	}, DemuxFailFast())
+
+	d.Feed(0, []byte("<summary>a"))
+	d.Feed(1, []byte("</bad>"))
+	if err := d.Feed(0, []byte("</summary>")); !errors.Is(err, ErrDemuxAborted) {
+		t.Fatalf("expected choice 0 to be aborted, got %v", err)
+	}
+	if !errors.Is(ends[0], ErrDemuxAborted) || ends[1] == nil {
+		t.Fatalf("every choice's sink must see its end, got %v", ends)
+	}
+}
+
+type endRecorder struct{ onEnd func(error) }
+
+func (r *endRecorder) OnEvent(Event)         {}
+func (r *endRecorder) OnStreamEnd(err error) { r.onEnd(err) }
@@ -0,0 +1,69 @@
+package promptweaver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+)
+
+// DigestEvent is the last event of a stream read with WithStreamDigest. Sum is the hash of
+// every byte read from the reader, exactly as read, whether or not it ended up in an event;
+// it is the digest of what WithRawCapture stores.
+type DigestEvent struct {
+	EventBase
+	Sum   []byte `json:"sum"`
+	Bytes int64  `json:"bytes"` // bytes hashed
+}
+
+// Kind implements Event.
+func (DigestEvent) Kind() EventKind { return KindDigest }
+
+func (ev DigestEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// Hex returns Sum hex-encoded.
+func (ev DigestEvent) Hex() string { return hex.EncodeToString(ev.Sum) }
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev DigestEvent) MarshalJSON() ([]byte, error) {
+	type plain DigestEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// AsDigest returns ev as a DigestEvent, if it is one.
+func AsDigest(ev Event) (DigestEvent, bool) {
+	dev, ok := ev.(DigestEvent)
+	return dev, ok
+}
+
+// streamDigest hashes the raw stream as it is read.
+type streamDigest struct {
+	h     hash.Hash
+	bytes int64
+}
+
+func newStreamDigest(options EngineOptions) *streamDigest {
+	if options.StreamDigest == nil {
+		return nil
+	}
+	return &streamDigest{h: options.StreamDigest()}
+}
+
+func (d *streamDigest) Write(b []byte) (int, error) {
+	d.bytes += int64(len(b))
+	return d.h.Write(b)
+}
+
+// emitDigest emits the DigestEvent of a stream that reached EOF.
+func (p *parser) emitDigest(d *streamDigest) error {
+	if d == nil {
+		return nil
+	}
+	return p.emit(DigestEvent{
+		EventBase: EventBase{StartPos: Position{Line: 1, Column: 1}, EndPos: p.pos},
+		Sum:       d.h.Sum(nil),
+		Bytes:     d.bytes,
+	})
+}
@@ -0,0 +1,41 @@
+package promptweaver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func Test_Engine_Should_Digest_The_Raw_Stream(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "preamble <unknown>dropped</unknown> <summary>done</summary> trailing"
+
+	var captured bytes.Buffer
+	en := NewEngineWithOptions(reg, WithStreamDigest(sha256.New), WithRawCapture(&captured), WithContinueMode())
+	rec := &recorderSink{}
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 7}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("expected the section and the digest, got %+v", rec.events)
+	}
+	ev, ok := AsDigest(rec.events[1])
+	if !ok {
+		t.Fatalf("the digest must be the last event, got %+v", rec.events[1])
+	}
+	want := sha256.Sum256([]byte(input))
+	if !bytes.Equal(ev.Sum, want[:]) || ev.Bytes != int64(len(input)) || ev.Seq != 2 {
+		t.Fatalf("unexpected digest %+v", ev)
+	}
+	if got := sha256.Sum256(captured.Bytes()); got != want {
+		t.Fatal("the digest must match the raw capture")
+	}
+
+	b, _ := json.Marshal(ev)
+	back, err := UnmarshalEvent(b)
+	if dev, ok := back.(DigestEvent); err != nil || !ok || dev.Hex() != ev.Hex() {
+		t.Fatalf("round trip failed: %v %+v", err, back)
+	}
+}
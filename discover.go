@@ -0,0 +1,115 @@
+package promptweaver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// SectionSummary aggregates what Discover saw for one canonical section name.
+type SectionSummary struct {
+	Count    int      // number of events that would be emitted
+	Bytes    int      // total content bytes across those events
+	AttrKeys []string // attribute keys seen on any occurrence, sorted
+}
+
+// DiscoveryReport describes what a stream would produce, without running any handlers.
+type DiscoveryReport struct {
+	Sections    map[string]*SectionSummary // keyed by canonical section name
+	UnknownTags []string                   // unregistered tag names, in order of first appearance
+	Errors      []error                    // parse errors the parser recovered from
+}
+
+// discoverySink collects events into a DiscoveryReport.
+type discoverySink struct {
+	report *DiscoveryReport
+	keys   map[string]map[string]bool
+}
+
+func (s *discoverySink) OnEvent(e Event) {
+	ev, ok := e.(SectionEvent)
+	if !ok {
+		return
+	}
+	sum, ok := s.report.Sections[ev.Name]
+	if !ok {
+		sum = &SectionSummary{}
+		s.report.Sections[ev.Name] = sum
+		s.keys[ev.Name] = map[string]bool{}
+	}
+	sum.Count++
+	sum.Bytes += len(ev.Content)
+	for k := range ev.Attrs {
+		if !s.keys[ev.Name][k] {
+			s.keys[ev.Name][k] = true
+			sum.AttrKeys = append(sum.AttrKeys, k)
+			sort.Strings(sum.AttrKeys)
+		}
+	}
+}
+
+// Discover runs r through the normal parser with a collecting sink and reports which sections
+// would be emitted. No sink or handlers are needed. Errors are recorded and parsing continues,
+// regardless of the engine's RecoveryMode or ErrorHandler; validators still run, so a section
+// that fails validation is reported as an error rather than counted.
+// The returned error is only set for failures that stop parsing altogether (e.g. read errors).
+func (e *Engine) Discover(r io.Reader) (DiscoveryReport, error) {
+	report := DiscoveryReport{Sections: map[string]*SectionSummary{}}
+	sink := &discoverySink{report: &report, keys: map[string]map[string]bool{}}
+
+	seen := map[string]bool{}
+	options := e.options
+	options.ErrorHandler = func(err error) bool {
+		report.Errors = append(report.Errors, err)
+		return true
+	}
+	options.UnknownTagHandler = func(name string, _ Position) {
+		name = strings.ToLower(name)
+		if !seen[name] {
+			seen[name] = true
+			report.UnknownTags = append(report.UnknownTags, name)
+		}
+	}
+
+	err := e.run(context.Background(), r, sink, options, e.validators)
+	return report, err
+}
+
+// String renders the report as a human-readable table.
+func (d DiscoveryReport) String() string {
+	var b strings.Builder
+
+	names := make([]string, 0, len(d.Sections))
+	for name := range d.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SECTION\tCOUNT\tBYTES\tATTRS")
+	for _, name := range names {
+		sum := d.Sections[name]
+		attrs := "-"
+		if len(sum.AttrKeys) > 0 {
+			attrs = strings.Join(sum.AttrKeys, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", name, sum.Count, sum.Bytes, attrs)
+	}
+	_ = tw.Flush()
+
+	if len(d.UnknownTags) > 0 {
+		fmt.Fprintf(&b, "unknown tags: %s\n", strings.Join(d.UnknownTags, ", "))
+	}
+	if len(d.Errors) > 0 {
+		fmt.Fprintf(&b, "recovered errors: %d\n", len(d.Errors))
+		for _, err := range d.Errors {
+			// Only the first line; the rendered context is too noisy for a summary.
+			msg, _, _ := strings.Cut(err.Error(), "\n")
+			fmt.Fprintf(&b, "  - %s\n", msg)
+		}
+	}
+	return b.String()
+}
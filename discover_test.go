@@ -0,0 +1,89 @@
+package promptweaver
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Discover_Should_Summarize_Sections_Without_Handlers(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	en := NewEngine(reg)
+	input := `<create-file path="a.go" type="x">abc</create-file>` +
+		`<div>ignored<img/>` +
+		`<write-file path="b.go">de</write-file>` +
+		`</bogus><summary>done</summary>`
+	report, err := en.Discover(ReaderFromString(input))
+	if err != nil {
+		t.Fatalf("Discover error: %v", err)
+	}
+
+	wf := report.Sections["write-file"]
+	if wf == nil || wf.Count != 2 || wf.Bytes != 5 {
+		t.Fatalf("unexpected write-file summary: %+v", wf)
+	}
+	if strings.Join(wf.AttrKeys, ",") != "path,type" {
+		t.Fatalf("unexpected attr keys: %v", wf.AttrKeys)
+	}
+	if s := report.Sections["summary"]; s == nil || s.Count != 1 || s.Bytes != 4 {
+		t.Fatalf("unexpected summary summary: %+v", s)
+	}
+	if strings.Join(report.UnknownTags, ",") != "div,img" {
+		t.Fatalf("unexpected unknown tags: %v", report.UnknownTags)
+	}
+	if len(report.Errors) != 1 {
+		t.Fatalf("want 1 recovered error, got %d", len(report.Errors))
+	}
+	if _, ok := report.Errors[0].(*UnmatchedTagError); !ok {
+		t.Fatalf("expected UnmatchedTagError, got %T", report.Errors[0])
+	}
+}
+
+func Test_Discover_Should_Agree_With_ProcessStream(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	en := NewEngine(reg)
+	sink, got := newSinkCatcher("think", "write-file", "summary")
+	if err := en.ProcessStream(ReaderFromString(src), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	report, err := en.Discover(ReaderFromString(src))
+	if err != nil {
+		t.Fatalf("Discover error: %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, ev := range *got {
+		counts[ev.Name]++
+	}
+	for name, n := range counts {
+		if report.Sections[name] == nil || report.Sections[name].Count != n {
+			t.Fatalf("section %q: ProcessStream saw %d, Discover reported %+v", name, n, report.Sections[name])
+		}
+	}
+	if len(report.Sections) != len(counts) {
+		t.Fatalf("Discover reported extra sections: %v", report)
+	}
+}
+
+func Test_DiscoveryReport_String_Renders_Table(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	en := NewEngine(reg)
+	report, err := en.Discover(ReaderFromString(`<summary k="v">done</summary><x/></oops>`))
+	if err != nil {
+		t.Fatalf("Discover error: %v", err)
+	}
+	out := report.String()
+	for _, want := range []string{"SECTION", "summary", "k", "unknown tags: x", "recovered errors: 1", "</oops>"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("report missing %q:\n%s", want, out)
+		}
+	}
+}
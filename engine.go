@@ -2,35 +2,202 @@ package promptweaver
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"sort"
 	"strings"
+	"time"
 )
 
 // SectionPlugin declares a tag name that the engine should recognize and emit.
 type SectionPlugin struct {
 	Name    string
 	Aliases []string
+
+	// NormalizeEmpty collapses whitespace-only bodies to "", so <x></x>, <x>\n</x> and <x/>
+	// all emit the same event.
+	NormalizeEmpty bool
+
+	// RejectEmpty reports a ValidationError for sections whose content is empty
+	// (after NormalizeEmpty, if set). The zero value allows empty sections.
+	RejectEmpty bool
+
+	// ParseFencesInBody emits CodeBlockEvents for fenced blocks inside this section's body,
+	// before the section itself. Content is unaffected: the fences stay in it verbatim.
+	// The zero value leaves fences in the body alone, as a file's markdown should be.
+	ParseFencesInBody bool
+
+	// IncludeRawEnvelope fills SectionEvent.Raw for this section (see EngineOptions).
+	IncludeRawEnvelope bool
+
+	// NoVariables turns off {{name}} expansion (see EngineOptions.Variables) for sections
+	// whose bodies legitimately contain mustache syntax, such as file templates.
+	NoVariables bool
+
+	// StrictBody reports the closing tag of another registered section inside this section's
+	// body as an UnexpectedClosingTagError instead of keeping it as text. Prose sections want
+	// this, since such a closer almost always means the model mismatched its tags; code
+	// sections, whose bodies may quote tags, do not. When the error is recovered from, the
+	// section is closed where the stray closer starts and the closer is handled on its own.
+	StrictBody bool
+
+	// Suppress discards the section: its content is counted but never buffered, validators
+	// are skipped and no event is emitted. EngineOptions.SuppressHandler is told about it.
+	Suppress bool
+
+	// TruncateAt, if positive, keeps only the first TruncateAt bytes of the body (cut back to
+	// a whole UTF-8 sequence). The rest is scanned for the closing tag but not buffered, and
+	// the event is marked Truncated. Validators see the truncated content.
+	TruncateAt int
+
+	// TruncationMarker is appended to the content of truncated sections, after validation.
+	TruncationMarker string
+
+	// RawUntil names an attribute that, when the opening tag carries it, holds a terminator
+	// ending the body instead of the closing tag, like a heredoc: <shell eof="END_7f3a">
+	// ... END_7f3a. Nothing in such a body is a tag, not even the section's own closer.
+	RawUntil string
+
+	// RawDelimiter recognizes the closing tag only on a line of its own: right after a
+	// newline (or the opening tag) and followed by the end of the line, so a closer quoted
+	// inside a line of the body stays text. Fences in the body are not parsed.
+	RawDelimiter bool
+
+	// OnOpen, if set, is called as soon as the section's opening tag has been parsed, before
+	// any of its body, e.g. to open the file named by a path attribute. Self-closing tags
+	// call it right before their event. A returned error goes through the engine's error
+	// handling; if recovered from, the section is aborted: its body is skipped and no event
+	// is emitted. It is not called for suppressed or rescued sections.
+	OnOpen OpenHook
+
+	// AttrDefaults fills in attributes the opening tag leaves out, such as mode="0644",
+	// before OnOpen, validators and handlers see them. An attribute that is present keeps
+	// its value, even "". SectionEvent.DefaultedAttrs lists the keys that were filled in.
+	AttrDefaults map[string]string
+
+	// Command parses the content as a command line, filling SectionEvent.Argv (see
+	// ParseCommand). Content that does not parse is a ValidationError at its place in the
+	// body, checked before the validators.
+	Command bool
+
+	// EmitIntent announces the section with an IntentEvent when its opening tag is parsed,
+	// so that a sink can veto it before any content is processed.
+	EmitIntent bool
+
+	// ContentTransforms rewrite the content, each one's output feeding the next, such as
+	// to strip ANSI escapes from command output. They run after NormalizeEmpty and
+	// variable expansion, and before Command parsing and validators; suppressed and
+	// vetoed sections skip them. An error is a ValidationError at the opening tag. When
+	// they change the content, the body as read is kept in SectionEvent.RawContent.
+	ContentTransforms []func(string) (string, error)
+
+	// LanguageDetector, if set, overrides EngineOptions.LanguageDetector for this section,
+	// even when the engine has none.
+	LanguageDetector LanguageDetector
+
+	// KnownAttrs lists, ignoring case, the attributes the section's opening tag may carry,
+	// for UnknownAttrs. RawUntil and the keys of AttrDefaults are known without being listed.
+	KnownAttrs []string
+
+	// UnknownAttrs decides what happens to attributes written in the opening tag that are
+	// not in KnownAttrs, checked as soon as the tag is parsed, before EmitIntent and OnOpen.
+	// The zero value allows them.
+	UnknownAttrs UnknownAttrPolicy
 }
 
+// OpenHook receives a section's canonical name, attributes (inherited ones included) and
+// the position of its opening tag. It must not modify attrs.
+type OpenHook func(name string, attrs map[string]string, pos Position) error
+
 // SectionEvent is emitted when a registered section is closed (or a self-closing tag is parsed).
+// Its StartPos is the position of the opening tag's '<' and its EndPos the position just past
+// the closing tag (or EOF), so [StartPos.Offset, EndPos.Offset) spans the section in the raw stream.
 type SectionEvent struct {
-	Name    string            // section/tag name
-	Attrs   map[string]string // parsed attributes on the opening tag
-	Content string            // inner text content between <tag> and </tag>
+	EventBase
+	Name    string            `json:"name"`    // section/tag name
+	Attrs   map[string]string `json:"attrs"`   // parsed attributes on the opening tag
+	Content string            `json:"content"` // inner text content between <tag> and </tag>
+
+	// AliasUsed is the name of the opening tag as written in the stream, such as
+	// "Create-File" for the canonical "write-file". Sections made from code blocks (see
+	// EngineOptions.FenceMapping) carry the mapped section name.
+	AliasUsed string `json:"alias_used,omitempty"`
+
+	// Raw is the section exactly as it appeared in the stream, from the opening tag's '<'
+	// through the closing tag's '>' (or EOF). Only set with IncludeRawEnvelope.
+	Raw string `json:"raw,omitempty"`
+
+	// Truncated reports that the body was cut at SectionPlugin.TruncateAt, and OriginalSize
+	// how many body bytes the stream actually had.
+	Truncated    bool `json:"truncated,omitempty"`
+	OriginalSize int  `json:"original_size,omitempty"`
+
+	// Rescued marks a section taken out of the body of an unclosed section at EOF
+	// (see EngineOptions.OrphanRescue).
+	Rescued bool `json:"rescued,omitempty"`
+
+	// ContentKind is a guess at what Content holds, set with EngineOptions.ContentSniffing.
+	ContentKind ContentKind `json:"content_kind,omitempty"`
+
+	// DefaultedAttrs lists, sorted, the attributes filled in from EngineOptions.DefaultAttrs
+	// or SectionPlugin.AttrDefaults rather than written in the tag.
+	DefaultedAttrs []string `json:"defaulted_attrs,omitempty"`
+
+	// Argv is the content split into words, for plugins with SectionPlugin.Command.
+	Argv []string `json:"argv,omitempty"`
+
+	// Supersedes is the Seq of the earlier section this one revises, named by its revision
+	// attribute (see EngineOptions.RevisionAttr). A SupersededEvent follows the section.
+	Supersedes int64 `json:"supersedes,omitempty"`
+
+	// RawContent is the body as read, set when SectionPlugin.ContentTransforms changed it.
+	RawContent string `json:"raw_content,omitempty"`
+
+	// DetectedLanguage is the language of Content, such as "go", named by the section's
+	// LanguageDetector or EngineOptions.LanguageDetector. It is mirrored in Attrs under
+	// LanguageAttr.
+	DetectedLanguage string `json:"detected_language,omitempty"`
 }
 
+// Kind implements Event.
+func (SectionEvent) Kind() EventKind { return KindSection }
+
+func (ev SectionEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
 // Registry holds enabled section names. It maps aliases -> canonical name.
-type Registry struct{ canon map[string]string }
+type Registry struct {
+	canon     map[string]string
+	plugins   map[string]SectionPlugin // canonical name -> plugin definition
+	conflicts []RegistryConflict       // names taken over by a later plugin
+	gen       uint64                   // registrations so far
+}
+
+func NewRegistry() *Registry {
+	return &Registry{canon: map[string]string{}, plugins: map[string]SectionPlugin{}}
+}
 
-func NewRegistry() *Registry { return &Registry{canon: map[string]string{}} }
+// Register enables a plugin. It panics if the plugin's name or an alias is reserved for
+// synthesized sections. A name or alias already taken by another plugin is taken over, and
+// the collision is recorded in Conflicts; use RegisterE to get errors instead.
 func (r *Registry) Register(p SectionPlugin) {
+	if err := checkReserved(p); err != nil {
+		panic("promptweaver: " + err.Error())
+	}
+	r.register(p)
+}
+
+func (r *Registry) register(p SectionPlugin) {
 	if p.Name == "" {
 		return
 	}
 	canon := strings.ToLower(p.Name)
+	r.gen++
+	r.conflicts = append(r.conflicts, r.collisions(p)...)
 	r.canon[canon] = canon
+	r.plugins[canon] = p
 	for _, a := range p.Aliases {
 		if a == "" {
 			continue
@@ -44,25 +211,344 @@ func (r *Registry) Canonical(name string) (string, bool) {
 	return c, ok
 }
 
+// Plugin returns the plugin registered for name, which may be the canonical name or an alias.
+func (r *Registry) Plugin(name string) (SectionPlugin, bool) {
+	c, ok := r.Canonical(name)
+	if !ok {
+		return SectionPlugin{}, false
+	}
+	p, ok := r.plugins[c]
+	return p, ok
+}
+
+// List returns the registered plugins, sorted by name.
+func (r *Registry) List() []SectionPlugin {
+	out := make([]SectionPlugin, 0, len(r.plugins))
+	for _, p := range r.plugins {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out
+}
+
+// Errors returned by ProcessStream before any input is read.
+var (
+	ErrNilReader = errors.New("nil reader")
+	ErrNilSink   = errors.New("nil sink")
+)
+
+// ErrUnknownSection is returned by strict registration variants when the name is not registered.
+var ErrUnknownSection = errors.New("unknown section")
+
 // HandlerSink routes events to handlers registered per section name.
-type HandlerSink struct{ handlers map[string]func(SectionEvent) }
+type HandlerSink struct {
+	handlers map[string]ContextHandler
+	modes    map[string]handlerMode // sections whose handler fires once per stream
+	reg      *Registry              // optional; resolves aliases at registration time
+
+	where          map[string][]whereHandler // attribute-conditional handlers, in registration order
+	whereExclusive bool                      // a matching Where handler stands in for the generic one
+
+	codeBlock func(CodeBlockEvent)     // typed handler for fenced code blocks
+	closed    func(SectionClosedEvent) // typed handler for sections that emit no SectionEvent
+	intent    func(IntentEvent) error  // typed handler for IntentEvents; an error vetoes the section
+	vetoed    func(VetoedEvent)        // typed handler for vetoed sections
+	fallback  func(Event)              // events no other handler takes
+
+	onStart func(StreamMeta) // called when a stream begins
+	onEnd   func(error)      // called when a stream is over
+
+	// Per-stream state, cleared by OnStreamStart and OnStreamEnd.
+	fired   map[string]bool         // first-only handlers that already ran
+	last    map[string]SectionEvent // latest event for last-only handlers
+	lastCtx context.Context         // the stream's context, as delivered with the last events
+}
+
+type handlerMode int
+
+const (
+	everyEvent handlerMode = iota
+	firstEvent
+	lastEvent
+)
+
+// ContextHandler handles a section with the context of the stream it came from.
+// A returned error goes through the engine's error handling like a parse error.
+type ContextHandler func(ctx context.Context, ev SectionEvent) error
+
+func NewHandlerSink() *HandlerSink { return &HandlerSink{handlers: map[string]ContextHandler{}} }
+
+// NewHandlerSinkFor creates a HandlerSink that resolves registration names through reg,
+// so a handler registered under any alias fires for the canonical section.
+func NewHandlerSinkFor(reg *Registry) *HandlerSink {
+	s := NewHandlerSink()
+	s.reg = reg
+	return s
+}
 
-func NewHandlerSink() *HandlerSink { return &HandlerSink{handlers: map[string]func(SectionEvent){}} }
 func (s *HandlerSink) RegisterHandler(section string, fn func(SectionEvent)) {
+	if fn == nil {
+		return
+	}
+	s.RegisterHandlerCtx(section, func(_ context.Context, ev SectionEvent) error {
+		fn(ev)
+		return nil
+	})
+}
+
+// RegisterHandlerCtx registers a handler that receives the stream's context (see
+// Engine.ProcessStreamContext) and may fail. It replaces any handler for section, so plain
+// and context-aware handlers can be mixed across sections.
+func (s *HandlerSink) RegisterHandlerCtx(section string, fn ContextHandler) {
+	s.register(section, fn, everyEvent)
+}
+
+// RegisterFirstHandler registers a handler that runs for the first section of its name in
+// each stream; later ones are ignored.
+func (s *HandlerSink) RegisterFirstHandler(section string, fn func(SectionEvent)) {
+	if fn == nil {
+		return
+	}
+	s.register(section, func(_ context.Context, ev SectionEvent) error { fn(ev); return nil }, firstEvent)
+}
+
+// RegisterLastHandler registers a handler that runs once per stream, for the last section of
+// its name, when the stream ends without error (see OnStreamEnd). A failed stream delivers
+// nothing, so a truncated final section is never mistaken for the last word.
+func (s *HandlerSink) RegisterLastHandler(section string, fn func(SectionEvent)) {
+	if fn == nil {
+		return
+	}
+	s.register(section, func(_ context.Context, ev SectionEvent) error { fn(ev); return nil }, lastEvent)
+}
+
+// RegisterLastHandlerCtx is RegisterLastHandler for a handler that receives the stream's
+// context and may fail. Its error is passed to the stream end handler.
+func (s *HandlerSink) RegisterLastHandlerCtx(section string, fn ContextHandler) {
+	s.register(section, fn, lastEvent)
+}
+
+func (s *HandlerSink) register(section string, fn ContextHandler, mode handlerMode) {
 	if section == "" || fn == nil {
 		return
 	}
-	s.handlers[strings.ToLower(section)] = fn
+	if s.handlers == nil { // zero-value HandlerSink
+		s.handlers = map[string]ContextHandler{}
+	}
+	key := s.resolve(section)
+	s.handlers[key] = fn
+	if mode == everyEvent {
+		delete(s.modes, key)
+		return
+	}
+	if s.modes == nil {
+		s.modes = map[string]handlerMode{}
+	}
+	s.modes[key] = mode
+}
+
+// RegisterHandlerStrict is like RegisterHandler but fails with ErrUnknownSection when the sink
+// was built with NewHandlerSinkFor and the registry does not know section. Use it at startup
+// to catch typos in handler names.
+func (s *HandlerSink) RegisterHandlerStrict(section string, fn func(SectionEvent)) error {
+	if s.reg != nil && !s.reg.IsAllowed(section) {
+		return fmt.Errorf("%w: no plugin registered for handler %q", ErrUnknownSection, section)
+	}
+	s.RegisterHandler(section, fn)
+	return nil
+}
+
+// RegisterCodeBlockHandler registers fn for fenced code blocks. It is keyed by event type,
+// so it never competes with a section handler, whatever the section is called.
+func (s *HandlerSink) RegisterCodeBlockHandler(fn func(CodeBlockEvent)) { s.codeBlock = fn }
+
+// RegisterSectionClosedHandler registers fn for SectionClosedEvents (see
+// EngineOptions.SectionClosedEvents).
+func (s *HandlerSink) RegisterSectionClosedHandler(fn func(SectionClosedEvent)) { s.closed = fn }
+
+// RegisterIntentHandler registers fn for IntentEvents (see SectionPlugin.EmitIntent). An
+// error from fn vetoes the section.
+func (s *HandlerSink) RegisterIntentHandler(fn func(IntentEvent) error) { s.intent = fn }
+
+// RegisterVetoedHandler registers fn for VetoedEvents, which replace vetoed sections.
+func (s *HandlerSink) RegisterVetoedHandler(fn func(VetoedEvent)) { s.vetoed = fn }
+
+// RegisterFallbackHandler registers fn for the events no other handler takes: sections
+// without a handler or matching Where handler, and other events without a typed handler.
+func (s *HandlerSink) RegisterFallbackHandler(fn func(Event)) { s.fallback = fn }
+
+// OnEvent implements EventSink by routing section events to Emit and other events to the
+// handler registered for their type.
+func (s *HandlerSink) OnEvent(ev Event) {
+	_ = s.OnEventContext(context.Background(), ev)
+}
+
+// OnEventContext implements ContextSink by routing section events to EmitContext and other
+// events to the handler registered for their type.
+func (s *HandlerSink) OnEventContext(ctx context.Context, ev Event) error {
+	switch ev := ev.(type) {
+	case SectionEvent:
+		return s.EmitContext(ctx, ev)
+	case CodeBlockEvent:
+		if s.codeBlock != nil {
+			s.codeBlock(ev)
+			return nil
+		}
+	case SectionClosedEvent:
+		if s.closed != nil {
+			s.closed(ev)
+			return nil
+		}
+	case IntentEvent:
+		if s.intent != nil {
+			return s.intent(ev)
+		}
+	case VetoedEvent:
+		if s.vetoed != nil {
+			s.vetoed(ev)
+			return nil
+		}
+	}
+	if s.fallback != nil {
+		s.fallback(ev)
+	}
+	return nil
 }
+
+// Emit dispatches ev to its handler, if any. A zero-value HandlerSink has no handlers.
+// Handler errors are dropped; the engine delivers through EmitContext to report them.
 func (s *HandlerSink) Emit(ev SectionEvent) {
-	if fn, ok := s.handlers[strings.ToLower(ev.Name)]; ok {
-		fn(ev)
+	_ = s.EmitContext(context.Background(), ev)
+}
+
+// EmitContext dispatches ev to the Where handlers whose attributes match, then to its
+// handler, or the fallback handler if neither took it, and returns the first handler error.
+func (s *HandlerSink) EmitContext(ctx context.Context, ev SectionEvent) error {
+	key := strings.ToLower(ev.Name)
+	matched, err := s.emitWhere(ctx, key, ev)
+	if err != nil || (matched && s.whereExclusive) {
+		return err
+	}
+	fn, ok := s.handlers[key]
+	if !ok {
+		if !matched && s.fallback != nil {
+			s.fallback(ev)
+		}
+		return nil
+	}
+	switch s.modes[key] {
+	case firstEvent:
+		if s.fired[key] {
+			return nil
+		}
+		if s.fired == nil {
+			s.fired = map[string]bool{}
+		}
+		s.fired[key] = true
+	case lastEvent:
+		if s.last == nil {
+			s.last = map[string]SectionEvent{}
+		}
+		s.last[key], s.lastCtx = ev, ctx
+		return nil
+	}
+	return fn(ctx, ev)
+}
+
+// RegisterStreamStartHandler registers fn to run when a stream begins, before its first
+// handler, with the stream's metadata.
+func (s *HandlerSink) RegisterStreamStartHandler(fn func(meta StreamMeta)) { s.onStart = fn }
+
+// RegisterStreamEndHandler registers fn to run once a stream is over, after its last-only
+// handlers, with the error the stream ended with or, for a clean end, the errors of its
+// last-only handlers joined (nil if there were none).
+func (s *HandlerSink) RegisterStreamEndHandler(fn func(err error)) { s.onEnd = fn }
+
+// OnStreamStart implements StreamStartSink. It clears the per-stream state, in case the
+// previous stream was not ended through OnStreamEnd, and runs the stream start handler.
+func (s *HandlerSink) OnStreamStart(meta StreamMeta) {
+	s.fired, s.last, s.lastCtx = nil, nil, nil
+	if s.onStart != nil {
+		s.onStart(meta)
+	}
+}
+
+// OnStreamEnd implements StreamEndSink. It delivers the events held for last-only handlers,
+// in stream order and with the stream's context, if err is nil, resets the per-stream state
+// so the sink can be reused, and runs the stream end handler.
+func (s *HandlerSink) OnStreamEnd(err error) {
+	last, ctx := s.last, s.lastCtx
+	s.fired, s.last, s.lastCtx = nil, nil, nil
+	if err == nil {
+		err = s.runLast(ctx, last)
+	}
+	if s.onEnd != nil {
+		s.onEnd(err)
 	}
 }
 
+// runLast runs the last-only handlers for the events in last, in stream order, and returns
+// their errors joined.
+func (s *HandlerSink) runLast(ctx context.Context, last map[string]SectionEvent) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	events := make([]SectionEvent, 0, len(last))
+	for _, ev := range last {
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	var errs []error
+	for _, ev := range events {
+		if err := s.handlers[strings.ToLower(ev.Name)](ctx, ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolve maps a registration name to the key events are dispatched under.
+func (s *HandlerSink) resolve(section string) string {
+	if s.reg != nil {
+		if c, ok := s.reg.Canonical(section); ok {
+			return c
+		}
+	}
+	return strings.ToLower(section)
+}
+
+// EventSink receives events from the engine. HandlerSink is the standard implementation.
+type EventSink interface {
+	OnEvent(ev Event)
+}
+
+// ContextSink is an EventSink that also accepts the stream's context and can fail. The engine
+// delivers through OnEventContext when a sink implements it; an error goes through the
+// engine's error handling.
+type ContextSink interface {
+	EventSink
+	OnEventContext(ctx context.Context, ev Event) error
+}
+
+// deliver hands ev to sink, with ctx if the sink takes one.
+func deliver(ctx context.Context, sink EventSink, ev Event) error {
+	if cs, ok := sink.(ContextSink); ok {
+		return cs.OnEventContext(ctx, ev)
+	}
+	sink.OnEvent(ev)
+	return nil
+}
+
+// EventSinkFunc adapts an ordinary function to an EventSink.
+type EventSinkFunc func(ev Event)
+
+// OnEvent implements EventSink.
+func (f EventSinkFunc) OnEvent(ev Event) { f(ev) }
+
 // Engine coordinates streaming parsing and event emission.
 type Engine struct {
 	reg        *Registry
+	gen        uint64 // reg's generation when the engine was built
 	options    EngineOptions
 	validators *ValidatorRegistry
 }
@@ -73,12 +559,37 @@ func NewEngine(reg *Registry) *Engine {
 }
 
 // NewEngineWithOptions creates a new Engine with the given registry and options.
-func NewEngineWithOptions(reg *Registry, options EngineOptions) *Engine {
-	return &Engine{
+// Options are applied in order on top of DefaultEngineOptions. An EngineOptions passed
+// first replaces the defaults; one passed later only sets its non-zero fields, so
+// NewEngineWithOptions(reg, WithContinueMode(), EngineOptions{RecoveryMode: StrictMode})
+// stays in ContinueMode. Later settings that must win go in With* helpers.
+func NewEngineWithOptions(reg *Registry, opts ...Option) *Engine {
+	options := DefaultEngineOptions()
+	if first, ok := firstOption(opts).(EngineOptions); ok {
+		options, opts = first, opts[1:]
+	}
+	for _, o := range opts {
+		if o != nil {
+			o.apply(&options)
+		}
+	}
+	e := &Engine{
 		reg:        reg,
 		options:    options,
-		validators: NewValidatorRegistry(),
+		validators: NewValidatorRegistryFor(reg),
+	}
+	if reg != nil {
+		e.gen = reg.Generation()
+	}
+	return e
+}
+
+// firstOption returns opts[0], or nil.
+func firstOption(opts []Option) Option {
+	if len(opts) == 0 {
+		return nil
 	}
+	return opts[0]
 }
 
 // RegisterValidator registers a validator for a section type.
@@ -86,6 +597,12 @@ func (e *Engine) RegisterValidator(sectionName string, validator Validator) {
 	e.validators.Register(sectionName, validator)
 }
 
+// RegisterValidatorStrict is like RegisterValidator but fails with ErrUnknownSection
+// when the engine's registry does not know sectionName.
+func (e *Engine) RegisterValidatorStrict(sectionName string, validator Validator) error {
+	return e.validators.RegisterStrict(sectionName, validator)
+}
+
 // RegisterRegexValidator creates and registers a regex validator.
 func (e *Engine) RegisterRegexValidator(sectionName, pattern, description string) error {
 	return e.validators.RegisterRegex(sectionName, pattern, description)
@@ -96,440 +613,997 @@ func (e *Engine) RegisterFuncValidator(sectionName string, validateFunc func(str
 	e.validators.RegisterFunc(sectionName, validateFunc)
 }
 
+// RegisterJSONSchemaValidator compiles schema and registers a JSONSchemaValidator.
+func (e *Engine) RegisterJSONSchemaValidator(sectionName string, schema []byte) error {
+	return e.validators.RegisterJSONSchema(sectionName, schema)
+}
+
 // ProcessStream incrementally parses from r and emits SectionEvents to sink as soon as sections close.
 // The format is a resilient XML-lite with rules:
 //   - Opening tag:   <name attr="value" attr2='v'>
 //   - Closing tag:   </name>
 //   - Self-closing:  <name .../>
 //   - Text nodes are treated as raw content. Nesting is supported; only registered tags produce events.
-func (e *Engine) ProcessStream(r io.Reader, sink *HandlerSink) error {
-	if e.reg == nil {
-		return errors.New("nil registry")
+func (e *Engine) ProcessStream(r io.Reader, sink EventSink) error {
+	return e.run(context.Background(), r, sink, e.options, e.validators)
+}
+
+// ProcessStreamContext is ProcessStream with a context that reaches ContextSinks such as
+// handlers registered with RegisterHandlerCtx. Once ctx is done no further events are
+// delivered and ctx.Err() is returned. ctx is checked between reads, so a reader that blocks
+// must honor ctx itself.
+func (e *Engine) ProcessStreamContext(ctx context.Context, r io.Reader, sink EventSink) error {
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	br := bufio.NewReader(r)
+	return e.run(ctx, r, sink, e.options, e.validators)
+}
 
-	p := newParser(e.reg, sink, e.options)
-	p.validators = e.validators // Pass validators to the parser
+// ProcessStreamWith is ProcessStream with validators and variables adjusted for this stream
+// only (see StreamOptions). The engine is not modified, so concurrent streams are
+// unaffected.
+func (e *Engine) ProcessStreamWith(r io.Reader, sink EventSink, opts StreamOptions) error {
+	return e.run(context.Background(), r, sink, opts.engineOptions(e.options), opts.validators(e.validators))
+}
+
+// run drives the parser over r, emitting to sink with the given options and validators.
+// Every public entry point (ProcessStream, Discover) goes through here so they never disagree.
+func (e *Engine) run(ctx context.Context, r io.Reader, sink EventSink, options EngineOptions, validators *ValidatorRegistry) (err error) {
+	if err := e.checkInputs(r, sink); err != nil {
+		return err
+	}
+	s := e.startStream(ctx, sink, options, validators)
+	defer func() { err = s.end(err) }()
+	return s.read(ctx, r)
+}
+
+// read parses r to its end, then closes the stream.
+func (s *stream) read(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(s.capture.reader(r))
 
 	buf := make([]byte, 4096)
+	failures := 0 // consecutive failed reads, for ReadRetry
+	empty := 0    // consecutive reads that returned nothing, for MaxEmptyReads
+	maxEmpty := limitOrDefault(s.options.MaxEmptyReads, DefaultMaxEmptyReads)
 	for {
-		n, readErr := br.Read(buf)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, readErr, err := s.readWatched(br, buf)
+		if err != nil {
+			return err
+		}
 		if n > 0 {
-			p.feed(buf[:n])
-			if err := p.drain(); err != nil {
-				// If a custom error handler is provided, use it
-				if p.errorHandler != nil {
-					if p.errorHandler(err) {
-						// Handler returned true, continue parsing
-						continue
-					}
-					// Handler returned false, stop parsing
-					return err
-				}
-
-				// No custom handler, use recovery mode
-				if e.options.RecoveryMode == ContinueMode {
-					// In a real implementation, we might use a logger here
-					// For now, we'll just continue
-					continue
-				}
-				return err
+			failures, empty = 0, 0
+		} else if readErr == nil {
+			if empty++; maxEmpty > 0 && empty > maxEmpty {
+				readErr = fmt.Errorf("%w: %d reads in a row returned no data", io.ErrNoProgress, empty)
+				empty = 0
 			}
 		}
+		if err := s.write(buf[:n]); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return s.close()
+		}
 		if readErr != nil {
-			if readErr == io.EOF {
-				return p.finish()
+			// Retry transient failures; bufio hands the error out once and reads afresh.
+			failures++
+			if err := s.options.ReadRetry.retry(ctx, failures, readErr); err != nil {
+				return err
 			}
-			return readErr
 		}
 	}
 }
 
-// --- Streaming parser implementation ---
-
-// --- Streaming parser implementation (flat / non-nested) ---
-
-// RecoveryMode defines how the parser should handle errors.
-type RecoveryMode int
-
-const (
-	// StrictMode stops parsing on the first error.
-	StrictMode RecoveryMode = iota
-
-	// ContinueMode attempts to recover from errors and continue parsing.
-	ContinueMode
-)
-
-// ErrorHandler is a function that can process parsing errors.
-// It receives the error and can decide whether to continue parsing.
-// If it returns true, parsing will continue; if false, parsing will stop.
-type ErrorHandler func(error) bool
-
-// EngineOptions configures the behavior of the Engine.
-type EngineOptions struct {
-	// RecoveryMode determines how the parser handles errors.
-	// Default is StrictMode.
-	RecoveryMode RecoveryMode
-
-	// ErrorHandler is called when a parsing error occurs.
-	// If nil, the default behavior is used based on RecoveryMode.
-	// If provided, it can override the RecoveryMode behavior.
-	ErrorHandler ErrorHandler
+// readWatched reads from br into buf. While a section that can time out is open, the read
+// runs on its own goroutine so that the section is cut off at its deadline even if the read
+// blocks; err is what cutting it off returned. The read is then abandoned: it finishes on
+// its own into a buffered channel, and end stops the capture so that what it returns is
+// dropped. No further read is started, so at most that one read outlives the stream.
+func (s *stream) readWatched(br *bufio.Reader, buf []byte) (n int, readErr, err error) {
+	if _, ok := s.p.timeoutIn(); !ok {
+		n, readErr = br.Read(buf)
+		return n, readErr, nil
+	}
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := br.Read(buf)
+		done <- readResult{n, err}
+	}()
+	for {
+		left, ok := s.p.timeoutIn()
+		if !ok {
+			r := <-done
+			return r.n, r.err, nil
+		}
+		timer := time.NewTimer(left)
+		select {
+		case r := <-done:
+			timer.Stop()
+			return r.n, r.err, nil
+		case <-timer.C:
+			if err := s.p.checkTimeout(); err != nil {
+				s.abandoned = true
+				return 0, nil, err
+			}
+		}
+	}
 }
 
-// DefaultEngineOptions returns the default engine options.
-func DefaultEngineOptions() EngineOptions {
-	return EngineOptions{
-		RecoveryMode: StrictMode,
-		ErrorHandler: nil, // Default to nil, will use RecoveryMode behavior
-	}
+// readResult is the outcome of a read run by readWatched.
+type readResult struct {
+	n   int
+	err error
 }
 
-// WithContinueMode returns engine options configured for continue mode.
-func WithContinueMode() EngineOptions {
-	return EngineOptions{
-		RecoveryMode: ContinueMode,
-		ErrorHandler: nil,
+// checkInputs rejects configurations that would otherwise fail mid-stream.
+func (e *Engine) checkInputs(r io.Reader, sink EventSink) error {
+	if r == nil && e.reg != nil {
+		return ErrNilReader
 	}
+	return e.checkSink(sink)
 }
 
-// WithErrorHandler returns engine options with a custom error handler.
-func WithErrorHandler(handler ErrorHandler) EngineOptions {
-	return EngineOptions{
-		RecoveryMode: StrictMode, // Default to strict, but handler can override
-		ErrorHandler: handler,
+// checkSink is checkInputs for streams that are pushed rather than read.
+func (e *Engine) checkSink(sink EventSink) error {
+	if e.reg == nil {
+		return errors.New("nil registry")
 	}
+	if e.options.RegistrySync == RegistryFailOnChange && e.reg.Generation() != e.gen {
+		return ErrRegistryModified
+	}
+	if sink == nil {
+		return ErrNilSink
+	}
+	if hs, ok := sink.(*HandlerSink); ok && hs == nil {
+		return ErrNilSink
+	}
+	return nil
 }
 
+// --- Streaming parser implementation (flat / non-nested) ---
+
 type parser struct {
-	reg          *Registry
-	sink         *HandlerSink
-	buf          bytes.Buffer       // rolling buffer of unconsumed bytes
-	active       *element           // currently open recognized section, or nil
-	pos          Position           // current position in the input stream
-	recoveryMode RecoveryMode       // how to handle errors
-	errorHandler ErrorHandler       // custom error handler
-	validators   *ValidatorRegistry // content validators
-	lastContent  string             // recent content for error context
+	reg           *Registry
+	sink          EventSink
+	ctx           context.Context      // the stream's context, passed on to ContextSinks
+	tz            *Tokenizer           // lexer over the raw input
+	active        *element             // currently open recognized section, or nil
+	pos           Position             // current position in the input stream
+	recoveryMode  RecoveryMode         // how to handle errors
+	errorHandler  ErrorHandler         // custom error handler
+	onUnknown     UnknownTagHandler    // observer for unregistered tags outside sections
+	eofPolicy     EOFPolicy            // what to do with sections cut off by EOF or timeout
+	timeout       time.Duration        // per-section timeout; zero disables
+	now           func() time.Time     // clock for timeouts
+	subParsers    map[string]SubParser // sub-engines keyed by canonical section name
+	maxEvents     int                  // cap on emitted events; zero is unlimited
+	block         *codeBlock           // code block open outside sections
+	rawEnvelope   bool                 // record SectionEvent.Raw for every section
+	lenientFences bool                 // fences may be deeply indented or blockquoted
+	streamMeta    StreamMeta           // attached to every emitted event
+	fenceMapping  FenceSectionMapping  // turns code blocks with a file= header into sections
+	fenceSection  string               // canonical name of fenceMapping.Section; empty if unmapped
+	events        int                  // events emitted so far
+	validators    *ValidatorRegistry   // content validators
+	variables     map[string]string    // {{name}} values; nil disables expansion
+	unknownVars   UnknownVariablePolicy
+	suppress      map[string]bool           // canonical names of suppressed sections
+	onSuppressed  SuppressHandler           // observer for suppressed sections
+	contexts      map[string]ContextSection // context sections keyed by canonical name
+	contextStack  []contextFrame            // open context sections, innermost last
+	contextPrefix string                    // key prefix for inherited attributes
+	pairer        *pairer                   // sections waiting for their Pairing counterpart; nil without pairings
+	onUnpaired    UnpairedHandler           // told about unpaired sections at the end of the stream
+	orphanRescue  bool                      // take complete sections out of a section cut off by EOF
+	rescued       *[]*element               // non-nil on rescueOrphans' inner parser, which collects sections instead of emitting them
+	maxSkipped    int                       // cap on ParseError.Skipped; negative records nothing
+	sniff         bool                      // set SectionEvent.ContentKind
+	detector      LanguageDetector          // sets SectionEvent.DetectedLanguage; nil leaves it to plugins
+
+	referencer         *referencer                     // declared ids of References; nil without references
+	onResolved         ReferenceResolver               // told which section defined a use's id
+	onReferenceWarning func(*ReferenceError)           // receives reference problems instead of the error handling
+	onAttrWarning      func(*AttributeValidationError) // told about unknown attributes under WarnUnknownAttrs
+	onDangling         DanglingReferenceHandler        // told about uses never resolved, at the end of the stream
+
+	timer          *handlerTimer                 // times deliveries to the sink; nil without HandlerTiming
+	onHandlerStats func(map[string]HandlerStats) // told the per-section timings at the end of the stream
+	wellFormed     *wellFormed                   // elements open outside sections; nil unless WellFormed
+	onTruncation   TruncationHandler             // told what the stream ended inside of
+	cutTag         *Token                        // the incomplete tag the stream ended in, with onTruncation
+	ancestry       *ancestry                     // unregistered tags open outside sections; nil unless Ancestry
+	plain          *plainText                    // text run outside sections; nil unless PlainText
+	defaultAttrs   map[string]map[string]string  // EngineOptions.DefaultAttrs by canonical name
+	closedEvents   bool                          // emit SectionClosedEvents
+	budget         *byteBudget                   // body bytes per section name; nil without ByteBudgets
+	truncations    map[string]int                // truncated sections per name; nil without TruncatedSectionsHandler
+	onTruncations  func(map[string]int)          // told truncations at the end of the stream
+	originalCase   bool                          // deliver names as registered
+	revisions      *revisions                    // sections emitted so far; nil without RevisionAttr
+	tap            func(TagTokenInfo)            // told what became of each tag; nil without TokenTap
+	tagged         TagDisposition                // what became of the tag being handled, for tap
+	halted         bool                          // the sink has what it wants (ExtractFirst); nothing more is parsed
 }
 
 type element struct {
-	name  string // original open tag name as seen in stream (e.g., "create-file")
-	canon string // canonical name if recognized (e.g., "write-file"); empty if unknown
-	attrs map[string]string
-	body  strings.Builder
+	name       string // original open tag name as seen in stream (e.g., "create-file")
+	canon      string // canonical name if recognized (e.g., "write-file"); empty if unknown
+	attrs      map[string]string
+	body       strings.Builder
+	start      Position         // position of the opening tag
+	bodyStart  Position         // position of the first content byte
+	openedAt   time.Time        // wall-clock time the opening tag was parsed
+	cutOff     bool             // force-closed by timeout; the rest of the body is discarded
+	end        Position         // end of a section that did not come from tags; zero means the current position
+	fences     bool             // the plugin parses fences in the body
+	block      *codeBlock       // code block open in the body
+	raw        *strings.Builder // opening tag, body and closing tag as read; nil unless wanted
+	suppress   bool             // count the body instead of buffering it
+	truncAt    int              // body bytes to buffer before counting the rest; 0 buffers all
+	skipped    int              // body bytes counted but not buffered
+	rescued    bool             // taken out of an unclosed section's body
+	defaulted  []string         // attributes filled in from DefaultAttrs or the plugin's AttrDefaults
+	vetoed     error            // the veto of the section's IntentEvent; the body is counted
+	overBudget bool             // the ByteBudgetHandler has decided on this section
 }
 
-func newParser(reg *Registry, sink *HandlerSink, options EngineOptions) *parser {
-	return &parser{
+// size is the number of body bytes read so far, buffered or not.
+func (el *element) size() int { return el.body.Len() + el.skipped }
+
+// truncated reports whether the body was cut at the plugin's TruncateAt.
+func (el *element) truncated() bool { return !el.suppress && el.skipped > 0 }
+
+// keep buffers text, or the part of it that fits under truncAt, and counts the rest.
+func (el *element) keep(text string) {
+	if el.truncAt > 0 && (el.skipped > 0 || el.body.Len()+len(text) > el.truncAt) {
+		kept := ""
+		if el.skipped == 0 {
+			kept = clipRunes(text, 0, el.truncAt-el.body.Len())
+		}
+		el.body.WriteString(kept)
+		el.skipped += len(text) - len(kept)
+		return
+	}
+	el.body.WriteString(text)
+}
+
+func (el *element) rawString() string {
+	if el.raw == nil {
+		return ""
+	}
+	return el.raw.String()
+}
+
+func newParser(reg *Registry, sink EventSink, options EngineOptions) *parser {
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+	p := &parser{
 		reg:          reg,
 		sink:         sink,
-		pos:          Position{Line: 1, Column: 1}, // Start at line 1, column 1
+		ctx:          context.Background(),
+		pos:          Position{Line: 1, Column: 1}, // Start at line 1, column 1, offset 0
 		recoveryMode: options.RecoveryMode,
 		errorHandler: options.ErrorHandler,
+		onUnknown:    options.UnknownTagHandler,
+		eofPolicy:    options.EOFPolicy,
+		timeout:      options.SectionTimeout,
+		now:          options.Clock,
+		maxEvents:    options.MaxEvents,
+		subParsers:   resolveSubParsers(reg, options.SubParsers),
+	}
+	p.tz = newTokenizer(options.CodeBlocks, options.LenientFences)
+	p.tz.tag.keepCase = options.PreserveAttrCase
+	p.tz.inlineCode, p.tz.escape = options.InlineCodeAwareness, options.EscapePrefix
+	p.tz.balanced = options.BalancedSameName
+	p.tz.tag.maxAttrs = limitOrDefault(options.MaxAttrs, DefaultMaxAttrs)
+	p.tz.tag.maxKeyLen = limitOrDefault(options.MaxAttrNameLen, DefaultMaxAttrNameLen)
+	p.tz.tag.maxLen = limitOrDefault(options.MaxLookahead, DefaultMaxLookahead)
+	p.lenientFences = options.LenientFences
+	p.streamMeta = options.StreamMeta
+	p.rawEnvelope = options.IncludeRawEnvelope
+	p.variables, p.unknownVars = options.Variables, options.UnknownVariables
+	p.suppress = resolveSuppressed(reg, options.SuppressedSections)
+	p.onSuppressed = options.SuppressHandler
+	p.contexts = resolveContextSections(reg, options.ContextSections)
+	p.defaultAttrs = resolveDefaultAttrs(reg, options.DefaultAttrs)
+	p.closedEvents = options.SectionClosedEvents
+	p.budget = newByteBudget(reg, options)
+	p.originalCase = options.OriginalNameCasing
+	p.revisions = newRevisions(options)
+	p.tap = options.TokenTap
+	p.contextPrefix = strings.ToLower(options.ContextAttrPrefix)
+	p.pairer, p.onUnpaired = newPairer(reg, options.Pairings), options.UnpairedHandler
+	p.orphanRescue = options.OrphanRescue
+	p.referencer = newReferencer(reg, options.References)
+	p.onResolved, p.onReferenceWarning = options.ReferenceResolver, options.ReferenceWarnings
+	p.onAttrWarning = options.AttrWarnings
+	p.onDangling = options.DanglingReferenceHandler
+	p.sniff = options.ContentSniffing
+	p.detector = options.LanguageDetector
+	p.timer, p.onHandlerStats = newHandlerTimer(options), options.HandlerStatsHandler
+	if options.WellFormed {
+		p.wellFormed = &wellFormed{}
+	}
+	p.onTruncation = options.TruncationHandler
+	if p.onTruncations = options.TruncatedSectionsHandler; p.onTruncations != nil {
+		p.truncations = map[string]int{}
+	}
+	if options.Ancestry {
+		p.ancestry = &ancestry{}
+	}
+	if options.PlainText != nil {
+		p.plain = &plainText{options: *options.PlainText}
 	}
+	p.maxSkipped = options.MaxSkippedBytes
+	if p.maxSkipped == 0 {
+		p.maxSkipped = DefaultMaxSkippedBytes
+	}
+	if c, ok := reg.Canonical(options.FenceMapping.Section); ok {
+		p.fenceMapping, p.fenceSection = options.FenceMapping, c
+	}
+	return p
 }
 
-func (p *parser) feed(b []byte) { p.buf.Write(b) }
+func (p *parser) feed(b []byte) { p.tz.feed(b) }
 
-// drain consumes as much of p.buf as possible.
-// Flat mode: if a recognized tag is open, treat all inner bytes as text until its matching </...>.
-func (p *parser) drain() error {
-	for {
-		data := p.buf.Bytes()
-		if len(data) == 0 {
+// recover decides whether parsing may continue after err.
+// A custom ErrorHandler takes precedence; otherwise RecoveryMode decides.
+// It returns nil when the caller should skip past the problem, or err when parsing must stop.
+func (p *parser) recover(err error) error {
+	p.locate(err)
+	if p.errorHandler != nil {
+		if p.errorHandler(err) {
 			return nil
 		}
+		return err
+	}
+	if p.recoveryMode == ContinueMode {
+		return nil
+	}
+	return err
+}
 
-		// If we are inside a recognized section, stream raw until its close.
-		if p.active != nil {
-			// Write everything up to the next '<' (if any)
-			lt := bytes.IndexByte(data, '<')
-			if lt == -1 {
-				// No '<' at all → dump everything as content
-				p.active.body.Write(data)
-				p.consume(len(data))
-				continue
-			}
-			if lt > 0 {
-				// Write text before '<'
-				p.active.body.Write(data[:lt])
-				p.consume(lt)
-				continue
-			}
+// locate replaces the snippets of a promptweaver error with the input around its position,
+// when the tokenizer still holds it: what came before, and the offending bytes after. The
+// first parser to locate an error wins, so errors from sub-parsers keep their own snippets.
+func (p *parser) locate(err error) {
+	var pe interface{ parseError() *ParseError }
+	if !errors.As(err, &pe) {
+		return
+	}
+	perr := pe.parseError()
+	if perr.located {
+		return
+	}
+	seen := p.tz.lastContent // ends at p.tz.pos
+	back := p.tz.pos.Offset - perr.Pos.Offset
+	if back < 0 || back > int64(len(seen)) {
+		return
+	}
+	at := len(seen) - int(back)
+	// The offending tag, if any, is still buffered; later input is left out so the
+	// snippets do not depend on how the stream was chunked.
+	window := seen + string(p.tz.buf.Bytes()[:p.tz.skip])
+	perr.SnippetBefore = validSnippet(clipRunes(window, at-maxSnippetLen, at))
+	perr.SnippetAfter = validSnippet(clipRunes(window, at, at+maxSnippetLen))
+	perr.Context = pe.(interface{ Render() string }).Render()
+	perr.located = true
+}
 
-			// Now data[0] == '<' — it *might* be our closing tag.
-			// Only close if it’s exactly a recognized close for this active section (by alias/canonical).
-			consumed, isClose, complete, err := p.parseOwnClose(data)
-			if err != nil {
-				// Error parsing closing tag
-				if p.recoveryMode == ContinueMode {
-					// In recovery mode, consume the bytes up to the error and continue
-					p.consume(consumed)
-					continue
-				}
-				return err
-			}
-			if !complete {
-				// Need more bytes to decide
-				return nil
-			}
-			if isClose {
-				// Consume the closing tag
-				p.consume(consumed)
-
-				// Prepare the section event
-				content := p.active.body.String()
-				sectionName := p.active.canon
-
-				// Validate the section content if validators are available
-				if p.validators != nil {
-					if err := p.validators.ValidateSection(sectionName, content, p.pos); err != nil {
-						// Handle validation error
-						if p.errorHandler != nil {
-							if p.errorHandler(err) {
-								// Handler returned true, continue with next section
-								p.active = nil
-								continue
-							}
-							// Handler returned false, stop parsing
-							return err
-						}
-
-						// No custom handler, use recovery mode
-						if p.recoveryMode == StrictMode {
-							return err
-						}
-						// In ContinueMode, just skip this section and continue
-						p.active = nil
-						continue
-					}
-				}
+// skipped records on err the input dropped when it is recovered from, up to maxSkipped bytes.
+func (p *parser) skipped(err error, b []byte) {
+	var pe interface{ parseError() *ParseError }
+	if p.maxSkipped < 0 || len(b) == 0 || !errors.As(err, &pe) {
+		return
+	}
+	if len(b) > p.maxSkipped {
+		b = b[:p.maxSkipped]
+	}
+	pe.parseError().Skipped = append([]byte(nil), b...)
+}
 
-				// Content is valid or no validators, emit the event
-				ev := SectionEvent{
-					Name:    sectionName,
-					Attrs:   p.active.attrs,
-					Content: content,
-				}
-				p.active = nil
-				p.sink.Emit(ev)
-				continue
+// drain handles every token the buffered input yields. With atEOF, input that is still
+// incomplete is handled too.
+// Flat mode: if a recognized tag is open, the tokenizer treats all inner bytes as text until its matching </...>.
+func (p *parser) drain(atEOF bool) error {
+	for {
+		if p.halted {
+			return errHalted
+		}
+		tok, ok, err := p.tz.next(atEOF)
+		if err != nil {
+			if p.active == nil && p.block == nil {
+				// Outside any section the offending bytes are text, which is dropped.
+				p.skipped(err, p.tz.buf.Bytes()[:p.tz.skip])
 			}
-
-			// Not our closing tag → treat leading '<' as literal text
-			// (Optional: if the next chars are "</", consume both; otherwise just consume '<')
-			if len(data) >= 2 && data[1] == '/' {
-				p.active.body.WriteString("</")
-				p.consume(2)
-			} else {
-				p.active.body.WriteByte('<')
-				p.consume(1)
+			if err := p.recover(err); err != nil {
+				return err
 			}
+			// Recovered: the offending bytes come back as text
 			continue
 		}
-
-		// No active section: look for a tag opener
-		lt := bytes.IndexByte(data, '<')
-		if lt == -1 {
-			// Text outside any tag is ignored
-			p.buf.Reset()
+		if !ok {
 			return nil
 		}
-		if lt > 0 {
-			// Ignore preceding text
-			p.consume(lt)
-			continue
+		p.pos = tok.End
+		if tok.Incomplete && p.onTruncation != nil {
+			p.cutTag = &tok
+		}
+		var attrs map[string]string
+		if p.tap != nil {
+			// Handling may add defaults and inherited attributes to the token's.
+			attrs = maps.Clone(tok.Attrs)
+		}
+		p.tagged = TagTreatedAsContent
+		if p.active != nil {
+			err = p.sectionToken(tok)
+		} else {
+			err = p.outsideToken(tok)
+		}
+		if p.tap != nil && isTagKind(tok.Kind) {
+			p.tapTag(tok, attrs, err)
 		}
-
-		// data[0] == '<' — try to parse a tag token
-		consumed, tok, ok, err := parseTagToken(data, p.pos, p.lastContent)
 		if err != nil {
-			// Error parsing tag
-			if p.recoveryMode == ContinueMode {
-				// In recovery mode, consume the bytes up to the error and continue
-				p.consume(consumed)
-				continue
-			}
 			return err
 		}
-		if !ok {
-			// Need more bytes to complete tag
+	}
+}
+
+// sectionToken handles a token inside the active section: its closing tag, or content.
+func (p *parser) sectionToken(tok Token) error {
+	el := p.active
+	if tok.Kind == TokenClose && !tok.Incomplete && !closesSection(p.reg, el.canon, el.name)(strings.ToLower(tok.Name)) {
+		return p.strayCloser(el, tok)
+	}
+	if tok.Kind == TokenClose && !tok.Incomplete {
+		p.tz.exitRaw()
+		p.active = nil
+		p.tagged = TagClosedSection
+		if el.cutOff {
+			// Already handled when the timeout fired
 			return nil
 		}
-		p.consume(consumed)
+		if err := p.endBodyFences(el, tok.Start); err != nil {
+			return err
+		}
+		if el.raw != nil {
+			el.raw.WriteString(tok.Text)
+		}
+		if err := p.checkBody(el, tok.Start); err != nil {
+			return err
+		}
+		return p.closeSection(el, false)
+	}
 
-		switch tok.kind {
-		case tokenOpen:
-			if c, ok := p.reg.Canonical(tok.name); ok {
-				// Start flat (raw) mode for this section
-				p.active = &element{name: tok.name, canon: c, attrs: tok.attrs}
-			} else {
-				// Unknown tag outside sections → ignore it (and its contents are ignored too,
-				// because we never enter active mode for unknowns)
-			}
+	if el.cutOff {
+		return nil
+	}
+	if el.suppress {
+		el.skipped += len(tok.Text)
+		return p.charge(el, len(tok.Text))
+	}
+	el.keep(tok.Text)
+	if err := p.charge(el, len(tok.Text)); err != nil {
+		return err
+	}
+	if el.raw != nil {
+		el.raw.WriteString(tok.Text)
+	}
+	if el.fences {
+		return p.fenceToken(&el.block, tok)
+	}
+	return nil
+}
 
-		case tokenSelfClose:
-			if c, ok := p.reg.Canonical(tok.name); ok {
-				p.sink.Emit(SectionEvent{Name: c, Attrs: tok.attrs, Content: ""})
-			} // else ignore
+// strayCloser handles the closing tag of another registered section inside a StrictBody
+// section. If the error is recovered from, the section ends where the closer starts and the
+// closer is then handled as if outside any section.
+func (p *parser) strayCloser(el *element, tok Token) error {
+	err := NewUnexpectedClosingTagError(tok.Start, strings.ToLower(tok.Name), el.canon, el.start, p.tz.lastContent)
+	if err := p.recover(err); err != nil {
+		return err
+	}
+	p.tz.exitRaw()
+	p.active = nil
+	if !el.cutOff {
+		if err := p.endBodyFences(el, tok.Start); err != nil {
+			return err
+		}
+		el.end = tok.Start
+		if err := p.closeSection(el, false); err != nil {
+			return err
+		}
+	}
+	rerr := p.outsideToken(tok)
+	p.tagged = TagError // for the tap, whatever became of the closer outside
+	return rerr
+}
 
-		case tokenClose:
-			// Closing tag with no active section → ignore
-			// In strict mode, we could report this as an error
-			if p.recoveryMode == StrictMode {
-				return NewUnmatchedTagError(p.pos, tok.name, p.lastContent)
+// outsideToken handles a token outside any section. Text is ignored unless it belongs to a code block.
+func (p *parser) outsideToken(tok Token) error {
+	if p.plain != nil {
+		if done, err := p.plainToken(tok); done || err != nil {
+			return err
+		}
+	}
+	if tok.Incomplete {
+		return nil
+	}
+	if p.contextTag(tok) {
+		p.tagged = TagContext
+		return nil
+	}
+	if err := p.checkTag(tok); err != nil {
+		return err
+	}
+	switch tok.Kind {
+	case TokenOpen:
+		if c, ok := p.reg.Canonical(tok.Name); ok {
+			// Start flat (raw) mode for this section
+			p.tagged = TagStartedSection
+			plugin, _ := p.reg.Plugin(c)
+			suppress := p.suppressed(c, plugin)
+			fences := plugin.ParseFencesInBody && !suppress
+			unknown := unknownAttrs(plugin, tok)
+			p.active = &element{name: tok.Name, canon: c, start: tok.Start, bodyStart: tok.End, openedAt: p.now(), fences: fences, suppress: suppress, truncAt: plugin.TruncateAt}
+			p.active.attrs, p.active.defaulted = p.sectionAttrs(plugin, tok.Attrs)
+			if !suppress {
+				p.keepRaw(p.active, plugin, tok)
+			}
+			closes := closesSection(p.reg, c, tok.Name)
+			if plugin.StrictBody {
+				closes = closesOrStrays(p.reg, closes)
+			}
+			p.tz.enterRaw(closes, fences)
+			p.tz.opaque(plugin, tok)
+			p.tz.nest(closesSection(p.reg, c, tok.Name))
+			p.active.fences = p.tz.fences
+			aborted, err := p.checkAttrs(plugin, p.active, tok, unknown)
+			if err == nil && !aborted {
+				if err = p.intent(plugin, p.active); err == nil {
+					aborted, err = p.open(plugin, p.active)
+				}
 			}
+			if err != nil || aborted {
+				// Skip the body the way a timed-out section does.
+				p.active.cutOff, p.active.raw = true, nil
+				return err
+			}
+		} else {
+			// Unknown tag outside sections → ignore it (and its contents are ignored too,
+			// because we never enter active mode for unknowns)
+			p.tagged = TagIgnoredUnknown
+			p.unknownTag(tok.Name, tok.Start)
+			p.openOutside(tok)
+			p.pushAncestor(tok)
 		}
+
+	case TokenSelfClose:
+		if c, ok := p.reg.Canonical(tok.Name); ok {
+			p.tagged = TagStartedSection
+			plugin, _ := p.reg.Plugin(c)
+			el := &element{name: tok.Name, canon: c, start: tok.Start, suppress: p.suppressed(c, plugin)}
+			unknown := unknownAttrs(plugin, tok)
+			el.attrs, el.defaulted = p.sectionAttrs(plugin, tok.Attrs)
+			p.keepRaw(el, plugin, tok)
+			if aborted, err := p.checkAttrs(plugin, el, tok, unknown); err != nil || aborted {
+				return err
+			}
+			if err := p.intent(plugin, el); err != nil {
+				return err
+			}
+			if aborted, err := p.open(plugin, el); err != nil || aborted {
+				return err
+			}
+			return p.closeSection(el, false)
+		}
+		p.tagged = TagIgnoredUnknown
+		p.unknownTag(tok.Name, tok.Start)
+
+	case TokenClose:
+		// Closing tag with no active section
+		p.popAncestor(tok)
+		if matched, err := p.closeOutside(tok); matched || err != nil {
+			p.tagged = TagIgnoredUnknown
+			return err
+		}
+		p.tagged = TagError
+		err := NewUnmatchedTagError(tok.Start, tok.Name, p.tz.lastContent)
+		p.skipped(err, []byte(tok.Text))
+		return p.recover(err)
+
+	default:
+		return p.fenceToken(&p.block, tok)
 	}
+	return nil
 }
 
-// parseOwnClose checks whether data starts with a closing tag that should close p.active.
-// Accepts any alias whose canonical equals p.active.canon.
-// Returns (consumedBytes, isOurClose, complete, error).
+// open calls the plugin's OnOpen hook for el, whose opening tag has just been parsed, and
+// reports whether a recovered error aborted the section.
+func (p *parser) open(plugin SectionPlugin, el *element) (bool, error) {
+	if plugin.OnOpen == nil || el.suppress || p.rescued != nil {
+		return false, nil
+	}
+	if err := plugin.OnOpen(el.canon, el.attrs, el.start); err != nil {
+		if rerr := p.recover(err); rerr != nil {
+			return true, rerr
+		}
+		return true, p.closed(el, p.pos, false, err)
+	}
+	return false, nil
+}
 
-func (p *parser) parseOwnClose(data []byte) (int, bool, bool, error) {
-	if p.active == nil {
-		return 0, false, true, nil
+// sectionAttrs returns the attributes of a section opened with attrs: inherited ones added,
+// then the engine's defaults and the plugin's for those still missing, whose keys it also
+// returns.
+func (p *parser) sectionAttrs(plugin SectionPlugin, attrs map[string]string) (map[string]string, []string) {
+	attrs = p.inheritAttrs(attrs)
+	engine := p.defaultAttrs[canonicalOrLower(p.reg, plugin.Name)]
+	if len(engine) == 0 && len(plugin.AttrDefaults) == 0 {
+		return attrs, nil
 	}
-	if len(data) < 2 || data[0] != '<' || data[1] != '/' {
-		return 0, false, true, nil
+	if attrs == nil {
+		attrs = map[string]string{}
 	}
-	i := 2
-	// Tolerate whitespace after "</"
-	for i < len(data) && isSpace(data[i]) {
-		i++
+	var filled []string
+	for _, defaults := range []map[string]string{engine, plugin.AttrDefaults} {
+		for k, v := range defaults {
+			if _, ok := lookupAttr(attrs, k); ok {
+				continue
+			}
+			if !p.tz.tag.keepCase {
+				k = strings.ToLower(k)
+			}
+			attrs[k] = v
+			filled = append(filled, k)
+		}
 	}
-	if i == len(data) {
-		return 0, false, false, nil
+	sort.Strings(filled)
+	return attrs, filled
+}
+
+// keepRaw starts recording el's raw envelope with its opening tag, if it is wanted.
+func (p *parser) keepRaw(el *element, plugin SectionPlugin, open Token) {
+	if p.rawEnvelope || plugin.IncludeRawEnvelope {
+		el.raw = &strings.Builder{}
+		el.raw.WriteString(open.Text)
 	}
+}
 
-	start := i
-	for i < len(data) && isNameChar(data[i]) {
-		i++
+// fenceToken assembles code blocks from fence and text tokens, emitting each when it closes.
+func (p *parser) fenceToken(block **codeBlock, tok Token) error {
+	switch tok.Kind {
+	case TokenFenceStart:
+		*block = newCodeBlock(tok, p.lenientFences)
+	case TokenFenceEnd:
+		ev := (*block).event(tok.End)
+		*block = nil
+		return p.emitCodeBlocks(ev)
+	case TokenText:
+		if *block != nil {
+			(*block).write(tok.Text)
+		}
 	}
-	if i == start { // no name
-		if p.recoveryMode == StrictMode {
-			return i, false, true, NewMalformedTagError(
-				p.pos, "", "missing tag name after '</'", p.lastContent)
+	return nil
+}
+
+// emitCodeBlocks emits completed code blocks. Blocks with a file= header are turned into
+// the mapped section, if one is configured, and go through the usual section checks.
+func (p *parser) emitCodeBlocks(blocks ...CodeBlockEvent) error {
+	for _, ev := range blocks {
+		mapped := p.fenceSection != "" && ev.File != ""
+		if !mapped || p.fenceMapping.KeepCodeBlocks {
+			if err := p.emit(ev); err != nil {
+				return err
+			}
+		}
+		if !mapped {
+			continue
+		}
+		el := &element{
+			name:  p.fenceMapping.Section,
+			canon: p.fenceSection,
+			start: ev.StartPos,
+			end:   ev.EndPos,
+		}
+		plugin, _ := p.reg.Plugin(p.fenceSection)
+		el.attrs, el.defaulted = p.sectionAttrs(plugin, map[string]string{p.fenceMapping.pathAttr(): ev.File, "lang": ev.Lang})
+		el.body.WriteString(ev.Content)
+		if err := p.closeSection(el, false); err != nil {
+			return err
 		}
-		return 0, false, true, nil
 	}
-	if i == len(data) { // incomplete closer across chunk
-		return 0, false, false, nil
+	return nil
+}
+
+// unknownTag reports an unregistered tag seen outside any section.
+func (p *parser) unknownTag(name string, pos Position) {
+	if p.onUnknown != nil {
+		p.onUnknown(name, pos)
+	}
+}
+
+func (p *parser) finish() error {
+	// Leftover bytes, such as an incomplete tag, are content if a section is open.
+	if err := p.drain(true); err != nil {
+		return err
+	}
+	p.pos = p.tz.pos
+	p.reportTruncation()
+	if err := p.flushPlainText(); err != nil {
+		return err
 	}
 
-	closeName := strings.ToLower(string(data[start:i]))
+	if p.block != nil {
+		ev := p.block.event(p.pos)
+		p.block = nil
+		if err := p.emitCodeBlocks(ev); err != nil {
+			return err
+		}
+	}
 
-	// Accept if canonical(closeName) == active.canon
-	if c, ok := p.reg.Canonical(closeName); ok {
-		if c != p.active.canon {
-			// Not our closing tag, but a valid tag name
-			return 0, false, true, nil
+	// Auto-close active recognized section on EOF
+	if p.active != nil && p.active.canon != "" {
+		el := p.active
+		p.active = nil
+		if el.cutOff {
+			return nil
 		}
-	} else {
-		// Fallback: literal match against the original open tag name (case-insensitive)
-		if !strings.EqualFold(closeName, p.active.name) {
-			// Not our closing tag
-			return 0, false, true, nil
+		if err := p.endBodyFences(el, p.pos); err != nil {
+			return err
+		}
+		if p.orphanRescue {
+			if err := p.rescueOrphans(el); err != nil {
+				return err
+			}
 		}
+		return p.cutOff(el, NewUnclosedSectionError(p.pos, el.canon, el.start, el.size(), p.tz.lastContent))
 	}
+	return nil
+}
 
-	// optional spaces before '>'
-	for i < len(data) && isSpace(data[i]) {
-		i++
+// checkTimeout force-closes the active section once it has been open longer than the
+// configured section timeout.
+func (p *parser) checkTimeout() error {
+	if left, ok := p.timeoutIn(); !ok || left > 0 {
+		return nil
 	}
-	if i == len(data) {
-		return 0, false, false, nil
+	el := p.active
+	if err := p.endBodyFences(el, p.pos); err != nil {
+		return err
 	}
-	if data[i] != '>' {
-		if p.recoveryMode == StrictMode {
-			return i, false, true, NewMalformedTagError(
-				p.pos, closeName, "expected '>' after closing tag name", p.lastContent)
-		}
-		return 0, false, true, nil
+	// Stay active so the remaining body and the closer are swallowed, not parsed as tags.
+	el.cutOff = true
+	err := p.cutOff(el, NewSectionTimeoutError(p.pos, el.canon, el.start, el.size(), p.timeout, p.tz.lastContent))
+	el.body.Reset()
+	return err
+}
+
+// timeoutIn returns how long the active section has left before it times out, or false if
+// no section can time out now.
+func (p *parser) timeoutIn() (time.Duration, bool) {
+	el := p.active
+	if p.timeout <= 0 || el == nil || el.cutOff {
+		return 0, false
 	}
+	return p.timeout - p.now().Sub(el.openedAt), true
+}
 
-	return i + 1, true, true, nil
+// cutOff applies the EOFPolicy to a section that did not close on its own.
+// errPartial is reported under ErrorPartial.
+func (p *parser) cutOff(el *element, errPartial error) error {
+	switch p.eofPolicy {
+	case DropPartial:
+	case ErrorPartial:
+		if err := p.recover(errPartial); err != nil {
+			return err
+		}
+	default:
+		return p.closeSection(el, true)
+	}
+	if el.vetoed != nil {
+		return p.vetoed(el, p.pos, true)
+	}
+	// A dropped suppressed section still gets its SectionClosedEvent.
+	if plugin, _ := p.reg.Plugin(el.canon); p.suppressed(el.canon, plugin) {
+		return p.closed(el, p.pos, true, nil)
+	}
+	return nil
 }
 
-func (p *parser) finish() error {
-	// If buffer has leftover bytes, and we are inside a section, they are part of the content.
-	if p.buf.Len() > 0 && p.active != nil {
-		p.active.body.Write(p.buf.Bytes())
-		p.buf.Reset()
-	} else {
-		p.buf.Reset()
+// closeSection finalizes a recognized section: it applies the plugin's empty-body rules,
+// expands variables, runs validators, and emits the event. Closing tags, self-closing tags
+// and EOF auto-close all go through here so the three spellings of a section produce the
+// same event. The section ends at the current position. A recovered validation or variable
+// error skips the section, except at EOF where the partial section is still emitted.
+func (p *parser) closeSection(el *element, atEOF bool) error {
+	if p.rescued != nil {
+		el.end = p.endOf(el)
+		*p.rescued = append(*p.rescued, el)
+		return nil
+	}
+	if el.vetoed != nil {
+		return p.vetoed(el, p.endOf(el), atEOF)
+	}
+	plugin, _ := p.reg.Plugin(el.canon)
+	if p.suppressed(el.canon, plugin) {
+		p.reportSuppressed(el, p.endOf(el), atEOF)
+		return p.closed(el, p.endOf(el), atEOF, nil)
+	}
+	content := el.body.String()
+	if plugin.NormalizeEmpty && strings.TrimSpace(content) == "" {
+		content = ""
 	}
 
-	// Auto-close active recognized section on EOF
-	if p.active != nil && p.active.canon != "" {
-		content := p.active.body.String()
-		sectionName := p.active.canon
-
-		// Validate the section content if validators are available
-		if p.validators != nil {
-			if err := p.validators.ValidateSection(sectionName, content, p.pos); err != nil {
-				// Handle validation error
-				if p.errorHandler != nil {
-					if !p.errorHandler(err) {
-						// Handler returned false, stop parsing
-						return err
-					}
-					// Handler returned true, continue and emit anyway
-				} else if p.recoveryMode == StrictMode {
-					return err
-				}
-				// In ContinueMode or if handler returned true, emit anyway
+	content, err := p.expandSection(plugin, el, content)
+	var rawContent string
+	if err == nil && len(plugin.ContentTransforms) > 0 {
+		var out string
+		if out, err = p.transformContent(plugin, el, content); err == nil && out != content {
+			rawContent, content = el.body.String(), out
+		}
+	}
+	ev := SectionEvent{
+		EventBase: EventBase{StartPos: el.start, EndPos: p.endOf(el), StreamMeta: p.streamMeta, Ancestry: p.ancestors()},
+		Name:      el.canon,
+		Attrs:     el.attrs,
+		Content:   content,
+		AliasUsed: el.name,
+		Raw:       el.rawString(),
+	}
+	ev.Rescued, ev.DefaultedAttrs, ev.RawContent = el.rescued, el.defaulted, rawContent
+	if p.sniff {
+		ev.ContentKind = SniffContent(content)
+	}
+	p.detectLanguage(plugin, &ev)
+	if el.truncated() {
+		ev.Truncated, ev.OriginalSize = true, el.size()
+	}
+	if plugin.Command && err == nil {
+		ev.Argv, err = ParseCommand(content)
+		err = p.contentError(el, content, err)
+	}
+	if err == nil {
+		err = p.validateSection(plugin, el, ev)
+	}
+	if err != nil {
+		if err := p.recover(err); err != nil {
+			return err
+		}
+		if !atEOF {
+			return nil
+		}
+	}
+	if ev.Truncated {
+		ev.Content += plugin.TruncationMarker
+	}
+	if p.referencer != nil {
+		if err := p.resolve(ev); err != nil {
+			if err := p.recover(err); err != nil {
+				return err
+			}
+			if !atEOF {
+				return nil
 			}
 		}
+	}
+	if err := p.emit(ev); err != nil {
+		return err
+	}
+	if ev.Truncated && p.truncations != nil {
+		p.truncations[strings.ToLower(el.canon)]++
+	}
+	return p.subParse(el, content)
+}
 
-		// Emit the section event
-		p.sink.Emit(SectionEvent{
-			Name:    sectionName,
-			Attrs:   p.active.attrs,
-			Content: content,
-		})
-		p.active = nil
+// endOf is where el ends: el.end if set, otherwise the current position.
+func (p *parser) endOf(el *element) Position {
+	if el.end == (Position{}) {
+		return p.pos
 	}
-	return nil
+	return el.end
 }
 
-func (p *parser) appendText(s string) {
-	// In flat mode, we only append when an active section exists.
-	if p.active == nil || s == "" {
-		return
+// emit delivers ev to the sink, enforcing the event cap. Limit errors and a done context
+// bypass recovery; sink errors go through it.
+func (p *parser) emit(ev Event) error {
+	_, err := p.emitVetoable(ev)
+	return err
+}
+
+// emitVetoable is emit that also returns the sink's error, if it was recovered from.
+func (p *parser) emitVetoable(ev Event) (veto, err error) {
+	if err := p.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.maxEvents > 0 && p.events >= p.maxEvents {
+		return nil, NewStreamLimitError(p.pos, "events", int64(p.maxEvents), p.tz.lastContent)
+	}
+	p.events++
+	base := ev.Base()
+	base.Seq = int64(p.events)
+	base.StreamMeta = p.streamMeta
+	base.Ancestry = p.ancestors()
+	ev = ev.withBase(base)
+	if sev, ok := ev.(SectionEvent); ok && p.revisions != nil {
+		ev = p.revise(sev)
+	}
+	if err := p.deliverTimed(p.registeredCase(ev)); err != nil {
+		return err, p.recover(err)
+	}
+	if p.halted {
+		return nil, errHalted
 	}
-	p.active.body.WriteString(s)
+	sev, ok := ev.(SectionEvent)
+	if !ok {
+		return nil, nil
+	}
+	if sev.Supersedes != 0 {
+		old := SupersededEvent{Superseded: sev.Supersedes, Name: p.revisions.sections[sev.Supersedes], By: sev}
+		old.StartPos, old.EndPos = sev.StartPos, sev.EndPos
+		if err := p.emit(old); err != nil {
+			return nil, err
+		}
+	}
+	if p.pairer != nil {
+		return nil, p.pair(sev)
+	}
+	return nil, nil
 }
 
-// consume processes n bytes from the buffer, updating position tracking
-func (p *parser) consume(n int) {
-	// Store the consumed bytes for context in error messages
-	consumed := p.buf.Bytes()[:n]
-	p.updateLastContent(string(consumed))
+// validateSection applies plugin-level rules and then the registered validators to ev, the
+// event of el. A ContentSyntaxError is turned into a ValidationError at its place in the stream.
+func (p *parser) validateSection(plugin SectionPlugin, el *element, ev SectionEvent) error {
+	content := ev.Content
+	if plugin.RejectEmpty && content == "" {
+		return NewValidationError(p.pos, el.canon, "section must not be empty", p.tz.lastContent)
+	}
+	if p.validators == nil {
+		return nil
+	}
+	return p.contentError(el, content, p.validators.validate(ev, p.pos))
+}
 
-	// Update line and column positions
-	for i := 0; i < n; i++ {
-		if i < len(consumed) && consumed[i] == '\n' {
-			p.pos.Line++
-			p.pos.Column = 1
-		} else {
-			p.pos.Column++
+// contentError turns a ContentSyntaxError about el's content into a ValidationError at its
+// place in the stream. Other errors are returned as they are.
+func (p *parser) contentError(el *element, content string, err error) error {
+	var cse *ContentSyntaxError
+	if errors.As(err, &cse) {
+		base := el.bodyStart
+		if base == (Position{}) {
+			base = el.start
 		}
+		return NewValidationError(rebase(cse.Pos, base), el.canon, cse.Message, content)
 	}
+	return err
+}
 
-	// Remove the bytes from the buffer
-	_ = p.buf.Next(n)
+// endBodyFences ends a code block that el's body left open at end.
+func (p *parser) endBodyFences(el *element, end Position) error {
+	if el.block == nil {
+		return nil
+	}
+	ev := el.block.event(end)
+	el.block = nil
+	return p.emitCodeBlocks(ev)
 }
 
-// updateLastContent maintains a sliding window of recent content for error context
-func (p *parser) updateLastContent(s string) {
-	const maxContextLen = 1000 // Limit context size to avoid memory issues
-	p.lastContent += s
-	if len(p.lastContent) > maxContextLen {
-		p.lastContent = p.lastContent[len(p.lastContent)-maxContextLen:]
+// advance returns the position just past b when b starts at pos.
+func advance(pos Position, b []byte) Position {
+	pos.Offset += int64(len(b))
+	for _, c := range b {
+		if c == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
 	}
+	return pos
 }
 
 // --- Tag tokenization ---
@@ -546,173 +1620,292 @@ type tagToken struct {
 	kind  tagTokenKind
 	name  string
 	attrs map[string]string
+	dup   string
 }
 
 // parseTagToken tries to parse a single tag token from the beginning of data (which must start with '<').
 // Returns (consumedBytes, token, ok, error). If ok=false and error is nil, the caller should wait for more input.
 // If error is not nil, parsing failed with a specific error.
 func parseTagToken(data []byte, pos Position, context string) (int, tagToken, bool, error) {
+	var s tagScanner
+	return s.scan(data, pos, context)
+}
+
+// tagPhase is where a tagScanner stopped inside a tag.
+type tagPhase int
+
+const (
+	tagStart        tagPhase = iota // at '<'
+	tagCloseName                    // inside the name after "</"
+	tagCloseEnd                     // after the closing name, expecting '>'
+	tagOpenName                     // inside the name after '<'
+	tagAttrs                        // between attributes, expecting a key, '>' or "/>"
+	tagSelfClose                    // after '/', expecting '>'
+	tagAttrKey                      // inside an attribute name
+	tagAttrEq                       // after an attribute name, expecting '='
+	tagAttrValue                    // after '=', expecting a quote or '{'
+	tagQuoted                       // inside a quoted value
+	tagBraced                       // inside a {…} value
+	tagBracedQuoted                 // inside a quoted string within a {…} value
+)
+
+// tagScanner tokenizes one tag that may arrive over several chunks. When scan has to wait
+// for more input it remembers how far it got, so the next call continues there instead of
+// rescanning the tag. The caller must pass the same data again, extended, until scan returns
+// a token or an error; the scanner then resets itself.
+type tagScanner struct {
+	phase tagPhase
+	i     int  // bytes of the tag examined so far
+	mark  int  // start of the name, key or value being scanned
+	quote byte // closing quote of the current string
+	depth int  // brace depth of a {…} value
+	name  string
+	key   string
+	keyAt int // start of key in the tag
+	attrs map[string]string
+	dup   string // first attribute key given twice; the later value wins
+
+	keyPos map[string]int // if set, where in the tag each attribute's key starts
+
+	keepCase  bool // keep attribute keys as written instead of lowercasing them
+	maxAttrs  int  // attributes per tag; zero is unlimited
+	maxKeyLen int  // bytes per attribute name; zero is unlimited
+	maxLen    int  // bytes per tag; zero is unlimited
+}
+
+// reset forgets the tag in progress, keeping the limits.
+func (s *tagScanner) reset() {
+	*s = tagScanner{keepCase: s.keepCase, maxAttrs: s.maxAttrs, maxKeyLen: s.maxKeyLen, maxLen: s.maxLen}
+}
+
+// scan has the contract of parseTagToken.
+func (s *tagScanner) scan(data []byte, pos Position, context string) (n int, tok tagToken, ok bool, err error) {
 	if len(data) == 0 || data[0] != '<' {
 		return 0, tagToken{}, false, nil
 	}
-
-	i := 1
-	skipSpaces := func() {
-		for i < len(data) && isSpace(data[i]) {
-			i++
+	defer func() {
+		if ok || err != nil {
+			s.reset()
 		}
+	}()
+	// Scan no further than maxLen, so that a tag ends the same way however it is chunked.
+	capped := s.maxLen > 0 && len(data) > s.maxLen
+	if capped {
+		data = data[:s.maxLen]
 	}
 
-	// Closing tag?
-	if i < len(data) && data[i] == '/' {
-		i++
-		start := i
-		for i < len(data) && isNameChar(data[i]) {
-			i++
-		}
-		if i == len(data) {
-			return 0, tagToken{}, false, nil
-		}
-		name := string(data[start:i])
-		skipSpaces()
-		if i == len(data) {
-			return 0, tagToken{}, false, nil
+	i := s.i
+	wait := func() (int, tagToken, bool, error) {
+		if capped {
+			return s.maxLen, tagToken{}, false, NewMalformedTagError(
+				pos, s.name, fmt.Sprintf("tag longer than %d bytes", s.maxLen), context)
 		}
-		if data[i] != '>' {
-			return i, tagToken{}, false, NewMalformedTagError(
-				pos, name, "expected '>' after closing tag name", context)
-		}
-		return i + 1, tagToken{kind: tokenClose, name: name}, true, nil
-	}
-
-	// Opening or self-closing
-	start := i
-	for i < len(data) && isNameChar(data[i]) {
-		i++
-	}
-	if i == len(data) {
+		s.i = i
 		return 0, tagToken{}, false, nil
 	}
-	if start == i {
-		return i, tagToken{}, false, NewMalformedTagError(
-			pos, "", "missing tag name after '<'", context)
-	}
-	name := string(data[start:i])
-
-	attrs := map[string]string{}
 	for {
-		skipSpaces()
-		if i == len(data) {
-			return 0, tagToken{}, false, nil
-		}
+		switch s.phase {
+		case tagStart:
+			if len(data) < 2 {
+				return wait()
+			}
+			if data[1] == '/' {
+				i, s.phase = 2, tagCloseName
+			} else {
+				i, s.phase = 1, tagOpenName
+			}
+			s.mark = i
 
-		switch data[i] {
-		case '>':
-			return i + 1, tagToken{kind: tokenOpen, name: name, attrs: attrs}, true, nil
-		case '/':
-			i++
+		case tagCloseName:
+			for i < len(data) && isNameChar(data[i]) {
+				i++
+			}
 			if i == len(data) {
-				return 0, tagToken{}, false, nil
+				return wait()
+			}
+			s.name, s.phase = string(data[s.mark:i]), tagCloseEnd
+
+		case tagCloseEnd:
+			i = skipSpace(data, i)
+			if i == len(data) {
+				return wait()
 			}
 			if data[i] != '>' {
 				return i, tagToken{}, false, NewMalformedTagError(
-					pos, name, "expected '>' after '/' in self-closing tag", context)
+					pos, s.name, "expected '>' after closing tag name", context)
 			}
-			return i + 1, tagToken{kind: tokenSelfClose, name: name, attrs: attrs}, true, nil
-		}
+			return i + 1, tagToken{kind: tokenClose, name: s.name}, true, nil
 
-		// attribute key
-		kStart := i
-		for i < len(data) && isAttrNameChar(data[i]) {
-			i++
-		}
-		if i == len(data) {
-			return 0, tagToken{}, false, nil
-		}
-		if kStart == i {
-			return i, tagToken{}, false, NewMalformedTagError(
-				pos, name, "expected attribute name or '>' or '/>'", context)
-		}
-		key := string(data[kStart:i])
+		case tagOpenName:
+			for i < len(data) && isNameChar(data[i]) {
+				i++
+			}
+			if i == len(data) {
+				return wait()
+			}
+			if s.mark == i {
+				return i, tagToken{}, false, NewMalformedTagError(
+					pos, "", "missing tag name after '<'", context)
+			}
+			s.name, s.attrs, s.phase = string(data[s.mark:i]), map[string]string{}, tagAttrs
 
-		skipSpaces()
-		if i == len(data) {
-			return 0, tagToken{}, false, nil
-		}
-		if data[i] != '=' {
-			return i, tagToken{}, false, NewAttributeParsingError(
-				pos, name, key, "expected '=' after attribute name", context)
-		}
-		i++
-		skipSpaces()
-		if i == len(data) {
-			return 0, tagToken{}, false, nil
-		}
+		case tagAttrs:
+			i = skipSpace(data, i)
+			if i == len(data) {
+				return wait()
+			}
+			switch data[i] {
+			case '>':
+				return i + 1, tagToken{kind: tokenOpen, name: s.name, attrs: s.attrs, dup: s.dup}, true, nil
+			case '/':
+				i++
+				s.phase = tagSelfClose
+			default:
+				if s.maxAttrs > 0 && len(s.attrs) >= s.maxAttrs {
+					return i, tagToken{}, false, NewMalformedTagError(
+						pos, s.name, fmt.Sprintf("more than %d attributes", s.maxAttrs), context)
+				}
+				s.mark, s.keyAt, s.phase = i, i, tagAttrKey
+			}
+
+		case tagSelfClose:
+			if i == len(data) {
+				return wait()
+			}
+			if data[i] != '>' {
+				return i, tagToken{}, false, NewMalformedTagError(
+					pos, s.name, "expected '>' after '/' in self-closing tag", context)
+			}
+			return i + 1, tagToken{kind: tokenSelfClose, name: s.name, attrs: s.attrs, dup: s.dup}, true, nil
+
+		case tagAttrKey:
+			for i < len(data) && isAttrNameChar(data[i]) {
+				i++
+			}
+			if s.maxKeyLen > 0 && i-s.mark > s.maxKeyLen {
+				return s.mark + s.maxKeyLen, tagToken{}, false, NewMalformedTagError(
+					pos, s.name, fmt.Sprintf("attribute name longer than %d bytes", s.maxKeyLen), context)
+			}
+			if i == len(data) {
+				return wait()
+			}
+			if s.mark == i {
+				return i, tagToken{}, false, NewMalformedTagError(
+					pos, s.name, "expected attribute name or '>' or '/>'", context)
+			}
+			s.key, s.phase = string(data[s.mark:i]), tagAttrEq
+
+		case tagAttrEq:
+			i = skipSpace(data, i)
+			if i == len(data) {
+				return wait()
+			}
+			if data[i] != '=' {
+				return i, tagToken{}, false, s.attrError(data, pos, "expected '=' after attribute name", context)
+			}
+			i++
+			s.phase = tagAttrValue
 
-		// attribute value: quoted "…"/'…' OR JSX braced { … }
-		switch data[i] {
-		case '"', '\'':
-			quote := data[i]
+		case tagAttrValue:
+			// attribute value: quoted "…"/'…' OR JSX braced { … }
+			i = skipSpace(data, i)
+			if i == len(data) {
+				return wait()
+			}
+			switch data[i] {
+			case '"', '\'':
+				s.quote, s.phase = data[i], tagQuoted
+			case '{':
+				s.depth, s.phase = 1, tagBraced
+			default:
+				return i, tagToken{}, false, s.attrError(data, pos, "expected attribute value to start with quote or brace", context)
+			}
 			i++
-			vStart := i
-			for i < len(data) && data[i] != quote {
-				if data[i] == '\\' && i+1 < len(data) { // skip escapes
+			s.mark = i
+
+		case tagQuoted, tagBracedQuoted:
+			for i < len(data) && data[i] != s.quote {
+				if data[i] == '\\' { // skip escapes
+					if i+1 == len(data) {
+						return wait()
+					}
 					i += 2
 					continue
 				}
 				i++
 			}
 			if i == len(data) {
-				return 0, tagToken{}, false, nil
+				return wait()
 			}
-			val := string(data[vStart:i])
 			i++ // consume closing quote
-			attrs[strings.ToLower(strings.TrimSpace(key))] = val
+			if s.phase == tagBracedQuoted {
+				s.phase = tagBraced
+				continue
+			}
+			s.setAttr(string(data[s.mark : i-1]))
+			s.phase = tagAttrs
 
-		case '{':
+		case tagBraced:
 			// scan balanced braces, allowing nested { } and quoted strings inside
-			i++
-			vStart := i
-			depth := 1
-			for i < len(data) && depth > 0 {
+			for i < len(data) && s.depth > 0 && s.phase == tagBraced {
 				switch data[i] {
 				case '{':
-					depth++
-					i++
+					s.depth++
 				case '}':
-					depth--
-					i++
+					s.depth--
 				case '"', '\'':
-					q := data[i]
-					i++
-					for i < len(data) && data[i] != q {
-						if data[i] == '\\' && i+1 < len(data) {
-							i += 2
-							continue
-						}
-						i++
-					}
-					if i == len(data) {
-						return 0, tagToken{}, false, nil
-					}
-					i++ // consume closing quote
-				default:
-					i++
+					s.quote, s.phase = data[i], tagBracedQuoted
 				}
+				i++
 			}
-			if depth != 0 {
-				return 0, tagToken{}, false, nil
-			} // incomplete
-			val := string(data[vStart : i-1]) // without outer braces
-			attrs[strings.ToLower(strings.TrimSpace(key))] = "{" + val + "}"
-
-		default:
-			return i, tagToken{}, false, NewAttributeParsingError(
-				pos, name, key, "expected attribute value to start with quote or brace", context)
+			if s.phase == tagBracedQuoted {
+				continue
+			}
+			if s.depth != 0 {
+				return wait()
+			}
+			val := string(data[s.mark : i-1]) // without outer braces
+			s.setAttr("{" + val + "}")
+			s.phase = tagAttrs
 		}
 	}
 }
 
+// attrKey is the map key of the attribute being scanned.
+func (s *tagScanner) attrKey() string {
+	if s.keepCase {
+		return strings.TrimSpace(s.key)
+	}
+	return strings.ToLower(strings.TrimSpace(s.key))
+}
+
+// setAttr stores value under the current key, noting the first key given twice.
+func (s *tagScanner) setAttr(value string) {
+	key := s.attrKey()
+	if _, ok := s.attrs[key]; ok && s.dup == "" {
+		s.dup = key
+	}
+	s.attrs[key] = value
+	if s.keyPos != nil {
+		s.keyPos[key] = s.keyAt
+	}
+}
+
+// attrError reports a problem with the attribute being scanned, located at its key.
+func (s *tagScanner) attrError(data []byte, pos Position, message, context string) error {
+	err := NewAttributeParsingError(pos, s.name, s.key, message, context)
+	err.AttrPos = advance(pos, data[:s.keyAt])
+	return err
+}
+
+// skipSpace returns the index of the first non-space byte of data at or after i.
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && isSpace(data[i]) {
+		i++
+	}
+	return i
+}
+
 func isSpace(b byte) bool { return b == ' ' || b == '\n' || b == '\t' || b == '\r' }
 func isNameChar(b byte) bool {
 	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_' || b == '-'
@@ -0,0 +1,221 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrorInfo is the structured form of a promptweaver error, for API responses and logs.
+// Fields that do not apply to an error kind are left empty.
+type ErrorInfo struct {
+	Kind          string     `json:"kind"` // e.g. "malformed_tag", "validation"
+	Message       string     `json:"message"`
+	Tag           string     `json:"tag,omitempty"`
+	Section       string     `json:"section,omitempty"`
+	Attribute     string     `json:"attribute,omitempty"`
+	Attrs         []string   `json:"attrs,omitempty"`   // the attributes an AttributeValidationError names
+	ID            string     `json:"id,omitempty"`      // the id a ReferenceError is about
+	Problem       string     `json:"problem,omitempty"` // what a WellFormednessError found
+	Pos           Position   `json:"position"`
+	Start         *Position  `json:"start,omitempty"` // opening tag of the section involved
+	AttrPos       *Position  `json:"attr_position,omitempty"`
+	BytesReceived int        `json:"bytes_received,omitempty"`
+	Timeout       string     `json:"timeout,omitempty"`
+	Limit         string     `json:"limit,omitempty"`
+	Max           int64      `json:"max,omitempty"`
+	SnippetBefore string     `json:"snippet_before,omitempty"` // input just before Pos
+	SnippetAfter  string     `json:"snippet_after,omitempty"`  // input from Pos on
+	Skipped       string     `json:"skipped,omitempty"`        // input dropped to recover
+	Choice        *int       `json:"choice,omitempty"`         // the choice a ChoiceError is about
+	Attempts      int        `json:"attempts,omitempty"`       // retries a ReadRetryError made
+	Key           string     `json:"key,omitempty"`            // the shard key of a ShardError
+	Seq           int64      `json:"seq,omitempty"`            // the event a ShardError failed on
+	Cause         *ErrorInfo `json:"cause,omitempty"`          // the error a wrapper error wraps
+}
+
+// DetailedError is implemented by every error type in this package.
+type DetailedError interface {
+	error
+	ErrorDetails() ErrorInfo
+}
+
+// ErrorToJSON finds the promptweaver error in err's chain and marshals its ErrorInfo.
+// It reports false if there is none.
+func ErrorToJSON(err error) ([]byte, bool) {
+	var d DetailedError
+	if !errors.As(err, &d) {
+		return nil, false
+	}
+	b, merr := json.Marshal(d.ErrorDetails())
+	return b, merr == nil
+}
+
+// wrapperInfo describes an error that wraps err: the first line of its message, and err's
+// details as the cause, and their position, when err has them.
+func wrapperInfo(kind, message string, err error) ErrorInfo {
+	message, _, _ = strings.Cut(message, "\n")
+	info := ErrorInfo{Kind: kind, Message: message}
+	var d DetailedError
+	if errors.As(err, &d) {
+		cause := d.ErrorDetails()
+		info.Cause, info.Pos = &cause, cause.Pos
+	}
+	return info
+}
+
+func (e *ParseError) info(kind string) ErrorInfo {
+	return ErrorInfo{Kind: kind, Message: e.Message, Pos: e.Pos, SnippetBefore: e.SnippetBefore, SnippetAfter: e.SnippetAfter, Skipped: string(e.Skipped)}
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ParseError) ErrorDetails() ErrorInfo { return e.info("parse") }
+
+// ErrorDetails returns the error as structured data.
+func (e *MalformedTagError) ErrorDetails() ErrorInfo {
+	info := e.info("malformed_tag")
+	info.Tag = e.TagName
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *AttributeParsingError) ErrorDetails() ErrorInfo {
+	info := e.info("attribute")
+	info.Tag, info.Attribute = e.TagName, e.AttributeName
+	if e.AttrPos.Line > 0 {
+		info.AttrPos = &e.AttrPos
+	}
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *UnmatchedTagError) ErrorDetails() ErrorInfo {
+	info := e.info("unmatched_tag")
+	info.Tag = e.TagName
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *UnexpectedClosingTagError) ErrorDetails() ErrorInfo {
+	info := e.info("unexpected_closing_tag")
+	info.Tag, info.Section, info.Start = e.TagName, e.SectionName, &e.Start
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ValidationError) ErrorDetails() ErrorInfo {
+	info := e.info("validation")
+	info.Section = e.SectionName
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *UnclosedSectionError) ErrorDetails() ErrorInfo {
+	info := e.info("unclosed_section")
+	info.Section, info.Start, info.BytesReceived = e.SectionName, &e.Start, e.BytesReceived
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *SectionTimeoutError) ErrorDetails() ErrorInfo {
+	info := e.info("section_timeout")
+	info.Section, info.Start, info.BytesReceived = e.SectionName, &e.Start, e.BytesReceived
+	info.Timeout = e.Timeout.String()
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ByteBudgetError) ErrorDetails() ErrorInfo {
+	info := e.info("byte_budget")
+	info.Section, info.Start, info.BytesReceived = e.SectionName, &e.Start, e.Used
+	info.Limit, info.Max = "budget", int64(e.Budget)
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *StreamLimitError) ErrorDetails() ErrorInfo {
+	info := e.info("stream_limit")
+	info.Limit, info.Max = e.Limit, e.Max
+	return info
+}
+
+// ErrorDetails returns the error as structured data. Pos is relative to the content.
+func (e *ContentSyntaxError) ErrorDetails() ErrorInfo {
+	return ErrorInfo{Kind: "content_syntax", Message: e.Message, Pos: e.Pos}
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ChoiceError) ErrorDetails() ErrorInfo {
+	info := wrapperInfo("choice", e.Error(), e.Err)
+	info.Choice = &e.Choice
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ReadRetryError) ErrorDetails() ErrorInfo {
+	info := wrapperInfo("read_retry", e.Error(), e.Err)
+	info.Attempts = e.Attempts
+	return info
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ShardError) ErrorDetails() ErrorInfo {
+	info := wrapperInfo("shard", e.Error(), e.Err)
+	info.Key, info.Seq = e.Key, e.Seq
+	return info
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *ParseError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *MalformedTagError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *AttributeParsingError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *UnmatchedTagError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *UnexpectedClosingTagError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.ErrorDetails())
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *ValidationError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *UnclosedSectionError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *SectionTimeoutError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *ByteBudgetError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *StreamLimitError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *ContentSyntaxError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *ReferenceError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *WellFormednessError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *AttributeValidationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.ErrorDetails())
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *ChoiceError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *ReadRetryError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
+
+// MarshalJSON implements json.Marshaler.
+func (e *ShardError) MarshalJSON() ([]byte, error) { return json.Marshal(e.ErrorDetails()) }
@@ -3,34 +3,57 @@ package promptweaver
 import (
 	"fmt"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Position represents a position in the input stream.
 type Position struct {
-	Line   int // 1-based line number
-	Column int // 1-based column number
+	Line   int   `json:"line"`   // 1-based line number
+	Column int   `json:"column"` // 1-based column number, counted in bytes
+	Offset int64 `json:"offset"` // 0-based byte offset into the raw stream
 }
 
+// maxReportedColumn is the largest column String spells out. Past it, on lines such as a
+// minified bundle, the offset locates the position better.
+const maxReportedColumn = 10000
+
 // String returns a string representation of the position.
 func (p Position) String() string {
-	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+	if p.Column > maxReportedColumn {
+		return fmt.Sprintf("line %d, column >%d (offset %d)", p.Line, maxReportedColumn, p.Offset)
+	}
+	return fmt.Sprintf("line %d, column %d (offset %d)", p.Line, p.Column, p.Offset)
 }
 
+// maxSnippetLen bounds each side of the input snippet kept in an error.
+const maxSnippetLen = 160
+
 // ParseError is the base error type for all parsing errors.
 type ParseError struct {
-	Pos     Position // Position where the error occurred
-	Message string   // Error message
-	Context string   // Surrounding content for context
+	Pos           Position // Position where the error occurred
+	Message       string   // Error message
+	SnippetBefore string   // Up to 160 bytes of input just before Pos
+	SnippetAfter  string   // Up to 160 bytes of input from Pos on
+	Skipped       []byte   // Input dropped to recover from the error, capped by EngineOptions.MaxSkippedBytes
+	located       bool     // the snippets were cut from the stream around Pos
+
+	// Deprecated: Context is Render's output as of when the snippets were set, kept
+	// for callers of earlier versions. Use SnippetBefore, SnippetAfter and Render.
+	Context string
 }
 
 // Error implements the error interface.
 func (e *ParseError) Error() string {
-	if e.Context != "" {
-		return fmt.Sprintf("%s at %s\nContext: %s", e.Message, e.Pos, e.Context)
+	if ctx := e.Render(); ctx != "" {
+		return fmt.Sprintf("%s at %s\nContext: %s", e.Message, e.Pos, ctx)
 	}
 	return fmt.Sprintf("%s at %s", e.Message, e.Pos)
 }
 
+// parseError gives access to the embedded ParseError of every error type in this package.
+func (e *ParseError) parseError() *ParseError { return e }
+
 // MalformedTagError represents an error when a tag is malformed.
 type MalformedTagError struct {
 	ParseError
@@ -40,24 +63,39 @@ type MalformedTagError struct {
 // Error implements the error interface.
 func (e *MalformedTagError) Error() string {
 	return fmt.Sprintf("malformed tag <%s> at %s: %s\nContext: %s",
-		e.TagName, e.Pos, e.Message, e.Context)
+		e.TagName, e.Pos, e.Message, e.Render())
 }
 
 // AttributeParsingError represents an error when parsing tag attributes.
 type AttributeParsingError struct {
 	ParseError
-	TagName       string // Name of the tag with the attribute error
-	AttributeName string // Name of the problematic attribute, if known
+	TagName       string   // Name of the tag with the attribute error
+	AttributeName string   // Name of the problematic attribute, if known
+	AttrPos       Position // Start of the attribute's key; Pos is the start of the tag
 }
 
 // Error implements the error interface.
 func (e *AttributeParsingError) Error() string {
 	if e.AttributeName != "" {
 		return fmt.Sprintf("error parsing attribute '%s' in tag <%s> at %s: %s\nContext: %s",
-			e.AttributeName, e.TagName, e.Pos, e.Message, e.Context)
+			e.AttributeName, e.TagName, e.Pos, e.Message, e.Render())
 	}
 	return fmt.Sprintf("error parsing attributes in tag <%s> at %s: %s\nContext: %s",
-		e.TagName, e.Pos, e.Message, e.Context)
+		e.TagName, e.Pos, e.Message, e.Render())
+}
+
+// Render is ParseError.Render with the caret under the attribute rather than the tag,
+// when the attribute lies within the snippet.
+func (e *AttributeParsingError) Render() string {
+	shift := int(e.AttrPos.Offset - e.Pos.Offset)
+	if e.AttrPos.Line == 0 || shift <= 0 || shift > len(e.SnippetAfter) {
+		return e.ParseError.Render()
+	}
+	at := e.ParseError
+	at.Pos = e.AttrPos
+	at.SnippetBefore += e.SnippetAfter[:shift]
+	at.SnippetAfter = e.SnippetAfter[shift:]
+	return at.Render()
 }
 
 // UnmatchedTagError represents an error when a closing tag doesn't match any opening tag.
@@ -69,7 +107,22 @@ type UnmatchedTagError struct {
 // Error implements the error interface.
 func (e *UnmatchedTagError) Error() string {
 	return fmt.Sprintf("unmatched closing tag </%s> at %s\nContext: %s",
-		e.TagName, e.Pos, e.Context)
+		e.TagName, e.Pos, e.Render())
+}
+
+// UnexpectedClosingTagError represents the closing tag of another registered section inside
+// the body of a section whose plugin sets StrictBody.
+type UnexpectedClosingTagError struct {
+	ParseError
+	TagName     string   // Name of the closing tag found
+	SectionName string   // Canonical name of the section that was open
+	Start       Position // Position of the open section's opening tag
+}
+
+// Error implements the error interface.
+func (e *UnexpectedClosingTagError) Error() string {
+	return fmt.Sprintf("unexpected closing tag </%s> in section <%s> opened at %s, at %s\nContext: %s",
+		e.TagName, e.SectionName, e.Start, e.Pos, e.Render())
 }
 
 // ValidationError represents an error when section content fails validation.
@@ -81,38 +134,92 @@ type ValidationError struct {
 // Error implements the error interface.
 func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation failed for section <%s> at %s: %s\nContext: %s",
-		e.SectionName, e.Pos, e.Message, e.Context)
+		e.SectionName, e.Pos, e.Message, e.Render())
+}
+
+// UnclosedSectionError represents a section still open at EOF under the ErrorPartial policy.
+type UnclosedSectionError struct {
+	ParseError
+	SectionName   string   // Canonical name of the unclosed section
+	Start         Position // Position of the opening tag
+	BytesReceived int      // Content bytes received before EOF
+}
+
+// Error implements the error interface.
+func (e *UnclosedSectionError) Error() string {
+	return fmt.Sprintf("section <%s> opened at %s was not closed before EOF at %s (%d bytes received)\nContext: %s",
+		e.SectionName, e.Start, e.Pos, e.BytesReceived, e.Render())
+}
+
+// SectionTimeoutError represents a section that stayed open longer than the section timeout.
+type SectionTimeoutError struct {
+	ParseError
+	SectionName   string        // Canonical name of the timed-out section
+	Start         Position      // Position of the opening tag
+	BytesReceived int           // Content bytes received before the timeout
+	Timeout       time.Duration // The configured timeout
+}
+
+// Error implements the error interface.
+func (e *SectionTimeoutError) Error() string {
+	return fmt.Sprintf("section <%s> opened at %s exceeded timeout %s at %s (%d bytes received)\nContext: %s",
+		e.SectionName, e.Start, e.Timeout, e.Pos, e.BytesReceived, e.Render())
+}
+
+// ByteBudgetError represents a section whose name went over its byte budget, aborting the
+// stream (see BudgetAbort).
+type ByteBudgetError struct {
+	ParseError
+	SectionName string   // Canonical name of the section
+	Start       Position // Position of the opening tag
+	Used        int      // Body bytes read for the name, in this stream
+	Budget      int      // The configured budget
+}
+
+// Error implements the error interface.
+func (e *ByteBudgetError) Error() string {
+	return fmt.Sprintf("section <%s> opened at %s exceeded byte budget %d at %s (%d bytes used)\nContext: %s",
+		e.SectionName, e.Start, e.Budget, e.Pos, e.Used, e.Render())
+}
+
+// StreamLimitError represents a stream that exceeded a configured byte or event cap.
+type StreamLimitError struct {
+	ParseError
+	Limit string // Which limit tripped: "bytes", "events" or "memory"
+	Max   int64  // The configured cap
+}
+
+// Error implements the error interface.
+func (e *StreamLimitError) Error() string {
+	return fmt.Sprintf("stream exceeded %s limit of %d at %s\nContext: %s",
+		e.Limit, e.Max, e.Pos, e.Render())
 }
 
 // NewParseError creates a new ParseError with context.
 func NewParseError(pos Position, message, context string) *ParseError {
-	return &ParseError{
-		Pos:     pos,
-		Message: message,
-		Context: extractContext(context, pos),
-	}
+	e := newParseError(pos, message, context)
+	return &e
+}
+
+// newParseError builds the ParseError embedded by the New*Error constructors.
+func newParseError(pos Position, message, context string) ParseError {
+	e := ParseError{Pos: pos, Message: message, SnippetBefore: snippetBefore(context)}
+	e.Context = e.Render()
+	return e
 }
 
 // NewMalformedTagError creates a new MalformedTagError.
 func NewMalformedTagError(pos Position, tagName, message, context string) *MalformedTagError {
 	return &MalformedTagError{
-		ParseError: ParseError{
-			Pos:     pos,
-			Message: message,
-			Context: extractContext(context, pos),
-		},
-		TagName: tagName,
+		ParseError: newParseError(pos, message, context),
+		TagName:    tagName,
 	}
 }
 
 // NewAttributeParsingError creates a new AttributeParsingError.
 func NewAttributeParsingError(pos Position, tagName, attrName, message, context string) *AttributeParsingError {
 	return &AttributeParsingError{
-		ParseError: ParseError{
-			Pos:     pos,
-			Message: message,
-			Context: extractContext(context, pos),
-		},
+		ParseError:    newParseError(pos, message, context),
 		TagName:       tagName,
 		AttributeName: attrName,
 	}
@@ -121,61 +228,151 @@ func NewAttributeParsingError(pos Position, tagName, attrName, message, context
 // NewUnmatchedTagError creates a new UnmatchedTagError.
 func NewUnmatchedTagError(pos Position, tagName, context string) *UnmatchedTagError {
 	return &UnmatchedTagError{
-		ParseError: ParseError{
-			Pos:     pos,
-			Message: "closing tag has no matching opening tag",
-			Context: extractContext(context, pos),
-		},
-		TagName: tagName,
+		ParseError: newParseError(pos, "closing tag has no matching opening tag", context),
+		TagName:    tagName,
+	}
+}
+
+// NewUnexpectedClosingTagError creates a new UnexpectedClosingTagError.
+func NewUnexpectedClosingTagError(pos Position, tagName, sectionName string, start Position, context string) *UnexpectedClosingTagError {
+	return &UnexpectedClosingTagError{
+		ParseError:  newParseError(pos, "closing tag does not match the open section", context),
+		TagName:     tagName,
+		SectionName: sectionName,
+		Start:       start,
 	}
 }
 
 // NewValidationError creates a new ValidationError.
 func NewValidationError(pos Position, sectionName, message, context string) *ValidationError {
 	return &ValidationError{
-		ParseError: ParseError{
-			Pos:     pos,
-			Message: message,
-			Context: extractContext(context, pos),
-		},
+		ParseError:  newParseError(pos, message, context),
 		SectionName: sectionName,
 	}
 }
 
-// extractContext extracts a snippet of text around the error position for context.
-// It tries to include a few lines before and after the error.
-func extractContext(content string, pos Position) string {
-	if content == "" {
-		return ""
+// NewUnclosedSectionError creates a new UnclosedSectionError.
+func NewUnclosedSectionError(pos Position, sectionName string, start Position, bytesReceived int, context string) *UnclosedSectionError {
+	return &UnclosedSectionError{
+		ParseError:    newParseError(pos, "section not closed before EOF", context),
+		SectionName:   sectionName,
+		Start:         start,
+		BytesReceived: bytesReceived,
 	}
+}
 
-	lines := strings.Split(content, "\n")
-	if pos.Line > len(lines) {
-		return content // Fallback if position is out of range
+// NewSectionTimeoutError creates a new SectionTimeoutError.
+func NewSectionTimeoutError(pos Position, sectionName string, start Position, bytesReceived int, timeout time.Duration, context string) *SectionTimeoutError {
+	return &SectionTimeoutError{
+		ParseError:    newParseError(pos, "section timed out", context),
+		SectionName:   sectionName,
+		Start:         start,
+		BytesReceived: bytesReceived,
+		Timeout:       timeout,
 	}
+}
 
-	// Determine the range of lines to include
-	startLine := max(0, pos.Line-3)
-	endLine := min(len(lines)-1, pos.Line+1)
+// NewStreamLimitError creates a new StreamLimitError.
+func NewStreamLimitError(pos Position, limit string, max int64, context string) *StreamLimitError {
+	return &StreamLimitError{
+		ParseError: newParseError(pos, fmt.Sprintf("%s limit of %d exceeded", limit, max), context),
+		Limit:      limit,
+		Max:        max,
+	}
+}
 
-	// Build the context with line numbers
-	var contextBuilder strings.Builder
-	for i := startLine; i <= endLine; i++ {
-		lineNum := i + 1 // Convert to 1-based line number
-		if lineNum == pos.Line {
-			// Highlight the error line
-			contextBuilder.WriteString(fmt.Sprintf("-> %d: %s\n", lineNum, lines[i]))
+// NewByteBudgetError creates a new ByteBudgetError.
+func NewByteBudgetError(pos Position, sectionName string, start Position, used, budget int, context string) *ByteBudgetError {
+	return &ByteBudgetError{
+		ParseError:  newParseError(pos, "byte budget exceeded", context),
+		SectionName: sectionName,
+		Start:       start,
+		Used:        used,
+		Budget:      budget,
+	}
+}
 
-			// Add a pointer to the column if possible
-			if pos.Column <= len(lines[i])+1 {
-				contextBuilder.WriteString(strings.Repeat(" ", pos.Column+5) + "^\n")
-			}
-		} else {
-			contextBuilder.WriteString(fmt.Sprintf("   %d: %s\n", lineNum, lines[i]))
-		}
+// snippetBefore keeps the end of context, the text a constructor was given as leading up to
+// the error. The engine replaces it with snippets cut from the stream around Pos.
+func snippetBefore(context string) string {
+	return validSnippet(clipRunes(context, len(context)-maxSnippetLen, len(context)))
+}
+
+// clipRunes returns s[from:to], clamped to s and shrunk to whole UTF-8 sequences.
+func clipRunes(s string, from, to int) string {
+	from, to = max(from, 0), min(to, len(s))
+	for from < to && !utf8.RuneStart(s[from]) {
+		from++
+	}
+	for to < len(s) && to > from && !utf8.RuneStart(s[to]) {
+		to--
+	}
+	return s[from:to]
+}
+
+// validSnippet makes s, cut from the input, valid UTF-8 so that it can be logged and
+// encoded as is: a rune the input was cut inside of at the end is dropped, and any other
+// invalid bytes are replaced with U+FFFD.
+func validSnippet(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	i := len(s) - 1
+	for i > 0 && len(s)-i < utf8.UTFMax && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	if !utf8.FullRuneInString(s[i:]) {
+		s = s[:i]
+	}
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
+
+// Render draws the snippets as numbered lines with a caret under the error position:
+//
+//	   4: <summary>Some content</summary>
+//	-> 5: <think attr missing-equals>
+//	                  ^
+//	   6:   Content
+//
+// It returns "" when the error carries no snippet. Snippets set by hand are cut to the
+// usual length first, so the caret line stays short on any input.
+func (e *ParseError) Render() string {
+	if e.SnippetBefore == "" && e.SnippetAfter == "" {
+		return ""
+	}
+	sb, sa := e.SnippetBefore, e.SnippetAfter
+	before := strings.Split(clipRunes(sb, len(sb)-maxSnippetLen, len(sb)), "\n")
+	after := strings.Split(clipRunes(sa, 0, maxSnippetLen), "\n")
+	head, tail := before[len(before)-1], after[0]
+	prev, next := before[:len(before)-1], after[1:]
+	if len(prev) > 2 {
+		prev = prev[len(prev)-2:]
+	}
+	if len(next) > 2 {
+		next = next[:2]
 	}
 
-	return contextBuilder.String()
+	var b strings.Builder
+	for i, l := range prev {
+		if n := e.Pos.Line - len(prev) + i; n >= 1 {
+			fmt.Fprintf(&b, "   %d: %s\n", n, strings.TrimSuffix(l, "\r"))
+		}
+	}
+	prefix := fmt.Sprintf("-> %d: ", e.Pos.Line)
+	fmt.Fprintf(&b, "%s%s\n", prefix, strings.TrimSuffix(head+tail, "\r"))
+	// One pad character per rune of head, so the caret lines up on multibyte lines.
+	pad := []rune(strings.Repeat(" ", len(prefix)))
+	for _, r := range head {
+		if r != '\t' {
+			r = ' '
+		}
+		pad = append(pad, r)
+	}
+	fmt.Fprintf(&b, "%s^\n", string(pad))
+	for i, l := range next {
+		fmt.Fprintf(&b, "   %d: %s\n", e.Pos.Line+1+i, strings.TrimSuffix(l, "\r"))
+	}
+	return b.String()
 }
 
 // Helper functions
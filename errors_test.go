@@ -1,8 +1,13 @@
 package promptweaver
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func Test_Engine_Should_Report_AttributeParsingError(t *testing.T) {
@@ -35,6 +40,29 @@ func Test_Engine_Should_Report_AttributeParsingError(t *testing.T) {
 	}
 }
 
+func Test_AttributeParsingError_Should_Locate_Attributes_On_Later_Lines(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	input := "intro\n<create-file path=\"a.go\"\n    mode=\"0644\"\n    owner=root>x</create-file>"
+
+	for _, chunk := range []int{1, 7, len(input)} {
+		err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, NewHandlerSink())
+		var attrErr *AttributeParsingError
+		if !errors.As(err, &attrErr) {
+			t.Fatalf("chunk %d: expected AttributeParsingError, got %v", chunk, err)
+		}
+		if attrErr.Pos != (Position{Line: 2, Column: 1, Offset: 6}) {
+			t.Fatalf("chunk %d: Pos should be the tag's, got %v", chunk, attrErr.Pos)
+		}
+		if attrErr.AttributeName != "owner" || attrErr.AttrPos != (Position{Line: 4, Column: 5, Offset: 51}) {
+			t.Fatalf("chunk %d: expected owner at line 4, column 5, got %q at %v", chunk, attrErr.AttributeName, attrErr.AttrPos)
+		}
+		if r := attrErr.Render(); !strings.Contains(r, "-> 4:     owner=\n") || !strings.Contains(r, "\n          ^\n") {
+			t.Fatalf("chunk %d: caret should be under the attribute:\n%s", chunk, r)
+		}
+	}
+}
+
 func Test_Engine_Should_Continue_After_Error_In_ContinueMode(t *testing.T) {
 	reg := NewRegistry()
 	reg.Register(SectionPlugin{Name: "think"})
@@ -221,3 +249,293 @@ func Test_Engine_Should_Support_Custom_Validation_Functions(t *testing.T) {
 		t.Fatalf("expected 1 event, got %d", len(events))
 	}
 }
+
+func Test_Engine_Should_Apply_Validator_Registered_Under_Alias(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"dyad-write"}})
+	sink := NewHandlerSink()
+
+	en := NewEngine(reg)
+	if err := en.RegisterRegexValidator("Dyad-Write", "package", "must declare a package"); err != nil {
+		t.Fatalf("failed to register validator: %v", err)
+	}
+	err := en.ProcessStream(ReaderFromString(`<write-file>x := 1</write-file>`), sink)
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError, got %T: %v", err, err)
+	}
+	if validationErr.SectionName != "write-file" {
+		t.Errorf("expected section name 'write-file', got %q", validationErr.SectionName)
+	}
+}
+
+func Test_Engine_RegisterValidatorStrict_Should_Reject_Unknown_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "code"})
+	en := NewEngine(reg)
+
+	v := &FuncValidator{ValidateFunc: func(string, string, Position) error { return nil }}
+	if err := en.RegisterValidatorStrict("code", v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := en.RegisterValidatorStrict("cod", v); !errors.Is(err, ErrUnknownSection) {
+		t.Fatalf("expected ErrUnknownSection, got %v", err)
+	}
+}
+
+func Test_Engine_ProcessStreamWith_Should_Scope_Validators_To_The_Stream(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}, RejectEmpty: true})
+	en := NewEngine(reg)
+	var order []string
+	check := func(name string, fail bool) Validator {
+		return &FuncValidator{ValidateFunc: func(section, content string, pos Position) error {
+			order = append(order, name)
+			if fail {
+				return NewValidationError(pos, section, name+" failed", content)
+			}
+			return nil
+		}}
+	}
+	en.RegisterValidator("write-file", check("engine", false))
+	strict := StreamOptions{ExtraValidators: map[string][]Validator{"create-file": {check("stream", true)}}}
+	trusted := StreamOptions{DisableValidators: []string{"create-file"}}
+
+	run := func(opts StreamOptions, input string) error {
+		order = nil
+		return en.ProcessStreamWith(ReaderFromString(input), NewHandlerSink(), opts)
+	}
+	var vErr *ValidationError
+	if err := run(strict, `<create-file path="a">x</create-file>`); !errors.As(err, &vErr) || vErr.Message != "stream failed" ||
+		strings.Join(order, ",") != "engine,stream" {
+		t.Fatalf("stream validators must run after the engine's, got %v %v", err, order)
+	}
+	if err := run(strict, `<create-file path="a"></create-file>`); !errors.As(err, &vErr) || vErr.Message != "section must not be empty" ||
+		len(order) != 0 {
+		t.Fatalf("plugin rules must run first, got %v %v", err, order)
+	}
+	if err := run(trusted, `<create-file path="a">x</create-file>`); err != nil || len(order) != 0 {
+		t.Fatalf("disabled engine validators must not run, got %v %v", err, order)
+	}
+	if err := en.ProcessStream(ReaderFromString(`<create-file path="a">x</create-file>`), NewHandlerSink()); err != nil ||
+		strings.Join(order, ",") != "engine" {
+		t.Fatalf("the engine's validators must be unchanged, got %v %v", err, order)
+	}
+}
+
+func Test_ErrorToJSON_Should_Find_Wrapped_Errors(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	err := NewEngineWithOptions(reg, WithEOFPolicy(ErrorPartial)).ProcessStream(ReaderFromString("a\n<think>half"), NewHandlerSink())
+	wrapped := fmt.Errorf("request 42: %w", err)
+
+	b, ok := ErrorToJSON(wrapped)
+	if !ok {
+		t.Fatalf("expected a promptweaver error in %v", wrapped)
+	}
+	var info ErrorInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if info.Kind != "unclosed_section" || info.Section != "think" || info.Start == nil || *info.Start != (Position{Line: 2, Column: 1, Offset: 2}) ||
+		info.Pos.Offset != 13 || info.BytesReceived != 4 || info.SnippetBefore != "a\n<think>half" || info.SnippetAfter != "" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if direct, _ := json.Marshal(err); string(direct) != string(b) {
+		t.Fatalf("MarshalJSON and ErrorToJSON disagree:\n%s\n%s", direct, b)
+	}
+	if !strings.HasPrefix(err.Error(), "section <think> opened at") {
+		t.Fatalf("Error() must stay unchanged, got %q", err.Error())
+	}
+
+	if _, ok := ErrorToJSON(errors.New("plain")); ok {
+		t.Fatal("a foreign error has no details")
+	}
+	b, _ = ErrorToJSON(NewAttributeParsingError(Position{Line: 1, Column: 5}, "think", "attr", "expected '='", ""))
+	if want := `{"kind":"attribute","message":"expected '='","tag":"think","attribute":"attr","position":{"line":1,"column":5,"offset":0}}`; string(b) != want {
+		t.Fatalf("got  %s\nwant %s", b, want)
+	}
+}
+
+func Test_ErrorToJSON_Should_Keep_Wrapper_Fields(t *testing.T) {
+	inner := NewValidationError(Position{Line: 1, Column: 2, Offset: 1}, "think", "too short", "")
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&ChoiceError{Choice: 0, Err: inner}, `"kind":"choice","message":"choice 0: validation failed for section \u003cthink\u003e at line 1, column 2 (offset 1): too short","position":{"line":1`},
+		{&ReadRetryError{Attempts: 3, Err: io.ErrUnexpectedEOF}, `"attempts":3`},
+		{&ShardError{Key: "a.go", Seq: 7, Err: inner}, `"key":"a.go","seq":7,"cause":{"kind":"validation"`},
+	}
+	for _, c := range cases {
+		b, ok := ErrorToJSON(fmt.Errorf("wrapped: %w", c.err))
+		if !ok || !strings.Contains(string(b), c.want) {
+			t.Errorf("%T: got %s, want it to contain %s", c.err, b, c.want)
+		}
+		if direct, _ := json.Marshal(c.err); string(direct) != string(b) {
+			t.Errorf("%T: MarshalJSON and ErrorToJSON disagree:\n%s\n%s", c.err, direct, b)
+		}
+	}
+	if info := (&ChoiceError{Choice: 0, Err: inner}).ErrorDetails(); info.Choice == nil || info.Cause == nil || info.Cause.Section != "think" {
+		t.Fatalf("ErrorDetails = %+v", info)
+	}
+	if info := (&ReadRetryError{Attempts: 1, Err: io.EOF}).ErrorDetails(); info.Cause != nil {
+		t.Fatalf("a foreign cause has no details, got %+v", info.Cause)
+	}
+}
+
+func Test_ParseError_Should_Keep_Bounded_Snippets_And_Render_Lazily(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	long := strings.Repeat("x", 500)
+	input := long + "\n<summary>Some content</summary>\n\n\t<think attr missing-equals>\n  Content\n</think>\n" + long
+
+	err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: 16}, NewHandlerSink())
+	var attrErr *AttributeParsingError
+	if !errors.As(err, &attrErr) {
+		t.Fatalf("expected AttributeParsingError, got %v", err)
+	}
+	if len(attrErr.SnippetBefore) > 160 || len(attrErr.SnippetAfter) > 160 {
+		t.Fatalf("snippets must be bounded, got %d and %d bytes", len(attrErr.SnippetBefore), len(attrErr.SnippetAfter))
+	}
+	at := int(attrErr.Pos.Offset)
+	if !strings.HasSuffix(input[:at], attrErr.SnippetBefore) || !strings.HasPrefix(input[at:], attrErr.SnippetAfter) {
+		t.Fatalf("snippets must surround the position: %q | %q", attrErr.SnippetBefore, attrErr.SnippetAfter)
+	}
+
+	want := "   2: <summary>Some content</summary>\n" +
+		"   3: \n" +
+		"-> 4: \t<think attr \n" +
+		"      \t       ^\n"
+	if got := attrErr.Render(); got != want {
+		t.Fatalf("unexpected rendering:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.HasSuffix(err.Error(), "Context: "+want) {
+		t.Fatalf("Error() must embed the rendering, got %q", err.Error())
+	}
+}
+
+func Test_ParseError_Should_Fill_The_Deprecated_Context(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	input := "some text\n<think attr missing-equals>\n  Content\n</think>\n"
+
+	err := NewEngine(reg).ProcessStream(ReaderFromString(input), NewHandlerSink())
+	var attrErr *AttributeParsingError
+	if !errors.As(err, &attrErr) {
+		t.Fatalf("expected AttributeParsingError, got %v", err)
+	}
+	if attrErr.Context == "" || attrErr.Context != attrErr.Render() {
+		t.Fatalf("Context = %q, want the rendering %q", attrErr.Context, attrErr.Render())
+	}
+
+	built := NewValidationError(Position{Line: 2, Column: 3}, "think", "too short", "first\nse")
+	if built.Context != built.Render() || !strings.Contains(built.Context, "-> 2: se") {
+		t.Fatalf("constructors must fill Context, got %q", built.Context)
+	}
+}
+
+func Test_ParseError_Should_Place_The_Caret_Under_Multibyte_Text(t *testing.T) {
+	e := &ParseError{Pos: Position{Line: 1, Column: 9}, SnippetBefore: "\tcafé ☕ ", SnippetAfter: "<x"}
+	want := "-> 1: \tcafé ☕ <x\n" +
+		"      \t       ^\n"
+	if got := e.Render(); got != want {
+		t.Fatalf("unexpected rendering:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_ParseError_Should_Render_Errors_On_Huge_Lines_Briefly(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	input := strings.Repeat("x", 1<<20) + "<think attr missing-equals>"
+
+	err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: 4096}, NewHandlerSink())
+	var attrErr *AttributeParsingError
+	if !errors.As(err, &attrErr) || attrErr.Pos.Column <= 1<<20 {
+		t.Fatalf("expected AttributeParsingError past column 1M, got %v", err)
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "column >10000 (offset ") || len(msg) > 1024 {
+		t.Fatalf("unexpected message of %d bytes: %.300q", len(msg), msg)
+	}
+
+	// Snippets set by hand are cut as well.
+	huge := &ParseError{Pos: attrErr.Pos, SnippetBefore: input, SnippetAfter: input}
+	if got := huge.Render(); len(got) > 1024 {
+		t.Fatalf("rendering of %d bytes", len(got))
+	}
+}
+
+func Test_Recovered_Error_Should_Carry_Skipped_Bytes(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := `<think>a</think>junk </ > <y =z> </think> more<summary>b</summary>`
+
+	for _, chunk := range []int{3, 64} {
+		var skipped []string
+		var sections []string
+		sink := EventSinkFunc(func(ev Event) { sections = append(sections, ev.(SectionEvent).Content) })
+		en := NewEngineWithOptions(reg, WithMaxSkippedBytes(6), WithErrorHandler(func(err error) bool {
+			var perr interface{ ErrorDetails() ErrorInfo }
+			if errors.As(err, &perr) {
+				skipped = append(skipped, perr.ErrorDetails().Skipped)
+			}
+			return true
+		}))
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, sink); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		if got := fmt.Sprintf("%q", skipped); got != `["</ >" "<y " "</thin"]` {
+			t.Fatalf("chunk %d: unexpected skipped bytes %s", chunk, got)
+		}
+		if strings.Join(sections, ",") != "a,b" {
+			t.Fatalf("chunk %d: unexpected sections %v", chunk, sections)
+		}
+	}
+}
+
+func Test_Error_Snippets_Should_Be_Valid_UTF8_When_Runes_Straddle_Chunks(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	input := "<think>" + strings.Repeat("😀", 1200) + "</think>"
+
+	for _, chunk := range []int{3, 5, 6, 7} {
+		for _, budget := range []int{4001, 4002, 4003} {
+			en := NewEngineWithOptions(reg, WithByteBudget(map[string]int{"think": budget}, func(string, int, int) BudgetAction { return BudgetAbort }))
+			err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, NewHandlerSink())
+			var budgetErr *ByteBudgetError
+			if !errors.As(err, &budgetErr) {
+				t.Fatalf("chunk %d, budget %d: expected ByteBudgetError, got %v", chunk, budget, err)
+			}
+			info := budgetErr.ErrorDetails()
+			if !utf8.ValidString(info.SnippetBefore) || !utf8.ValidString(info.SnippetAfter) || !utf8.ValidString(err.Error()) {
+				t.Fatalf("chunk %d, budget %d: invalid UTF-8 in %q / %q", chunk, budget, info.SnippetBefore, info.SnippetAfter)
+			}
+			if strings.ContainsRune(info.SnippetBefore, utf8.RuneError) || !strings.HasSuffix(info.SnippetBefore, "😀") {
+				t.Fatalf("chunk %d, budget %d: mangled snippet %q", chunk, budget, info.SnippetBefore)
+			}
+		}
+	}
+
+	// Invalid bytes in the input itself are replaced.
+	err := NewEngine(reg).ProcessStream(ReaderFromString("a\xffb</think>"), NewHandlerSink())
+	var unmatched *UnmatchedTagError
+	if !errors.As(err, &unmatched) || unmatched.SnippetBefore != "a�b" {
+		t.Fatalf("expected replaced snippet, got %v", err)
+	}
+}
+
+func Test_Tokenizer_Should_Keep_Whole_Runes_In_The_Error_Context_Window(t *testing.T) {
+	tz := newTokenizer(false, false)
+	tz.feed([]byte(strings.Repeat("é😀", maxContextRunes)))
+	for {
+		if _, ok, err := tz.next(true); err != nil || !ok {
+			break
+		}
+	}
+	if n := utf8.RuneCountInString(tz.lastContent); n != maxContextRunes || !utf8.ValidString(tz.lastContent) {
+		t.Fatalf("window of %d runes, valid %v", n, utf8.ValidString(tz.lastContent))
+	}
+}
@@ -0,0 +1,138 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event is implemented by every value the engine delivers to an EventSink.
+// Switch on Kind, or use AsSection and AsCodeBlock, to tell them apart.
+type Event interface {
+	// Kind identifies the concrete event type.
+	Kind() EventKind
+
+	// Base returns the fields common to all events.
+	Base() EventBase
+
+	withBase(EventBase) Event
+}
+
+// EventKind discriminates event types, in Go and in JSON (the "kind" field).
+type EventKind string
+
+const (
+	KindSection   EventKind = "section"    // SectionEvent
+	KindCodeBlock EventKind = "code_block" // CodeBlockEvent
+	KindPaired    EventKind = "paired"     // PairedEvent
+	KindDigest    EventKind = "digest"     // DigestEvent
+	KindProgress  EventKind = "progress"   // ProgressEvent
+
+	KindSectionClosed EventKind = "section_closed" // SectionClosedEvent
+	KindSuperseded    EventKind = "superseded"     // SupersededEvent
+	KindIntent        EventKind = "intent"         // IntentEvent
+	KindVetoed        EventKind = "vetoed"         // VetoedEvent
+)
+
+// StreamMeta is caller-supplied metadata identifying a stream, such as a request id.
+type StreamMeta map[string]string
+
+// EventBase holds the fields every event has. It is embedded in each event type.
+type EventBase struct {
+	// Seq numbers the events of a stream from 1, in emission order.
+	Seq int64 `json:"seq"`
+
+	// StartPos and EndPos delimit the event in the raw stream: [StartPos.Offset, EndPos.Offset).
+	StartPos Position `json:"start_pos"`
+	EndPos   Position `json:"end_pos"`
+
+	// StreamMeta is the metadata the engine was configured with (see WithStreamMeta).
+	StreamMeta StreamMeta `json:"stream_meta,omitempty"`
+
+	// Ancestry lists the unregistered tags open around the event when it was emitted,
+	// outermost first and as written, e.g. ["Response", "ToolCalls"] (see WithAncestry).
+	Ancestry []string `json:"ancestry,omitempty"`
+}
+
+// Base implements Event.
+func (b EventBase) Base() EventBase { return b }
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev SectionEvent) MarshalJSON() ([]byte, error) {
+	type plain SectionEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev CodeBlockEvent) MarshalJSON() ([]byte, error) {
+	type plain CodeBlockEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// UnmarshalEvent decodes an event marshaled with encoding/json into its concrete type,
+// chosen by the "kind" field.
+func UnmarshalEvent(data []byte) (Event, error) {
+	var head struct {
+		Kind EventKind `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	switch head.Kind {
+	case KindSection:
+		var ev SectionEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindCodeBlock:
+		var ev CodeBlockEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindPaired:
+		var ev PairedEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindDigest:
+		var ev DigestEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindProgress:
+		var ev ProgressEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindSectionClosed:
+		var ev SectionClosedEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindSuperseded:
+		var ev SupersededEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindIntent:
+		var ev IntentEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case KindVetoed:
+		var ev VetoedEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	default:
+		return nil, fmt.Errorf("promptweaver: unknown event kind %q", head.Kind)
+	}
+}
+
+// AsSection returns ev as a SectionEvent, if it is one.
+func AsSection(ev Event) (SectionEvent, bool) {
+	sev, ok := ev.(SectionEvent)
+	return sev, ok
+}
+
+// AsCodeBlock returns ev as a CodeBlockEvent, if it is one.
+func AsCodeBlock(ev Event) (CodeBlockEvent, bool) {
+	cev, ok := ev.(CodeBlockEvent)
+	return cev, ok
+}
@@ -0,0 +1,76 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Stamp_Seq_And_StreamMeta(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	meta := StreamMeta{"request": "r-1"}
+	en := NewEngineWithOptions(reg, WithCodeBlocks(), WithStreamMeta(meta))
+
+	rec := &recorderSink{}
+	input := "<summary>a</summary>\n```go\nx\n```\n<summary>b</summary>"
+	if err := en.ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	kinds := []EventKind{KindSection, KindCodeBlock, KindSection}
+	if len(rec.events) != len(kinds) {
+		t.Fatalf("expected %d events, got %+v", len(kinds), rec.events)
+	}
+	for i, ev := range rec.events {
+		if ev.Kind() != kinds[i] || ev.Base().Seq != int64(i+1) || ev.Base().StreamMeta["request"] != "r-1" {
+			t.Fatalf("event %d: kind %s, base %+v", i, ev.Kind(), ev.Base())
+		}
+	}
+	if _, ok := AsSection(rec.events[1]); ok {
+		t.Fatalf("AsSection accepted a code block")
+	}
+	if cb, ok := AsCodeBlock(rec.events[1]); !ok || cb.Content != "x\n" {
+		t.Fatalf("AsCodeBlock failed: %+v", rec.events[1])
+	}
+}
+
+func Test_Events_Should_Round_Trip_Through_JSON(t *testing.T) {
+	events := []Event{
+		SectionEvent{
+			EventBase: EventBase{Seq: 1, StartPos: Position{Line: 1, Column: 1}, EndPos: Position{Line: 1, Column: 9, Offset: 8}},
+			Name:      "write-file",
+			Attrs:     map[string]string{"path": "a.go"},
+			Content:   "x",
+		},
+		CodeBlockEvent{
+			EventBase: EventBase{Seq: 2, StreamMeta: StreamMeta{"choice": "0"}},
+			Lang:      "go",
+			File:      "b.go",
+			Meta:      map[string]string{"file": "b.go"},
+			Content:   "package b\n",
+		},
+		IntentEvent{EventBase: EventBase{Seq: 3}, Name: "write-file", Attrs: map[string]string{"path": "c.go"}},
+		VetoedEvent{EventBase: EventBase{Seq: 4}, Name: "write-file", Bytes: 12, Partial: true},
+	}
+	for _, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if !strings.Contains(string(b), `"kind":"`+string(ev.Kind())+`"`) {
+			t.Fatalf("missing kind field: %s", b)
+		}
+		back, err := UnmarshalEvent(b)
+		if err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(back, ev) {
+			t.Fatalf("round trip mismatch:\ngot  %+v\nwant %+v", back, ev)
+		}
+	}
+
+	if _, err := UnmarshalEvent([]byte(`{"kind":"bogus"}`)); err == nil {
+		t.Fatalf("expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,108 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExtractFirst returns the first section named section (a name or an alias) in r. Only that
+// section is buffered and validated: every other registered section is scanned for its
+// closer and its body discarded, as if suppressed, and no handlers run. Parsing stops once
+// the section closes, even within a read, and nothing more is read. It fails with
+// ErrUnknownSection for a name that is not registered, and with ErrSectionNotSeen,
+// wrapping the stream's error if there was one, when the stream ends without the section.
+func (e *Engine) ExtractFirst(r io.Reader, section string) (SectionEvent, error) {
+	canon, err := e.extractable(r, section)
+	if err != nil {
+		return SectionEvent{}, err
+	}
+	var first *SectionEvent
+	err = e.extract(r, canon, func(ev SectionEvent) bool {
+		first = &ev
+		return false
+	})
+	if first == nil {
+		return SectionEvent{}, sectionNotSeen(canon, err)
+	}
+	return *first, nil
+}
+
+// ExtractAll is ExtractFirst for every section named section in r, in stream order. The
+// whole stream is read. When it fails part way, the sections before the failure are
+// returned with the error.
+func (e *Engine) ExtractAll(r io.Reader, section string) ([]SectionEvent, error) {
+	canon, err := e.extractable(r, section)
+	if err != nil {
+		return nil, err
+	}
+	var all []SectionEvent
+	err = e.extract(r, canon, func(ev SectionEvent) bool {
+		all = append(all, ev)
+		return true
+	})
+	if len(all) == 0 {
+		return nil, sectionNotSeen(canon, err)
+	}
+	return all, err
+}
+
+// extractable returns the canonical name of section, which must be registered.
+func (e *Engine) extractable(r io.Reader, section string) (string, error) {
+	if e.reg == nil {
+		return "", e.checkSink(nil)
+	}
+	if r == nil {
+		return "", ErrNilReader
+	}
+	canon, ok := e.reg.Canonical(section)
+	if !ok {
+		return "", fmt.Errorf("%w: cannot extract %q", ErrUnknownSection, section)
+	}
+	return canon, nil
+}
+
+// errHalted ends a stream whose sink has what it wants.
+var errHalted = errors.New("stream halted")
+
+// extract runs r with every registered section but canon suppressed, passing the events of
+// canon to fn. Once fn returns false the stream stops where it is: the rest of the input
+// is neither read nor parsed, and nothing more is delivered.
+func (e *Engine) extract(r io.Reader, canon string, fn func(SectionEvent) bool) (err error) {
+	options := e.options
+	options.SuppressedSections = nil
+	for _, plugin := range e.reg.List() {
+		if !strings.EqualFold(plugin.Name, canon) {
+			options.SuppressedSections = append(options.SuppressedSections, plugin.Name)
+		}
+	}
+	options.SuppressHandler = nil
+	options.SectionClosedEvents = false
+
+	var s *stream
+	sink := EventSinkFunc(func(ev Event) {
+		if sev, ok := AsSection(ev); ok && strings.EqualFold(sev.Name, canon) && !fn(sev) {
+			s.p.halted = true
+		}
+	})
+	// run, with the stream at hand so that the sink can halt it.
+	if err := e.checkInputs(r, sink); err != nil {
+		return err
+	}
+	s = e.startStream(context.Background(), sink, options, e.validators)
+	defer func() { err = s.end(err) }()
+	if err := s.read(context.Background(), r); !errors.Is(err, errHalted) {
+		return err
+	}
+	return nil
+}
+
+// sectionNotSeen is ErrSectionNotSeen for canon, wrapping the stream's error, if any.
+func sectionNotSeen(canon string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrSectionNotSeen, canon, err)
+	}
+	return fmt.Errorf("%w: %q", ErrSectionNotSeen, canon)
+}
@@ -0,0 +1,157 @@
+package promptweaver
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_ExtractFirst_Should_Return_The_Target_And_Stop_Reading(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary", Aliases: []string{"tldr"}})
+
+	en := NewEngine(reg)
+	var validated []string
+	en.RegisterFuncValidator("think", func(name, content string, pos Position) error {
+		validated = append(validated, name)
+		return nil
+	})
+	input := `<think>long</think><tldr n="1">first</tldr>` + strings.Repeat("x", 1<<20) + `<summary>second</summary>`
+	r := &chunkedReader{data: []byte(input), chunk: 512}
+
+	ev, err := en.ExtractFirst(r, "SUMMARY")
+	if err != nil {
+		t.Fatalf("ExtractFirst error: %v", err)
+	}
+	if ev.Name != "summary" || ev.Content != "first" || ev.Attrs["n"] != "1" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if len(validated) != 0 {
+		t.Fatalf("validators of other sections ran: %v", validated)
+	}
+	if r.pos >= len(input)/2 {
+		t.Fatalf("read %d of %d bytes after the section closed", r.pos, len(input))
+	}
+}
+
+func Test_ExtractFirst_Should_Stop_Dispatching_At_The_Target(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	var resolved int
+	en := NewEngineWithOptions(reg,
+		WithReference("summary", "id", "summary", "ref"),
+		WithReferenceResolver(func(use, def SectionEvent) { resolved++ }),
+	)
+	var validated int
+	en.RegisterFuncValidator("summary", func(name, content string, pos Position) error {
+		validated++
+		return nil
+	})
+
+	// One read holds both sections.
+	input := `<summary id="a">first</summary><summary ref="a">second</summary>`
+	ev, err := en.ExtractFirst(strings.NewReader(input), "summary")
+	if err != nil || ev.Content != "first" {
+		t.Fatalf("unexpected result %+v, %v", ev, err)
+	}
+	if validated != 1 || resolved != 0 {
+		t.Fatalf("work went on after the target: %d validations, %d resolved references", validated, resolved)
+	}
+}
+
+func Test_ExtractAll_Should_Return_Every_Target_In_Order(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary", Aliases: []string{"tldr"}})
+	en := NewEngine(reg)
+
+	input := `<summary>a</summary><think><summary>in think</summary></think><tldr>b</tldr>`
+	got, err := en.ExtractAll(strings.NewReader(input), "tldr")
+	if err != nil {
+		t.Fatalf("ExtractAll error: %v", err)
+	}
+	if len(got) != 2 || got[0].Content != "a" || got[1].Content != "b" {
+		t.Fatalf("unexpected events %+v", got)
+	}
+
+	if _, err := en.ExtractAll(strings.NewReader(`<think>x</think>`), "summary"); !errors.Is(err, ErrSectionNotSeen) {
+		t.Fatalf("expected ErrSectionNotSeen, got %v", err)
+	}
+	if _, err := en.ExtractFirst(strings.NewReader(input), "plan"); !errors.Is(err, ErrUnknownSection) {
+		t.Fatalf("expected ErrUnknownSection, got %v", err)
+	}
+
+	// A stream error after the target keeps what was extracted.
+	failing := io.MultiReader(strings.NewReader(`<summary>a</summary>`), iotestErrReader{})
+	got, err = en.ExtractAll(failing, "summary")
+	if len(got) != 1 || !errors.Is(err, errExtractRead) {
+		t.Fatalf("expected one event and the read error, got %+v, %v", got, err)
+	}
+}
+
+var errExtractRead = errors.New("read failed")
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, errExtractRead }
+
+// benchmarkExtractInput is a response of about 240 KB with its summary halfway through.
+func benchmarkExtractInput() []byte {
+	var b strings.Builder
+	for i := 0; i < 2000; i++ {
+		if i == 1000 {
+			b.WriteString("<summary>done</summary>\n")
+		}
+		b.WriteString("<think>" + strings.Repeat("reasoning ", 10) + "</think>\n")
+	}
+	return []byte(b.String())
+}
+
+func Benchmark_ExtractFirst(b *testing.B) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+	input := benchmarkExtractInput()
+
+	b.SetBytes(int64(len(input)))
+	for b.Loop() {
+		if _, err := en.ExtractFirst(&chunkedReader{data: input, chunk: 4096}, "summary"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ExtractAll(b *testing.B) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+	input := benchmarkExtractInput()
+
+	b.SetBytes(int64(len(input)))
+	for b.Loop() {
+		if _, err := en.ExtractAll(&chunkedReader{data: input, chunk: 4096}, "summary"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ProcessStream_For_One_Section(b *testing.B) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+	input := benchmarkExtractInput()
+	sink := NewHandlerSink()
+	sink.RegisterHandler("summary", func(SectionEvent) {})
+
+	b.SetBytes(int64(len(input)))
+	for b.Loop() {
+		if err := en.ProcessStream(&chunkedReader{data: input, chunk: 4096}, sink); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package promptweaver
+
+import "strings"
+
+// CodeBlockEvent is a fenced code block (```lang key="value" ... ```). Its StartPos is the
+// start of the opening fence line and its EndPos the position just past the closing fence.
+type CodeBlockEvent struct {
+	EventBase
+	Lang    string            `json:"lang"`    // language token of the info string, e.g. "go"; may be empty
+	File    string            `json:"file"`    // shorthand for Meta["file"]
+	Meta    map[string]string `json:"meta"`    // all key/value pairs of the info string, keys lowercased
+	Content string            `json:"content"` // lines between the fences, indentation stripped
+}
+
+// Kind implements Event.
+func (CodeBlockEvent) Kind() EventKind { return KindCodeBlock }
+
+func (ev CodeBlockEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// ParseFenceHeader returns the language and file= value of a fence info string.
+// It is ParseFenceMeta reduced to the one key most callers need.
+func ParseFenceHeader(header string) (lang, file string) {
+	lang, meta := ParseFenceMeta(header)
+	return lang, meta["file"]
+}
+
+// ParseFenceMeta parses a fence info string such as `go file="m.go" test=true region='handlers'`.
+// The first token is the language unless it is itself a key=value pair. Values may be quoted
+// with " or ' (a backslash skips the next character and is kept, as in tag attributes) or bare,
+// running to the next whitespace. Keys are lowercased; a key without '=' maps to "".
+// Later duplicates win. The map is never nil.
+func ParseFenceMeta(header string) (lang string, meta map[string]string) {
+	meta = map[string]string{}
+	s := strings.TrimSpace(header)
+
+	if end := strings.IndexFunc(s, isSpaceRune); end != 0 {
+		if end < 0 {
+			end = len(s)
+		}
+		if first := s[:end]; !strings.ContainsAny(first, `="'`) {
+			lang, s = first, s[end:]
+		}
+	}
+
+	i := 0
+	for {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return lang, meta
+		}
+
+		kStart := i
+		for i < len(s) && s[i] != '=' && !isSpace(s[i]) {
+			i++
+		}
+		key := strings.ToLower(s[kStart:i])
+		if i == len(s) || s[i] != '=' {
+			meta[key] = ""
+			continue
+		}
+		i++ // '='
+
+		var val string
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			quote := s[i]
+			i++
+			vStart := i
+			for i < len(s) && s[i] != quote {
+				if s[i] == '\\' && i+1 < len(s) { // skip escapes
+					i += 2
+					continue
+				}
+				i++
+			}
+			val = s[vStart:min(i, len(s))]
+			if i < len(s) {
+				i++ // consume closing quote
+			}
+		} else {
+			vStart := i
+			for i < len(s) && !isSpace(s[i]) {
+				i++
+			}
+			val = s[vStart:i]
+		}
+		if key != "" {
+			meta[key] = val
+		}
+	}
+}
+
+func isSpaceRune(r rune) bool { return r < 0x80 && isSpace(byte(r)) }
+
+// ExtractCodeBlocks returns the fenced code blocks in s, with positions relative to s.
+// It applies the same rules as the streaming scanner enabled by WithCodeBlocks; tags in s
+// are plain text.
+func ExtractCodeBlocks(s string) []CodeBlockEvent {
+	t := newTokenizer(true, false)
+	t.mode = lexText
+	t.feed([]byte(s))
+
+	var blocks []CodeBlockEvent
+	var open *codeBlock
+	for {
+		tok, ok, _ := t.next(true) // lexText cannot fail
+		if !ok {
+			break
+		}
+		switch tok.Kind {
+		case TokenFenceStart:
+			open = newCodeBlock(tok, false)
+		case TokenFenceEnd:
+			blocks = append(blocks, open.event(tok.End))
+			open = nil
+		default:
+			if open != nil {
+				open.write(tok.Text)
+			}
+		}
+	}
+	if open != nil {
+		blocks = append(blocks, open.event(t.pos))
+	}
+	return blocks
+}
+
+// codeBlock assembles a CodeBlockEvent from the tokens between TokenFenceStart and
+// TokenFenceEnd. Fences follow CommonMark: an opener is a run of at least three '`' or '~'
+// indented by at most three spaces, and the block ends at a line holding a run of the same
+// character at least as long, so shorter fences inside a block are content. The opener's
+// indentation is removed from content lines. An unclosed block runs to the end of the input.
+type codeBlock struct {
+	indent string // opener's indentation, stripped from content lines
+	col    int    // bytes of the current line seen so far
+	strip  bool   // still matching the current line's indentation
+	lang   string
+	meta   map[string]string
+	body   strings.Builder
+	start  Position
+}
+
+func newCodeBlock(open Token, lenient bool) *codeBlock {
+	_, _, indent, _, _ := parseFenceOpener(strings.TrimRight(open.Text, "\r\n"), lenient)
+	return &codeBlock{indent: indent, strip: true, lang: open.Name, meta: open.Attrs, start: open.Start}
+}
+
+// write adds content, which may end or start mid-line.
+func (b *codeBlock) write(text string) {
+	for text != "" {
+		n := len(text)
+		if nl := strings.IndexByte(text, '\n'); nl >= 0 {
+			n = nl + 1
+		}
+		seg := text[:n]
+		text = text[n:]
+
+		// Strip as much of the opener's indentation as the line shares with it, so shorter
+		// or differently indented lines are kept rather than cut.
+		i := 0
+		for b.strip && i < len(seg) && b.col < len(b.indent) && seg[i] == b.indent[b.col] {
+			i++
+			b.col++
+		}
+		if i < len(seg) {
+			b.strip = false
+		}
+		b.body.WriteString(seg[i:])
+		if seg[len(seg)-1] == '\n' {
+			b.col, b.strip = 0, true
+		}
+	}
+}
+
+func (b *codeBlock) event(end Position) CodeBlockEvent {
+	return CodeBlockEvent{
+		EventBase: EventBase{StartPos: b.start, EndPos: end},
+		Lang:      b.lang,
+		File:      b.meta["file"],
+		Meta:      b.meta,
+		Content:   b.body.String(),
+	}
+}
+
+// parseFenceOpener recognizes an opening fence line and returns its character, run length,
+// indentation and info string. Backtick fences may not have a backtick in the info string.
+func parseFenceOpener(line string, lenient bool) (char byte, n int, indent, info string, ok bool) {
+	indent, rest, ok := splitFenceIndent(line, lenient)
+	if !ok || rest == "" || (rest[0] != '`' && rest[0] != '~') {
+		return 0, 0, "", "", false
+	}
+	char = rest[0]
+	for n < len(rest) && rest[n] == char {
+		n++
+	}
+	if n < 3 {
+		return 0, 0, "", "", false
+	}
+	info = strings.TrimSpace(rest[n:])
+	if char == '`' && strings.IndexByte(info, '`') >= 0 {
+		return 0, 0, "", "", false
+	}
+	return char, n, indent, info, true
+}
+
+// isFenceCloser reports whether line closes a fence opened with n times char.
+// The closer's indentation is independent of the opener's.
+func isFenceCloser(line string, char byte, n int, lenient bool) bool {
+	_, rest, ok := splitFenceIndent(line, lenient)
+	if !ok {
+		return false
+	}
+	i := 0
+	for i < len(rest) && rest[i] == char {
+		i++
+	}
+	return i >= n && strings.TrimSpace(rest[i:]) == ""
+}
+
+// splitFenceIndent splits the indentation off a candidate fence line. CommonMark allows up
+// to three spaces; lenient mode allows any run of spaces, tabs and '>' so that fences nested
+// in list items and blockquotes are found.
+func splitFenceIndent(line string, lenient bool) (indent, rest string, ok bool) {
+	i := 0
+	for i < len(line) && (line[i] == ' ' || lenient && (line[i] == '\t' || line[i] == '>')) {
+		i++
+	}
+	if !lenient && i > 3 {
+		return "", "", false
+	}
+	return line[:i], line[i:], true
+}
@@ -0,0 +1,256 @@
+package promptweaver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseFenceMeta_Should_Parse_Quoted_And_Bare_Pairs(t *testing.T) {
+	cases := []struct {
+		header string
+		lang   string
+		meta   map[string]string
+	}{
+		{`go file="m.go" test=true region='handlers'`, "go", map[string]string{"file": "m.go", "test": "true", "region": "handlers"}},
+		{"  python\tFILE='a b.py'  ", "python", map[string]string{"file": "a b.py"}},
+		{`file="x.txt"`, "", map[string]string{"file": "x.txt"}},
+		{`sh title="say \"hi\"" readonly`, "sh", map[string]string{"title": `say \"hi\"`, "readonly": ""}},
+		{`go file="unterminated`, "go", map[string]string{"file": "unterminated"}},
+		{`go a=1 a=2 empty=`, "go", map[string]string{"a": "2", "empty": ""}},
+		{"", "", map[string]string{}},
+	}
+	for _, c := range cases {
+		lang, meta := ParseFenceMeta(c.header)
+		if lang != c.lang || !reflect.DeepEqual(meta, c.meta) {
+			t.Errorf("ParseFenceMeta(%q) = %q, %v; want %q, %v", c.header, lang, meta, c.lang, c.meta)
+		}
+	}
+}
+
+func Test_ParseFenceHeader_Should_Return_Lang_And_File(t *testing.T) {
+	lang, file := ParseFenceHeader(`go file="m.go" test=true`)
+	if lang != "go" || file != "m.go" {
+		t.Fatalf("got %q, %q", lang, file)
+	}
+	if lang, file := ParseFenceHeader("rust"); lang != "rust" || file != "" {
+		t.Fatalf("got %q, %q", lang, file)
+	}
+}
+
+func Test_ExtractCodeBlocks_Should_Apply_CommonMark_Fence_Rules(t *testing.T) {
+	input := "intro\n" +
+		"````markdown file=\"README.md\"\n" +
+		"```go file=\"inner.go\"\n" +
+		"package inner\n" +
+		"```\n" +
+		"````\n" +
+		"~~~ sh\n" +
+		"```\n" +
+		"~~\n" +
+		"~~~~~  \n" +
+		"``` py `x`\n" +
+		"not a fence\n"
+	blocks := ExtractCodeBlocks(input)
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d: %+v", len(blocks), blocks)
+	}
+
+	outer := blocks[0]
+	if outer.Lang != "markdown" || outer.File != "README.md" {
+		t.Fatalf("unexpected outer header: %+v", outer)
+	}
+	want := "```go file=\"inner.go\"\npackage inner\n```\n"
+	if outer.Content != want {
+		t.Fatalf("inner fence must be content:\ngot  %q\nwant %q", outer.Content, want)
+	}
+	if span := input[outer.StartPos.Offset:outer.EndPos.Offset]; span != "````markdown file=\"README.md\"\n"+want+"````" {
+		t.Fatalf("unexpected span %q", span)
+	}
+
+	tilde := blocks[1]
+	if tilde.Lang != "sh" || tilde.Content != "```\n~~\n" || tilde.StartPos.Line != 7 {
+		t.Fatalf("unexpected tilde block: %+v", tilde)
+	}
+}
+
+func Test_ExtractCodeBlocks_Should_Run_Unclosed_Block_To_End(t *testing.T) {
+	blocks := ExtractCodeBlocks("```go\nfunc f() {}")
+	if len(blocks) != 1 || blocks[0].Content != "func f() {}" {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+}
+
+func Test_Engine_Should_Emit_CodeBlocks_Outside_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngineWithOptions(reg, WithCodeBlocks())
+
+	input := "Here you go:\n" +
+		"```go file=\"a.go\"\n" +
+		"x := \"<summary>not a section</summary>\"\n" +
+		"```\n" +
+		"<summary>done</summary> ```not a fence\n" +
+		"~~~\r\ntail\r\n~~~"
+	for _, chunk := range []int{1, 3, len(input)} {
+		rec := &recorderSink{}
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 3 {
+			t.Fatalf("chunk %d: expected 3 events, got %+v", chunk, rec.events)
+		}
+		code, ok := rec.events[0].(CodeBlockEvent)
+		if !ok || code.File != "a.go" || code.Content != "x := \"<summary>not a section</summary>\"\n" {
+			t.Fatalf("chunk %d: unexpected first event %+v", chunk, rec.events[0])
+		}
+		if sec, ok := rec.events[1].(SectionEvent); !ok || sec.Content != "done" {
+			t.Fatalf("chunk %d: unexpected second event %+v", chunk, rec.events[1])
+		}
+		if tail, ok := rec.events[2].(CodeBlockEvent); !ok || tail.Content != "tail\r\n" {
+			t.Fatalf("chunk %d: unexpected third event %+v", chunk, rec.events[2])
+		}
+	}
+}
+
+func Test_ExtractCodeBlocks_Should_Strip_Opener_Indentation(t *testing.T) {
+	input := "1. First, create the file:\n" +
+		"   ```go file=\"a.go\"\n" +
+		"   package a\n" +
+		"\n" +
+		" x\n" +
+		"     y\n" +
+		" ```\n" +
+		"2. Then:\n" +
+		"    ```go\n" +
+		"    not a fence, indented code\n" +
+		"    ```\n"
+	blocks := ExtractCodeBlocks(input)
+	if len(blocks) != 1 {
+		t.Fatalf("expected 1 block, got %+v", blocks)
+	}
+	if want := "package a\n\nx\n  y\n"; blocks[0].Content != want || blocks[0].File != "a.go" {
+		t.Fatalf("got %q, want %q", blocks[0].Content, want)
+	}
+}
+
+func Test_Engine_Should_Find_Nested_Fences_In_Lenient_Mode(t *testing.T) {
+	input := "1. Create it:\n" +
+		"      ```go file=\"a.go\"\n" +
+		"      package a\n" +
+		"  \n" +
+		"      ```\n" +
+		"> Quoted:\n" +
+		"> ```sh\n" +
+		"> echo hi\n" +
+		">\n" +
+		"> ```\n"
+
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(NewRegistry(), WithCodeBlocks()).ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 0 {
+		t.Fatalf("strict mode should not see nested fences, got %+v", rec.events)
+	}
+
+	rec = &recorderSink{}
+	if err := NewEngineWithOptions(NewRegistry(), WithLenientFences()).ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("expected 2 blocks, got %+v", rec.events)
+	}
+	if ev := rec.events[0].(CodeBlockEvent); ev.Content != "package a\n\n" {
+		t.Fatalf("unexpected list block content %q", ev.Content)
+	}
+	if ev := rec.events[1].(CodeBlockEvent); ev.Lang != "sh" || ev.Content != "echo hi\n\n" {
+		t.Fatalf("unexpected blockquote block %+v", ev)
+	}
+}
+
+func Test_Engine_Should_Map_File_Fences_Onto_Section(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	input := "<create-file path=\"a.go\">package a</create-file>\n" +
+		"```go file=\"b.go\"\npackage b\n```\n" +
+		"```sh\necho no file\n```\n" +
+		"```go file=\"c.go\"\nTODO\n```\n"
+
+	var rejected []string
+	en := NewEngineWithOptions(reg, WithFenceSectionMapping("create-file", "path"), WithErrorHandler(func(err error) bool {
+		rejected = append(rejected, err.Error())
+		return true
+	}))
+	en.RegisterFuncValidator("write-file", func(section, content string, pos Position) error {
+		if strings.Contains(content, "TODO") {
+			return NewValidationError(pos, section, "unfinished file", content)
+		}
+		return nil
+	})
+
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 3 || len(rejected) != 1 {
+		t.Fatalf("expected 3 events and 1 rejection, got %+v and %v", rec.events, rejected)
+	}
+	mapped, ok := rec.events[1].(SectionEvent)
+	if !ok || mapped.Name != "write-file" || mapped.Attrs["path"] != "b.go" || mapped.Attrs["lang"] != "go" || mapped.Content != "package b\n" {
+		t.Fatalf("unexpected mapped event %+v", rec.events[1])
+	}
+	if span := input[mapped.StartPos.Offset:mapped.EndPos.Offset]; span != "```go file=\"b.go\"\npackage b\n```" {
+		t.Fatalf("unexpected span %q", span)
+	}
+	if _, ok := rec.events[2].(CodeBlockEvent); !ok {
+		t.Fatalf("blocks without file= should stay code blocks, got %+v", rec.events[2])
+	}
+
+	rec = &recorderSink{}
+	en = NewEngineWithOptions(reg, WithFenceSectionMapping("write-file", ""), WithKeepMappedCodeBlocks())
+	if err := en.ProcessStream(ReaderFromString("```go file=\"b.go\"\nx\n```"), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("expected code block and section, got %+v", rec.events)
+	}
+	if _, ok := rec.events[0].(CodeBlockEvent); !ok {
+		t.Fatalf("expected the code block first, got %+v", rec.events[0])
+	}
+}
+
+func Test_Engine_Should_Scan_Fences_In_Body_Only_When_Plugin_Asks(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "thinking", ParseFencesInBody: true})
+
+	file := "# Title\n```go file=\"x.go\"\npackage x\n```\n"
+	thought := "try this:\n```py\nprint(1)\n```\nand\n~~~\nunclosed"
+	input := "<create-file path=\"README.md\">" + file + "</create-file>" +
+		"<thinking>" + thought + "</thinking>"
+
+	for _, chunk := range []int{1, 5, len(input)} {
+		rec := &recorderSink{}
+		en := NewEngineWithOptions(reg, WithCodeBlocks())
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 4 {
+			t.Fatalf("chunk %d: expected 4 events, got %+v", chunk, rec.events)
+		}
+		if ev, ok := rec.events[0].(SectionEvent); !ok || ev.Content != file {
+			t.Fatalf("chunk %d: fences in create-file must stay verbatim, got %+v", chunk, rec.events[0])
+		}
+		if ev, ok := rec.events[1].(CodeBlockEvent); !ok || ev.Lang != "py" || ev.Content != "print(1)\n" {
+			t.Fatalf("chunk %d: unexpected first block %+v", chunk, rec.events[1])
+		}
+		unclosed, ok := rec.events[2].(CodeBlockEvent)
+		if !ok || unclosed.Content != "unclosed" || input[unclosed.EndPos.Offset:] != "</thinking>" {
+			t.Fatalf("chunk %d: section close should end the open block, got %+v", chunk, rec.events[2])
+		}
+		if ev, ok := rec.events[3].(SectionEvent); !ok || ev.Content != thought {
+			t.Fatalf("chunk %d: thinking content must be verbatim, got %+v", chunk, rec.events[3])
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package gosyntax checks that sections hold syntactically valid Go. It is kept out of
+// promptweaver so that go/parser is only linked into programs that use it:
+//
+//	gosyntax.Register(engine, "create-file", gosyntax.File)
+//
+// Syntax errors are reported as promptweaver.ValidationErrors at the offending line and
+// column in the stream.
+package gosyntax
+
+import (
+	goparser "go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+
+	"github.com/grahms/promptweaver"
+)
+
+// Mode selects how a Validator reads content.
+type Mode int
+
+const (
+	// File expects a complete source file, package clause included.
+	File Mode = iota
+
+	// Snippet also accepts content without a package clause, and bare statements,
+	// by wrapping it in a synthetic package (and function) before parsing.
+	Snippet
+)
+
+func (m Mode) String() string {
+	if m == Snippet {
+		return "snippet"
+	}
+	return "file"
+}
+
+// Validator checks that content is syntactically valid Go. Sections with a path, file or
+// lang attribute are only checked when it names Go (".go", "go", "golang").
+type Validator struct {
+	Mode Mode
+}
+
+// New returns a Validator reading content in mode.
+func New(mode Mode) *Validator { return &Validator{Mode: mode} }
+
+// Register registers a Validator reading content in mode for sectionName on e.
+func Register(e *promptweaver.Engine, sectionName string, mode Mode) {
+	e.RegisterValidator(sectionName, New(mode))
+}
+
+// String describes the validator, as in DescribeConfig.
+func (v *Validator) String() string { return "go_syntax mode=" + v.Mode.String() }
+
+// Validate implements promptweaver.Validator.
+func (v *Validator) Validate(sectionName, content string, pos promptweaver.Position) error {
+	return v.ValidateAttrs(sectionName, content, nil, pos)
+}
+
+// ValidateAttrs implements promptweaver.AttrValidator.
+func (v *Validator) ValidateAttrs(sectionName, content string, attrs map[string]string, pos promptweaver.Position) error {
+	if !promptweaver.InLanguage(attrs, []string{"go", "golang"}, []string{".go"}) {
+		return nil
+	}
+	err := parse("", content, "")
+	if err == nil {
+		return nil
+	}
+	if v.Mode != Snippet || hasPackageClause(content) {
+		return err
+	}
+	// Report whichever reading got furthest into the content.
+	for _, wrap := range [][2]string{{"package snippet\n", ""}, {"package snippet\nfunc _() {\n", "\n}"}} {
+		alt := parse(wrap[0], content, wrap[1])
+		if alt == nil {
+			return nil
+		}
+		if alt.Pos.Offset > err.Pos.Offset {
+			err = alt
+		}
+	}
+	return err
+}
+
+// parse parses prefix+content+suffix and reports the first syntax error relative to
+// content. Errors in the suffix are reported at the end of content.
+func parse(prefix, content, suffix string) *promptweaver.ContentSyntaxError {
+	_, err := goparser.ParseFile(token.NewFileSet(), "", prefix+content+suffix, goparser.SkipObjectResolution)
+	if err == nil {
+		return nil
+	}
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		return &promptweaver.ContentSyntaxError{Pos: promptweaver.Position{Line: 1, Column: 1}, Message: err.Error()}
+	}
+	first := list[0]
+	pos := promptweaver.Position{
+		Line:   max(first.Pos.Line-strings.Count(prefix, "\n"), 1),
+		Column: first.Pos.Column,
+		Offset: int64(max(first.Pos.Offset-len(prefix), 0)),
+	}
+	if pos.Offset >= int64(len(content)) {
+		pos = endOf(content)
+	}
+	return &promptweaver.ContentSyntaxError{Pos: pos, Message: "go syntax: " + first.Msg}
+}
+
+// endOf returns the position just past content, counting columns in bytes as the engine does.
+func endOf(content string) promptweaver.Position {
+	last := strings.LastIndexByte(content, '\n')
+	return promptweaver.Position{
+		Line:   strings.Count(content, "\n") + 1,
+		Column: len(content) - last,
+		Offset: int64(len(content)),
+	}
+}
+
+// hasPackageClause reports whether the first code in content is a package clause.
+func hasPackageClause(content string) bool {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(content)), []byte(content), nil, 0)
+	_, tok, _ := s.Scan()
+	return tok == token.PACKAGE
+}
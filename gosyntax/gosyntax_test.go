@@ -0,0 +1,54 @@
+package gosyntax
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grahms/promptweaver"
+)
+
+func Test_Register_Should_Report_Errors_At_Stream_Position(t *testing.T) {
+	reg := promptweaver.NewRegistry()
+	reg.Register(promptweaver.SectionPlugin{Name: "create-file"})
+	en := promptweaver.NewEngine(reg)
+	Register(en, "create-file", File)
+
+	input := "intro\n<create-file path=\"main.go\">package main\n\nfunc main() {\n\tx := \n}\n</create-file>"
+	err := en.ProcessStream(strings.NewReader(input), promptweaver.NewHandlerSink())
+	var verr *promptweaver.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	if verr.Pos.Line != 6 || input[verr.Pos.Offset] != '}' {
+		t.Fatalf("expected the error at the closing brace on line 6, got %s", verr.Pos)
+	}
+
+	ok := "<create-file path=\"README.md\">not go {</create-file>" +
+		"<create-file path=\"a.go\">//go:build linux\n\npackage a\n</create-file>"
+	if err := en.ProcessStream(strings.NewReader(ok), promptweaver.NewHandlerSink()); err != nil {
+		t.Fatalf("expected non-Go files and build tags to pass, got %v", err)
+	}
+	if !strings.Contains(strings.Join(en.DescribeConfig().Validators["create-file"], ";"), "go_syntax mode=file") {
+		t.Fatalf("DescribeConfig should name the validator, got %+v", en.DescribeConfig().Validators)
+	}
+}
+
+func Test_Validator_Should_Accept_Snippets_In_Snippet_Mode(t *testing.T) {
+	for _, snippet := range []string{"func f() int { return 1 }", "x := 1\nfmt.Println(x)"} {
+		if err := New(File).Validate("go", snippet, promptweaver.Position{}); err == nil {
+			t.Fatalf("File must require a package clause for %q", snippet)
+		}
+		if err := New(Snippet).Validate("go", snippet, promptweaver.Position{}); err != nil {
+			t.Fatalf("Snippet rejected %q: %v", snippet, err)
+		}
+	}
+	err := New(Snippet).Validate("go", "x := 1\ny := (", promptweaver.Position{})
+	var cse *promptweaver.ContentSyntaxError
+	if !errors.As(err, &cse) || cse.Pos.Line != 2 {
+		t.Fatalf("expected an error on snippet line 2, got %v", err)
+	}
+	if want := (promptweaver.Position{Line: 2, Column: 7, Offset: 13}); cse.Pos != want {
+		t.Fatalf("an error past the content should be at its end %s, got %s", want, cse.Pos)
+	}
+}
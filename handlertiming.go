@@ -0,0 +1,74 @@
+package promptweaver
+
+import "time"
+
+// SlowHandler reports one delivery to the sink that took longer than the
+// EngineOptions.HandlerTiming threshold.
+type SlowHandler struct {
+	Section  string // section name, or the event kind for other events
+	Seq      int64  // the event's sequence number
+	Duration time.Duration
+}
+
+// HandlerStats sums up how long the sink took to handle the events of one section.
+type HandlerStats struct {
+	Count int
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Avg returns the mean handling time.
+func (s HandlerStats) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// handlerTimer measures event delivery with the engine's clock.
+type handlerTimer struct {
+	threshold time.Duration
+	onSlow    func(SlowHandler)
+	stats     map[string]HandlerStats
+}
+
+func newHandlerTimer(options EngineOptions) *handlerTimer {
+	if options.HandlerTiming <= 0 {
+		return nil
+	}
+	return &handlerTimer{threshold: options.HandlerTiming, onSlow: options.OnSlowHandler, stats: map[string]HandlerStats{}}
+}
+
+// deliverTimed delivers ev, timing the sink when handler timing is enabled.
+func (p *parser) deliverTimed(ev Event) error {
+	if p.timer == nil {
+		return deliver(p.ctx, p.sink, ev)
+	}
+	start := p.now()
+	err := deliver(p.ctx, p.sink, ev)
+	d := p.now().Sub(start)
+
+	name := string(ev.Kind())
+	if sev, ok := ev.(SectionEvent); ok {
+		name = sev.Name
+	}
+	s := p.timer.stats[name]
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+	p.timer.stats[name] = s
+	if d > p.timer.threshold && p.timer.onSlow != nil {
+		p.timer.onSlow(SlowHandler{Section: name, Seq: ev.Base().Seq, Duration: d})
+	}
+	return err
+}
+
+// reportHandlerStats hands the per-section timings to the HandlerStatsHandler.
+func (p *parser) reportHandlerStats() {
+	if p.timer == nil || p.onHandlerStats == nil || len(p.timer.stats) == 0 {
+		return
+	}
+	p.onHandlerStats(p.timer.stats)
+}
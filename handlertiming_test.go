@@ -0,0 +1,47 @@
+package promptweaver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Engine_Should_Time_Handlers_And_Report_Slow_Ones(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "write-file"})
+
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	cost := map[string]time.Duration{"think": time.Millisecond, "write-file": 40 * time.Millisecond}
+	sink := NewHandlerSink()
+	for name, d := range cost {
+		sink.RegisterHandler(name, func(SectionEvent) { now = now.Add(d) })
+	}
+
+	var slow []SlowHandler
+	var stats map[string]HandlerStats
+	en := NewEngineWithOptions(reg, WithClock(clock),
+		WithHandlerTiming(10*time.Millisecond, func(s SlowHandler) { slow = append(slow, s) }),
+		WithHandlerStatsHandler(func(m map[string]HandlerStats) { stats = m }))
+	input := "<think>a</think><write-file>b</write-file><think>c</think><write-file>d</write-file>"
+	if err := en.ProcessStream(strings.NewReader(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	if len(slow) != 2 || slow[0] != (SlowHandler{Section: "write-file", Seq: 2, Duration: 40 * time.Millisecond}) || slow[1].Seq != 4 {
+		t.Fatalf("unexpected slow handlers %+v", slow)
+	}
+	if s := stats["think"]; s.Count != 2 || s.Max != time.Millisecond || s.Avg() != time.Millisecond {
+		t.Fatalf("unexpected think stats %+v", s)
+	}
+	if s := stats["write-file"]; s.Count != 2 || s.Total != 80*time.Millisecond {
+		t.Fatalf("unexpected write-file stats %+v", s)
+	}
+
+	stats = nil
+	_ = NewEngineWithOptions(reg, WithHandlerStatsHandler(func(m map[string]HandlerStats) { stats = m })).ProcessStream(strings.NewReader(input), sink)
+	if stats != nil {
+		t.Fatalf("timing must be opt-in")
+	}
+}
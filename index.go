@@ -0,0 +1,161 @@
+package promptweaver
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// IndexSink keeps the sections of a stream for lookup by name, by attribute value and by
+// Seq, so callers need not build their own maps. Every event is passed on to Next, if set,
+// and a section is indexed only once Next has taken it: wrapped around a BufferSink, the
+// index holds what the buffer holds and is bounded by the buffer's limit.
+//
+//	index := promptweaver.NewIndexSink(promptweaver.NewBufferSink(1<<20), "path")
+//	err := engine.ProcessStream(r, index)
+//	main, ok := index.ByAttr("create-file", "path", "main.go")
+//
+// The index starts empty for every stream. Its lookups may be called from any goroutine,
+// during the stream or after it has ended. The zero value indexes no attribute values, so
+// ByAttr scans.
+type IndexSink struct {
+	Next EventSink
+
+	attrs []string // attributes indexed by value, lowercased
+
+	mu       sync.RWMutex
+	sections []SectionEvent   // in emission order
+	byName   map[string][]int // lowercased canonical name -> positions in sections
+	bySeq    map[int64]int    // Seq -> position in sections
+	byAttr   map[indexKey]int // the last section with each value of an indexed attribute
+}
+
+// indexKey names an attribute value of one section name in an IndexSink.
+type indexKey struct{ name, attr, value string }
+
+// NewIndexSink creates an IndexSink that forwards events to next, which may be nil, and
+// indexes sections by the values of attrs, such as "path". Attribute names ignore case.
+func NewIndexSink(next EventSink, attrs ...string) *IndexSink {
+	s := &IndexSink{Next: next}
+	for _, a := range attrs {
+		s.attrs = append(s.attrs, strings.ToLower(a))
+	}
+	return s
+}
+
+// OnEvent implements EventSink.
+func (s *IndexSink) OnEvent(ev Event) { _ = s.OnEventContext(context.Background(), ev) }
+
+// OnEventContext implements ContextSink, so a context-aware Next keeps its context. An event
+// Next fails on, such as one over a BufferSink's limit, is not indexed and its error is
+// returned.
+func (s *IndexSink) OnEventContext(ctx context.Context, ev Event) error {
+	if s.Next != nil {
+		if err := deliver(ctx, s.Next, ev); err != nil {
+			return err
+		}
+	}
+	if sev, ok := ev.(SectionEvent); ok {
+		s.record(sev)
+	}
+	return nil
+}
+
+func (s *IndexSink) record(sev SectionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byName == nil { // zero-value IndexSink
+		s.reset()
+	}
+	i, name := len(s.sections), strings.ToLower(sev.Name)
+	s.sections = append(s.sections, sev)
+	s.byName[name] = append(s.byName[name], i)
+	s.bySeq[sev.Seq] = i
+	for _, a := range s.attrs {
+		if v, ok := lookupAttr(sev.Attrs, a); ok {
+			s.byAttr[indexKey{name, a, v}] = i
+		}
+	}
+}
+
+// OnStreamStart implements StreamStartSink by emptying the index and telling Next, if it
+// wants to know.
+func (s *IndexSink) OnStreamStart(meta StreamMeta) {
+	s.mu.Lock()
+	s.reset()
+	s.mu.Unlock()
+	if ss, ok := s.Next.(StreamStartSink); ok {
+		ss.OnStreamStart(meta)
+	}
+}
+
+// OnStreamEnd implements StreamEndSink by telling Next, if it wants to know. The index keeps
+// the stream's sections until the next stream starts.
+func (s *IndexSink) OnStreamEnd(err error) {
+	if es, ok := s.Next.(StreamEndSink); ok {
+		es.OnStreamEnd(err)
+	}
+}
+
+// reset empties the index. s.mu must be held.
+func (s *IndexSink) reset() {
+	s.sections = nil
+	s.byName = map[string][]int{}
+	s.bySeq = map[int64]int{}
+	s.byAttr = map[indexKey]int{}
+}
+
+// Sections returns the indexed sections in emission order.
+func (s *IndexSink) Sections() []SectionEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]SectionEvent(nil), s.sections...)
+}
+
+// ByName returns the sections with the canonical name name, ignoring case, in emission
+// order; ByName("create-file")[n] is the (n+1)th create-file.
+func (s *IndexSink) ByName(name string) []SectionEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []SectionEvent
+	for _, i := range s.byName[strings.ToLower(name)] {
+		out = append(out, s.sections[i])
+	}
+	return out
+}
+
+// ByAttr returns the last section named name whose attribute attr is value, so a later
+// section for the same path wins. Attributes not given to NewIndexSink are found by a scan.
+func (s *IndexSink) ByAttr(name, attr, value string) (SectionEvent, bool) {
+	name, attr = strings.ToLower(name), strings.ToLower(attr)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.attrs {
+		if a == attr {
+			i, ok := s.byAttr[indexKey{name, attr, value}]
+			if !ok {
+				return SectionEvent{}, false
+			}
+			return s.sections[i], true
+		}
+	}
+	idx := s.byName[name]
+	for j := len(idx) - 1; j >= 0; j-- {
+		sev := s.sections[idx[j]]
+		if v, ok := lookupAttr(sev.Attrs, attr); ok && v == value {
+			return sev, true
+		}
+	}
+	return SectionEvent{}, false
+}
+
+// At returns the section with the given Seq (see EventBase), if it was indexed.
+func (s *IndexSink) At(seq int) (SectionEvent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.bySeq[int64(seq)]
+	if !ok {
+		return SectionEvent{}, false
+	}
+	return s.sections[i], true
+}
@@ -0,0 +1,149 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const indexInput = `<create-file path="a.go">package a</create-file>` +
+	`<note kind="plan">first</note>` +
+	`<create-file path="b.go" mode="0600">package b</create-file>` +
+	`<Create-File path="a.go">package a // v2</Create-File>`
+
+func indexRegistry() *Registry {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "note"})
+	return reg
+}
+
+func Test_IndexSink_Should_Look_Up_Sections_By_Name_Attr_And_Seq(t *testing.T) {
+	rec := &recorderSink{}
+	index := NewIndexSink(rec, "Path")
+	if err := NewEngine(indexRegistry()).ProcessStream(strings.NewReader(indexInput), index); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 4 || len(index.Sections()) != 4 {
+		t.Fatalf("events should pass through, got %d forwarded, %d indexed", len(rec.events), len(index.Sections()))
+	}
+
+	files := index.ByName("CREATE-FILE")
+	if len(files) != 3 || files[1].Attrs["path"] != "b.go" {
+		t.Fatalf("ByName = %+v", files)
+	}
+	if got := index.ByName("missing"); got != nil {
+		t.Fatalf("ByName(missing) = %+v", got)
+	}
+
+	a, ok := index.ByAttr("create-file", "path", "a.go")
+	if !ok || a.Content != "package a // v2" {
+		t.Fatalf("the last section for a path should win, got %+v, %v", a, ok)
+	}
+	if _, ok := index.ByAttr("note", "path", "a.go"); ok {
+		t.Fatalf("ByAttr should only match sections of the given name")
+	}
+	// mode is not indexed, so it is found by a scan.
+	if b, ok := index.ByAttr("create-file", "MODE", "0600"); !ok || b.Attrs["path"] != "b.go" {
+		t.Fatalf("ByAttr(mode) = %+v, %v", b, ok)
+	}
+	if _, ok := index.ByAttr("create-file", "path", "c.go"); ok {
+		t.Fatalf("ByAttr should miss unknown values")
+	}
+
+	if note, ok := index.At(2); !ok || note.Name != "note" || note.Seq != 2 {
+		t.Fatalf("At(2) = %+v, %v", note, ok)
+	}
+	if _, ok := index.At(5); ok {
+		t.Fatalf("At past the last section should miss")
+	}
+}
+
+func Test_IndexSink_Should_Only_Index_What_Next_Accepts(t *testing.T) {
+	buf := NewBufferSink(len("package a") + len("path") + len("a.go"))
+	index := NewIndexSink(buf, "path")
+	err := NewEngine(indexRegistry()).ProcessStream(strings.NewReader(indexInput), index)
+	if !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("expected ErrBufferFull, got %v", err)
+	}
+	if got := index.Sections(); len(got) != 1 || len(buf.Events()) != 1 || got[0].Content != "package a" {
+		t.Fatalf("index %+v should hold what the buffer holds, %+v", got, buf.Events())
+	}
+
+	// Recovered, the sections over the limit are skipped by both.
+	en := NewEngineWithOptions(indexRegistry(), WithContinueMode())
+	if err := en.ProcessStream(strings.NewReader(indexInput), index); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(index.Sections()) != len(buf.Events()) {
+		t.Fatalf("index has %d sections, buffer %d events", len(index.Sections()), len(buf.Events()))
+	}
+}
+
+func Test_IndexSink_Should_Start_Every_Stream_Empty(t *testing.T) {
+	index := NewIndexSink(nil, "path")
+	en := NewEngine(indexRegistry())
+	if err := en.ProcessStream(strings.NewReader(indexInput), index); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if err := en.ProcessStream(strings.NewReader(`<note>second</note>`), index); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if got := index.Sections(); len(got) != 1 || got[0].Content != "second" {
+		t.Fatalf("sections = %+v", got)
+	}
+	if _, ok := index.ByAttr("create-file", "path", "a.go"); ok {
+		t.Fatalf("the previous stream's attributes should be forgotten")
+	}
+}
+
+func Test_IndexSink_Should_Be_Queryable_From_Other_Goroutines(t *testing.T) {
+	ended := make(chan struct{})
+	sink := NewHandlerSink()
+	sink.RegisterStreamEndHandler(func(error) { close(ended) })
+	index := NewIndexSink(sink, "path")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ended:
+				return
+			default:
+				index.ByName("create-file")
+				index.ByAttr("create-file", "path", "a.go")
+			}
+		}
+	}()
+	input := strings.Repeat(indexInput, 50)
+	if err := NewEngine(indexRegistry()).ProcessStream(&chunkedReader{data: []byte(input), chunk: 17}, index); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	wg.Wait()
+	if got := len(index.ByName("create-file")); got != 150 {
+		t.Fatalf("indexed %d create-files, want 150", got)
+	}
+}
+
+func Test_IndexSink_Zero_Value_Should_Be_Usable(t *testing.T) {
+	rec := &recorderSink{}
+	index := &IndexSink{Next: rec}
+	if _, ok := index.ByAttr("create-file", "path", "a.go"); ok || index.ByName("note") != nil {
+		t.Fatal("an empty index should find nothing")
+	}
+	if err := NewEngine(indexRegistry()).ProcessStream(strings.NewReader(indexInput), index); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 4 || len(index.ByName("create-file")) != 3 {
+		t.Fatalf("forwarded %d events, indexed %+v", len(rec.events), index.Sections())
+	}
+	if a, ok := index.ByAttr("create-file", "path", "a.go"); !ok || a.Content != "package a // v2" {
+		t.Fatalf("ByAttr = %+v, %v", a, ok)
+	}
+	if note, ok := index.At(2); !ok || note.Name != "note" {
+		t.Fatalf("At(2) = %+v, %v", note, ok)
+	}
+}
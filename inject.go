@@ -0,0 +1,60 @@
+package promptweaver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Inject runs ev, built outside the parser (e.g. from a structured tool call), through the
+// engine as if its tag had been parsed: the name is resolved to its canonical form, and
+// attribute defaults, suppression, OnOpen, truncation, validators, references and pairings
+// apply before the event reaches sink. Errors go through the engine's error handling; one
+// that is not recovered is returned. Positions are kept as given and may be zero. The
+// event is a stream of its own, with Seq 1: sink is told the stream starts and ends, as
+// with ProcessStream. To inject into a stream in progress, use Demux.Inject.
+func (e *Engine) Inject(sink EventSink, ev SectionEvent) (err error) {
+	if err := e.checkSink(sink); err != nil {
+		return err
+	}
+	s := e.startStream(context.Background(), sink, e.options, e.validators)
+	defer func() { err = s.end(err) }()
+	if err := s.p.inject(ev); err != nil {
+		return err
+	}
+	return s.close()
+}
+
+// inject delivers ev as a section closed at the current position.
+func (p *parser) inject(ev SectionEvent) error {
+	canon, ok := p.reg.Canonical(ev.Name)
+	if !ok {
+		return fmt.Errorf("%w: cannot inject %q", ErrUnknownSection, ev.Name)
+	}
+	plugin, _ := p.reg.Plugin(canon)
+	name := ev.AliasUsed
+	if name == "" {
+		name = ev.Name
+	}
+	attrs := make(map[string]string, len(ev.Attrs))
+	for k, v := range ev.Attrs {
+		if !p.tz.tag.keepCase {
+			k = strings.ToLower(k)
+		}
+		attrs[k] = v
+	}
+	el := &element{name: name, canon: canon, start: ev.StartPos, end: ev.EndPos, suppress: p.suppressed(canon, plugin), truncAt: plugin.TruncateAt}
+	el.attrs, el.defaulted = p.sectionAttrs(plugin, attrs)
+	if el.end == (Position{}) {
+		el.end = ev.StartPos
+	}
+	if aborted, err := p.open(plugin, el); err != nil || aborted {
+		return err
+	}
+	if el.suppress {
+		el.skipped = len(ev.Content)
+	} else {
+		el.keep(ev.Content)
+	}
+	return p.closeSection(el, false)
+}
@@ -0,0 +1,78 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Inject_Should_Apply_The_Parsing_Rules(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}, AttrDefaults: map[string]string{"mode": "0644"}})
+	en := NewEngine(reg)
+	en.RegisterValidator("write-file", SafePath("path"))
+
+	rec := &recorderSink{}
+	if err := en.Inject(rec, SectionEvent{Name: "Create-File", Attrs: map[string]string{"Path": "a.go"}, Content: "package a"}); err != nil {
+		t.Fatalf("Inject error: %v", err)
+	}
+	ev := rec.events[0].(SectionEvent)
+	if ev.Name != "write-file" || ev.AliasUsed != "Create-File" || ev.Attrs["path"] != "a.go" || ev.Attrs["mode"] != "0644" || ev.Seq != 1 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	err := en.Inject(rec, SectionEvent{Name: "write-file", Attrs: map[string]string{"path": "../etc/passwd"}})
+	if err == nil || !strings.Contains(err.Error(), "path") || len(rec.events) != 1 {
+		t.Fatalf("expected the validator to reject the event, got %v", err)
+	}
+	if err := en.Inject(rec, SectionEvent{Name: "shell"}); !errors.Is(err, ErrUnknownSection) {
+		t.Fatalf("expected ErrUnknownSection, got %v", err)
+	}
+}
+
+func Test_Demux_Inject_Should_Keep_Stream_Order(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "step"})
+	rec := &recorderSink{}
+	d := NewDemux(NewEngine(reg), func(int) EventSink { return rec })
+
+	if err := d.Feed(0, []byte("<step>1</step><st")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Inject(0, SectionEvent{Name: "step", Content: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Feed(0, []byte("ep>3</step>")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range rec.events {
+		sev := ev.(SectionEvent)
+		got = append(got, sev.Content+"@"+string(rune('0'+sev.Seq)))
+	}
+	if strings.Join(got, " ") != "1@1 2@2 3@3" {
+		t.Fatalf("unexpected order %q", got)
+	}
+}
+
+func Test_Engine_Inject_Should_Start_And_End_A_Stream(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+
+	var got []string
+	sink := NewHandlerSink()
+	sink.RegisterStreamStartHandler(func(StreamMeta) { got = append(got, "start") })
+	sink.RegisterLastHandler("summary", func(ev SectionEvent) { got = append(got, "last:"+ev.Content) })
+	sink.RegisterStreamEndHandler(func(err error) { got = append(got, "end") })
+
+	if err := en.Inject(sink, SectionEvent{Name: "summary", Content: "done"}); err != nil {
+		t.Fatalf("Inject error: %v", err)
+	}
+	if strings.Join(got, " ") != "start last:done end" {
+		t.Fatalf("unexpected stream lifecycle %q", got)
+	}
+}
@@ -0,0 +1,113 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// IntentEvent announces a section of a plugin with EmitIntent as soon as its opening tag
+// has been parsed, before any of its content. It spans the opening tag. A ContextSink that
+// fails on it vetoes the section: the error goes through the engine's error handling, and
+// once recovered from, the body is read past without being buffered and a VetoedEvent
+// takes the place of the section.
+type IntentEvent struct {
+	EventBase
+	Name  string            `json:"name"`            // canonical section name
+	Attrs map[string]string `json:"attrs,omitempty"` // attributes of the opening tag
+}
+
+// Kind implements Event.
+func (IntentEvent) Kind() EventKind { return KindIntent }
+
+func (ev IntentEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev IntentEvent) MarshalJSON() ([]byte, error) {
+	type plain IntentEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// AsIntent returns ev as an IntentEvent, if it is one.
+func AsIntent(ev Event) (IntentEvent, bool) {
+	iev, ok := ev.(IntentEvent)
+	return iev, ok
+}
+
+// VetoedEvent replaces the SectionEvent of a section whose IntentEvent was vetoed. It comes
+// where the section ends and spans it.
+type VetoedEvent struct {
+	EventBase
+	Name    string            `json:"name"`            // canonical section name
+	Attrs   map[string]string `json:"attrs,omitempty"` // attributes of the opening tag
+	Err     error             `json:"-"`               // the veto
+	Bytes   int               `json:"bytes"`           // content bytes read and discarded
+	Partial bool              `json:"partial,omitempty"`
+}
+
+// Kind implements Event.
+func (VetoedEvent) Kind() EventKind { return KindVetoed }
+
+func (ev VetoedEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// MarshalJSON adds the "kind" field, and Err as the "error" message.
+func (ev VetoedEvent) MarshalJSON() ([]byte, error) {
+	type plain VetoedEvent
+	var msg string
+	if ev.Err != nil {
+		msg = ev.Err.Error()
+	}
+	return json.Marshal(struct {
+		Kind  EventKind `json:"kind"`
+		Error string    `json:"error,omitempty"`
+		plain
+	}{ev.Kind(), msg, plain(ev)})
+}
+
+// UnmarshalJSON restores Err from the "error" message.
+func (ev *VetoedEvent) UnmarshalJSON(data []byte) error {
+	type plain VetoedEvent
+	var v struct {
+		Error string `json:"error"`
+		plain
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*ev = VetoedEvent(v.plain)
+	if v.Error != "" {
+		ev.Err = errors.New(v.Error)
+	}
+	return nil
+}
+
+// AsVetoed returns ev as a VetoedEvent, if it is one.
+func AsVetoed(ev Event) (VetoedEvent, bool) {
+	vev, ok := ev.(VetoedEvent)
+	return vev, ok
+}
+
+// intent emits the IntentEvent of el, whose opening tag has just been parsed, if its plugin
+// wants one. A veto that is recovered from makes el count its body instead of buffering it.
+func (p *parser) intent(plugin SectionPlugin, el *element) error {
+	if !plugin.EmitIntent || el.suppress || p.rescued != nil {
+		return nil
+	}
+	ev := IntentEvent{Name: el.canon, Attrs: el.attrs}
+	ev.StartPos, ev.EndPos = el.start, p.pos
+	veto, err := p.emitVetoable(ev)
+	if err != nil || veto == nil {
+		return err
+	}
+	el.vetoed, el.suppress, el.raw = veto, true, nil
+	return nil
+}
+
+// vetoed emits the VetoedEvent of el, which ended at end.
+func (p *parser) vetoed(el *element, end Position, partial bool) error {
+	ev := VetoedEvent{Name: el.canon, Attrs: el.attrs, Err: el.vetoed, Bytes: el.size(), Partial: partial}
+	ev.StartPos, ev.EndPos = el.start, end
+	return p.emit(ev)
+}
@@ -0,0 +1,88 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// gaugedReader records the largest MemoryGauge reading between reads.
+type gaugedReader struct {
+	chunkedReader
+	gauge *MemoryGauge
+	peak  int
+}
+
+func (r *gaugedReader) Read(p []byte) (int, error) {
+	r.peak = max(r.peak, r.gauge.InUse())
+	return r.chunkedReader.Read(p)
+}
+
+func Test_Intent_Should_Let_A_Handler_Veto_A_Section_Before_Its_Content(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file", EmitIntent: true})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	body := strings.Repeat("x", 1<<20)
+	input := `<create-file path="/etc/passwd">` + body + `</create-file>` +
+		`<create-file path="ok.txt">hi</create-file><summary>s</summary>`
+
+	var order []string
+	sink := NewHandlerSink()
+	errDenied := errors.New("path denied")
+	sink.RegisterIntentHandler(func(ev IntentEvent) error {
+		order = append(order, "intent "+ev.Attrs["path"])
+		if strings.HasPrefix(ev.Attrs["path"], "/") {
+			return errDenied
+		}
+		return nil
+	})
+	sink.RegisterHandler("create-file", func(ev SectionEvent) { order = append(order, "section "+ev.Content) })
+	sink.RegisterHandler("summary", func(ev SectionEvent) { order = append(order, "section "+ev.Content) })
+	var vetoed []VetoedEvent
+	sink.RegisterVetoedHandler(func(ev VetoedEvent) {
+		order = append(order, "vetoed")
+		vetoed = append(vetoed, ev)
+	})
+
+	gauge := &MemoryGauge{}
+	r := &gaugedReader{chunkedReader: chunkedReader{data: []byte(input), chunk: 4096}, gauge: gauge}
+	en := NewEngineWithOptions(reg, WithMemoryGauge(gauge), WithErrorHandler(func(error) bool { return true }))
+	if err := en.ProcessStream(r, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	want := []string{"intent /etc/passwd", "vetoed", "intent ok.txt", "section hi", "section s"}
+	if strings.Join(order, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	ev := vetoed[0]
+	if !errors.Is(ev.Err, errDenied) || ev.Bytes != len(body) || ev.Name != "create-file" || ev.Partial {
+		t.Fatalf("unexpected VetoedEvent %+v", ev)
+	}
+	// The vetoed body was read in 4 KB chunks and never buffered.
+	if r.peak > 16<<10 {
+		t.Fatalf("parser held %d bytes while reading a vetoed section", r.peak)
+	}
+}
+
+func Test_Intent_Veto_Should_Stop_The_Stream_In_StrictMode(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file", EmitIntent: true})
+
+	errDenied := errors.New("path denied")
+	var kinds []EventKind
+	sink := NewHandlerSink()
+	sink.RegisterIntentHandler(func(ev IntentEvent) error {
+		kinds = append(kinds, ev.Kind())
+		return errDenied
+	})
+	sink.RegisterFallbackHandler(func(ev Event) { kinds = append(kinds, ev.Kind()) })
+	err := NewEngine(reg).ProcessStream(ReaderFromString(`<create-file path="a">x</create-file>`), sink)
+	if !errors.Is(err, errDenied) {
+		t.Fatalf("expected the veto, got %v", err)
+	}
+	if len(kinds) != 1 || kinds[0] != KindIntent {
+		t.Fatalf("unexpected events %v", kinds)
+	}
+}
@@ -0,0 +1,168 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSchemaViolations is how many violations a JSONSchemaValidator error lists.
+const maxSchemaViolations = 3
+
+// JSONSchemaValidator checks that section content is JSON matching a schema. It implements
+// the common keywords: type, required, properties, enum, items, minimum and maximum; other
+// keywords are ignored. Build one with NewJSONSchemaValidator.
+type JSONSchemaValidator struct {
+	root *jsonSchema
+}
+
+// jsonSchema is a compiled schema node.
+type jsonSchema struct {
+	Type       schemaTypes            `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Enum       []any                  `json:"enum"`
+	Items      *jsonSchema            `json:"items"`
+	Minimum    *float64               `json:"minimum"`
+	Maximum    *float64               `json:"maximum"`
+}
+
+// schemaTypes accepts "type" as a single name or a list of names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+// NewJSONSchemaValidator compiles schema once for reuse.
+func NewJSONSchemaValidator(schema []byte) (*JSONSchemaValidator, error) {
+	var root jsonSchema
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &JSONSchemaValidator{root: &root}, nil
+}
+
+// Validate implements Validator. Content that is not JSON and content that violates the
+// schema are reported with different messages; violations name their schema path and the
+// location in the document, e.g. "#/properties/port/type at $.port: expected integer".
+func (v *JSONSchemaValidator) Validate(sectionName, content string, pos Position) error {
+	var doc any
+	if err := json.Unmarshal([]byte(trimJSON(content)), &doc); err != nil {
+		return NewValidationError(pos, sectionName, "invalid JSON: "+err.Error(), content)
+	}
+	var violations []string
+	v.root.check(doc, "#", "$", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	msg := "schema violation: " + strings.Join(violations, "; ")
+	return NewValidationError(pos, sectionName, msg, content)
+}
+
+// check appends violations of s by doc, stopping once maxSchemaViolations are collected.
+func (s *jsonSchema) check(doc any, schemaPath, docPath string, out *[]string) {
+	fail := func(keyword, format string, args ...any) {
+		if len(*out) < maxSchemaViolations {
+			*out = append(*out, fmt.Sprintf("%s/%s at %s: %s", schemaPath, keyword, docPath, fmt.Sprintf(format, args...)))
+		}
+	}
+	if s == nil || len(*out) >= maxSchemaViolations {
+		return
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(doc) {
+		fail("type", "expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeName(doc))
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, doc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "value is not one of the allowed values")
+		}
+	}
+
+	switch d := doc.(type) {
+	case float64:
+		if s.Minimum != nil && d < *s.Minimum {
+			fail("minimum", "%v is less than %v", d, *s.Minimum)
+		}
+		if s.Maximum != nil && d > *s.Maximum {
+			fail("maximum", "%v is greater than %v", d, *s.Maximum)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := d[name]; !ok {
+				fail("required", "missing property %q", name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names) // deterministic order of violations
+		for _, name := range names {
+			if val, ok := d[name]; ok {
+				s.Properties[name].check(val, schemaPath+"/properties/"+name, docPath+"."+name, out)
+			}
+		}
+	case []any:
+		for i, item := range d {
+			s.Items.check(item, schemaPath+"/items", docPath+"["+strconv.Itoa(i)+"]", out)
+		}
+	}
+}
+
+func (t schemaTypes) match(doc any) bool {
+	for _, name := range t {
+		switch name {
+		case "integer":
+			if f, ok := doc.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "number", "string", "boolean", "object", "array", "null":
+			if jsonTypeName(doc) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonTypeName(doc any) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", doc)
+}
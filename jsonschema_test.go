@@ -0,0 +1,80 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const deploySchema = `{
+	"type": "object",
+	"required": ["service", "replicas"],
+	"properties": {
+		"service": {"type": "string"},
+		"replicas": {"type": "integer", "minimum": 1, "maximum": 10},
+		"env": {"enum": ["dev", "prod"]},
+		"ports": {"type": "array", "items": {"type": ["integer", "string"]}}
+	}
+}`
+
+func Test_JSONSchemaValidator_Should_Report_Violations_With_Paths(t *testing.T) {
+	v, err := NewJSONSchemaValidator([]byte(deploySchema))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if err := v.Validate("deploy", `{"service":"api","replicas":3,"env":"prod","ports":[80,"https"]}`, Position{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		content string
+		want    []string
+	}{
+		{`{"replicas": 1.5, "env": "qa"}`, []string{
+			`#/required at $: missing property "service"`,
+			"#/properties/env/enum at $.env",
+			"#/properties/replicas/type at $.replicas: expected integer, got number",
+		}},
+		{`{"service":"api","replicas":0,"ports":[true]}`, []string{
+			"#/properties/ports/items/type at $.ports[0]: expected integer or string, got boolean",
+			"#/properties/replicas/minimum at $.replicas: 0 is less than 1",
+		}},
+		{`[]`, []string{"#/type at $: expected object, got array"}},
+	}
+	for _, c := range cases {
+		var verr *ValidationError
+		if err := v.Validate("deploy", c.content, Position{}); !errors.As(err, &verr) {
+			t.Fatalf("%s: expected ValidationError, got %v", c.content, err)
+		}
+		if !strings.HasPrefix(verr.Message, "schema violation: ") {
+			t.Fatalf("%s: unexpected message %q", c.content, verr.Message)
+		}
+		for _, w := range c.want {
+			if !strings.Contains(verr.Message, w) {
+				t.Errorf("%s: message %q lacks %q", c.content, verr.Message, w)
+			}
+		}
+	}
+}
+
+func Test_Engine_Should_Validate_Sections_Against_JSON_Schema(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "deploy"})
+	en := NewEngine(reg)
+	if err := en.RegisterJSONSchemaValidator("deploy", []byte(`{"type": 1}`)); err == nil {
+		t.Fatal("expected a schema compile error")
+	}
+	if err := en.RegisterJSONSchemaValidator("deploy", []byte(deploySchema)); err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+
+	err := en.ProcessStream(ReaderFromString(`<deploy>{"service": "api",}</deploy>`), NewHandlerSink())
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !strings.HasPrefix(verr.Message, "invalid JSON: ") {
+		t.Fatalf("expected an invalid JSON error, got %v", err)
+	}
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString("<deploy>\n{\"service\": \"api\", \"replicas\": 2}\n</deploy>"), rec); err != nil || len(rec.events) != 1 {
+		t.Fatalf("expected a valid section, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package promptweaver
+
+import (
+	"path"
+	"strings"
+)
+
+// LanguageAttr is the attribute key under which a detected language is mirrored into
+// SectionEvent.Attrs, so that consumers of serialized events see it without knowing the
+// DetectedLanguage field. A detected language replaces any value the tag itself gave it.
+const LanguageAttr = "_lang"
+
+// LanguageDetector names the language of a section body from its path attribute (which
+// may be empty) and content, or returns "" when it cannot tell. It should only look at a
+// prefix of the content.
+type LanguageDetector func(path, content string) string
+
+// languageExts maps lowercased file extensions to language names, as highlighters
+// spell them.
+var languageExts = map[string]string{
+	".go": "go", ".ts": "typescript", ".tsx": "tsx", ".js": "javascript", ".mjs": "javascript",
+	".cjs": "javascript", ".jsx": "jsx", ".py": "python", ".rb": "ruby", ".rs": "rust",
+	".java": "java", ".kt": "kotlin", ".swift": "swift", ".c": "c", ".h": "c", ".cc": "cpp",
+	".cpp": "cpp", ".hpp": "cpp", ".cs": "csharp", ".php": "php", ".md": "markdown",
+	".markdown": "markdown", ".json": "json", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml",
+	".sql": "sql", ".sh": "bash", ".bash": "bash", ".zsh": "bash", ".ps1": "powershell",
+	".html": "html", ".htm": "html", ".css": "css", ".scss": "scss", ".xml": "xml",
+	".proto": "protobuf", ".tf": "hcl", ".lua": "lua", ".diff": "diff", ".patch": "diff",
+}
+
+// languageFiles maps lowercased file names without a telling extension to languages.
+var languageFiles = map[string]string{
+	"makefile": "makefile", "dockerfile": "dockerfile", "go.mod": "gomod", "go.sum": "gosum",
+	"gemfile": "ruby", "rakefile": "ruby", "cmakelists.txt": "cmake",
+}
+
+// shebangLanguages maps interpreters named on a #! line to languages.
+var shebangLanguages = map[string]string{
+	"sh": "bash", "bash": "bash", "zsh": "bash", "dash": "bash", "python": "python",
+	"python3": "python", "node": "javascript", "deno": "typescript", "ruby": "ruby",
+	"perl": "perl", "php": "php", "lua": "lua", "pwsh": "powershell",
+}
+
+// DetectLanguage is the default LanguageDetector. It maps the extension (or name) of path,
+// then falls back to the interpreter of a #! line and to a few unambiguous openings of
+// the first 512 bytes of content, such as "package " for Go and "<?php". It returns ""
+// for an empty path and content it does not recognize.
+func DetectLanguage(file, content string) string {
+	if file != "" {
+		base := strings.ToLower(path.Base(strings.ReplaceAll(file, "\\", "/")))
+		if lang, ok := languageFiles[base]; ok {
+			return lang
+		}
+		if lang, ok := languageExts[path.Ext(base)]; ok {
+			return lang
+		}
+	}
+	head := content
+	if len(head) > sniffPrefix {
+		head = head[:sniffPrefix]
+	}
+	if strings.HasPrefix(head, "#!") {
+		return shebangLanguage(head)
+	}
+	head = strings.TrimLeft(head, " \t\r\n")
+	switch {
+	case strings.HasPrefix(head, "package "):
+		return "go"
+	case strings.HasPrefix(head, "<?php"):
+		return "php"
+	case strings.HasPrefix(head, "#include"):
+		return "c"
+	case strings.HasPrefix(head, "def "), strings.HasPrefix(head, "from ") && strings.Contains(head, " import "):
+		return "python"
+	case strings.HasPrefix(head, "<!DOCTYPE html"), strings.HasPrefix(head, "<html"):
+		return "html"
+	}
+	switch SniffContent(head) {
+	case ContentJSON:
+		return "json"
+	case ContentDiff:
+		return "diff"
+	}
+	return ""
+}
+
+// shebangLanguage names the language of the interpreter on the #! line opening head,
+// seeing through /usr/bin/env and version suffixes such as python3.12.
+func shebangLanguage(head string) string {
+	line, _, _ := strings.Cut(head[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	interp := path.Base(fields[0])
+	if interp == "env" {
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return ""
+		}
+		interp = path.Base(fields[0])
+	}
+	if lang, ok := shebangLanguages[interp]; ok {
+		return lang
+	}
+	return shebangLanguages[strings.TrimRight(interp, "0123456789.")]
+}
+
+// detectLanguage sets ev.DetectedLanguage with the plugin's detector, or else the
+// engine's, and mirrors it into ev.Attrs under LanguageAttr. The path comes from the
+// path attribute, or else file.
+func (p *parser) detectLanguage(plugin SectionPlugin, ev *SectionEvent) {
+	detect := plugin.LanguageDetector
+	if detect == nil {
+		detect = p.detector
+	}
+	if detect == nil {
+		return
+	}
+	file, _ := lookupAttr(ev.Attrs, "path")
+	if file == "" {
+		file, _ = lookupAttr(ev.Attrs, "file")
+	}
+	lang := detect(file, ev.Content)
+	if lang == "" {
+		return
+	}
+	attrs := make(map[string]string, len(ev.Attrs)+1)
+	for k, v := range ev.Attrs {
+		attrs[k] = v
+	}
+	attrs[LanguageAttr] = lang
+	ev.DetectedLanguage, ev.Attrs = lang, attrs
+}
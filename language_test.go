@@ -0,0 +1,134 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_DetectLanguage_Should_Map_Path_Extensions(t *testing.T) {
+	for file, want := range map[string]string{
+		"main.go":            "go",
+		"src/App.tsx":        "tsx",
+		"lib/util.ts":        "typescript",
+		"scripts/run.PY":     "python",
+		"README.md":          "markdown",
+		"package.json":       "json",
+		"ci/deploy.yml":      "yaml",
+		"config.yaml":        "yaml",
+		"Cargo.toml":         "toml",
+		"db/001_init.sql":    "sql",
+		"bin/setup.sh":       "bash",
+		`C:\src\tool.rs`:     "rust",
+		"Makefile":           "makefile",
+		"docker/Dockerfile":  "dockerfile",
+		"go.mod":             "gomod",
+		"notes.unknownext":   "",
+		"LICENSE":            "",
+		"archive.tar.gz.bak": "",
+	} {
+		if got := DetectLanguage(file, ""); got != want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", file, got, want)
+		}
+	}
+	if got := DetectLanguage("main.go", "#!/usr/bin/env python3\n"); got != "go" {
+		t.Errorf("the extension should win over the content, got %q", got)
+	}
+}
+
+func Test_DetectLanguage_Should_Read_Shebangs(t *testing.T) {
+	for content, want := range map[string]string{
+		"#!/bin/sh\necho hi":                     "bash",
+		"#!/bin/bash -e\nset -u":                 "bash",
+		"#!/usr/bin/env python3\nprint(1)":       "python",
+		"#!/usr/bin/env python3.12\n":            "python",
+		"#!/usr/bin/env -S node --no-warnings\n": "javascript",
+		"#!/usr/bin/ruby\n":                      "ruby",
+		"#!/usr/bin/env\n":                       "",
+		"#!\n":                                   "",
+		"#!/opt/custom/interp\n":                 "",
+		" #!/bin/sh\n":                           "", // not a shebang unless it comes first
+	} {
+		if got := DetectLanguage("", content); got != want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func Test_DetectLanguage_Should_Fall_Back_To_Content_With_An_Empty_Path(t *testing.T) {
+	for content, want := range map[string]string{
+		"":                                     "",
+		"\n  \n":                               "",
+		"package main\n\nfunc main() {}":       "go",
+		"<?php echo 1;":                        "php",
+		"#include <stdio.h>\n":                 "c",
+		"def main():\n    pass":                "python",
+		"from os import path\n":                "python",
+		"<!DOCTYPE html>\n<html></html>":       "html",
+		`{"name": "x"}`:                        "json",
+		"diff --git a/x b/x\n":                 "diff",
+		"Just a sentence.":                     "",
+		"from here on, it is prose":            "",
+		strings.Repeat(" ", 600) + "package x": "", // past the prefix
+	} {
+		if got := DetectLanguage("", content); got != want {
+			t.Errorf("DetectLanguage(%.30q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func Test_Engine_Should_Attach_Detected_Language_And_Mirror_It_In_Attrs(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "note"})
+	reg.Register(SectionPlugin{Name: "sql", LanguageDetector: func(string, string) string { return "postgresql" }})
+	input := `<create-file path="web/App.tsx">export default 1</create-file>` +
+		`<create-file>#!/bin/sh` + "\n" + `echo hi</create-file>` +
+		`<note>Just a sentence.</note>` +
+		`<sql>select 1</sql>`
+
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(reg, WithLanguageDetection(nil)).ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	var langs []string
+	for _, ev := range rec.events {
+		sev := ev.(SectionEvent)
+		if sev.Attrs[LanguageAttr] != sev.DetectedLanguage {
+			t.Errorf("%s: Attrs[%q] = %q, want %q", sev.Name, LanguageAttr, sev.Attrs[LanguageAttr], sev.DetectedLanguage)
+		}
+		langs = append(langs, sev.DetectedLanguage)
+	}
+	if got, want := strings.Join(langs, ","), "tsx,bash,,postgresql"; got != want {
+		t.Fatalf("languages = %q, want %q", got, want)
+	}
+	if _, ok := rec.events[2].(SectionEvent).Attrs[LanguageAttr]; ok {
+		t.Fatalf("an undetected language should not be mirrored")
+	}
+
+	data, err := json.Marshal(rec.events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.Contains(s, `"_lang":"tsx"`) || !strings.Contains(s, `"detected_language":"tsx"`) {
+		t.Fatalf("JSON = %s", data)
+	}
+}
+
+func Test_Engine_Should_Not_Detect_Languages_By_Default(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "shell", LanguageDetector: func(string, string) string { return "bash" }})
+
+	rec := &recorderSink{}
+	input := `<create-file path="main.go">package main</create-file><shell>ls</shell>`
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if lang := rec.events[0].(SectionEvent).DetectedLanguage; lang != "" {
+		t.Fatalf("DetectedLanguage = %q without detection", lang)
+	}
+	if lang := rec.events[1].(SectionEvent).DetectedLanguage; lang != "bash" {
+		t.Fatalf("a plugin's detector should work on its own, got %q", lang)
+	}
+}
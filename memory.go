@@ -0,0 +1,51 @@
+package promptweaver
+
+import "sync/atomic"
+
+// MemoryGauge reports how many bytes of input a parse is holding, and may be read from
+// any goroutine while the parse runs. Pass it with WithMemoryGauge; it serves one stream at
+// a time.
+type MemoryGauge struct {
+	n atomic.Int64
+}
+
+// InUse returns the bytes held after the last chunk was parsed: input not yet tokenized,
+// the open section's body and raw envelope, open code block bodies and the window kept for
+// error snippets. It is zero once the stream has ended.
+func (g *MemoryGauge) InUse() int { return int(g.n.Load()) }
+
+// memoryInUse adds up the input buffers the parser holds.
+func (p *parser) memoryInUse() int64 {
+	n := p.tz.buf.Len() + len(p.tz.lastContent)
+	if el := p.active; el != nil {
+		n += el.body.Len()
+		if el.raw != nil {
+			n += el.raw.Len()
+		}
+		if el.block != nil {
+			n += el.block.body.Len()
+		}
+	}
+	if p.block != nil {
+		n += p.block.body.Len()
+	}
+	if p.plain != nil {
+		n += p.plain.buf.Len()
+	}
+	return int64(n)
+}
+
+// checkMemory updates the gauge and enforces EngineOptions.MemoryLimit.
+func (s *stream) checkMemory() error {
+	if s.options.MemoryGauge == nil && s.options.MemoryLimit <= 0 {
+		return nil
+	}
+	n := s.p.memoryInUse()
+	if g := s.options.MemoryGauge; g != nil {
+		g.n.Store(n)
+	}
+	if max := s.options.MemoryLimit; max > 0 && n > max {
+		return NewStreamLimitError(s.p.pos, "memory", max, s.p.tz.lastContent)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// gaugeReader feeds r in small chunks, noting the gauge's peak between reads.
+type gaugeReader struct {
+	r    *strings.Reader
+	g    *MemoryGauge
+	peak int
+}
+
+func (gr *gaugeReader) Read(p []byte) (int, error) {
+	gr.peak = max(gr.peak, gr.g.InUse())
+	return gr.r.Read(p[:min(len(p), 256)])
+}
+
+func Test_Engine_Should_Enforce_The_Combined_Memory_Limit(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", IncludeRawEnvelope: true})
+	body := strings.Repeat("x", 3000)
+	input := `<write-file path="a">` + body + `</write-file>`
+
+	// No single limit trips: the stream, body and envelope are each under 4000 bytes.
+	g := &MemoryGauge{}
+	gr := &gaugeReader{r: strings.NewReader(input), g: g}
+	err := NewEngineWithOptions(reg, WithMaxStreamBytes(8000), WithMemoryLimit(4000), WithMemoryGauge(g)).
+		ProcessStream(gr, &recorderSink{})
+	var le *StreamLimitError
+	if !errors.As(err, &le) || le.Limit != "memory" || le.Max != 4000 {
+		t.Fatalf("expected a memory limit error, got %v", err)
+	}
+	if gr.peak <= 2000 || gr.peak > 4000 {
+		t.Fatalf("unexpected peak %d", gr.peak)
+	}
+	if g.InUse() != 0 {
+		t.Fatalf("gauge not reset at the end: %d", g.InUse())
+	}
+
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(reg, WithMemoryLimit(8000)).ProcessStream(strings.NewReader(input), rec); err != nil || len(rec.events) != 1 {
+		t.Fatalf("got %v with %d events", err, len(rec.events))
+	}
+}
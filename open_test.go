@@ -0,0 +1,55 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Plugin_Should_Be_Told_When_A_Section_Opens(t *testing.T) {
+	var log []string
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{
+		Name:    "write-file",
+		Aliases: []string{"create-file"},
+		OnOpen: func(name string, attrs map[string]string, pos Position) error {
+			log = append(log, fmt.Sprintf("open %s %s @%d", name, attrs["path"], pos.Offset))
+			if attrs["path"] == "locked" {
+				return errors.New("path is locked")
+			}
+			return nil
+		},
+	})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var handled []error
+	sink := EventSinkFunc(func(ev Event) {
+		sev := ev.(SectionEvent)
+		log = append(log, "event "+sev.Name+" "+sev.Content)
+	})
+	input := `<create-file path="a.go">package a</create-file><summary>s</summary>` +
+		`<write-file path="locked">secret</write-file><write-file path="b.go"/>`
+	en := NewEngineWithOptions(reg, WithErrorHandler(func(err error) bool {
+		handled = append(handled, err)
+		return true
+	}))
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	want := "open write-file a.go @0|event write-file package a|event summary s|" +
+		"open write-file locked @68|open write-file b.go @113|event write-file "
+	if got := strings.Join(log, "|"); got != want {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+	if len(handled) != 1 || handled[0].Error() != "path is locked" {
+		t.Fatalf("unexpected handled errors %v", handled)
+	}
+
+	// In strict mode the hook's error ends the stream.
+	err := NewEngine(reg).ProcessStream(strings.NewReader(`<write-file path="locked">x</write-file>`), sink)
+	if err == nil || err.Error() != "path is locked" {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+}
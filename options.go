@@ -0,0 +1,824 @@
+package promptweaver
+
+import (
+	"hash"
+	"io"
+	"maps"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RecoveryMode defines how the parser should handle errors.
+type RecoveryMode int
+
+const (
+	// StrictMode stops parsing on the first error.
+	StrictMode RecoveryMode = iota
+
+	// ContinueMode attempts to recover from errors and continue parsing.
+	ContinueMode
+)
+
+// ErrorHandler is a function that can process parsing errors.
+// It receives the error and can decide whether to continue parsing.
+// If it returns true, parsing will continue; if false, parsing will stop.
+type ErrorHandler func(error) bool
+
+// UnknownTagHandler is notified of unregistered tags seen outside any section, opening and
+// self-closing alike, with the name as written. pos is the position of the tag's opening '<'.
+// Tags inside an active section are content, not tags.
+type UnknownTagHandler func(name string, pos Position)
+
+// EOFPolicy decides what happens to a section that is still open when it is cut off,
+// either by the end of the stream or by a section timeout.
+type EOFPolicy int
+
+const (
+	// EmitPartial emits the section with whatever content arrived. This is the default.
+	EmitPartial EOFPolicy = iota
+
+	// DropPartial discards the section without emitting it.
+	DropPartial
+
+	// ErrorPartial reports an error (UnclosedSectionError at EOF, SectionTimeoutError on timeout),
+	// subject to the RecoveryMode and ErrorHandler. A recovered section is dropped.
+	ErrorPartial
+)
+
+// EngineOptions configures the behavior of the Engine. Passed to NewEngineWithOptions as
+// the first Option, it is the whole configuration; passed after another Option, only its
+// non-zero fields apply (see Option).
+type EngineOptions struct {
+	// RecoveryMode determines how the parser handles errors.
+	// Default is StrictMode.
+	RecoveryMode RecoveryMode
+
+	// ErrorHandler is called when a parsing error occurs.
+	// If nil, the default behavior is used based on RecoveryMode.
+	// If provided, it can override the RecoveryMode behavior.
+	ErrorHandler ErrorHandler
+
+	// UnknownTagHandler, if set, observes unregistered tags that the parser skips.
+	UnknownTagHandler UnknownTagHandler
+
+	// EOFPolicy determines what happens to sections cut off by EOF or a section timeout.
+	EOFPolicy EOFPolicy
+
+	// SectionTimeout bounds how long a single section may stay open. Zero disables it.
+	// The deadline is checked whenever data arrives and, while such a section is open, by a
+	// timer, so a section is cut off on time even if the reader blocks. If the cut-off ends
+	// the stream (see ErrorPartial), ProcessStream returns at once and abandons the blocked
+	// read: whatever it returns is dropped, neither parsed nor captured, and r is not read
+	// again.
+	SectionTimeout time.Duration
+
+	// Clock returns the current time. Nil means time.Now; tests inject a fake.
+	Clock func() time.Time
+
+	// MaxStreamBytes aborts the stream with a StreamLimitError once more than this many
+	// bytes have been read. Zero means unlimited.
+	MaxStreamBytes int64
+
+	// SubParsers re-parse the content of the named sections with another engine.
+	// Keys are section names or aliases.
+	SubParsers map[string]SubParser
+
+	// MaxEvents aborts the stream with a StreamLimitError instead of emitting more than
+	// this many events. Zero means unlimited.
+	MaxEvents int
+
+	// CodeBlocks scans text outside sections for fenced code blocks and emits them as
+	// CodeBlockEvents. Tags inside a fenced block are content.
+	CodeBlocks bool
+
+	// LenientFences accepts fences indented by more than three spaces, as in nested list
+	// items, and fences inside blockquotes ("> ```"). Applies wherever fences are scanned.
+	LenientFences bool
+
+	// FenceMapping turns code blocks with a file= header into events of a registered section.
+	FenceMapping FenceSectionMapping
+
+	// StreamMeta is attached to every event of the stream, e.g. a request or choice id.
+	StreamMeta StreamMeta
+
+	// IncludeRawEnvelope fills SectionEvent.Raw, the section's exact bytes including its tags,
+	// for every section. It keeps a second copy of each body, so it is off by default;
+	// SectionPlugin.IncludeRawEnvelope enables it for single sections.
+	IncludeRawEnvelope bool
+
+	// Variables, if non-nil, are substituted for {{name}} in section content and attribute
+	// values after parsing and before validation. "{{{{" yields a literal "{{".
+	// SectionPlugin.NoVariables opts a section out.
+	Variables map[string]string
+
+	// UnknownVariables decides what happens to placeholders missing from Variables.
+	UnknownVariables UnknownVariablePolicy
+
+	// SuppressedSections are discarded as if their plugins set Suppress. Keys are section
+	// names or aliases.
+	SuppressedSections []string
+
+	// SuppressHandler, if set, is told about every suppressed section: its size, duration
+	// and how many validators were skipped.
+	SuppressHandler SuppressHandler
+
+	// RawCapture receives every byte read from the stream, exactly as read, so the stream
+	// can be replayed later (see Replay).
+	RawCapture io.Writer
+
+	// TimingCapture receives one JSON ChunkTiming per read, with its size and the delay
+	// since the previous read, measured with Clock.
+	TimingCapture io.Writer
+
+	// ContextSections are wrapper tags whose attributes are inherited by the sections
+	// inside them, instead of being emitted as sections themselves.
+	ContextSections []ContextSection
+
+	// ContextAttrPrefix, if set, puts inherited attributes under prefixed keys ("_ctx_"
+	// turns root into _ctx_root) so they never collide with a section's own attributes.
+	ContextAttrPrefix string
+
+	// Pairings correlate sections by an id attribute and emit a PairedEvent for each pair.
+	Pairings []Pairing
+
+	// UnpairedHandler, if set, is told at the end of the stream about sections of a
+	// Pairing that never found their counterpart.
+	UnpairedHandler UnpairedHandler
+
+	// OrphanRescue, when a section is cut off by EOF, takes the complete registered sections
+	// out of its body and emits them on their own, marked Rescued, before it. Off by default:
+	// it is a recovery heuristic for models that forget a closing tag.
+	OrphanRescue bool
+
+	// PreserveAttrCase keeps attribute keys as written (onClick, Content-Type) instead of
+	// lowercasing them. SectionEvent.Attr looks keys up ignoring case either way.
+	PreserveAttrCase bool
+
+	// StreamDigest, if set, makes a hash that every byte read from the stream is written to;
+	// its sum is delivered in a DigestEvent once the stream reaches EOF.
+	StreamDigest func() hash.Hash
+
+	// ReadRetry retries reads that fail with transient errors. The zero value does not retry.
+	ReadRetry RetryPolicy
+
+	// ExpectedLength, if positive, is the stream's length in bytes, e.g. from Content-Length.
+	// The engine then reports how much has been read in ProgressEvents, every ProgressEvery
+	// bytes or, when that is zero, every ProgressPercent percent (1 by default), and once
+	// more at EOF if bytes were read since the last report.
+	ExpectedLength  int64
+	ProgressEvery   int64
+	ProgressPercent float64
+
+	// ProgressHandler, if set, receives the progress reports instead of the sink, so they
+	// neither reach it nor count towards MaxEvents.
+	ProgressHandler func(ProgressEvent)
+
+	// MaxSkippedBytes caps ParseError.Skipped, the input a recovered error made the parser
+	// drop. Zero means DefaultMaxSkippedBytes; a negative value records nothing.
+	MaxSkippedBytes int
+
+	// References check that sections refer, by id, to sections declared earlier (see Reference).
+	References []Reference
+
+	// ReferenceResolver, if set, is told which section defined the id of each resolved use.
+	ReferenceResolver ReferenceResolver
+
+	// ReferenceWarnings, if set, receives unknown references and duplicate definitions, and
+	// the sections are delivered anyway. Otherwise they go through the error handling.
+	ReferenceWarnings func(*ReferenceError)
+
+	// DanglingReferenceHandler, if set, is told at the end of the stream about the uses whose
+	// ids were never defined.
+	DanglingReferenceHandler DanglingReferenceHandler
+
+	// ContentSniffing sets SectionEvent.ContentKind from the first bytes of each body
+	// (see SniffContent).
+	ContentSniffing bool
+
+	// MaxAttrs and MaxAttrNameLen bound the attributes of a tag: how many it may have and how
+	// long each name may be. A tag over a limit is a MalformedTagError, and its attributes
+	// stop being collected there. Zero means DefaultMaxAttrs and DefaultMaxAttrNameLen; a
+	// negative value lifts the limit.
+	MaxAttrs       int
+	MaxAttrNameLen int
+
+	// MaxLookahead bounds how many bytes the tokenizer buffers while it waits to see what
+	// they are: a tag, a closing tag in a section body, a fence line or a run of backticks.
+	// Past it, an opening or closing tag outside sections is a MalformedTagError and the rest
+	// is text, so input that never completes a tag cannot grow the buffer without end. Zero
+	// means DefaultMaxLookahead; a negative value lifts the limit.
+	MaxLookahead int
+
+	// HandlerTiming, if positive, times each delivery to the sink with Clock. Deliveries
+	// slower than it go to OnSlowHandler, and HandlerStatsHandler is told the count,
+	// total and maximum per section at the end of the stream. Zero disables timing.
+	HandlerTiming       time.Duration
+	OnSlowHandler       func(SlowHandler)
+	HandlerStatsHandler func(map[string]HandlerStats)
+
+	// WellFormed checks that the markup is well-formed XML while parsing as usual: every
+	// element is closed, in order, and no tag repeats an attribute. Elements are tracked
+	// outside sections and, when a section closes, within its body. Problems are
+	// WellFormednessErrors; the events emitted do not change. Outside sections, a closing tag
+	// that matches an open unregistered element is no longer an UnmatchedTagError.
+	WellFormed bool
+
+	// MemoryLimit aborts the stream with a StreamLimitError (Limit "memory") once the input
+	// the parser holds exceeds this many bytes after a chunk, whichever buffer it is in (see
+	// MemoryGauge.InUse). It backs up the individual limits. Zero means unlimited.
+	MemoryLimit int64
+
+	// MemoryGauge, if set, is kept up to date with the bytes the parser holds.
+	MemoryGauge *MemoryGauge
+
+	// TruncationHandler, if set, is told at EOF when the stream ended inside an incomplete
+	// tag, an unterminated code block or an unclosed section, as output cut off by a token
+	// limit does. It runs before the EOFPolicy is applied.
+	TruncationHandler TruncationHandler
+
+	// Ancestry tracks the unregistered wrapper tags open outside sections and lists them in
+	// the EventBase.Ancestry of every event. A closing tag pops the innermost open tag of
+	// its name along with those opened inside it; one that matches no open tag is ignored.
+	// Tracking is observational: the events emitted and errors reported do not change.
+	Ancestry bool
+
+	// PlainText, if set, emits the text outside sections and code blocks as sections named
+	// SectionPlainText, one per run of text, shaped by the options. Nil drops the text.
+	PlainText *PlainTextOptions
+
+	// DefaultAttrs fills in attributes the opening tags of a section leave out, keyed by
+	// section name or alias, like SectionPlugin.AttrDefaults but set per engine, e.g. a
+	// project and branch the model need not repeat on every tag. A value written in the tag
+	// wins; these win over the plugin's defaults. The keys filled in are listed in
+	// SectionEvent.DefaultedAttrs.
+	DefaultAttrs map[string]map[string]string
+
+	// SectionClosedEvents emits a SectionClosedEvent for each section that ends without a
+	// SectionEvent: suppressed sections, and sections aborted by a failed OnOpen hook.
+	SectionClosedEvents bool
+
+	// ByteBudgets caps the body bytes read per section name or alias over the stream, e.g.
+	// to bill or limit tool output. The first time a section takes its name over budget,
+	// ByteBudgetHandler decides, mid-section, whether to read on, truncate the section or
+	// abort; nil truncates. ByteUsageHandler, if set, is told the bytes read per canonical
+	// name when the stream ends, with or without budgets.
+	ByteBudgets       map[string]int
+	ByteBudgetHandler ByteBudgetHandler
+	ByteUsageHandler  func(map[string]int)
+
+	// TruncatedSectionsHandler, if set, is told when the stream ends how many sections of
+	// each canonical name were emitted truncated, by SectionPlugin.TruncateAt or a byte
+	// budget. Sections dropped by a recovered error are not counted.
+	TruncatedSectionsHandler func(map[string]int)
+
+	// InlineCodeAwareness treats a '<' inside a markdown inline code span outside sections
+	// as text, so that a tag mentioned in prose, as in "use `<create-file>` for new files",
+	// opens nothing. A span opens at a run of backticks and closes at a run of the same
+	// length or at the end of the line. Section bodies and code blocks are not affected.
+	InlineCodeAwareness bool
+
+	// EscapePrefix, if set, lets the model write a tag as text outside sections: the '<'
+	// after the prefix opens nothing, and the prefix itself is dropped from PlainText. A
+	// doubled prefix before '<', as in `\\<summary>`, stands for one prefix followed by a
+	// real tag. Elsewhere the prefix is ordinary text, and section bodies are unaffected.
+	EscapePrefix byte
+
+	// OriginalNameCasing delivers section names in the casing their plugins were registered
+	// with, "WriteFile" rather than "writefile", in SectionEvent.Name and the names of
+	// paired and closed sections. Validators, references and pairings still see canonical
+	// names; handler lookup and Registry.Canonical stay case-insensitive.
+	OriginalNameCasing bool
+
+	// RevisionAttr names an attribute with which the model revises an earlier section of
+	// the stream, e.g. revises="3" for the section with Seq 3. The revision gets Supersedes
+	// set and is followed by a SupersededEvent. A value that names no earlier section,
+	// such as a later Seq, is reported to RevisionWarnings, if set, and otherwise ignored.
+	RevisionAttr     string
+	RevisionWarnings func(*ReferenceError)
+
+	// RegistrySync decides how the engine treats plugins registered after it was built.
+	RegistrySync RegistrySync
+
+	// BalancedSameName nests opening tags of a section's own name in its body: each one,
+	// under any alias, must be closed before a closer ends the section, so that
+	// <think>a <think>b</think> c</think> is one section with all of it as content. A
+	// self-closing tag does not nest. Bodies with RawUntil or RawDelimiter are unaffected.
+	// By default the first closer ends the section.
+	BalancedSameName bool
+
+	// TokenTap, if set, is called for every tag the parser reads, opening, closing or
+	// self-closing, once it has been handled, with what became of it. It runs on the
+	// parsing goroutine, before the parser reads on. Tags in a section body other than its
+	// closer are body text and are not tags here; ProcessXML does not call it.
+	TokenTap func(TagTokenInfo)
+
+	// MaxEmptyReads is how many reads in a row may return no data and no error before the
+	// stream fails with an error wrapping io.ErrNoProgress, rather than spin on a broken
+	// reader. The error goes to ReadRetry, so a policy whose Retryable accepts
+	// io.ErrNoProgress backs off instead. Zero means DefaultMaxEmptyReads; a negative value
+	// tolerates any number.
+	MaxEmptyReads int
+
+	// LanguageDetector sets SectionEvent.DetectedLanguage from each section's path (or
+	// file) attribute and content, for bodies without a fence to name their language (see
+	// DetectLanguage). SectionPlugin.LanguageDetector overrides it.
+	LanguageDetector LanguageDetector
+
+	// AttrWarnings, if set, receives the unknown attributes of sections whose plugin sets
+	// UnknownAttrs to WarnUnknownAttrs, one error per opening tag; the section is kept.
+	AttrWarnings func(*AttributeValidationError)
+}
+
+// RegistrySync is a policy for registrations made after an engine was built (see
+// Registry.Generation).
+type RegistrySync int
+
+const (
+	// RegistryLive reads the registry as it is, even while a stream is being parsed.
+	// This is the default.
+	RegistryLive RegistrySync = iota
+
+	// RegistrySnapshot parses each stream with a copy of the registry taken when the stream
+	// starts, so a registration applies from the next call on and never mid-stream.
+	RegistrySnapshot
+
+	// RegistryFailOnChange fails every call with ErrRegistryModified once a plugin has
+	// been registered after the engine was built. Build a new engine to pick it up.
+	RegistryFailOnChange
+)
+
+// Default limits on the attributes of a tag (see EngineOptions.MaxAttrs).
+const (
+	DefaultMaxAttrs       = 64
+	DefaultMaxAttrNameLen = 256
+)
+
+// DefaultMaxLookahead is the limit on buffered lookahead when MaxLookahead is zero.
+const DefaultMaxLookahead = 16 << 20
+
+// limitOrDefault maps a limit option to its value: zero is def, negative is unlimited (0).
+func limitOrDefault(n, def int) int {
+	switch {
+	case n == 0:
+		return def
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// DefaultMaxEmptyReads is the limit on empty reads in a row when MaxEmptyReads is zero, the
+// same as bufio's.
+const DefaultMaxEmptyReads = 100
+
+// DefaultMaxSkippedBytes is the cap on ParseError.Skipped when MaxSkippedBytes is zero.
+const DefaultMaxSkippedBytes = 1024
+
+// FenceSectionMapping describes how code blocks carrying a file= header are turned into
+// SectionEvents, so that a sink sees one shape whether the model wrote a tag or a fence.
+type FenceSectionMapping struct {
+	// Section is the registered section (name or alias) the blocks become. Empty, or a name
+	// the registry does not know, disables the mapping.
+	Section string
+
+	// PathAttr is the attribute that receives the file name. Empty means "path".
+	// The language is always passed as "lang".
+	PathAttr string
+
+	// KeepCodeBlocks also emits the CodeBlockEvent, just before the mapped section.
+	KeepCodeBlocks bool
+}
+
+func (m FenceSectionMapping) pathAttr() string {
+	if m.PathAttr == "" {
+		return "path"
+	}
+	return strings.ToLower(m.PathAttr)
+}
+
+// Option configures an Engine. The With* helpers each change one setting and can be
+// combined. EngineOptions is itself an Option: passed first, it replaces the defaults as a
+// whole; passed later, it only sets each of its fields that is not the zero value, so it
+// cannot turn a setting back off. Use With* helpers, such as WithRecoveryMode, for that.
+type Option interface{ apply(*EngineOptions) }
+
+type optionFunc func(*EngineOptions)
+
+func (f optionFunc) apply(o *EngineOptions) { f(o) }
+
+// apply sets the fields of dst that o does not leave at the zero value. A zero field cannot
+// be told apart from one left unset, so it never clears what an earlier Option set.
+func (o EngineOptions) apply(dst *EngineOptions) {
+	src, out := reflect.ValueOf(o), reflect.ValueOf(dst).Elem()
+	for i := range src.NumField() {
+		if f := src.Field(i); !f.IsZero() {
+			out.Field(i).Set(f)
+		}
+	}
+}
+
+// DefaultEngineOptions returns the default engine options.
+func DefaultEngineOptions() EngineOptions {
+	return EngineOptions{
+		RecoveryMode: StrictMode,
+		ErrorHandler: nil, // Default to nil, will use RecoveryMode behavior
+	}
+}
+
+// WithRecoveryMode sets how the engine handles errors, even to StrictMode, which an
+// EngineOptions passed after WithContinueMode cannot do.
+func WithRecoveryMode(mode RecoveryMode) Option {
+	return optionFunc(func(o *EngineOptions) { o.RecoveryMode = mode })
+}
+
+// WithContinueMode returns engine options configured for continue mode, in which the engine
+// recovers from errors and keeps parsing.
+func WithContinueMode() EngineOptions {
+	return EngineOptions{RecoveryMode: ContinueMode}
+}
+
+// WithErrorHandler returns engine options with a custom error handler, which overrides the
+// RecoveryMode.
+func WithErrorHandler(handler ErrorHandler) EngineOptions {
+	return EngineOptions{ErrorHandler: handler}
+}
+
+// WithEOFPolicy sets what happens to sections cut off by EOF or a section timeout.
+func WithEOFPolicy(policy EOFPolicy) Option {
+	return optionFunc(func(o *EngineOptions) { o.EOFPolicy = policy })
+}
+
+// WithSectionTimeout force-closes any section still open d after its opening tag,
+// according to the EOFPolicy. The rest of its body, up to the closing tag, is discarded.
+func WithSectionTimeout(d time.Duration) Option {
+	return optionFunc(func(o *EngineOptions) { o.SectionTimeout = d })
+}
+
+// WithClock replaces time.Now for time-based options.
+func WithClock(now func() time.Time) Option {
+	return optionFunc(func(o *EngineOptions) { o.Clock = now })
+}
+
+// WithMaxStreamBytes caps the number of bytes read from a stream. Bytes up to the cap are
+// still parsed, so sections that closed within it are emitted. Zero means unlimited.
+func WithMaxStreamBytes(n int64) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxStreamBytes = n })
+}
+
+// WithMaxEvents caps the number of events emitted per stream. Zero means unlimited.
+func WithMaxEvents(n int) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxEvents = n })
+}
+
+// WithSubParser re-parses the content of section with engine once it closes, delivering the
+// resulting events to sink. This gives controlled two-level nesting without enabling nesting
+// globally.
+func WithSubParser(section string, engine *Engine, sink EventSink) Option {
+	return optionFunc(func(o *EngineOptions) {
+		subs := make(map[string]SubParser, len(o.SubParsers)+1)
+		for k, v := range o.SubParsers {
+			subs[k] = v
+		}
+		subs[section] = SubParser{Engine: engine, Sink: sink}
+		o.SubParsers = subs
+	})
+}
+
+// WithCodeBlocks emits a CodeBlockEvent for every fenced code block outside sections.
+func WithCodeBlocks() Option {
+	return optionFunc(func(o *EngineOptions) { o.CodeBlocks = true })
+}
+
+// WithLenientFences enables code block scanning and also accepts deeply indented fences and
+// fences inside blockquotes.
+func WithLenientFences() Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.CodeBlocks = true
+		o.LenientFences = true
+	})
+}
+
+// WithFenceSectionMapping enables code block scanning and emits blocks with a file= header
+// as a SectionEvent of section, with Attrs{pathAttr: file, "lang": language}, instead of a
+// CodeBlockEvent. The mapped events are validated like the tag form of the section.
+func WithFenceSectionMapping(section, pathAttr string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.CodeBlocks = true
+		o.FenceMapping.Section = section
+		o.FenceMapping.PathAttr = pathAttr
+	})
+}
+
+// WithKeepMappedCodeBlocks emits the CodeBlockEvent in addition to the mapped section.
+func WithKeepMappedCodeBlocks() Option {
+	return optionFunc(func(o *EngineOptions) { o.FenceMapping.KeepCodeBlocks = true })
+}
+
+// WithStreamMeta attaches meta to every event the engine emits.
+func WithStreamMeta(meta StreamMeta) Option {
+	return optionFunc(func(o *EngineOptions) { o.StreamMeta = meta })
+}
+
+// WithRawEnvelope fills SectionEvent.Raw for every section.
+func WithRawEnvelope() Option {
+	return optionFunc(func(o *EngineOptions) { o.IncludeRawEnvelope = true })
+}
+
+// WithVariables expands {{name}} placeholders in sections using vars. vars is copied, so
+// the caller may change it while streams run.
+func WithVariables(vars map[string]string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.Variables = maps.Clone(vars)
+		if o.Variables == nil {
+			o.Variables = map[string]string{}
+		}
+	})
+}
+
+// WithUnknownVariables sets the policy for placeholders that have no value.
+func WithUnknownVariables(policy UnknownVariablePolicy) Option {
+	return optionFunc(func(o *EngineOptions) { o.UnknownVariables = policy })
+}
+
+// WithSuppressedSections discards the named sections without buffering their content.
+func WithSuppressedSections(sections ...string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.SuppressedSections = append(o.SuppressedSections, sections...)
+	})
+}
+
+// WithSuppressHandler observes suppressed sections.
+func WithSuppressHandler(handler SuppressHandler) Option {
+	return optionFunc(func(o *EngineOptions) { o.SuppressHandler = handler })
+}
+
+// WithRawCapture tees the raw stream into w.
+func WithRawCapture(w io.Writer) Option {
+	return optionFunc(func(o *EngineOptions) { o.RawCapture = w })
+}
+
+// WithTimingCapture writes the stream's chunk sizes and inter-arrival delays to w as JSONL.
+func WithTimingCapture(w io.Writer) Option {
+	return optionFunc(func(o *EngineOptions) { o.TimingCapture = w })
+}
+
+// WithContextSection makes name a wrapper whose attributes (all of them, or only those
+// listed in inheritAttrs) are inherited by the sections inside it.
+func WithContextSection(name string, inheritAttrs ...string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.ContextSections = append(o.ContextSections, ContextSection{Name: name, Inherit: inheritAttrs})
+	})
+}
+
+// WithContextAttrPrefix puts inherited attributes under prefixed keys.
+func WithContextAttrPrefix(prefix string) Option {
+	return optionFunc(func(o *EngineOptions) { o.ContextAttrPrefix = prefix })
+}
+
+// WithPairing emits a PairedEvent whenever an openSection and a closeSection with the same
+// attr value have both been emitted (see PairedEvent for the rules).
+func WithPairing(openSection, closeSection, attr string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.Pairings = append(o.Pairings, Pairing{Open: openSection, Close: closeSection, Attr: attr})
+	})
+}
+
+// WithUnpairedHandler sets the handler told about unpaired sections at the end of the stream.
+func WithUnpairedHandler(handler UnpairedHandler) Option {
+	return optionFunc(func(o *EngineOptions) { o.UnpairedHandler = handler })
+}
+
+// WithOrphanRescue turns EngineOptions.OrphanRescue on or off.
+func WithOrphanRescue(on bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.OrphanRescue = on })
+}
+
+// WithPreserveAttrCase turns EngineOptions.PreserveAttrCase on or off.
+func WithPreserveAttrCase(on bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.PreserveAttrCase = on })
+}
+
+// WithStreamDigest hashes the raw stream with a hash from h, e.g. sha256.New, and emits the
+// sum in a DigestEvent at the end of the stream.
+func WithStreamDigest(h func() hash.Hash) Option {
+	return optionFunc(func(o *EngineOptions) { o.StreamDigest = h })
+}
+
+// WithExpectedLength reports progress through a stream of n bytes (see EngineOptions.ExpectedLength).
+func WithExpectedLength(n int64) Option {
+	return optionFunc(func(o *EngineOptions) { o.ExpectedLength = n })
+}
+
+// WithProgressEvery reports progress every n bytes read.
+func WithProgressEvery(n int64) Option {
+	return optionFunc(func(o *EngineOptions) { o.ProgressEvery = n })
+}
+
+// WithProgressPercent reports progress every pct percent of the expected length.
+func WithProgressPercent(pct float64) Option {
+	return optionFunc(func(o *EngineOptions) { o.ProgressPercent = pct })
+}
+
+// WithProgressHandler delivers progress reports to fn instead of emitting ProgressEvents.
+func WithProgressHandler(fn func(ProgressEvent)) Option {
+	return optionFunc(func(o *EngineOptions) { o.ProgressHandler = fn })
+}
+
+// WithMaxSkippedBytes caps the skipped input recorded on errors (see EngineOptions.MaxSkippedBytes).
+func WithMaxSkippedBytes(n int) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxSkippedBytes = n })
+}
+
+// WithReference checks that the useAttr of useSection names the defAttr of an earlier
+// defSection (see Reference).
+func WithReference(defSection, defAttr, useSection, useAttr string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.References = append(o.References, Reference{Def: defSection, DefAttr: defAttr, Use: useSection, UseAttr: useAttr})
+	})
+}
+
+// WithReferenceResolver tells fn which section defined the id of each resolved use.
+func WithReferenceResolver(fn ReferenceResolver) Option {
+	return optionFunc(func(o *EngineOptions) { o.ReferenceResolver = fn })
+}
+
+// WithReferenceWarnings reports reference problems to fn and keeps the sections.
+func WithReferenceWarnings(fn func(*ReferenceError)) Option {
+	return optionFunc(func(o *EngineOptions) { o.ReferenceWarnings = fn })
+}
+
+// WithDanglingReferenceHandler reports the uses left unresolved when the stream ends.
+func WithDanglingReferenceHandler(fn DanglingReferenceHandler) Option {
+	return optionFunc(func(o *EngineOptions) { o.DanglingReferenceHandler = fn })
+}
+
+// WithContentSniffing sets SectionEvent.ContentKind on every section.
+func WithContentSniffing(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.ContentSniffing = enabled })
+}
+
+// WithMaxAttrs limits how many attributes a tag may have (see EngineOptions.MaxAttrs).
+func WithMaxAttrs(n int) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxAttrs = n })
+}
+
+// WithMaxAttrNameLen limits the length of attribute names (see EngineOptions.MaxAttrNameLen).
+func WithMaxAttrNameLen(n int) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxAttrNameLen = n })
+}
+
+// WithReadRetry retries reads that fail with transient errors according to policy.
+func WithReadRetry(policy RetryPolicy) Option {
+	return optionFunc(func(o *EngineOptions) { o.ReadRetry = policy })
+}
+
+// WithHandlerTiming times deliveries to the sink, reporting those slower than threshold to
+// fn (see EngineOptions.HandlerTiming).
+func WithHandlerTiming(threshold time.Duration, fn func(SlowHandler)) Option {
+	return optionFunc(func(o *EngineOptions) { o.HandlerTiming, o.OnSlowHandler = threshold, fn })
+}
+
+// WithHandlerStatsHandler receives the per-section handler timings at the end of the stream.
+func WithHandlerStatsHandler(fn func(map[string]HandlerStats)) Option {
+	return optionFunc(func(o *EngineOptions) { o.HandlerStatsHandler = fn })
+}
+
+// WithWellFormed reports markup that is not well-formed XML (see EngineOptions.WellFormed).
+func WithWellFormed(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.WellFormed = enabled })
+}
+
+// WithMemoryLimit caps the input the parser may hold at once (see EngineOptions.MemoryLimit).
+func WithMemoryLimit(n int64) Option {
+	return optionFunc(func(o *EngineOptions) { o.MemoryLimit = n })
+}
+
+// WithMemoryGauge keeps g up to date with the bytes the parser holds.
+func WithMemoryGauge(g *MemoryGauge) Option {
+	return optionFunc(func(o *EngineOptions) { o.MemoryGauge = g })
+}
+
+// WithTruncationHandler reports streams that look cut off (see EngineOptions.TruncationHandler).
+func WithTruncationHandler(fn TruncationHandler) Option {
+	return optionFunc(func(o *EngineOptions) { o.TruncationHandler = fn })
+}
+
+// WithAncestry lists the unregistered tags around each event (see EngineOptions.Ancestry).
+func WithAncestry(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.Ancestry = enabled })
+}
+
+// WithPlainText emits the text outside sections as PlainText sections (see
+// EngineOptions.PlainText).
+func WithPlainText(options PlainTextOptions) Option {
+	return optionFunc(func(o *EngineOptions) { o.PlainText = &options })
+}
+
+// WithDefaultAttrs fills in attrs on every section that leaves them out (see
+// EngineOptions.DefaultAttrs). Calls for the same section add to its defaults.
+func WithDefaultAttrs(section string, attrs map[string]string) Option {
+	return optionFunc(func(o *EngineOptions) {
+		// Copied, so that engines built from the same options do not share the maps.
+		all := map[string]map[string]string{}
+		maps.Copy(all, o.DefaultAttrs)
+		merged := map[string]string{}
+		maps.Copy(merged, all[section])
+		maps.Copy(merged, attrs)
+		all[section] = merged
+		o.DefaultAttrs = all
+	})
+}
+
+// WithSectionClosedEvents signals the end of sections that emit no SectionEvent (see
+// EngineOptions.SectionClosedEvents).
+func WithSectionClosedEvents(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.SectionClosedEvents = enabled })
+}
+
+// WithByteBudget caps the body bytes read per section name (see EngineOptions.ByteBudgets).
+func WithByteBudget(budgets map[string]int, onExceed ByteBudgetHandler) Option {
+	return optionFunc(func(o *EngineOptions) {
+		o.ByteBudgets = maps.Clone(budgets)
+		o.ByteBudgetHandler = onExceed
+	})
+}
+
+// WithByteUsageHandler receives the body bytes read per section name at the end of the stream.
+func WithByteUsageHandler(fn func(map[string]int)) Option {
+	return optionFunc(func(o *EngineOptions) { o.ByteUsageHandler = fn })
+}
+
+// WithTruncatedSectionsHandler receives the number of truncated sections per section name at
+// the end of the stream.
+func WithTruncatedSectionsHandler(fn func(map[string]int)) Option {
+	return optionFunc(func(o *EngineOptions) { o.TruncatedSectionsHandler = fn })
+}
+
+// WithInlineCodeAwareness keeps tags in inline code spans as text (see
+// EngineOptions.InlineCodeAwareness).
+func WithInlineCodeAwareness(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.InlineCodeAwareness = enabled })
+}
+
+// WithEscapePrefix makes '<' after prefix, usually '\\', text outside sections (see
+// EngineOptions.EscapePrefix).
+func WithEscapePrefix(prefix byte) Option {
+	return optionFunc(func(o *EngineOptions) { o.EscapePrefix = prefix })
+}
+
+// WithOriginalNameCasing delivers section names as registered (see
+// EngineOptions.OriginalNameCasing).
+func WithOriginalNameCasing(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.OriginalNameCasing = enabled })
+}
+
+// WithRevisions lets sections revise earlier ones through attr (see
+// EngineOptions.RevisionAttr). warn, if not nil, receives revisions of unknown sections.
+func WithRevisions(attr string, warn func(*ReferenceError)) Option {
+	return optionFunc(func(o *EngineOptions) { o.RevisionAttr, o.RevisionWarnings = attr, warn })
+}
+
+// WithRegistrySync sets the policy for registrations made after the engine was built (see
+// EngineOptions.RegistrySync).
+func WithRegistrySync(policy RegistrySync) Option {
+	return optionFunc(func(o *EngineOptions) { o.RegistrySync = policy })
+}
+
+// WithBalancedSameName nests same-name tags in section bodies (see
+// EngineOptions.BalancedSameName).
+func WithBalancedSameName(enabled bool) Option {
+	return optionFunc(func(o *EngineOptions) { o.BalancedSameName = enabled })
+}
+
+// WithTokenTap calls tap with every tag the parser reads (see EngineOptions.TokenTap).
+func WithTokenTap(tap func(TagTokenInfo)) Option {
+	return optionFunc(func(o *EngineOptions) { o.TokenTap = tap })
+}
+
+// WithMaxEmptyReads sets how many reads in a row may return nothing (see
+// EngineOptions.MaxEmptyReads).
+func WithMaxEmptyReads(n int) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxEmptyReads = n })
+}
+
+// WithLanguageDetection sets SectionEvent.DetectedLanguage with detector, or with
+// DetectLanguage if detector is nil (see EngineOptions.LanguageDetector).
+func WithLanguageDetection(detector func(path, content string) string) Option {
+	if detector == nil {
+		detector = DetectLanguage
+	}
+	return optionFunc(func(o *EngineOptions) { o.LanguageDetector = detector })
+}
+
+// WithAttrWarnings reports unknown attributes under WarnUnknownAttrs to fn (see
+// EngineOptions.AttrWarnings).
+func WithAttrWarnings(fn func(*AttributeValidationError)) Option {
+	return optionFunc(func(o *EngineOptions) { o.AttrWarnings = fn })
+}
+
+// WithMaxLookahead limits how much input the tokenizer buffers before deciding what it is
+// (see EngineOptions.MaxLookahead).
+func WithMaxLookahead(n int) Option {
+	return optionFunc(func(o *EngineOptions) { o.MaxLookahead = n })
+}
@@ -0,0 +1,428 @@
+package promptweaver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced by tickingReader to simulate slow streams. Reads may run on their
+// own goroutine under a section timeout, so it is locked.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// tickingReader returns one chunk per Read and advances the clock by step before each.
+type tickingReader struct {
+	chunks []string
+	clock  *fakeClock
+	step   time.Duration
+}
+
+func (r *tickingReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	r.clock.Advance(r.step)
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func newTimeoutFixture(policy EOFPolicy) (*Engine, *fakeClock, *HandlerSink, *[]SectionEvent) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	sink, got := newSinkCatcher("think", "summary")
+
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	en := NewEngineWithOptions(reg,
+		WithSectionTimeout(3*time.Second),
+		WithClock(clock.Now),
+		WithEOFPolicy(policy),
+	)
+	return en, clock, sink, got
+}
+
+func Test_Engine_SectionTimeout_Should_Emit_Partial_And_Skip_Rest(t *testing.T) {
+	en, clock, sink, got := newTimeoutFixture(EmitPartial)
+	r := &tickingReader{
+		chunks: []string{"<think>loop ", "loop ", "loop ", "loop ", "loop</think>", "<summary>ok</summary>"},
+		clock:  clock,
+		step:   time.Second,
+	}
+	if err := en.ProcessStream(r, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 2 {
+		t.Fatalf("want 2 events, got %+v", *got)
+	}
+	if (*got)[0].Name != "think" || (*got)[0].Content != "loop loop loop loop " {
+		t.Fatalf("unexpected partial think: %+v", (*got)[0])
+	}
+	if (*got)[1].Name != "summary" || (*got)[1].Content != "ok" {
+		t.Fatalf("unexpected summary: %+v", (*got)[1])
+	}
+}
+
+func Test_Engine_SectionTimeout_Should_Drop_Under_DropPartial(t *testing.T) {
+	en, clock, sink, got := newTimeoutFixture(DropPartial)
+	r := &tickingReader{
+		chunks: []string{"<think>a", "b", "c", "d</think><summary>ok</summary>"},
+		clock:  clock,
+		step:   2 * time.Second,
+	}
+	if err := en.ProcessStream(r, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 1 || (*got)[0].Name != "summary" {
+		t.Fatalf("want only summary, got %+v", *got)
+	}
+}
+
+func Test_Engine_SectionTimeout_Should_Report_Error_Under_ErrorPartial(t *testing.T) {
+	en, clock, sink, got := newTimeoutFixture(ErrorPartial)
+	r := &tickingReader{
+		chunks: []string{"\n<think>ab", "cd", "ef", "gh", "</think>"},
+		clock:  clock,
+		step:   time.Second,
+	}
+	err := en.ProcessStream(r, sink)
+	timeoutErr, ok := err.(*SectionTimeoutError)
+	if !ok {
+		t.Fatalf("expected SectionTimeoutError, got %T: %v", err, err)
+	}
+	if timeoutErr.SectionName != "think" || timeoutErr.BytesReceived != 8 || timeoutErr.Timeout != 3*time.Second {
+		t.Fatalf("unexpected error fields: %+v", timeoutErr)
+	}
+	if timeoutErr.Start != (Position{Line: 2, Column: 1, Offset: 1}) {
+		t.Fatalf("unexpected start position: %s", timeoutErr.Start)
+	}
+	if len(*got) != 0 {
+		t.Fatalf("no events expected, got %+v", *got)
+	}
+}
+
+func Test_Engine_SectionTimeout_Should_Not_Fire_For_Quick_Sections_In_Long_Stream(t *testing.T) {
+	en, clock, sink, got := newTimeoutFixture(ErrorPartial)
+	var chunks []string
+	for i := 0; i < 20; i++ {
+		chunks = append(chunks, "<think>", "x</think> ")
+	}
+	r := &tickingReader{chunks: chunks, clock: clock, step: 2 * time.Second}
+	if err := en.ProcessStream(r, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 20 {
+		t.Fatalf("want 20 events, got %d", len(*got))
+	}
+}
+
+func Test_Engine_EOFPolicy_Should_Apply_To_Unclosed_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	sink, got := newSinkCatcher("summary")
+	en := NewEngineWithOptions(reg, WithEOFPolicy(DropPartial))
+	if err := en.ProcessStream(ReaderFromString(`<summary>partial`), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 0 {
+		t.Fatalf("DropPartial should not emit, got %+v", *got)
+	}
+
+	en = NewEngineWithOptions(reg, WithEOFPolicy(ErrorPartial))
+	err := en.ProcessStream(ReaderFromString(`<summary>partial`), sink)
+	unclosed, ok := err.(*UnclosedSectionError)
+	if !ok {
+		t.Fatalf("expected UnclosedSectionError, got %T: %v", err, err)
+	}
+	if unclosed.SectionName != "summary" || unclosed.BytesReceived != 7 {
+		t.Fatalf("unexpected error fields: %+v", unclosed)
+	}
+
+	en = NewEngineWithOptions(reg, WithEOFPolicy(ErrorPartial), WithContinueMode())
+	if err := en.ProcessStream(ReaderFromString(`<summary>partial`), sink); err != nil {
+		t.Fatalf("recovered ErrorPartial should not fail: %v", err)
+	}
+	if len(*got) != 0 {
+		t.Fatalf("recovered ErrorPartial should drop the section, got %+v", *got)
+	}
+}
+
+func Test_EngineOptions_Struct_Should_Still_Be_Accepted(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	sink, got := newSinkCatcher("think")
+
+	options := DefaultEngineOptions()
+	options.RecoveryMode = ContinueMode
+	en := NewEngineWithOptions(reg, options, WithEOFPolicy(DropPartial))
+	if err := en.ProcessStream(ReaderFromString(`</x><think>a</think><think>b`), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 1 || (*got)[0].Content != "a" {
+		t.Fatalf("unexpected events: %+v", *got)
+	}
+}
+
+func Test_Option_Helpers_Should_Keep_Returning_EngineOptions(t *testing.T) {
+	var options EngineOptions = WithContinueMode() // as callers wrote before Option existed
+	options.UnknownTagHandler = func(string, Position) {}
+	if options.RecoveryMode != ContinueMode || WithErrorHandler(func(error) bool { return true }).ErrorHandler == nil {
+		t.Fatalf("unexpected options %+v", options)
+	}
+
+	// Combined with other options in any order, they change only what they set.
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	for _, opts := range [][]Option{
+		{WithEOFPolicy(DropPartial), WithContinueMode()},
+		{WithContinueMode(), WithEOFPolicy(DropPartial)},
+	} {
+		sink, got := newSinkCatcher("think")
+		if err := NewEngineWithOptions(reg, opts...).ProcessStream(ReaderFromString(`</x><think>a</think><think>b`), sink); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		if len(*got) != 1 || (*got)[0].Content != "a" {
+			t.Fatalf("unexpected events: %+v", *got)
+		}
+	}
+}
+
+func Test_EngineOptions_Should_Replace_Defaults_Only_When_First(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	strict := func(opts ...Option) bool {
+		err := NewEngineWithOptions(reg, opts...).ProcessStream(ReaderFromString(`</x>`), NewHandlerSink())
+		return err != nil
+	}
+	if !strict(EngineOptions{}) || !strict(EngineOptions{RecoveryMode: StrictMode}, WithEOFPolicy(DropPartial)) {
+		t.Fatal("an EngineOptions passed first must be the whole configuration")
+	}
+	// Later, its zero fields change nothing; WithRecoveryMode sets the mode whatever it is.
+	if strict(WithContinueMode(), EngineOptions{RecoveryMode: StrictMode}) {
+		t.Fatal("a later EngineOptions must only set its non-zero fields")
+	}
+	if !strict(WithContinueMode(), WithRecoveryMode(StrictMode)) {
+		t.Fatal("WithRecoveryMode must turn StrictMode back on")
+	}
+
+	// Limits alike: a later zero does not lift one, a leading EngineOptions or a helper does.
+	input := "<think>a</think><think>b</think>"
+	count := func(opts ...Option) int {
+		sink, got := newSinkCatcher("think")
+		_ = NewEngineWithOptions(reg, opts...).ProcessStream(ReaderFromString(input), sink)
+		return len(*got)
+	}
+	if n := count(WithMaxEvents(1), EngineOptions{MaxEvents: 0}); n != 1 {
+		t.Fatalf("a later EngineOptions lifted the event cap: %d events", n)
+	}
+	if n := count(EngineOptions{}, WithMaxEvents(1), WithMaxEvents(0)); n != 2 {
+		t.Fatalf("WithMaxEvents(0) must lift the event cap: %d events", n)
+	}
+}
+
+// blockingReader returns head, then blocks until release is closed, then ends.
+type blockingReader struct {
+	head    string
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if r.head != "" {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	<-r.release
+	return 0, io.EOF
+}
+
+func Test_Engine_SectionTimeout_Should_Fire_While_The_Reader_Blocks(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	cut := make(chan SectionEvent, 1)
+	sink := NewHandlerSink()
+	sink.RegisterHandler("think", func(ev SectionEvent) { cut <- ev })
+
+	r := &blockingReader{head: "<think>loop", release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- NewEngineWithOptions(reg, WithSectionTimeout(20*time.Millisecond)).ProcessStream(r, sink)
+	}()
+
+	select {
+	case ev := <-cut:
+		if ev.Content != "loop" {
+			t.Fatalf("unexpected partial section %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the section was not cut off while the reader blocked")
+	}
+	close(r.release)
+	if err := <-done; err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	// Under ErrorPartial in strict mode, the timeout ends the stream without waiting for the
+	// reader, even one that never returns.
+	hung := &hangingReader{head: "<think>loop"}
+	go func() {
+		done <- NewEngineWithOptions(reg, WithSectionTimeout(20*time.Millisecond), WithEOFPolicy(ErrorPartial)).ProcessStream(hung, NewHandlerSink())
+	}()
+	select {
+	case err := <-done:
+		var te *SectionTimeoutError
+		if !errors.As(err, &te) || te.BytesReceived != len("loop") {
+			t.Fatalf("expected a SectionTimeoutError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream waited for a reader that never returns")
+	}
+}
+
+// hangingReader returns head, then blocks forever.
+type hangingReader struct{ head string }
+
+func (r *hangingReader) Read(p []byte) (int, error) {
+	if r.head != "" {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	select {}
+}
+
+func Test_Engine_SectionTimeout_Should_Drop_The_Abandoned_Read(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	var raw lockedBuffer
+	done := make(chan error, 1)
+	go func() {
+		en := NewEngineWithOptions(reg, WithSectionTimeout(50*time.Millisecond), WithEOFPolicy(ErrorPartial), WithRawCapture(&raw))
+		done <- en.ProcessStream(pr, NewHandlerSink())
+	}()
+
+	if _, err := pw.Write([]byte("<think>12")); err != nil {
+		t.Fatal(err)
+	}
+	// The section times out while the next read blocks; the stream returns at once.
+	var te *SectionTimeoutError
+	select {
+	case err := <-done:
+		if !errors.As(err, &te) {
+			t.Fatalf("expected a SectionTimeoutError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream waited for its blocked read")
+	}
+	captured := raw.Len()
+
+	// The abandoned read takes one write, which is not captured; nothing reads the next.
+	if _, err := pw.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	second := make(chan struct{})
+	go func() {
+		_, _ = pw.Write([]byte("0123456789"))
+		close(second)
+	}()
+	select {
+	case <-second:
+		t.Fatal("the reader was read again after the stream returned")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if raw.Len() != captured {
+		t.Fatalf("captured %d bytes after returning", raw.Len()-captured)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to inspect while the engine writes to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func Test_Engine_MaxStreamBytes_Should_Abort_After_Cap(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	sink, got := newSinkCatcher("think")
+
+	input := "<think>a</think>\n<think>b</think>"
+	en := NewEngineWithOptions(reg, WithMaxStreamBytes(20), WithContinueMode())
+	err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 7}, sink)
+	limitErr, ok := err.(*StreamLimitError)
+	if !ok {
+		t.Fatalf("expected StreamLimitError, got %T: %v", err, err)
+	}
+	if limitErr.Limit != "bytes" || limitErr.Max != 20 {
+		t.Fatalf("unexpected limit error: %+v", limitErr)
+	}
+	if limitErr.Pos.Line != 2 {
+		t.Fatalf("expected position on line 2, got %s", limitErr.Pos)
+	}
+	if ctx := limitErr.Render(); ctx == "" || !strings.HasSuffix(limitErr.Error(), "\nContext: "+ctx) {
+		t.Fatalf("Error() must render the snippets, got %q", limitErr.Error())
+	}
+	// Events that completed before the cap are kept.
+	if len(*got) != 1 || (*got)[0].Content != "a" {
+		t.Fatalf("unexpected events: %+v", *got)
+	}
+
+	// Exactly at the cap is fine.
+	en = NewEngineWithOptions(reg, WithMaxStreamBytes(int64(len(input))))
+	if err := en.ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("stream at the cap should succeed: %v", err)
+	}
+}
+
+func Test_Engine_MaxEvents_Should_Abort_Before_Extra_Emit(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	sink, got := newSinkCatcher("think")
+
+	en := NewEngineWithOptions(reg, WithMaxEvents(2), WithContinueMode())
+	err := en.ProcessStream(ReaderFromString("<think>1</think><think>2</think><think>3</think>"), sink)
+	limitErr, ok := err.(*StreamLimitError)
+	if !ok {
+		t.Fatalf("expected StreamLimitError, got %T: %v", err, err)
+	}
+	if limitErr.Limit != "events" || limitErr.Max != 2 {
+		t.Fatalf("unexpected limit error: %+v", limitErr)
+	}
+	if len(*got) != 2 {
+		t.Fatalf("want 2 events before the cap, got %d", len(*got))
+	}
+}
@@ -0,0 +1,134 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Pairing correlates two sections that carry the same attribute value, such as
+// <edit id="3"> and a later <result id="3"/>.
+type Pairing struct {
+	Open  string // section that starts the pair (name or alias)
+	Close string // section that completes it (name or alias)
+	Attr  string // attribute holding the id
+}
+
+// PairedEvent is emitted right after the section that completes a pair, in addition to
+// the two SectionEvents. It spans from the earlier section's start to the later one's end.
+//
+// Pairs are matched whichever section comes first: a Close that arrives before its Open
+// waits for it. If a second section of the same kind arrives while one with the same id is
+// still waiting, it replaces the waiting one, which is reported as unpaired. Sections
+// without the attribute are not paired.
+type PairedEvent struct {
+	EventBase
+	Open  SectionEvent `json:"open"`
+	Close SectionEvent `json:"close"`
+}
+
+// Kind implements Event.
+func (PairedEvent) Kind() EventKind { return KindPaired }
+
+func (ev PairedEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev PairedEvent) MarshalJSON() ([]byte, error) {
+	type plain PairedEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// AsPaired returns ev as a PairedEvent, if it is one.
+func AsPaired(ev Event) (PairedEvent, bool) {
+	pev, ok := ev.(PairedEvent)
+	return pev, ok
+}
+
+// UnpairedHandler receives, once the stream has ended, the sections of every Pairing that
+// never found their counterpart, in emission order.
+type UnpairedHandler func(unpaired []SectionEvent)
+
+// pairKey identifies a waiting section: which pairing, which side, which id.
+type pairKey struct {
+	pairing int
+	close   bool
+	id      string
+}
+
+// pairer tracks the sections waiting for their counterpart.
+type pairer struct {
+	pairings []Pairing // names canonicalized, attrs lowercased
+	waiting  map[pairKey]SectionEvent
+	replaced []SectionEvent // waiting sections displaced by a duplicate id
+}
+
+func newPairer(reg *Registry, pairings []Pairing) *pairer {
+	if len(pairings) == 0 {
+		return nil
+	}
+	pr := &pairer{waiting: map[pairKey]SectionEvent{}}
+	for _, pg := range pairings {
+		pg.Open, pg.Close = canonicalOrLower(reg, pg.Open), canonicalOrLower(reg, pg.Close)
+		pg.Attr = strings.ToLower(pg.Attr)
+		pr.pairings = append(pr.pairings, pg)
+	}
+	return pr
+}
+
+// pair records ev, which has been delivered, and emits a PairedEvent for every pair it completes.
+func (p *parser) pair(ev SectionEvent) error {
+	pr := p.pairer
+	for i, pg := range pr.pairings {
+		id, ok := ev.Attr(pg.Attr)
+		if !ok {
+			continue
+		}
+		for _, isClose := range []bool{false, true} {
+			name := pg.Open
+			if isClose {
+				name = pg.Close
+			}
+			if ev.Name != name {
+				continue
+			}
+			other := pairKey{pairing: i, close: !isClose, id: id}
+			if match, ok := pr.waiting[other]; ok {
+				delete(pr.waiting, other)
+				paired := PairedEvent{Open: match, Close: ev}
+				if !isClose {
+					paired.Open, paired.Close = ev, match
+				}
+				paired.StartPos, paired.EndPos = match.StartPos, ev.EndPos
+				if err := p.emit(paired); err != nil {
+					return err
+				}
+				continue
+			}
+			own := pairKey{pairing: i, close: isClose, id: id}
+			if prev, ok := pr.waiting[own]; ok {
+				pr.replaced = append(pr.replaced, prev)
+			}
+			pr.waiting[own] = ev
+		}
+	}
+	return nil
+}
+
+// reportUnpaired hands the sections still waiting at the end of the stream to the UnpairedHandler.
+func (p *parser) reportUnpaired() {
+	if p.pairer == nil || p.onUnpaired == nil {
+		return
+	}
+	unpaired := p.pairer.replaced
+	for _, ev := range p.pairer.waiting {
+		unpaired = append(unpaired, ev)
+	}
+	if len(unpaired) == 0 {
+		return
+	}
+	sort.Slice(unpaired, func(i, j int) bool { return unpaired[i].Seq < unpaired[j].Seq })
+	p.onUnpaired(unpaired)
+}
@@ -0,0 +1,58 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_Engine_Should_Pair_Sections_By_Id(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "edit"})
+	reg.Register(SectionPlugin{Name: "result"})
+	input := `<edit id="1" path="a.go">a</edit><result id="2" status="ok"/>` +
+		`<edit id="2" path="b.go">b</edit><edit id="3">old</edit><edit id="3">new</edit>` +
+		`<result id="1" status="ok"/><edit>no id</edit><edit id="4">lost</edit>`
+
+	var unpaired []SectionEvent
+	en := NewEngineWithOptions(reg,
+		WithPairing("edit", "result", "id"),
+		WithUnpairedHandler(func(evs []SectionEvent) { unpaired = evs }),
+	)
+	rec := &recorderSink{}
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 5}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	var pairs []PairedEvent
+	for _, ev := range rec.events {
+		if pev, ok := AsPaired(ev); ok {
+			pairs = append(pairs, pev)
+		}
+	}
+	if len(rec.events) != 10 || len(pairs) != 2 {
+		t.Fatalf("expected 8 sections and 2 pairs, got %+v", rec.events)
+	}
+
+	// The result for id 2 came first and waited for its edit.
+	if p := pairs[0]; p.Open.Attrs["path"] != "b.go" || p.Close.Attrs["id"] != "2" || p.Seq != 4 ||
+		input[p.StartPos.Offset:p.EndPos.Offset] != `<result id="2" status="ok"/><edit id="2" path="b.go">b</edit>` {
+		t.Fatalf("unexpected out-of-order pair %+v", p)
+	}
+	if p := pairs[1]; p.Open.Attrs["path"] != "a.go" || p.Close.Attrs["status"] != "ok" || p.Open.Seq != 1 {
+		t.Fatalf("unexpected pair %+v", p)
+	}
+
+	// The first edit with id 3 was replaced by the second; neither, nor id 4, found a result.
+	if len(unpaired) != 3 || unpaired[0].Content != "old" || unpaired[1].Content != "new" || unpaired[2].Content != "lost" {
+		t.Fatalf("unexpected unpaired sections %+v", unpaired)
+	}
+
+	b, err := json.Marshal(pairs[1])
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	back, err := UnmarshalEvent(b)
+	if pev, ok := back.(PairedEvent); err != nil || !ok || pev.Open.Attrs["path"] != "a.go" || pev.Close.Name != "result" {
+		t.Fatalf("round trip failed: %v %+v", err, back)
+	}
+}
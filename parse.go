@@ -0,0 +1,37 @@
+package promptweaver
+
+import (
+	"io"
+	"strings"
+)
+
+// Parse returns the sections of input, in stream order, recognizing the given section
+// names (no aliases) with the default engine settings. It is ParseReader for a string.
+//
+//	events, err := promptweaver.Parse(reply, []string{"think", "summary"})
+func Parse(input string, sections []string) ([]SectionEvent, error) {
+	return ParseReader(strings.NewReader(input), sections)
+}
+
+// ParseReader registers a plugin for each of sections in a new Registry, runs r through an
+// Engine built with opts, and returns the SectionEvents, in stream order. It is meant for
+// scripts and tests; anything it does not cover, such as aliases or handlers, takes an
+// Engine. When the stream fails part way, the sections before the failure are returned
+// with the error. An invalid section name fails with ErrReservedSection or
+// ErrRegistryConflict before anything is read.
+func ParseReader(r io.Reader, sections []string, opts ...Option) ([]SectionEvent, error) {
+	reg := NewRegistry()
+	for _, name := range sections {
+		if err := reg.RegisterE(SectionPlugin{Name: name}); err != nil {
+			return nil, err
+		}
+	}
+	var events []SectionEvent
+	sink := EventSinkFunc(func(ev Event) {
+		if sev, ok := AsSection(ev); ok {
+			events = append(events, sev)
+		}
+	})
+	err := NewEngineWithOptions(reg, opts...).ProcessStream(r, sink)
+	return events, err
+}
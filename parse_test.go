@@ -0,0 +1,40 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_Parse_Should_Return_Sections_In_Order(t *testing.T) {
+	events, err := Parse(`<think>plan</think> text <Summary n="1">done</Summary><other/>`, []string{"think", "summary"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(events) != 2 || events[0].Name != "think" || events[1].Name != "summary" ||
+		events[1].Content != "done" || events[1].Attrs["n"] != "1" || events[1].Seq != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func Test_ParseReader_Should_Behave_Like_The_Engine(t *testing.T) {
+	input := `<think>a</think></bogus><think>b`
+
+	// Strict by default, like NewEngine: the sections before the error come back with it.
+	events, err := ParseReader(strings.NewReader(input), []string{"think"})
+	var unmatched *UnmatchedTagError
+	if !errors.As(err, &unmatched) || len(events) != 1 || events[0].Content != "a" {
+		t.Fatalf("expected one event and UnmatchedTagError, got %+v, %v", events, err)
+	}
+
+	// Options apply as they would to NewEngineWithOptions.
+	events, err = ParseReader(strings.NewReader(input), []string{"think"},
+		WithErrorHandler(func(error) bool { return true }), WithEOFPolicy(DropPartial))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected the partial section dropped, got %+v, %v", events, err)
+	}
+
+	if _, err := Parse("x", []string{SectionPlainText}); !errors.Is(err, ErrReservedSection) {
+		t.Fatalf("expected ErrReservedSection, got %v", err)
+	}
+}
@@ -1,9 +1,14 @@
 package promptweaver
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // chunkedReader para simular streaming em testes.
@@ -82,6 +87,29 @@ end</think>`
 	}
 }
 
+func Test_Engine_Should_Report_Unknown_SelfClosing_Outside_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	rec := &recorderSink{}
+
+	var unknown []string
+	en := NewEngineWithOptions(reg, EngineOptions{UnknownTagHandler: func(name string, pos Position) {
+		unknown = append(unknown, fmt.Sprintf("%s@%d", name, pos.Offset))
+	}})
+	input := `<hr/>text <Img src="x" /><think>a<br/>b</think><bogus>c`
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 4}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	// Self-closing unknowns reach the handler like open ones, and emit nothing; inside a
+	// section they are content and the handler is not told.
+	if got := strings.Join(unknown, " "); got != "hr@0 Img@10 bogus@47" {
+		t.Fatalf("unexpected unknown tags %q", got)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Content != "a<br/>b" {
+		t.Fatalf("unexpected events %+v", rec.events)
+	}
+}
+
 func Test_Engine_Should_Ignore_Unmatched_Closing_Tag_Gracefully(t *testing.T) {
 	reg := NewRegistry()
 	reg.Register(SectionPlugin{Name: "think"})
@@ -232,3 +260,717 @@ func Test_Engine_AutoClose_OnEOF_With_Alias(t *testing.T) {
 		t.Fatalf("unexpected event: %+v", (*got)[0])
 	}
 }
+
+func Test_Engine_Empty_Spellings_Under_Plugin_Settings(t *testing.T) {
+	spellings := []string{"<summary></summary>", "<summary>\n</summary>", "<summary/>"}
+	cases := []struct {
+		name      string
+		plugin    SectionPlugin
+		want      []string // expected content per spelling
+		wantError []bool   // expected ValidationError per spelling
+	}{
+		{"default", SectionPlugin{Name: "summary"},
+			[]string{"", "\n", ""}, []bool{false, false, false}},
+		{"normalize", SectionPlugin{Name: "summary", NormalizeEmpty: true},
+			[]string{"", "", ""}, []bool{false, false, false}},
+		{"reject", SectionPlugin{Name: "summary", RejectEmpty: true},
+			[]string{"", "\n", ""}, []bool{true, false, true}},
+		{"normalize+reject", SectionPlugin{Name: "summary", NormalizeEmpty: true, RejectEmpty: true},
+			[]string{"", "", ""}, []bool{true, true, true}},
+	}
+	for _, tc := range cases {
+		for i, input := range spellings {
+			reg := NewRegistry()
+			reg.Register(tc.plugin)
+			sink, got := newSinkCatcher("summary")
+
+			err := NewEngine(reg).ProcessStream(ReaderFromString(input), sink)
+			if tc.wantError[i] {
+				if _, ok := err.(*ValidationError); !ok {
+					t.Fatalf("%s %q: expected ValidationError, got %v", tc.name, input, err)
+				}
+				if len(*got) != 0 {
+					t.Fatalf("%s %q: rejected section was emitted: %+v", tc.name, input, *got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s %q: unexpected error: %v", tc.name, input, err)
+			}
+			if len(*got) != 1 || (*got)[0].Content != tc.want[i] {
+				t.Fatalf("%s %q: want content %q, got %+v", tc.name, input, tc.want[i], *got)
+			}
+		}
+	}
+}
+
+func Test_Engine_RejectEmpty_Should_Skip_Section_In_ContinueMode(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary", NormalizeEmpty: true, RejectEmpty: true})
+	reg.Register(SectionPlugin{Name: "think"})
+	sink, got := newSinkCatcher("summary", "think")
+
+	en := NewEngineWithOptions(reg, WithContinueMode())
+	input := "<summary> </summary><think>t</think><summary>ok</summary>"
+	if err := en.ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 2 || (*got)[0].Name != "think" || (*got)[1].Content != "ok" {
+		t.Fatalf("unexpected events: %+v", *got)
+	}
+}
+
+func Test_HandlerSinkFor_Should_Resolve_Alias_Registrations(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file", "dyad-write"}})
+
+	var got []SectionEvent
+	sink := NewHandlerSinkFor(reg)
+	sink.RegisterHandler("Dyad-Write", func(ev SectionEvent) { got = append(got, ev) })
+
+	en := NewEngine(reg)
+	input := `<create-file path="a">x</create-file>`
+	if err := en.ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "write-file" {
+		t.Fatalf("alias-registered handler did not fire: %+v", got)
+	}
+}
+
+func Test_HandlerSink_RegisterHandlerStrict_Should_Reject_Unknown_Names(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary", Aliases: []string{"tldr"}})
+	sink := NewHandlerSinkFor(reg)
+
+	if err := sink.RegisterHandlerStrict("tldr", func(SectionEvent) {}); err != nil {
+		t.Fatalf("alias registration should succeed: %v", err)
+	}
+	err := sink.RegisterHandlerStrict("sumary", func(SectionEvent) {})
+	if !errors.Is(err, ErrUnknownSection) {
+		t.Fatalf("expected ErrUnknownSection, got %v", err)
+	}
+
+	// Without a registry there is nothing to check against.
+	if err := NewHandlerSink().RegisterHandlerStrict("anything", func(SectionEvent) {}); err != nil {
+		t.Fatalf("registry-less sink should accept any name: %v", err)
+	}
+}
+
+// recorderSink is the shape user-defined sinks take: it records every event it sees.
+type recorderSink struct{ events []Event }
+
+func (r *recorderSink) OnEvent(ev Event) { r.events = append(r.events, ev) }
+
+func Test_Engine_Should_Reject_Nil_Sink_And_Reader(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+
+	if err := en.ProcessStream(ReaderFromString(`<summary>x</summary>`), nil); !errors.Is(err, ErrNilSink) {
+		t.Fatalf("expected ErrNilSink, got %v", err)
+	}
+	var typedNil *HandlerSink
+	if err := en.ProcessStream(ReaderFromString(`<summary>x</summary>`), typedNil); !errors.Is(err, ErrNilSink) {
+		t.Fatalf("expected ErrNilSink for typed nil, got %v", err)
+	}
+	if err := en.ProcessStream(nil, NewHandlerSink()); !errors.Is(err, ErrNilReader) {
+		t.Fatalf("expected ErrNilReader, got %v", err)
+	}
+}
+
+func Test_Engine_ZeroValue_HandlerSink_Should_Not_Panic(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+
+	sink := &HandlerSink{}
+	if err := en.ProcessStream(ReaderFromString(`<summary>x</summary>`), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	var got []SectionEvent
+	sink.RegisterHandler("summary", func(ev SectionEvent) { got = append(got, ev) })
+	if err := en.ProcessStream(ReaderFromString(`<summary>y</summary>`), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(got) != 1 || got[0].Content != "y" {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+}
+
+func Test_Engine_Should_Accept_Custom_Sink(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngine(reg)
+
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString(`<summary>x</summary>`), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("want 1 event, got %d", len(rec.events))
+	}
+	if ev, ok := rec.events[0].(SectionEvent); !ok || ev.Content != "x" {
+		t.Fatalf("unexpected event: %#v", rec.events[0])
+	}
+
+	var funcGot []Event
+	fs := EventSinkFunc(func(ev Event) { funcGot = append(funcGot, ev) })
+	if err := en.ProcessStream(ReaderFromString(`<summary>y</summary>`), fs); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(funcGot) != 1 {
+		t.Fatalf("EventSinkFunc want 1 event, got %d", len(funcGot))
+	}
+}
+
+func Test_Engine_Event_Positions_Should_Slice_Raw_Input(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	sink, got := newSinkCatcher("think", "summary")
+
+	input := "intro\n<think a=\"1\">héllo\nworld</think> <summary/>\n<think>tail"
+	en := NewEngine(reg)
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 3 {
+		t.Fatalf("want 3 events, got %d", len(*got))
+	}
+	want := []string{"<think a=\"1\">héllo\nworld</think>", "<summary/>", "<think>tail"}
+	for i, ev := range *got {
+		raw := input[ev.StartPos.Offset:ev.EndPos.Offset]
+		if raw != want[i] {
+			t.Fatalf("event %d: raw slice %q, want %q", i, raw, want[i])
+		}
+	}
+	first := (*got)[0]
+	if first.StartPos != (Position{Line: 2, Column: 1, Offset: 6}) {
+		t.Fatalf("unexpected start: %s", first.StartPos)
+	}
+	if first.EndPos.Line != 3 || first.EndPos.Column != 14 {
+		t.Fatalf("unexpected end: %s", first.EndPos)
+	}
+}
+
+func Test_Engine_Error_Positions_Should_Carry_Offset(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+
+	en := NewEngine(reg)
+	err := en.ProcessStream(ReaderFromString("<think>ok</think>\n</bogus>"), NewHandlerSink())
+	unmatched, ok := err.(*UnmatchedTagError)
+	if !ok {
+		t.Fatalf("expected UnmatchedTagError, got %T: %v", err, err)
+	}
+	if unmatched.Pos != (Position{Line: 2, Column: 1, Offset: 18}) {
+		t.Fatalf("unexpected position: %s", unmatched.Pos)
+	}
+	if !strings.Contains(unmatched.Error(), "line 2, column 1 (offset 18)") {
+		t.Fatalf("error string missing offset: %v", unmatched)
+	}
+}
+
+func Test_Engine_Should_Close_Section_When_Closer_Arrives_Byte_By_Byte(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	sink, got := newSinkCatcher("summary")
+	input := "<summary>a < b</summary>"
+	if err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: 1}, sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 1 || (*got)[0].Content != "a < b" {
+		t.Fatalf("unexpected events: %+v", *got)
+	}
+}
+
+func Test_Engine_Should_Close_Section_When_A_Read_Ends_On_The_Closers_Lt(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "<summary>a < b</summary>"
+	lt := strings.LastIndexByte(input, '<')
+	for _, opts := range [][]Option{nil, {WithCodeBlocks()}} {
+		// Every chunk size whose reads end right after the closer's '<', so that the
+		// parser has a lone '<' at the end of its input.
+		for chunk := 1; chunk <= lt+1; chunk++ {
+			if (lt+1)%chunk != 0 {
+				continue
+			}
+			sink, got := newSinkCatcher("summary")
+			if err := NewEngineWithOptions(reg, opts...).ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, sink); err != nil {
+				t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+			}
+			if len(*got) != 1 || (*got)[0].Content != "a < b" {
+				t.Fatalf("chunk %d: unexpected events: %+v", chunk, *got)
+			}
+		}
+	}
+}
+
+var tagCorpus = []string{
+	`<a>`, `<a/>`, `</a>`, `</ a >`, `</>`, `<a b="1" c='2' d={x}>`, `<a  b = "q\"uo\\" />`,
+	`<a b={ {"k": "}"} }>`, `<a b={'\''}/>`, `<>`, `< a>`, `<a/x>`, `</a x>`, `<a b>`, `<a b=c>`, `<a =1>`,
+	`<a b="unterminated`, `<a b={"`, `<a b=`,
+}
+
+// Test_TagScanner_Should_Match_Whole_Input_Parsing feeds every tag one byte at a time and checks
+// that resuming yields exactly what parsing the whole input at once does.
+func Test_TagScanner_Should_Match_Whole_Input_Parsing(t *testing.T) {
+	pos := Position{Line: 3, Column: 7, Offset: 40}
+	for _, tag := range tagCorpus {
+		data := []byte(tag + " tail")
+		wantN, wantTok, wantOK, wantErr := parseTagToken(data, pos, "ctx")
+
+		var s tagScanner
+		var n int
+		var tok tagToken
+		var ok bool
+		var err error
+		for k := 1; k <= len(data); k++ {
+			n, tok, ok, err = s.scan(data[:k], pos, "ctx")
+			if ok || err != nil {
+				break
+			}
+		}
+		if n != wantN || ok != wantOK || !reflect.DeepEqual(tok, wantTok) || fmt.Sprint(err) != fmt.Sprint(wantErr) {
+			t.Errorf("%q: incremental (%d, %+v, %v, %v) != whole (%d, %+v, %v, %v)",
+				tag, n, tok, ok, err, wantN, wantTok, wantOK, wantErr)
+		}
+	}
+}
+
+func FuzzParseTagToken_Should_Stay_In_Bounds_And_Resume(f *testing.F) {
+	for _, tag := range tagCorpus {
+		f.Add([]byte(tag + " tail"))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 || data[0] != '<' {
+			return
+		}
+		pos := Position{Line: 1, Column: 1}
+		n, tok, ok, err := parseTagToken(data, pos, "")
+		if n < 0 || n > len(data) {
+			t.Fatalf("consumed %d of %d bytes", n, len(data))
+		}
+		if ok && (n < 2 || data[n-1] != '>') {
+			t.Fatalf("token %+v ends at %d, not after a '>'", tok, n)
+		}
+		if err != nil && n == 0 {
+			t.Fatalf("error %v consumed nothing", err)
+		}
+
+		var s tagScanner
+		var sn int
+		var stok tagToken
+		var sok bool
+		var serr error
+		for k := 1; k <= len(data); k++ {
+			sn, stok, sok, serr = s.scan(data[:k], pos, "")
+			if sok || serr != nil {
+				break
+			}
+		}
+		if sn != n || sok != ok || !reflect.DeepEqual(stok, tok) || fmt.Sprint(serr) != fmt.Sprint(err) {
+			t.Fatalf("incremental (%d, %+v, %v, %v) != whole (%d, %+v, %v, %v)", sn, stok, sok, serr, n, tok, ok, err)
+		}
+	})
+}
+
+func Test_Engine_Should_Be_Chunk_Invariant(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "a"})
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	input := "intro <create-file path=\"x.go\" meta={ {\"k\": \"}\"} }>package x</write-file> <a/> < b> </a>" +
+		"<a q='1' r>\n<a z=\"2\">body <b>kept</b></A>"
+
+	run := func(chunk int) string {
+		var log []string
+		en := NewEngineWithOptions(reg, WithErrorHandler(func(err error) bool {
+			log = append(log, "error: "+err.Error())
+			return true
+		}))
+		sink := EventSinkFunc(func(ev Event) { log = append(log, fmt.Sprintf("%+v", ev)) })
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, sink); err != nil {
+			log = append(log, "fatal: "+err.Error())
+		}
+		return strings.Join(log, "\n")
+	}
+	want := run(len(input))
+	for chunk := 1; chunk < len(input); chunk++ {
+		if got := run(chunk); got != want {
+			t.Fatalf("chunk size %d differs:\ngot\n%s\nwant\n%s", chunk, got, want)
+		}
+	}
+}
+
+func Benchmark_Engine_Large_Attribute_One_Byte_Chunks(b *testing.B) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	en := NewEngine(reg)
+	input := []byte(`<create-file data="` + strings.Repeat("x", 64<<10) + `">ok</create-file>`)
+	sink := EventSinkFunc(func(Event) {})
+
+	b.SetBytes(int64(len(input)))
+	for b.Loop() {
+		if err := en.ProcessStream(&chunkedReader{data: input, chunk: 1}, sink); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_Engine_Should_Include_Raw_Envelope_When_Asked(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}, IncludeRawEnvelope: true})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := `x <create-file path='a'>A <b>c</b></ write-file > <summary>s</summary> <create-file/> <create-file>tail`
+
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: 1}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	want := []string{`<create-file path='a'>A <b>c</b></ write-file >`, "", `<create-file/>`, `<create-file>tail`}
+	if len(rec.events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), rec.events)
+	}
+	for i, ev := range rec.events {
+		sev := ev.(SectionEvent)
+		if sev.Raw != want[i] {
+			t.Fatalf("event %d: Raw %q, want %q", i, sev.Raw, want[i])
+		}
+		if sev.Raw != "" && input[sev.StartPos.Offset:sev.EndPos.Offset] != sev.Raw {
+			t.Fatalf("event %d: Raw does not match the event span", i)
+		}
+	}
+	if rec.events[0].(SectionEvent).Content != "A <b>c</b>" {
+		t.Fatalf("Content must keep its meaning, got %q", rec.events[0].(SectionEvent).Content)
+	}
+
+	rec = &recorderSink{}
+	if err := NewEngineWithOptions(reg, WithRawEnvelope()).ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if raw := rec.events[1].(SectionEvent).Raw; raw != "<summary>s</summary>" {
+		t.Fatalf("global option should cover every section, got %q", raw)
+	}
+}
+
+func Test_Engine_Should_Pass_Context_To_Handlers_And_Stop_When_Cancelled(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "step"})
+	reg.Register(SectionPlugin{Name: "note"})
+
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "trace-1"))
+	defer cancel()
+
+	var steps, notes []string
+	sink := NewHandlerSink()
+	sink.RegisterHandlerCtx("step", func(ctx context.Context, ev SectionEvent) error {
+		steps = append(steps, ev.Content+":"+ctx.Value(key{}).(string))
+		if ev.Content == "2" {
+			cancel()
+		}
+		return nil
+	})
+	sink.RegisterHandler("note", func(ev SectionEvent) { notes = append(notes, ev.Content) })
+
+	input := "<note>a</note><step>1</step><step>2</step><note>b</note><step>3</step>"
+	err := NewEngine(reg).ProcessStreamContext(ctx, ReaderFromString(input), sink)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !reflect.DeepEqual(steps, []string{"1:trace-1", "2:trace-1"}) || !reflect.DeepEqual(notes, []string{"a"}) {
+		t.Fatalf("no handler may run after cancellation, got steps %v and notes %v", steps, notes)
+	}
+}
+
+func Test_Engine_Should_Route_Handler_Errors_Through_Error_Handling(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "step"})
+	failed := errors.New("step failed")
+	sink := NewHandlerSink()
+	sink.RegisterHandlerCtx("step", func(_ context.Context, ev SectionEvent) error {
+		if ev.Content == "bad" {
+			return failed
+		}
+		return nil
+	})
+
+	input := "<step>bad</step><step>ok</step>"
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(input), sink); !errors.Is(err, failed) {
+		t.Fatalf("expected the handler error in strict mode, got %v", err)
+	}
+	if err := NewEngineWithOptions(reg, WithContinueMode()).ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("expected recovery in continue mode, got %v", err)
+	}
+}
+
+func Test_HandlerSink_Should_Deliver_First_And_Last_Once_Per_Stream(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "plan"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	reg.Register(SectionPlugin{Name: "step"})
+
+	var got []string
+	sink := NewHandlerSink()
+	sink.RegisterFirstHandler("plan", func(ev SectionEvent) { got = append(got, "plan:"+ev.Content) })
+	sink.RegisterLastHandler("summary", func(ev SectionEvent) { got = append(got, "summary:"+ev.Content) })
+	sink.RegisterHandler("step", func(ev SectionEvent) { got = append(got, "step:"+ev.Content) })
+
+	en := NewEngine(reg)
+	input := "<plan>a</plan><summary>s1</summary><plan>b</plan><step>1</step><summary>s2</summary><step>2</step>"
+	for range 2 { // per-stream state must reset between streams
+		got = nil
+		if err := en.ProcessStream(ReaderFromString(input), sink); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		if want := []string{"plan:a", "step:1", "step:2", "summary:s2"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	got = nil
+	if err := en.ProcessStream(ReaderFromString("<summary>s1</summary></bogus>"), sink); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(got) != 0 {
+		t.Fatalf("a failed stream must not deliver last-only events, got %v", got)
+	}
+}
+
+func Test_HandlerSink_Should_Give_Last_Handlers_The_Stream_Context_And_Report_Their_Errors(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "stream")
+
+	failed := errors.New("store failed")
+	var seen any
+	var ended error
+	sink := NewHandlerSink()
+	sink.RegisterLastHandlerCtx("summary", func(ctx context.Context, ev SectionEvent) error {
+		seen = ctx.Value(key{})
+		return failed
+	})
+	sink.RegisterStreamEndHandler(func(err error) { ended = err })
+
+	input := "<summary>a</summary><summary>b</summary>"
+	if err := NewEngine(reg).ProcessStreamContext(ctx, ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStreamContext error: %v", err)
+	}
+	if seen != "stream" {
+		t.Fatalf("the last handler did not get the stream's context, got %v", seen)
+	}
+	if !errors.Is(ended, failed) {
+		t.Fatalf("expected the last handler's error at the stream end, got %v", ended)
+	}
+}
+
+func Test_Engine_Should_Report_Stray_Closers_In_Strict_Bodies(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "plan", StrictBody: true})
+	reg.Register(SectionPlugin{Name: "summary"})
+	reg.Register(SectionPlugin{Name: "create-file"})
+
+	input := "<plan>step one</summary><summary>done</summary>"
+
+	err := NewEngine(reg).ProcessStream(ReaderFromString(input), NewHandlerSink())
+	var stray *UnexpectedClosingTagError
+	if !errors.As(err, &stray) || stray.TagName != "summary" || stray.SectionName != "plan" || stray.Pos.Offset != 14 {
+		t.Fatalf("expected UnexpectedClosingTagError at offset 14, got %v", err)
+	}
+
+	var seen []error
+	en := NewEngineWithOptions(reg, WithErrorHandler(func(err error) bool {
+		seen = append(seen, err)
+		return true
+	}))
+	for _, chunk := range []int{1, 4, len(input)} {
+		seen = nil
+		rec := &recorderSink{}
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 2 {
+			t.Fatalf("chunk %d: expected plan and summary, got %+v", chunk, rec.events)
+		}
+		plan := rec.events[0].(SectionEvent)
+		if plan.Content != "step one" || input[plan.StartPos.Offset:plan.EndPos.Offset] != "<plan>step one" {
+			t.Fatalf("chunk %d: plan must end at the stray closer, got %+v", chunk, plan)
+		}
+		if sum := rec.events[1].(SectionEvent); sum.Content != "done" {
+			t.Fatalf("chunk %d: unexpected summary %+v", chunk, sum)
+		}
+		// The closer is reprocessed outside any section, where it matches nothing.
+		var unmatched *UnmatchedTagError
+		if len(seen) != 2 || !errors.As(seen[0], &stray) || !errors.As(seen[1], &unmatched) {
+			t.Fatalf("chunk %d: unexpected errors %v", chunk, seen)
+		}
+	}
+
+	rec := &recorderSink{}
+	code := "<create-file>fmt.Println(\"</summary>\")</create-file>"
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(code), rec); err != nil || rec.events[0].(SectionEvent).Content != "fmt.Println(\"</summary>\")" {
+		t.Fatalf("non-strict bodies must keep registered closers as text, got %v, %+v", err, rec.events)
+	}
+}
+
+// fuzzEngine builds an engine from the bits of flags, so that the fuzzer explores plugin
+// options, recovery modes and EOF policies along with the input.
+func fuzzEngine(flags uint32) *Engine {
+	bit := func(i uint) bool { return flags&(1<<i) != 0 }
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{
+		Name:              "create-file",
+		Aliases:           []string{"write"},
+		ParseFencesInBody: bit(0),
+		StrictBody:        bit(1),
+		RawDelimiter:      bit(2),
+		TruncateAt:        map[bool]int{true: 5}[bit(3)],
+		TruncationMarker:  "…",
+		KnownAttrs:        []string{"path"},
+		UnknownAttrs:      UnknownAttrPolicy(flags >> 20 & 3),
+	})
+	reg.Register(SectionPlugin{Name: "think", NormalizeEmpty: bit(4), RejectEmpty: bit(5), Suppress: bit(6), EmitIntent: bit(7)})
+	reg.Register(SectionPlugin{Name: "shell", RawUntil: "eof", Command: bit(8)})
+	reg.Register(SectionPlugin{Name: "a"})
+
+	opts := []Option{WithEOFPolicy(EOFPolicy(flags >> 22 % 3)), WithMaxEmptyReads(-1)}
+	if bit(9) {
+		opts = append(opts, WithErrorHandler(func(error) bool { return true }))
+	}
+	if bit(10) {
+		opts = append(opts, WithCodeBlocks(), WithFenceSectionMapping("create-file", "path"))
+	}
+	if bit(11) {
+		opts = append(opts, WithLenientFences())
+	}
+	if bit(12) {
+		opts = append(opts, WithOrphanRescue(true))
+	}
+	if bit(13) {
+		opts = append(opts, WithWellFormed(true), WithAncestry(true))
+	}
+	if bit(14) {
+		opts = append(opts, WithInlineCodeAwareness(true))
+	}
+	if bit(15) {
+		opts = append(opts, WithEscapePrefix('\\'))
+	}
+	if bit(16) {
+		opts = append(opts, WithBalancedSameName(true))
+	}
+	if bit(17) {
+		opts = append(opts, WithPlainText(PlainTextOptions{CoalesceAdjacent: true}), WithSectionClosedEvents(true))
+	}
+	if bit(18) {
+		opts = append(opts, WithContextSection("a", "root"))
+	}
+	if bit(19) {
+		opts = append(opts, WithVariables(map[string]string{"x": "y"}), WithRawEnvelope())
+	}
+	return NewEngineWithOptions(reg, opts...)
+}
+
+// Test_Engine_Should_Finish_Every_Truncated_Input cuts hostile input at every byte, the
+// way a stream dropped mid-tag ends, and checks that each prefix parses to the end under
+// every recovery mode and EOF policy, with and without the features that buffer at EOF.
+func Test_Engine_Recovery_Should_Locate_Route_And_Keep_Malformed_Closers(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+
+	// An unmatched closer is reported at its '<', not just past its '>'.
+	err := NewEngine(reg).ProcessStream(ReaderFromString("hello </x>"), NewHandlerSink())
+	var unmatched *UnmatchedTagError
+	if !errors.As(err, &unmatched) || unmatched.Pos != (Position{Line: 1, Column: 7, Offset: 6}) {
+		t.Fatalf("expected an UnmatchedTagError at column 7, got %v", err)
+	}
+
+	// Unmatched closers go to the ErrorHandler like any other error.
+	var seen []error
+	handler := WithErrorHandler(func(err error) bool { seen = append(seen, err); return true })
+	if err := NewEngineWithOptions(reg, handler).ProcessStream(ReaderFromString("hello </x>"), NewHandlerSink()); err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+	if len(seen) != 1 || !errors.As(seen[0], &unmatched) {
+		t.Fatalf("expected the handler to see the unmatched closer, got %v", seen)
+	}
+
+	// A malformed closer of the open section, once recovered from, is kept as content.
+	seen = nil
+	sink, got := newSinkCatcher("think")
+	if err := NewEngineWithOptions(reg, handler).ProcessStream(ReaderFromString("<think>a</think x>b</think>"), sink); err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+	var malformed *MalformedTagError
+	if len(seen) != 1 || !errors.As(seen[0], &malformed) {
+		t.Fatalf("expected the handler to see the malformed closer, got %v", seen)
+	}
+	if len(*got) != 1 || (*got)[0].Content != "a</think x>b" {
+		t.Fatalf("expected the malformed closer kept as content, got %+v", *got)
+	}
+}
+
+func Test_Engine_Should_Finish_Every_Truncated_Input(t *testing.T) {
+	input := "<a root=\"r\"><create-file path=\"x\" meta={ {\"k\": \"}\"} } extra>\n```go file=y.go\n" +
+		"<think>x</think>\n```\n</create-file><shell eof=\"END\">ls</shell>\nEND `<think>` \\<think>" +
+		"<think><think>é</think></think></a></b><think"
+	for _, features := range []uint32{0, 1<<0 | 1<<2 | 1<<10 | 1<<12 | 1<<17, 1<<1 | 1<<3 | 1<<13 | 1<<14 | 1<<15 | 1<<16 | 3<<20} {
+		for _, recovery := range []uint32{0, 1 << 9} {
+			for policy := uint32(0); policy < 3; policy++ {
+				flags := features | recovery | policy<<22
+				for cut := 0; cut <= len(input); cut++ {
+					r := &chunkedReader{data: []byte(input[:cut]), chunk: 7}
+					_ = fuzzEngine(flags).ProcessStream(r, EventSinkFunc(func(Event) {}))
+				}
+			}
+		}
+	}
+}
+
+func FuzzProcessStream_Should_Terminate_Without_Panicking(f *testing.F) {
+	for _, seed := range []string{
+		"<think>a</think>\n<create-file path=\"x.go\">package x\n```go\ny\n```\n</create-file>",
+		"<write path='a' extra=1/><think></think><a root=\"r\"><shell eof=\"END\">ls </shell>\nEND</a>",
+		"<think>x</create-file></think></a><think",
+		"<create-file path=\"a\" b=\"",
+		"<create-file\n\tpath=\"é\x80\"",
+		"```go file=a.go\nx\n",
+		"`<think>` \\<think> <think><think>a</think></think>",
+		"<!-- c --><![CDATA[<think>]]><think>{{x}}",
+		"<",
+		"</",
+		"<a\x00b>",
+	} {
+		f.Add([]byte(seed), uint32(0), 3)
+		f.Add([]byte(seed), uint32(0xffffffff), 1)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, flags uint32, chunk int) {
+		if chunk <= 0 || chunk > len(data) {
+			chunk = len(data) + 1
+		}
+		var events, content int
+		sink := EventSinkFunc(func(ev Event) {
+			events++
+			if sev, ok := AsSection(ev); ok {
+				content += len(sev.Content)
+			}
+		})
+		done := make(chan error, 1)
+		go func() {
+			done <- fuzzEngine(flags).ProcessStream(&chunkedReader{data: data, chunk: chunk}, sink)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("ProcessStream did not terminate on %q with flags %#x", data, flags)
+		}
+		// Every event and every content byte comes from the input, give or take
+		// truncation markers, rescued sections emitted again and end-of-stream events.
+		if limit := 4*len(data) + 8; events > limit || content > 4*len(data)+len("…")*events {
+			t.Fatalf("%d events with %d content bytes from %d input bytes", events, content, len(data))
+		}
+	})
+}
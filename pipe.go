@@ -0,0 +1,161 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+)
+
+// ErrUnrenderable is reported by a PipeSink for an event whose rendered text would not
+// parse back to it, e.g. a section whose content holds its own closing tag. Section bodies
+// have no escapes, so such an event cannot be passed on faithfully.
+var ErrUnrenderable = errors.New("event does not render faithfully")
+
+// PipeSink writes the events of a stream back out as text, as they arrive, for another
+// engine to read: sections as SectionEvent.Render writes them, code blocks as fences, and
+// PlainText sections (see WithPlainText) as the text itself. Other events are skipped.
+// Paired with io.Pipe it runs two engines back to back with bounded memory:
+//
+//	pr, pw := io.Pipe()
+//	go func() { _ = engineA.ProcessStream(in, promptweaver.NewPipeSink(pw, rename)) }()
+//	err := engineB.ProcessStream(pr, sink)
+//
+// Each section is checked before it is written: one whose text would not parse back to it
+// is not written, and ErrUnrenderable goes through the engine's error handling.
+type PipeSink struct {
+	w         io.Writer
+	transform func(SectionEvent) (SectionEvent, bool)
+	midLine   bool // the last byte written was not a newline
+	fenceEnd  bool // the last thing written was a closing fence, whose line is not ended yet
+}
+
+// NewPipeSink returns a PipeSink writing to w. transform, if not nil, may change each
+// section before it is written, or drop it by returning false.
+func NewPipeSink(w io.Writer, transform func(SectionEvent) (SectionEvent, bool)) *PipeSink {
+	return &PipeSink{w: w, transform: transform}
+}
+
+// OnEvent implements EventSink. Errors are dropped; engines deliver through OnEventContext,
+// which reports them.
+func (s *PipeSink) OnEvent(ev Event) { _ = s.OnEventContext(context.Background(), ev) }
+
+// OnEventContext implements ContextSink.
+func (s *PipeSink) OnEventContext(_ context.Context, ev Event) error {
+	var text string
+	switch ev := ev.(type) {
+	case SectionEvent:
+		if s.transform != nil {
+			var ok bool
+			if ev, ok = s.transform(ev); !ok {
+				return nil
+			}
+		}
+		var err error
+		if text, err = renderFaithfully(ev); err != nil {
+			return err
+		}
+	case CodeBlockEvent:
+		// The newline after the closing fence is left to what follows, as in the input.
+		text = strings.TrimSuffix(renderCodeBlock(ev), "\n")
+		if s.midLine && !s.fenceEnd {
+			text = "\n" + text // a fence opens only at the start of a line
+		}
+	default:
+		return nil
+	}
+	if text == "" {
+		return nil
+	}
+	if s.fenceEnd && !strings.HasPrefix(text, "\n") && !strings.HasPrefix(text, "\r\n") {
+		text = "\n" + text
+	}
+	if _, err := io.WriteString(s.w, text); err != nil {
+		return err
+	}
+	_, s.fenceEnd = ev.(CodeBlockEvent)
+	s.midLine = !strings.HasSuffix(text, "\n")
+	return nil
+}
+
+// OnStreamEnd implements StreamEndSink. A writer with a CloseWithError method, such as an
+// *io.PipeWriter, is closed with the stream's error, so its reader sees EOF or the failure.
+func (s *PipeSink) OnStreamEnd(err error) {
+	if c, ok := s.w.(interface{ CloseWithError(error) error }); ok {
+		_ = c.CloseWithError(err)
+	}
+}
+
+// renderFaithfully renders ev and checks that the text tokenizes back to it.
+func renderFaithfully(ev SectionEvent) (string, error) {
+	if ev.Name == SectionPlainText {
+		return ev.Content, checkPlain(ev.Content)
+	}
+	text := ev.Render()
+	if why := mismatch(ev, text); why != "" {
+		return "", fmt.Errorf("%w: <%s> %s", ErrUnrenderable, ev.Name, why)
+	}
+	return text, nil
+}
+
+// checkPlain reports text that would not read back as plain text: tags, fences, or a tag
+// left open at its end.
+func checkPlain(text string) error {
+	t := newTokenizer(true, false)
+	t.feed([]byte(text))
+	for {
+		tok, ok, err := t.next(true)
+		if err != nil {
+			continue // the offending bytes are text
+		}
+		if !ok {
+			return nil
+		}
+		if tok.Kind != TokenText || tok.Incomplete {
+			return fmt.Errorf("%w: plain text holds %q", ErrUnrenderable, tok.Text)
+		}
+	}
+}
+
+// mismatch tokenizes text, the rendering of ev, the way a section's tags and body are read,
+// and says how the result differs from ev, or returns "".
+func mismatch(ev SectionEvent, text string) string {
+	t := newTokenizer(false, false)
+	t.tag.keepCase = true
+	t.feed([]byte(text))
+	next := func() (Token, bool) {
+		tok, ok, err := t.next(true)
+		return tok, ok && err == nil && !tok.Incomplete
+	}
+
+	open, ok := next()
+	if !ok || (open.Kind != TokenOpen && open.Kind != TokenSelfClose) || open.Name != ev.Name {
+		return "is not a valid tag name"
+	}
+	if !maps.Equal(open.Attrs, ev.Attrs) && len(open.Attrs)+len(ev.Attrs) > 0 {
+		return "has attributes that cannot be written"
+	}
+	if open.Kind == TokenOpen {
+		t.enterRaw(func(name string) bool { return strings.EqualFold(name, ev.Name) }, false)
+		var body strings.Builder
+		for {
+			tok, ok := next()
+			if !ok {
+				return "has content that cannot be written"
+			}
+			if tok.Kind == TokenClose {
+				break
+			}
+			body.WriteString(tok.Text)
+		}
+		if body.String() != ev.Content {
+			return "has content holding its own closing tag"
+		}
+	}
+	if _, more, _ := t.next(true); more {
+		return "has content holding its own closing tag"
+	}
+	return ""
+}
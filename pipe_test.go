@@ -0,0 +1,104 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_PipeSink_Should_Chain_Two_Engines(t *testing.T) {
+	regA := NewRegistry()
+	regA.Register(SectionPlugin{Name: "think"})
+	regA.Register(SectionPlugin{Name: "write-file"})
+	regB := NewRegistry()
+	regB.Register(SectionPlugin{Name: "create-file"})
+	regB.Register(SectionPlugin{Name: "think"})
+
+	toB := func(ev SectionEvent) (SectionEvent, bool) {
+		if ev.Name == "write-file" {
+			ev.Name = "create-file"
+		}
+		return ev, ev.Name != "think"
+	}
+	// The dropped <think> leaves the text around it in one run.
+	input := "Sure.\n<think>hm</think>\n<write-file path=\"a.go\">package a</write-file>\n```sh\nls\n```\nDone."
+	pr, pw := io.Pipe()
+	errA := make(chan error, 1)
+	go func() {
+		en := NewEngineWithOptions(regA, WithCodeBlocks(), WithPlainText(PlainTextOptions{}))
+		errA <- en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, NewPipeSink(pw, toB))
+	}()
+
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(regB, WithCodeBlocks(), WithPlainText(PlainTextOptions{})).ProcessStream(pr, rec); err != nil {
+		t.Fatalf("engine B: %v", err)
+	}
+	if err := <-errA; err != nil {
+		t.Fatalf("engine A: %v", err)
+	}
+	var got []string
+	for _, ev := range rec.events {
+		switch ev := ev.(type) {
+		case SectionEvent:
+			got = append(got, fmt.Sprintf("%s %v:%q", ev.Name, ev.Attrs, ev.Content))
+		case CodeBlockEvent:
+			got = append(got, fmt.Sprintf("```%s:%q", ev.Lang, ev.Content))
+		}
+	}
+	want := []string{`PlainText map[]:"Sure.\n\n"`, `create-file map[path:a.go]:"package a"`, `PlainText map[]:"\n"`, "```sh:\"ls\\n\"", `PlainText map[]:"\nDone."`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("engine B saw\n%q\nwant\n%q", got, want)
+	}
+}
+
+func Test_PipeSink_Should_Refuse_Events_That_Do_Not_Parse_Back(t *testing.T) {
+	var b strings.Builder
+	sink := NewPipeSink(&b, nil)
+	for _, ev := range []SectionEvent{
+		{Name: "note", Content: "see </NOTE > here"},
+		{Name: "note", Attrs: map[string]string{"q": `it's "x"`}},
+		{Name: "bad name"},
+		{Name: SectionPlainText, Content: "a <b>tag</b>"},
+	} {
+		if err := sink.OnEventContext(t.Context(), ev); !errors.Is(err, ErrUnrenderable) {
+			t.Errorf("%+v: expected ErrUnrenderable, got %v", ev, err)
+		}
+	}
+	if b.Len() != 0 {
+		t.Fatalf("expected nothing written, got %q", b.String())
+	}
+}
+
+func FuzzPipeSink_Should_Write_Only_What_Parses_Back(f *testing.F) {
+	f.Add("note", "a.go", "body")
+	f.Add("note", `say "hi"`, "x </note> y")
+	f.Add("Note", "{ {\"k\": 1} }", "<other>a</other>\n```\n")
+	f.Add("note", "it's", "")
+	f.Fuzz(func(t *testing.T, name, attr, content string) {
+		ev := SectionEvent{Name: name, Attrs: map[string]string{"k": attr}, Content: content}
+		var b strings.Builder
+		if err := NewPipeSink(&b, nil).OnEventContext(t.Context(), ev); err != nil {
+			if b.Len() != 0 {
+				t.Fatalf("wrote %q for a refused event", b.String())
+			}
+			return
+		}
+		reg := NewRegistry()
+		if err := reg.RegisterE(SectionPlugin{Name: name}); err != nil {
+			t.Skip()
+		}
+		rec := &recorderSink{}
+		if err := NewEngineWithOptions(reg, WithPreserveAttrCase(true)).ProcessStream(strings.NewReader(b.String()), rec); err != nil {
+			t.Fatalf("%q: %v", b.String(), err)
+		}
+		if len(rec.events) != 1 {
+			t.Fatalf("%q: expected one event, got %d", b.String(), len(rec.events))
+		}
+		got := rec.events[0].(SectionEvent)
+		if !strings.EqualFold(got.Name, name) || got.Attrs["k"] != attr || len(got.Attrs) != 1 || got.Content != content {
+			t.Fatalf("%q parsed back as %+v", b.String(), got)
+		}
+	})
+}
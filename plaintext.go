@@ -0,0 +1,140 @@
+package promptweaver
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PlainTextOptions shapes the PlainText sections emitted for the text outside sections and
+// code blocks (see EngineOptions.PlainText). A run of text ends at the next tag, code block
+// or the end of the stream, and is judged as a whole then, however many reads it took.
+type PlainTextOptions struct {
+	// SkipWhitespaceOnly drops runs that are only whitespace, such as the "\n\n" between tags.
+	SkipWhitespaceOnly bool
+
+	// CoalesceAdjacent lets a run go on across tags that emit nothing: unregistered tags,
+	// context sections and stray closers. The tags themselves are left out of the text.
+	CoalesceAdjacent bool
+
+	// KeepUnknownSelfClosing keeps unregistered self-closing tags, such as <br/>, in the run
+	// verbatim, as if they were text, so that a UI can still render what the model wrote.
+	// The UnknownTagHandler is told about them all the same. The zero value leaves them out.
+	KeepUnknownSelfClosing bool
+
+	// MinLength drops runs shorter than this many characters, counted after TrimEdges.
+	MinLength int
+
+	// TrimEdges trims leading and trailing whitespace off each run, and its positions with it.
+	TrimEdges bool
+
+	// FlushAfter, if positive, ends a run once its first byte has waited this long, measured
+	// with Clock, so that text shows up live even while no tag follows it. It is checked
+	// after each chunk is parsed, as SectionTimeout is. The rest of the text starts a new run.
+	FlushAfter time.Duration
+}
+
+// plainText is the run of text being collected for a PlainText section.
+type plainText struct {
+	options    PlainTextOptions
+	buf        strings.Builder
+	start, end Position
+	since      time.Time // when the run's first byte was parsed
+}
+
+// plainToken adds text outside code blocks to the pending run and ends the run at any other
+// token, unless it is a tag that emits nothing and runs are coalesced. It reports whether it
+// consumed tok.
+func (p *parser) plainToken(tok Token) (bool, error) {
+	t := p.plain
+	if tok.Kind == TokenText && p.block == nil {
+		if t.buf.Len() == 0 {
+			t.start, t.since = tok.Start, p.now()
+		}
+		if !tok.Escape {
+			t.buf.WriteString(tok.Text)
+		}
+		t.end = tok.End
+		return true, nil
+	}
+	if t.options.KeepUnknownSelfClosing && p.block == nil && p.unknownSelfClosing(tok) {
+		if t.buf.Len() == 0 {
+			t.start, t.since = tok.Start, p.now()
+		}
+		t.buf.WriteString(tok.Text)
+		t.end = tok.End
+		// Not consumed: the tag is still handled as an unknown one.
+		return false, nil
+	}
+	if t.options.CoalesceAdjacent && p.emitsNothing(tok) {
+		return false, nil
+	}
+	return false, p.flushPlainText()
+}
+
+// emitsNothing reports whether tok, outside sections, is a tag no event comes from.
+func (p *parser) emitsNothing(tok Token) bool {
+	switch tok.Kind {
+	case TokenOpen, TokenSelfClose:
+		_, ok := p.reg.Canonical(tok.Name)
+		return !ok
+	case TokenClose:
+		return true
+	}
+	return false
+}
+
+// unknownSelfClosing reports whether tok is a whole self-closing tag that is neither a
+// registered section nor a context section.
+func (p *parser) unknownSelfClosing(tok Token) bool {
+	if tok.Kind != TokenSelfClose || tok.Incomplete {
+		return false
+	}
+	if _, ok := p.reg.Canonical(tok.Name); ok {
+		return false
+	}
+	_, ok := p.contexts[canonicalOrLower(p.reg, tok.Name)]
+	return !ok
+}
+
+// flushStaleText ends the pending run once it is older than FlushAfter.
+func (p *parser) flushStaleText() error {
+	t := p.plain
+	if t == nil || t.options.FlushAfter <= 0 || t.buf.Len() == 0 || p.now().Sub(t.since) < t.options.FlushAfter {
+		return nil
+	}
+	return p.flushPlainText()
+}
+
+// flushPlainText ends the pending run, emitting it unless the options drop it.
+func (p *parser) flushPlainText() error {
+	t := p.plain
+	if t == nil || t.buf.Len() == 0 {
+		return nil
+	}
+	text, start, end := t.buf.String(), t.start, t.end
+	t.buf.Reset()
+
+	if t.options.TrimEdges {
+		lead := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+		trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+		if lead < len(trimmed) {
+			start, end = advance(start, []byte(text[:lead])), advance(start, []byte(trimmed))
+			text = trimmed[lead:]
+		} else {
+			text = ""
+		}
+	}
+	switch {
+	case text == "",
+		t.options.SkipWhitespaceOnly && strings.TrimSpace(text) == "",
+		utf8.RuneCountInString(text) < t.options.MinLength:
+		return nil
+	}
+	return p.emit(SectionEvent{
+		EventBase: EventBase{StartPos: start, EndPos: end},
+		Name:      SectionPlainText,
+		Content:   text,
+	})
+}
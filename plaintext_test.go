@@ -0,0 +1,150 @@
+package promptweaver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainTextRun parses input in chunks of every size given and returns the events of the
+// last run as "name:content" strings, failing t if the runs disagree.
+func plainTextRun(t *testing.T, input string, options PlainTextOptions, chunks ...int) []string {
+	t.Helper()
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	en := NewEngineWithOptions(reg, WithPlainText(options), WithContinueMode())
+	var want []string
+	for i, chunk := range chunks {
+		rec := &recorderSink{}
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		var got []string
+		for _, ev := range rec.events {
+			sev := ev.(SectionEvent)
+			got = append(got, sev.Name+":"+sev.Content)
+		}
+		if i > 0 && fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("chunk %d: got %q, want %q as with chunk %d", chunk, got, want, chunks[0])
+		}
+		want = got
+	}
+	return want
+}
+
+func Test_PlainText_Should_Judge_A_Run_Once_It_Ends(t *testing.T) {
+	input := "<think>a</think>" + strings.Repeat(" ", 10) + "<think>b</think>"
+
+	got := plainTextRun(t, input, PlainTextOptions{}, 1, len(input))
+	if want := []string{"think:a", "PlainText:" + strings.Repeat(" ", 10), "think:b"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected one run for ten 1-byte reads, got %q", got)
+	}
+	got = plainTextRun(t, input, PlainTextOptions{SkipWhitespaceOnly: true}, 1, len(input))
+	if want := []string{"think:a", "think:b"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the whitespace run to be skipped, got %q", got)
+	}
+}
+
+func Test_PlainText_Should_Coalesce_Across_Tags_That_Emit_Nothing(t *testing.T) {
+	input := "Hello <b>big</b> world</i><think>x</think>\n"
+
+	got := plainTextRun(t, input, PlainTextOptions{}, 1, 3, len(input))
+	if want := []string{"PlainText:Hello ", "PlainText:big", "PlainText: world", "think:x", "PlainText:\n"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected a run per text between tags, got %q", got)
+	}
+	got = plainTextRun(t, input, PlainTextOptions{CoalesceAdjacent: true, SkipWhitespaceOnly: true}, 1, 3, len(input))
+	if want := []string{"PlainText:Hello big world", "think:x"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the runs to be merged, got %q", got)
+	}
+}
+
+func Test_PlainText_Should_Keep_Unknown_Self_Closing_Tags_When_Asked(t *testing.T) {
+	input := "a<br/>b <Img src=\"x\" /><think>c<hr/></think><hr/>"
+
+	got := plainTextRun(t, input, PlainTextOptions{}, 1, 3, len(input))
+	if want := []string{"PlainText:a", "PlainText:b ", "think:c<hr/>"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the tags to end the runs, got %q", got)
+	}
+	got = plainTextRun(t, input, PlainTextOptions{KeepUnknownSelfClosing: true}, 1, 3, len(input))
+	if want := []string{"PlainText:a<br/>b <Img src=\"x\" />", "think:c<hr/>", "PlainText:<hr/>"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the tags kept in the runs verbatim, got %q", got)
+	}
+}
+
+func Test_PlainText_Should_Trim_Edges_Before_MinLength(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	en := NewEngineWithOptions(reg, WithPlainText(PlainTextOptions{TrimEdges: true, MinLength: 3}))
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString("\n  Hello\n<think>x</think> ok \n"), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("expected Hello and think, got %d events", len(rec.events))
+	}
+	ev := rec.events[0].(SectionEvent)
+	if ev.Content != "Hello" || ev.StartPos != (Position{Line: 2, Column: 3, Offset: 3}) || ev.EndPos != (Position{Line: 2, Column: 8, Offset: 8}) {
+		t.Fatalf("expected Hello at 2:3-2:8, got %q at %+v-%+v", ev.Content, ev.StartPos, ev.EndPos)
+	}
+}
+
+func Test_PlainText_Should_Flush_Held_Text_On_Demand(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "file"})
+	rec := &recorderSink{}
+	d := NewDemux(NewEngineWithOptions(reg, WithPlainText(PlainTextOptions{})), func(int) EventSink { return rec })
+	contents := func() string {
+		var out []string
+		for _, ev := range rec.events {
+			sev := ev.(SectionEvent)
+			out = append(out, sev.Name+":"+sev.Content)
+		}
+		return strings.Join(out, "|")
+	}
+
+	if err := d.FlushText(0); err != nil || len(rec.events) != 0 {
+		t.Fatalf("flushing an unfed choice: %v, %d events", err, len(rec.events))
+	}
+	if err := d.Feed(0, []byte("Hello, see <fil")); err != nil {
+		t.Fatal(err)
+	}
+	// The "<fil" may still become a tag: only the text before it goes out.
+	if err := d.FlushText(0); err != nil || contents() != "PlainText:Hello, see " {
+		t.Fatalf("got %v, %q", err, contents())
+	}
+	if err := d.FlushText(0); err != nil || len(rec.events) != 1 {
+		t.Fatalf("a second flush emitted again: %v, %q", err, contents())
+	}
+	if err := d.Feed(0, []byte("e>x</file> done")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(); got != "PlainText:Hello, see |file:x|PlainText: done" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func Test_PlainText_Should_Flush_Runs_Held_Longer_Than_FlushAfter(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "file"})
+	clock := time.Unix(0, 0)
+	en := NewEngineWithOptions(reg,
+		WithPlainText(PlainTextOptions{FlushAfter: 25 * time.Millisecond}),
+		WithClock(func() time.Time { clock = clock.Add(10 * time.Millisecond); return clock }))
+	rec := &recorderSink{}
+	if err := en.ProcessStream(&chunkedReader{data: []byte("abcdefg <file>x</file>"), chunk: 2}, rec); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range rec.events {
+		sev := ev.(SectionEvent)
+		got = append(got, sev.Name+":"+sev.Content)
+	}
+	// The clock advances 10ms each time it is read, so the first run ages past 25ms mid-stream.
+	if want := []string{"PlainText:abcdef", "PlainText:g ", "file:x"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
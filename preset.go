@@ -0,0 +1,94 @@
+package promptweaver
+
+import "strings"
+
+// RegistryPreset adjusts the plugins of a preset before they are registered.
+type RegistryPreset func(plugins []SectionPlugin) []SectionPlugin
+
+// WithoutSections leaves the named sections (names or aliases) out of the preset.
+func WithoutSections(names ...string) RegistryPreset {
+	return func(plugins []SectionPlugin) []SectionPlugin {
+		out := plugins[:0]
+		for _, p := range plugins {
+			if !pluginNamed(p, names) {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+}
+
+// WithoutRunCommand leaves run-command out, for agents that must not execute anything.
+func WithoutRunCommand() RegistryPreset { return WithoutSections("run-command") }
+
+// WithExtraAliases adds aliases to a section of the preset. Unknown sections are ignored.
+func WithExtraAliases(section string, aliases ...string) RegistryPreset {
+	return func(plugins []SectionPlugin) []SectionPlugin {
+		for i, p := range plugins {
+			if pluginNamed(p, []string{section}) {
+				plugins[i].Aliases = append(append([]string(nil), p.Aliases...), aliases...)
+			}
+		}
+		return plugins
+	}
+}
+
+// codingAgentPlugins is the plugin set of NewCodingAgentRegistry. Prose sections use
+// StrictBody to catch mismatched tags; file bodies keep whatever they quote.
+func codingAgentPlugins() []SectionPlugin {
+	return []SectionPlugin{
+		{Name: "think", NormalizeEmpty: true},
+		{Name: "plan", NormalizeEmpty: true, StrictBody: true},
+		{Name: "write-file", Aliases: []string{"create-file"}, NoVariables: true},
+		{Name: "edit-file", NoVariables: true},
+		{Name: "delete-file", NormalizeEmpty: true},
+		{Name: "run-command", NormalizeEmpty: true},
+		{Name: "summary", NormalizeEmpty: true, StrictBody: true},
+	}
+}
+
+// NewCodingAgentRegistry returns a Registry with the sections coding agents usually emit:
+// think, plan, write-file (alias create-file), edit-file, delete-file, run-command and
+// summary, adjusted by presets in order. Registry.List shows exactly what was registered;
+// RegisterCodingAgentValidators adds the matching attribute checks to an engine.
+func NewCodingAgentRegistry(presets ...RegistryPreset) *Registry {
+	plugins := codingAgentPlugins()
+	for _, preset := range presets {
+		plugins = preset(plugins)
+	}
+	reg := NewRegistry()
+	for _, p := range plugins {
+		reg.Register(p)
+	}
+	return reg
+}
+
+// RegisterCodingAgentValidators registers the preset's validators on e, for the preset
+// sections its registry has: file operations require a safe relative path attribute, and
+// run-command requires a command attribute.
+func RegisterCodingAgentValidators(e *Engine) {
+	for _, section := range []string{"write-file", "edit-file", "delete-file"} {
+		if e.reg.IsAllowed(section) {
+			e.RegisterValidator(section, RequiredAttrs("path"))
+			e.RegisterValidator(section, SafePath("path"))
+		}
+	}
+	if e.reg.IsAllowed("run-command") {
+		e.RegisterValidator("run-command", RequiredAttrs("command"))
+	}
+}
+
+// pluginNamed reports whether any of names is p's name or one of its aliases.
+func pluginNamed(p SectionPlugin, names []string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, p.Name) {
+			return true
+		}
+		for _, a := range p.Aliases {
+			if strings.EqualFold(n, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
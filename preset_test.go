@@ -0,0 +1,51 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_CodingAgentRegistry_Should_Apply_Presets(t *testing.T) {
+	reg := NewCodingAgentRegistry(WithoutRunCommand(), WithExtraAliases("write-file", "file"))
+	var names []string
+	for _, p := range reg.List() {
+		names = append(names, p.Name)
+	}
+	if got := strings.Join(names, " "); got != "delete-file edit-file plan summary think write-file" {
+		t.Fatalf("unexpected sections %q", got)
+	}
+	if c, _ := reg.Canonical("FILE"); c != "write-file" || !reg.IsAllowed("create-file") {
+		t.Fatalf("aliases not registered")
+	}
+
+	// Presets work on a fresh set every time.
+	if !NewCodingAgentRegistry().IsAllowed("run-command") || NewCodingAgentRegistry().IsAllowed("file") {
+		t.Fatalf("presets leaked between registries")
+	}
+}
+
+func Test_CodingAgentValidators_Should_Check_Paths_And_Commands(t *testing.T) {
+	en := NewEngine(NewCodingAgentRegistry())
+	RegisterCodingAgentValidators(en)
+
+	ok := `<think>x</think><create-file path="src/a.go">package a</create-file>` +
+		`<run-command command="go test ./..."/><delete-file path="./old/b.go"/>`
+	if err := en.ProcessStream(strings.NewReader(ok), NewHandlerSink()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for input, want := range map[string]string{
+		`<write-file>x</write-file>`:                    `missing required attribute "path"`,
+		`<edit-file path="../etc/passwd">x</edit-file>`: "parent directory reference",
+		`<write-file path="/tmp/x">x</write-file>`:      "absolute path",
+		`<delete-file path="C:\x"/>`:                    "absolute path",
+		`<run-command> ls </run-command>`:               `missing required attribute "command"`,
+	} {
+		err := en.ProcessStream(strings.NewReader(input), NewHandlerSink())
+		var verr *ValidationError
+		if !errors.As(err, &verr) || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected %q, got %v", input, want, err)
+		}
+	}
+}
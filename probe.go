@@ -0,0 +1,104 @@
+package promptweaver
+
+// ContainsSections reports whether s holds any registered section. It is a cheap screen
+// before a full parse; see CountSections for what it looks at.
+func (e *Engine) ContainsSections(s string) bool {
+	found := false
+	e.scanSections(s, func(string) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// CountSections counts the sections in s by canonical name. It runs the tokenizer alone, in
+// one pass, without events, validators or handlers, but with the engine's rules for aliases,
+// case, section bodies, code fences, context sections and fence mapping.
+//
+// For well-formed input the counts are those of the sections a parse opens; the parse may
+// still emit fewer, dropping sections that are suppressed or fail validation. For malformed
+// input the counts may be higher than a parse's, but never lower.
+func (e *Engine) CountSections(s string) map[string]int {
+	counts := map[string]int{}
+	e.scanSections(s, func(name string) bool {
+		counts[name]++
+		return true
+	})
+	return counts
+}
+
+// scanSections calls fn with the canonical name of every section s opens, and reports
+// false as soon as fn does.
+func (e *Engine) scanSections(s string, fn func(name string) bool) bool {
+	o := e.options
+	contexts := resolveContextSections(e.reg, o.ContextSections)
+	fenceSection := ""
+	if c, ok := e.reg.Canonical(o.FenceMapping.Section); ok {
+		fenceSection = c
+	}
+	t := newTokenizer(o.CodeBlocks, o.LenientFences)
+	t.inlineCode, t.escape = o.InlineCodeAwareness, o.EscapePrefix
+	t.balanced = o.BalancedSameName
+	t.tag.maxAttrs = limitOrDefault(o.MaxAttrs, DefaultMaxAttrs)
+	t.tag.maxKeyLen = limitOrDefault(o.MaxAttrNameLen, DefaultMaxAttrNameLen)
+	t.tag.maxLen = limitOrDefault(o.MaxLookahead, DefaultMaxLookahead)
+	t.feed([]byte(s))
+
+	open := false // inside a section body
+	var bodyStart int64
+	for {
+		tok, ok, err := t.next(true)
+		if err != nil {
+			continue // the offending bytes come back as text
+		}
+		if !ok {
+			break
+		}
+		if tok.Incomplete {
+			continue
+		}
+		switch tok.Kind {
+		case TokenFenceStart:
+			// A fence with a file= header is a mapped section, in a body or not.
+			if fenceSection != "" && tok.Attrs["file"] != "" && !fn(fenceSection) {
+				return false
+			}
+		case TokenClose:
+			// Section bodies only yield the closers that end them, strays included.
+			if open {
+				t.exitRaw()
+				open = false
+			}
+		case TokenOpen, TokenSelfClose:
+			if open {
+				continue
+			}
+			if _, ok := contexts[canonicalOrLower(e.reg, tok.Name)]; ok {
+				continue
+			}
+			c, ok := e.reg.Canonical(tok.Name)
+			if !ok {
+				continue
+			}
+			if !fn(c) {
+				return false
+			}
+			if tok.Kind == TokenOpen {
+				plugin, _ := e.reg.Plugin(c)
+				closes := closesSection(e.reg, c, tok.Name)
+				if plugin.StrictBody {
+					closes = closesOrStrays(e.reg, closes)
+				}
+				t.enterRaw(closes, plugin.ParseFencesInBody)
+				t.opaque(plugin, tok)
+				t.nest(closesSection(e.reg, c, tok.Name))
+				open, bodyStart = true, tok.End.Offset
+			}
+		}
+	}
+	if open && o.OrphanRescue {
+		// The sections in the body of one left open are taken out of it.
+		return e.scanSections(s[bodyStart:], fn)
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package promptweaver
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_CountSections_Should_Agree_With_A_Parse(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	reg.Register(SectionPlugin{Name: "summary"})
+	en := NewEngineWithOptions(reg, WithCodeBlocks(), WithContextSection("project"), WithContinueMode(),
+		WithFenceSectionMapping("write-file", "path"), WithOrphanRescue(true))
+
+	parsed := func(input string) map[string]int {
+		rec := &recorderSink{}
+		_ = en.ProcessStream(ReaderFromString(input), rec)
+		counts := map[string]int{}
+		for _, ev := range rec.events {
+			if sev, ok := ev.(SectionEvent); ok {
+				counts[sev.Name]++
+			}
+		}
+		return counts
+	}
+
+	wellFormed := []string{
+		"Just prose, with a < and a <b>bold</b> word.",
+		"<THINK>plan <summary> later</think><Create-File path=\"a\">x</create-file><summary/>",
+		"<project root=\"x\"><write-file path=\"b\">y</write-file></project>",
+		"```html\n<think>not a section</think>\n```\n<summary>done</summary>",
+		"```go file=\"a.go\"\npackage a\n```\n",
+	}
+	for _, input := range wellFormed {
+		got, want := en.CountSections(input), parsed(input)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%q: counted %v, a parse found %v", input, got, want)
+		}
+		if en.ContainsSections(input) != (len(want) > 0) {
+			t.Errorf("%q: ContainsSections disagrees with %v", input, want)
+		}
+	}
+
+	malformed := []string{
+		"<think>never closed <summary>x</summary>",
+		"</summary><think a=\"1\" a=\"2\">x</think><summary",
+		"<write-file path=\"a\">x</think></write-file><summary>",
+	}
+	for _, input := range malformed {
+		got, want := en.CountSections(input), parsed(input)
+		for name, n := range want {
+			if got[name] < n {
+				t.Errorf("%q: counted %d %s, a parse found %d", input, got[name], name, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package promptweaver
+
+import "encoding/json"
+
+// ProgressEvent reports how much of a stream of known length has been read (see
+// WithExpectedLength). BytesRead counts raw bytes from the reader, before any parsing.
+type ProgressEvent struct {
+	EventBase
+	BytesRead int64   `json:"bytes_read"`
+	Total     int64   `json:"total"`
+	Percent   float64 `json:"percent"` // 100*BytesRead/Total, at most 100
+}
+
+// Kind implements Event.
+func (ProgressEvent) Kind() EventKind { return KindProgress }
+
+func (ev ProgressEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev ProgressEvent) MarshalJSON() ([]byte, error) {
+	type plain ProgressEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// AsProgress returns ev as a ProgressEvent, if it is one.
+func AsProgress(ev Event) (ProgressEvent, bool) {
+	pev, ok := ev.(ProgressEvent)
+	return pev, ok
+}
+
+// defaultProgressPercent is the reporting step when neither ProgressEvery nor
+// ProgressPercent is set.
+const defaultProgressPercent = 1
+
+// progress decides when a stream of known length reports how far it has got.
+type progress struct {
+	total    int64
+	step     int64 // bytes between reports
+	handler  func(ProgressEvent)
+	read     int64
+	next     int64 // report once read reaches it
+	reported int64 // read at the last report
+}
+
+func newProgress(options EngineOptions) *progress {
+	total := options.ExpectedLength
+	if total <= 0 {
+		return nil
+	}
+	step := options.ProgressEvery
+	if step <= 0 {
+		pct := options.ProgressPercent
+		if pct <= 0 {
+			pct = defaultProgressPercent
+		}
+		step = int64(float64(total) * pct / 100)
+	}
+	if step < 1 {
+		step = 1
+	}
+	pr := &progress{total: total, step: step, handler: options.ProgressHandler}
+	pr.advance()
+	return pr
+}
+
+// advance sets the next reporting point, never past the end so that reaching it is reported.
+func (pr *progress) advance() {
+	pr.next = (pr.read/pr.step + 1) * pr.step
+	if pr.read < pr.total && pr.next > pr.total {
+		pr.next = pr.total
+	}
+}
+
+// reportProgress counts n more bytes read and reports if a reporting point was reached.
+func (p *parser) reportProgress(pr *progress, n int64) error {
+	if pr == nil {
+		return nil
+	}
+	pr.read += n
+	if pr.read < pr.next {
+		return nil
+	}
+	pr.advance()
+	return p.sendProgress(pr)
+}
+
+// finishProgress reports bytes read since the last report, e.g. when the stream was shorter
+// than expected.
+func (p *parser) finishProgress(pr *progress) error {
+	if pr == nil || pr.read == pr.reported {
+		return nil
+	}
+	return p.sendProgress(pr)
+}
+
+func (p *parser) sendProgress(pr *progress) error {
+	pr.reported = pr.read
+	ev := ProgressEvent{
+		EventBase: EventBase{StartPos: p.pos, EndPos: p.pos},
+		BytesRead: pr.read,
+		Total:     pr.total,
+		Percent:   100 * float64(pr.read) / float64(pr.total),
+	}
+	if ev.Percent > 100 {
+		ev.Percent = 100
+	}
+	if pr.handler != nil {
+		ev.StreamMeta = p.streamMeta
+		pr.handler(ev)
+		return nil
+	}
+	return p.emit(ev)
+}
@@ -0,0 +1,65 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Report_Progress_When_Length_Is_Known(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	input := "<think>" + strings.Repeat("x", 93) + "</think>" // 108 bytes
+
+	rec := &recorderSink{}
+	en := NewEngineWithOptions(reg, WithExpectedLength(int64(len(input))), WithProgressPercent(25))
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 10}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	var got []int64
+	for _, ev := range rec.events {
+		if pev, ok := AsProgress(ev); ok {
+			got = append(got, pev.BytesRead)
+			if pev.Total != 108 {
+				t.Fatalf("unexpected total %+v", pev)
+			}
+		}
+	}
+	// Every 27 bytes, checked at 10-byte reads, and the end exactly.
+	if want := "[30 60 90 108]"; fmt.Sprint(got) != want {
+		t.Fatalf("got progress at %v, want %s", got, want)
+	}
+	last, _ := AsProgress(rec.events[len(rec.events)-1])
+	if last.Percent != 100 {
+		t.Fatalf("expected 100%% at the end, got %+v", last)
+	}
+
+	b, err := json.Marshal(last)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if back, err := UnmarshalEvent(b); err != nil || back.(ProgressEvent).BytesRead != 108 {
+		t.Fatalf("round trip failed: %v %+v", err, back)
+	}
+}
+
+func Test_Engine_Should_Send_Progress_To_Handler_And_Report_Short_Streams(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	input := "<think>short</think>" // 20 of an expected 100 bytes
+
+	var got []ProgressEvent
+	rec := &recorderSink{}
+	en := NewEngineWithOptions(reg, WithExpectedLength(100), WithProgressEvery(8),
+		WithProgressHandler(func(ev ProgressEvent) { got = append(got, ev) }))
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 4}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("progress should bypass the sink, got %+v", rec.events)
+	}
+	if len(got) != 3 || got[0].BytesRead != 8 || got[1].BytesRead != 16 || got[2].BytesRead != 20 || got[2].Percent != 20 {
+		t.Fatalf("unexpected progress %+v", got)
+	}
+}
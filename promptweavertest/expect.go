@@ -0,0 +1,304 @@
+package promptweavertest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/grahms/promptweaver"
+)
+
+// Expectation describes an event a stream should produce, or a group of them. Build one
+// from Expect and refine it with its methods; each returns a new Expectation.
+//
+//	sink := promptweavertest.ExpectSink(t,
+//		Expect.Section("think"),
+//		Expect.Section("create-file").WithAttr("path", "a.go").ContentContains("func main"),
+//		Expect.End())
+//	_ = engine.ProcessStream(r, sink)
+type Expectation struct {
+	desc   []string
+	checks []func(promptweaver.Event) string // each returns why the event does not match, or ""
+	group  []Expectation                     // for Unordered
+	end    bool
+}
+
+type expectBuilder struct{}
+
+// Expect starts expectations: Expect.Section("plan"), Expect.End().
+var Expect expectBuilder
+
+// Section expects a section with the canonical name.
+func (expectBuilder) Section(name string) Expectation {
+	return Expectation{desc: []string{fmt.Sprintf("section %q", name)}}.check(func(ev promptweaver.Event) string {
+		sev, ok := promptweaver.AsSection(ev)
+		switch {
+		case !ok:
+			return "not a section"
+		case sev.Name != name:
+			return fmt.Sprintf("name is %q", sev.Name)
+		}
+		return ""
+	})
+}
+
+// CodeBlock expects a code block in the language; "" matches any language.
+func (expectBuilder) CodeBlock(lang string) Expectation {
+	desc := "code block"
+	if lang != "" {
+		desc += fmt.Sprintf(" %q", lang)
+	}
+	return Expectation{desc: []string{desc}}.check(func(ev promptweaver.Event) string {
+		cb, ok := promptweaver.AsCodeBlock(ev)
+		switch {
+		case !ok:
+			return "not a code block"
+		case lang != "" && cb.Lang != lang:
+			return fmt.Sprintf("lang is %q", cb.Lang)
+		}
+		return ""
+	})
+}
+
+// Kind expects any event of the kind.
+func (expectBuilder) Kind(kind promptweaver.EventKind) Expectation {
+	return Expectation{desc: []string{fmt.Sprintf("%s event", kind)}}.check(func(ev promptweaver.Event) string {
+		if ev.Kind() != kind {
+			return fmt.Sprintf("kind is %s", ev.Kind())
+		}
+		return ""
+	})
+}
+
+// Any expects one event of any kind.
+func (expectBuilder) Any() Expectation { return Expectation{desc: []string{"any event"}} }
+
+// Unordered expects the events of exps, each once, in any order.
+func (expectBuilder) Unordered(exps ...Expectation) Expectation {
+	descs := make([]string, len(exps))
+	for i, e := range exps {
+		descs[i] = e.String()
+	}
+	return Expectation{desc: []string{"in any order: [" + strings.Join(descs, "; ") + "]"}, group: exps}
+}
+
+// End expects the stream to end here, without error: no event may follow.
+func (expectBuilder) End() Expectation {
+	return Expectation{desc: []string{"end of stream"}, end: true}
+}
+
+// WithAttr also requires the attribute to have the value.
+func (e Expectation) WithAttr(key, value string) Expectation {
+	e.desc = append(e.desc[:len(e.desc):len(e.desc)], fmt.Sprintf("with %s=%q", key, value))
+	return e.check(func(ev promptweaver.Event) string {
+		sev, _ := promptweaver.AsSection(ev)
+		got, ok := sev.Attr(key)
+		switch {
+		case !ok:
+			return fmt.Sprintf("attr %s is missing", key)
+		case got != value:
+			return fmt.Sprintf("attr %s is %q", key, got)
+		}
+		return ""
+	})
+}
+
+// Content also requires the section's or code block's content to equal s.
+func (e Expectation) Content(s string) Expectation {
+	e.desc = append(e.desc[:len(e.desc):len(e.desc)], fmt.Sprintf("with content %q", s))
+	return e.check(func(ev promptweaver.Event) string {
+		if got := content(ev); got != s {
+			return fmt.Sprintf("content is %q", clip(got))
+		}
+		return ""
+	})
+}
+
+// ContentContains also requires the content to contain s.
+func (e Expectation) ContentContains(s string) Expectation {
+	e.desc = append(e.desc[:len(e.desc):len(e.desc)], fmt.Sprintf("with content containing %q", s))
+	return e.check(func(ev promptweaver.Event) string {
+		if got := content(ev); !strings.Contains(got, s) {
+			return fmt.Sprintf("content is %q", clip(got))
+		}
+		return ""
+	})
+}
+
+// Where also requires fn to accept the event; desc names the condition in failures.
+func (e Expectation) Where(desc string, fn func(promptweaver.Event) bool) Expectation {
+	e.desc = append(e.desc[:len(e.desc):len(e.desc)], desc)
+	return e.check(func(ev promptweaver.Event) string {
+		if !fn(ev) {
+			return "not " + desc
+		}
+		return ""
+	})
+}
+
+func (e Expectation) check(fn func(promptweaver.Event) string) Expectation {
+	e.checks = append(e.checks[:len(e.checks):len(e.checks)], fn)
+	return e
+}
+
+// String describes the expectation.
+func (e Expectation) String() string { return strings.Join(e.desc, " ") }
+
+// mismatch returns why ev does not meet e, or "".
+func (e Expectation) mismatch(ev promptweaver.Event) string {
+	for _, c := range e.checks {
+		if why := c(ev); why != "" {
+			return why
+		}
+	}
+	return ""
+}
+
+// ExpectingSink checks the events of a stream against expectations, failing the test at
+// the first mismatch. Create one with ExpectSink.
+type ExpectingSink struct {
+	t testing.TB
+
+	mu      sync.Mutex
+	exps    []Expectation
+	pending []Expectation // unmatched members of the Unordered group being matched
+	n       int           // events received
+	failed  bool
+	ended   bool
+}
+
+// ExpectSink returns a sink that fails t unless the stream's events meet exps in order.
+// Events after the last expectation are ignored unless it is Expect.End. Every failure
+// names the event's number, sequence number and position, and the expectation it missed.
+func ExpectSink(t testing.TB, exps ...Expectation) *ExpectingSink {
+	return &ExpectingSink{t: t, exps: exps}
+}
+
+// OnEvent implements promptweaver.EventSink.
+func (s *ExpectingSink) OnEvent(ev promptweaver.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	if s.failed {
+		return
+	}
+	if len(s.pending) == 0 && len(s.exps) > 0 && s.exps[0].group != nil {
+		s.pending = append([]Expectation(nil), s.exps[0].group...)
+		s.exps = s.exps[1:]
+	}
+	if len(s.pending) > 0 {
+		var whys []string
+		for i, e := range s.pending {
+			why := e.mismatch(ev)
+			if why == "" {
+				s.pending = append(s.pending[:i:i], s.pending[i+1:]...)
+				return
+			}
+			whys = append(whys, e.String()+": "+why)
+		}
+		s.fail(ev, "one of the unordered expectations", strings.Join(whys, "\n    "))
+		return
+	}
+	if len(s.exps) == 0 {
+		return
+	}
+	e := s.exps[0]
+	if e.end {
+		s.fail(ev, e.String(), "the stream went on")
+		return
+	}
+	if why := e.mismatch(ev); why != "" {
+		s.fail(ev, e.String(), why)
+		return
+	}
+	s.exps = s.exps[1:]
+}
+
+// OnStreamEnd implements promptweaver.StreamEndSink: expectations not met by then fail t.
+func (s *ExpectingSink) OnStreamEnd(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	if s.failed {
+		return
+	}
+	var missing []string
+	for _, e := range s.pending {
+		missing = append(missing, e.String())
+	}
+	for _, e := range s.exps {
+		if e.end {
+			if err != nil {
+				s.failed = true
+				s.t.Errorf("expected %s, got error after %d events: %v", e, s.n, err)
+				return
+			}
+			continue
+		}
+		missing = append(missing, e.String())
+	}
+	if len(missing) > 0 {
+		s.failed = true
+		s.t.Errorf("stream ended after %d events (err: %v) without:\n  %s", s.n, err, strings.Join(missing, "\n  "))
+	}
+}
+
+// Ended reports whether the stream has ended.
+func (s *ExpectingSink) Ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ended
+}
+
+func (s *ExpectingSink) fail(ev promptweaver.Event, want, why string) {
+	s.failed = true
+	b := ev.Base()
+	s.t.Errorf("event %d (seq %d, %s to %s) does not match:\n  want: %s\n  got:  %s\n  why:  %s",
+		s.n, b.Seq, b.StartPos, b.EndPos, want, describe(ev), why)
+}
+
+// describe summarizes an event for failure messages.
+func describe(ev promptweaver.Event) string {
+	switch ev := ev.(type) {
+	case promptweaver.SectionEvent:
+		keys := make([]string, 0, len(ev.Attrs))
+		for k := range ev.Attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		attrs := make([]string, len(keys))
+		for i, k := range keys {
+			attrs[i] = fmt.Sprintf("%s=%q", k, ev.Attrs[k])
+		}
+		return fmt.Sprintf("section %q {%s} content %q", ev.Name, strings.Join(attrs, " "), clip(ev.Content))
+	case promptweaver.CodeBlockEvent:
+		return fmt.Sprintf("code block %q content %q", ev.Lang, clip(ev.Content))
+	}
+	return fmt.Sprintf("%s event", ev.Kind())
+}
+
+func content(ev promptweaver.Event) string {
+	switch ev := ev.(type) {
+	case promptweaver.SectionEvent:
+		return ev.Content
+	case promptweaver.CodeBlockEvent:
+		return ev.Content
+	}
+	return ""
+}
+
+// clip shortens s for failure messages.
+func clip(s string) string {
+	const max = 80
+	if len(s) <= max {
+		return s
+	}
+	i := max
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i] + "…"
+}
@@ -0,0 +1,68 @@
+package promptweavertest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grahms/promptweaver"
+)
+
+func expectEngine() *promptweaver.Engine {
+	reg := promptweaver.NewRegistry()
+	reg.Register(promptweaver.SectionPlugin{Name: "think"})
+	reg.Register(promptweaver.SectionPlugin{Name: "create-file"})
+	return promptweaver.NewEngineWithOptions(reg, promptweaver.WithCodeBlocks())
+}
+
+const expectInput = "<think>plan</think>\n<create-file path=\"a.go\">package main\nfunc main() {}</create-file>\n" +
+	"<create-file path=\"b.go\">package b</create-file>\n```sh\nmake\n```\n"
+
+func Test_ExpectSink_Should_Pass_When_Events_Match(t *testing.T) {
+	sink := ExpectSink(t,
+		Expect.Section("think").Content("plan"),
+		Expect.Unordered(
+			Expect.Section("create-file").WithAttr("path", "b.go"),
+			Expect.Section("create-file").WithAttr("path", "a.go").ContentContains("func main"),
+		),
+		Expect.CodeBlock("sh").Content("make\n"),
+		Expect.End(),
+	)
+	if err := expectEngine().ProcessStream(strings.NewReader(expectInput), sink); err != nil {
+		t.Fatal(err)
+	}
+	if !sink.Ended() {
+		t.Fatalf("stream end not seen")
+	}
+}
+
+func Test_ExpectSink_Should_Report_The_First_Mismatch(t *testing.T) {
+	for name, tc := range map[string]struct {
+		exps []Expectation
+		want []string
+	}{
+		"attr": {
+			exps: []Expectation{Expect.Any(), Expect.Section("create-file").WithAttr("path", "b.go")},
+			want: []string{"event 2 (seq 2, line 2, column 1 (offset 20)", `want: section "create-file" with path="b.go"`, `got:  section "create-file" {path="a.go"}`, `why:  attr path is "a.go"`},
+		},
+		"unordered": {
+			exps: []Expectation{Expect.Any(), Expect.Unordered(Expect.Section("think"), Expect.Kind(promptweaver.KindCodeBlock))},
+			want: []string{"event 2", "one of the unordered expectations", `section "think": name is "create-file"`, "code_block event: kind is section"},
+		},
+		"end": {
+			exps: []Expectation{Expect.Any(), Expect.End()},
+			want: []string{"event 2", "want: end of stream", "why:  the stream went on"},
+		},
+		"missing": {
+			exps: []Expectation{Expect.Any(), Expect.Any(), Expect.Any(), Expect.Any(), Expect.Section("summary")},
+			want: []string{"stream ended after 4 events", `section "summary"`},
+		},
+	} {
+		rec := &recordingTB{TB: t}
+		_ = expectEngine().ProcessStream(strings.NewReader(expectInput), ExpectSink(rec, tc.exps...))
+		for _, w := range tc.want {
+			if !strings.Contains(rec.msg, w) {
+				t.Errorf("%s: message %q lacks %q", name, rec.msg, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package promptweavertest runs promptweaver engines over transcript fixtures and compares
+// the events they produce with golden files, or checks them against expectations written
+// in the test (see ExpectSink).
+//
+// A golden file holds one JSON event per line, as produced by encoding/json, so attributes
+// come out in sorted key order and every event carries its "kind". A stream that ends in an
+// error gets a last line {"error": ...} with the error's ErrorInfo, or its message.
+package promptweavertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/grahms/promptweaver"
+)
+
+// GoldenSuffix is appended to an input file's name to get its golden file.
+const GoldenSuffix = ".golden"
+
+// Option adjusts how events are serialized for comparison.
+type Option func(*config)
+
+type config struct {
+	ignorePositions bool
+}
+
+// IgnorePositions leaves start_pos and end_pos out of the serialized events, for goldens
+// that should survive changes in whitespace.
+func IgnorePositions() Option {
+	return func(c *config) { c.ignorePositions = true }
+}
+
+// RunGolden parses inputPath with en and compares the events with goldenPath. With update,
+// it writes the golden file instead (run `go test ./... -update` with a flag of your own).
+func RunGolden(t testing.TB, en *promptweaver.Engine, inputPath, goldenPath string, update bool, opts ...Option) {
+	t.Helper()
+	got, err := Serialize(en, inputPath, opts...)
+	if err != nil {
+		t.Fatalf("%s: %v", inputPath, err)
+	}
+	if update {
+		if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if diff := diffLines(string(want), string(got)); diff != "" {
+		t.Errorf("%s does not match %s (run with -update to accept):\n%s", inputPath, goldenPath, diff)
+	}
+}
+
+// Serialize parses inputPath with en and returns the events as golden file contents.
+func Serialize(en *promptweaver.Engine, inputPath string, opts ...Option) ([]byte, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	var encErr error
+	write := func(v any) {
+		b, err := json.Marshal(v)
+		if err == nil && c.ignorePositions {
+			b, err = stripPositions(b)
+		}
+		if err != nil && encErr == nil {
+			encErr = err
+		}
+		out.Write(b)
+		out.WriteByte('\n')
+	}
+	sink := promptweaver.EventSinkFunc(func(ev promptweaver.Event) { write(ev) })
+	if err := en.ProcessStream(f, sink); err != nil {
+		if info, ok := promptweaver.ErrorToJSON(err); ok {
+			write(map[string]json.RawMessage{"error": info})
+		} else {
+			write(map[string]string{"error": err.Error()})
+		}
+	}
+	return out.Bytes(), encErr
+}
+
+// Fixture is an input transcript and its golden file.
+type Fixture struct {
+	Name       string // input file name without directory
+	InputPath  string
+	GoldenPath string
+}
+
+// LoadCorpus lists the fixtures in dir: every regular file not ending in GoldenSuffix is an
+// input, whose golden file is the same path plus GoldenSuffix. Fixtures are sorted by name.
+// Golden files need not exist yet.
+func LoadCorpus(dir string) ([]Fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Fixture
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasSuffix(e.Name(), GoldenSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		out = append(out, Fixture{Name: e.Name(), InputPath: path, GoldenPath: path + GoldenSuffix})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// RunCorpus runs RunGolden as a subtest for every fixture in dir.
+func RunCorpus(t *testing.T, en *promptweaver.Engine, dir string, update bool, opts ...Option) {
+	t.Helper()
+	fixtures, err := LoadCorpus(dir)
+	if err != nil {
+		t.Fatalf("loading corpus: %v", err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("no fixtures in %s", dir)
+	}
+	for _, fx := range fixtures {
+		t.Run(fx.Name, func(t *testing.T) {
+			RunGolden(t, en, fx.InputPath, fx.GoldenPath, update, opts...)
+		})
+	}
+}
+
+// stripPositions removes start_pos and end_pos from a JSON object and the objects in it.
+func stripPositions(b []byte) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	var strip func(any)
+	strip = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			delete(v, "start_pos")
+			delete(v, "end_pos")
+			for _, x := range v {
+				strip(x)
+			}
+		case []any:
+			for _, x := range v {
+				strip(x)
+			}
+		}
+	}
+	strip(v)
+	return json.Marshal(v)
+}
+
+// diffLines describes the first line where want and got differ, or returns "".
+func diffLines(want, got string) string {
+	if want == got {
+		return ""
+	}
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			return fmt.Sprintf("line %d:\n- %s\n+ %s", i+1, wl, gl)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package promptweavertest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grahms/promptweaver"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func engine() *promptweaver.Engine {
+	reg := promptweaver.NewRegistry()
+	reg.Register(promptweaver.SectionPlugin{Name: "think"})
+	reg.Register(promptweaver.SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	reg.Register(promptweaver.SectionPlugin{Name: "summary"})
+	return promptweaver.NewEngineWithOptions(reg, promptweaver.WithEOFPolicy(promptweaver.ErrorPartial))
+}
+
+func Test_RunCorpus_Should_Match_Golden_Files(t *testing.T) {
+	RunCorpus(t, engine(), "testdata", *update)
+}
+
+func Test_LoadCorpus_Should_Pair_Inputs_With_Goldens(t *testing.T) {
+	fixtures, err := LoadCorpus("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fx := range fixtures {
+		names = append(names, fx.Name)
+		if fx.GoldenPath != fx.InputPath+GoldenSuffix {
+			t.Errorf("unexpected golden path %q for %q", fx.GoldenPath, fx.InputPath)
+		}
+	}
+	if strings.Join(names, ",") != "basic.txt,malformed.txt,unclosed.txt" {
+		t.Fatalf("unexpected fixtures %v", names)
+	}
+}
+
+func Test_RunGolden_Should_Report_Differences_And_Update(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.txt")
+	golden := input + GoldenSuffix
+	os.WriteFile(input, []byte("<summary>one</summary>"), 0o644)
+
+	RunGolden(t, engine(), input, golden, true, IgnorePositions())
+	b, _ := os.ReadFile(golden)
+	if string(b) != `{"alias_used":"summary","attrs":{},"content":"one","kind":"section","name":"summary","seq":1}`+"\n" {
+		t.Fatalf("unexpected golden file %s", b)
+	}
+
+	os.WriteFile(input, []byte("<summary>two</summary>"), 0o644)
+	rec := &recordingTB{TB: t}
+	RunGolden(rec, engine(), input, golden, false, IgnorePositions())
+	if !strings.Contains(rec.msg, "line 1:") || !strings.Contains(rec.msg, `+ {"alias_used":"summary","attrs":{},"content":"two"`) {
+		t.Fatalf("expected a line diff, got %q", rec.msg)
+	}
+}
+
+// recordingTB captures Errorf instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	msg string
+}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.msg = fmt.Sprintf(format, args...)
+}
+
+func Test_WriteEvents_Should_Round_Trip_The_Corpus(t *testing.T) {
+	fixtures, err := LoadCorpus("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fx := range fixtures {
+		t.Run(fx.Name, func(t *testing.T) {
+			f, err := os.Open(fx.InputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var events []promptweaver.Event
+			_ = engine().ProcessStream(f, promptweaver.EventSinkFunc(func(ev promptweaver.Event) { events = append(events, ev) }))
+
+			rendered := filepath.Join(t.TempDir(), fx.Name)
+			out, err := os.Create(rendered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := promptweaver.WriteEvents(out, events); err != nil {
+				t.Fatal(err)
+			}
+			out.Close()
+
+			want, _ := Serialize(engine(), fx.InputPath, IgnorePositions())
+			got, _ := Serialize(engine(), rendered, IgnorePositions())
+			if eventLines(got) != eventLines(want) {
+				t.Fatalf("round trip changed events:\nwant %s\ngot  %s", eventLines(want), eventLines(got))
+			}
+		})
+	}
+}
+
+// eventLines drops the error line Serialize appends, which canonical text never reproduces.
+func eventLines(b []byte) string {
+	var keep []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(line, `{"error"`) {
+			keep = append(keep, line)
+		}
+	}
+	return strings.Join(keep, "\n")
+}
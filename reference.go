@@ -0,0 +1,150 @@
+package promptweaver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reference declares that the UseAttr of Use sections names the DefAttr of an earlier Def
+// section, as <edit-file ref="F3"> refers to <create-file id="F3">.
+//
+// Each use is checked before its event is delivered. An id no earlier definition declared,
+// and a second definition of an id, are ReferenceErrors: they go through the engine's error
+// handling like a failed validation, so a recovered one drops the section, unless
+// EngineOptions.ReferenceWarnings is set, which receives them instead and keeps the section.
+// Sections without the attribute are neither definitions nor uses.
+type Reference struct {
+	Def     string // defining section (name or alias)
+	DefAttr string // attribute declaring the id
+	Use     string // referring section (name or alias)
+	UseAttr string // attribute naming the id
+}
+
+// ReferenceError reports a use of an id that was not defined before it, or a second
+// definition of an id.
+type ReferenceError struct {
+	ParseError
+	SectionName string
+	Attr        string
+	ID          string
+	Duplicate   bool      // a second definition rather than an unknown use
+	DefinedAt   *Position // the first definition, for duplicates
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *ReferenceError) ErrorDetails() ErrorInfo {
+	info := e.info("reference")
+	info.Section, info.Attribute, info.ID = e.SectionName, e.Attr, e.ID
+	info.Start = e.DefinedAt
+	return info
+}
+
+// ReferenceResolver is told, before a use is delivered, which section defined its id.
+type ReferenceResolver func(use, def SectionEvent)
+
+// DanglingReferenceHandler receives, once the stream has ended, the uses whose ids were
+// never defined, in stream order.
+type DanglingReferenceHandler func(uses []SectionEvent)
+
+// refKey identifies a declared id of one Reference.
+type refKey struct {
+	ref int
+	id  string
+}
+
+// referencer tracks the ids declared so far and the uses that did not resolve.
+type referencer struct {
+	refs       []Reference // names canonicalized, attrs lowercased
+	defs       map[refKey]SectionEvent
+	unresolved map[refKey][]SectionEvent
+}
+
+func newReferencer(reg *Registry, refs []Reference) *referencer {
+	if len(refs) == 0 {
+		return nil
+	}
+	rf := &referencer{defs: map[refKey]SectionEvent{}, unresolved: map[refKey][]SectionEvent{}}
+	for _, r := range refs {
+		r.Def, r.Use = canonicalOrLower(reg, r.Def), canonicalOrLower(reg, r.Use)
+		r.DefAttr, r.UseAttr = strings.ToLower(r.DefAttr), strings.ToLower(r.UseAttr)
+		rf.refs = append(rf.refs, r)
+	}
+	return rf
+}
+
+// resolve checks ev, which is about to be delivered, as a use and records it as a definition.
+// The first problem is returned, or passed to the warning handler.
+func (p *parser) resolve(ev SectionEvent) error {
+	rf := p.referencer
+	for i, r := range rf.refs {
+		if ev.Name == r.Use {
+			if id, ok := ev.Attr(r.UseAttr); ok {
+				key := refKey{ref: i, id: id}
+				if def, ok := rf.defs[key]; ok {
+					if p.onResolved != nil {
+						p.onResolved(ev, def)
+					}
+				} else {
+					rf.unresolved[key] = append(rf.unresolved[key], ev)
+					err := newReferenceError(ev, r.UseAttr, id, fmt.Sprintf("<%s %s=%q> refers to no earlier <%s %s=%q>", ev.Name, r.UseAttr, id, r.Def, r.DefAttr, id))
+					if err := p.reportReference(err); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if ev.Name == r.Def {
+			if id, ok := ev.Attr(r.DefAttr); ok {
+				key := refKey{ref: i, id: id}
+				if first, ok := rf.defs[key]; ok {
+					err := newReferenceError(ev, r.DefAttr, id, fmt.Sprintf("duplicate <%s %s=%q>, first defined at %s", ev.Name, r.DefAttr, id, first.StartPos))
+					pos := first.StartPos
+					err.Duplicate, err.DefinedAt = true, &pos
+					if err := p.reportReference(err); err != nil {
+						return err
+					}
+					continue
+				}
+				rf.defs[key] = ev
+			}
+		}
+	}
+	return nil
+}
+
+func newReferenceError(ev SectionEvent, attr, id, msg string) *ReferenceError {
+	return &ReferenceError{
+		ParseError:  ParseError{Pos: ev.StartPos, Message: msg},
+		SectionName: ev.Name,
+		Attr:        attr,
+		ID:          id,
+	}
+}
+
+// reportReference returns err, or hands it to the warning handler and returns nil.
+func (p *parser) reportReference(err *ReferenceError) error {
+	if p.onReferenceWarning != nil {
+		p.onReferenceWarning(err)
+		return nil
+	}
+	return err
+}
+
+// reportDangling hands the uses whose ids were never defined to the DanglingReferenceHandler.
+func (p *parser) reportDangling() {
+	if p.referencer == nil || p.onDangling == nil {
+		return
+	}
+	var dangling []SectionEvent
+	for key, uses := range p.referencer.unresolved {
+		if _, ok := p.referencer.defs[key]; !ok {
+			dangling = append(dangling, uses...)
+		}
+	}
+	if len(dangling) == 0 {
+		return
+	}
+	sort.Slice(dangling, func(i, j int) bool { return dangling[i].StartPos.Offset < dangling[j].StartPos.Offset })
+	p.onDangling(dangling)
+}
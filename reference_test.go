@@ -0,0 +1,81 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Resolve_References_To_Earlier_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	reg.Register(SectionPlugin{Name: "edit-file"})
+	input := `<create-file id="F3" path="a.go">a</create-file><edit-file ref="F3">x</edit-file>` +
+		`<edit-file ref="F9">y</edit-file><write-file id="F3" path="b.go">b</write-file>` +
+		`<edit-file>no ref</edit-file><edit-file ref="F4">z</edit-file><write-file id="F4">c</write-file>`
+
+	var resolved, warnings, dangling []string
+	rec := &recorderSink{}
+	en := NewEngineWithOptions(reg,
+		WithReference("create-file", "id", "edit-file", "ref"),
+		WithReferenceResolver(func(use, def SectionEvent) {
+			resolved = append(resolved, use.Content+"->"+def.Attrs["path"])
+		}),
+		WithReferenceWarnings(func(err *ReferenceError) {
+			warnings = append(warnings, fmt.Sprintf("%s %s dup=%v", err.SectionName, err.ID, err.Duplicate))
+		}),
+		WithDanglingReferenceHandler(func(uses []SectionEvent) {
+			for _, ev := range uses {
+				dangling = append(dangling, ev.Content)
+			}
+		}),
+	)
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 7}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 7 {
+		t.Fatalf("warnings must keep sections, got %d events", len(rec.events))
+	}
+	if got := strings.Join(resolved, " "); got != "x->a.go" {
+		t.Fatalf("unexpected resolutions %q", got)
+	}
+	// F4 is defined after its use: a warning when used, but not dangling at the end.
+	if got := strings.Join(warnings, "|"); got != "edit-file F9 dup=false|write-file F3 dup=true|edit-file F4 dup=false" {
+		t.Fatalf("unexpected warnings %q", got)
+	}
+	if got := strings.Join(dangling, " "); got != "y" {
+		t.Fatalf("unexpected dangling uses %q", got)
+	}
+}
+
+func Test_Engine_Should_Fail_Unknown_References_Without_Warnings(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "edit-file"})
+	en := NewEngineWithOptions(reg, WithReference("create-file", "id", "edit-file", "ref"))
+
+	err := en.ProcessStream(strings.NewReader(`<create-file id="F1">a</create-file><edit-file ref="F2">x</edit-file>`), NewHandlerSink())
+	var rerr *ReferenceError
+	if !errors.As(err, &rerr) || rerr.ID != "F2" || rerr.Pos.Offset != 36 {
+		t.Fatalf("expected ReferenceError for F2, got %v", err)
+	}
+	b, _ := ErrorToJSON(err)
+	if !strings.Contains(string(b), `"kind":"reference"`) || !strings.Contains(string(b), `"id":"F2"`) {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+	if direct, _ := json.Marshal(rerr); string(direct) != string(b) {
+		t.Fatalf("MarshalJSON and ErrorToJSON disagree:\n%s\n%s", direct, b)
+	}
+
+	// Recovered, the use is dropped like a section that failed validation.
+	rec := &recorderSink{}
+	en = NewEngineWithOptions(reg, WithReference("create-file", "id", "edit-file", "ref"), WithContinueMode())
+	if err := en.ProcessStream(strings.NewReader(`<edit-file ref="F2">x</edit-file><create-file id="F2">a</create-file>`), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Name != "create-file" {
+		t.Fatalf("unexpected events %+v", rec.events)
+	}
+}
@@ -0,0 +1,83 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ErrRegistryConflict is returned by Registry.RegisterE for a plugin whose name or alias is
+// already taken by another plugin.
+var ErrRegistryConflict = errors.New("section name conflict")
+
+// ErrRegistryModified is returned by an engine built with RegistryFailOnChange when its
+// registry has changed since.
+var ErrRegistryModified = errors.New("registry modified after the engine was built")
+
+// Generation counts the plugins registered so far. Engines record it when they are built,
+// so comparing it tells whether an engine has seen every registration.
+func (r *Registry) Generation() uint64 { return r.gen }
+
+// clone copies the registry, for RegistrySnapshot.
+func (r *Registry) clone() *Registry {
+	return &Registry{canon: maps.Clone(r.canon), plugins: maps.Clone(r.plugins), conflicts: slices.Clone(r.conflicts), gen: r.gen}
+}
+
+// RegistryConflict records a name (canonical or alias) that one plugin took over from another.
+type RegistryConflict struct {
+	Name     string // the contested name, lowercased
+	Previous string // canonical name of the plugin that had it
+	Plugin   string // canonical name of the plugin that has it now
+}
+
+func (c RegistryConflict) String() string {
+	return fmt.Sprintf("%q belonged to section %q and was taken over by %q", c.Name, c.Previous, c.Plugin)
+}
+
+// RegisterE is Register, but fails instead of registering a plugin whose name or alias is
+// reserved (ErrReservedSection, see IsSynthetic) or already used by another plugin
+// (ErrRegistryConflict).
+func (r *Registry) RegisterE(p SectionPlugin) error {
+	if err := checkReserved(p); err != nil {
+		return err
+	}
+	if conflicts := r.collisions(p); len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s", ErrRegistryConflict, conflicts[0])
+	}
+	r.register(p)
+	return nil
+}
+
+// Conflicts returns the collisions Register resolved by letting the later plugin win, in
+// registration order. An empty result means every name maps to the plugin that declared it.
+func (r *Registry) Conflicts() []RegistryConflict {
+	return append([]RegistryConflict(nil), r.conflicts...)
+}
+
+// collisions lists the names of p already mapped to a different plugin.
+func (r *Registry) collisions(p SectionPlugin) []RegistryConflict {
+	if p.Name == "" {
+		return nil
+	}
+	canon := strings.ToLower(p.Name)
+	var out []RegistryConflict
+	for _, name := range append([]string{p.Name}, p.Aliases...) {
+		name = strings.ToLower(name)
+		if prev, ok := r.canon[name]; ok && name != "" && prev != canon {
+			out = append(out, RegistryConflict{Name: name, Previous: prev, Plugin: canon})
+		}
+	}
+	return out
+}
+
+// checkReserved fails with ErrReservedSection if p uses a reserved name.
+func checkReserved(p SectionPlugin) error {
+	for _, name := range append([]string{p.Name}, p.Aliases...) {
+		if IsSynthetic(name) {
+			return fmt.Errorf("%w: %q cannot be registered as a section", ErrReservedSection, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Registry_Should_Record_Alias_Collisions(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "file"})
+	reg.Register(SectionPlugin{Name: "patch", Aliases: []string{"diff"}})
+	reg.Register(SectionPlugin{Name: "write", Aliases: []string{"File", "create"}})
+	reg.Register(SectionPlugin{Name: "edit", Aliases: []string{"diff"}})
+	reg.Register(SectionPlugin{Name: "write", Aliases: []string{"create"}}) // same plugin again
+
+	want := []RegistryConflict{
+		{Name: "file", Previous: "file", Plugin: "write"},
+		{Name: "diff", Previous: "patch", Plugin: "edit"},
+	}
+	got := reg.Conflicts()
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("unexpected conflicts %+v", got)
+	}
+	if c, _ := reg.Canonical("file"); c != "write" {
+		t.Fatalf("Register must keep last-wins, file maps to %q", c)
+	}
+}
+
+func Test_Registry_RegisterE_Should_Refuse_Collisions(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.RegisterE(SectionPlugin{Name: "file"}); err != nil {
+		t.Fatal(err)
+	}
+	err := reg.RegisterE(SectionPlugin{Name: "write", Aliases: []string{"file"}})
+	if !errors.Is(err, ErrRegistryConflict) {
+		t.Fatalf("expected ErrRegistryConflict, got %v", err)
+	}
+	if c, _ := reg.Canonical("file"); c != "file" || reg.IsAllowed("write") || len(reg.Conflicts()) != 0 {
+		t.Fatal("a refused plugin must leave the registry untouched")
+	}
+}
+
+func Test_Engine_Should_Report_The_Alias_Used(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file", "dyad-write"}})
+	input := `<Create-File path="a">x</dyad-write><write-file path="b"/><dyad-write>y</dyad-write>`
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	var used []string
+	for _, ev := range rec.events {
+		sev := ev.(SectionEvent)
+		if sev.Name != "write-file" {
+			t.Fatalf("unexpected name %q", sev.Name)
+		}
+		used = append(used, sev.AliasUsed)
+	}
+	if got := strings.Join(used, ","); got != "Create-File,write-file,dyad-write" {
+		t.Fatalf("unexpected aliases %q", got)
+	}
+}
+
+func Test_Engine_Should_Deliver_Names_As_Registered_With_OriginalNameCasing(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "CreateFile", Aliases: []string{"create-file"}})
+	reg.Register(SectionPlugin{Name: "Summary"})
+	if name, ok := reg.RegisteredName("CREATE-FILE"); !ok || name != "CreateFile" {
+		t.Fatalf("got %q, %v", name, ok)
+	}
+	if c, _ := reg.Canonical("CreateFile"); c != "createfile" {
+		t.Fatalf("canonical names stay lowercase, got %q", c)
+	}
+
+	input := `<create-file id="1">x</create-file><summary>done</summary><CREATEFILE id="1"/>`
+	var names []string
+	sink := NewHandlerSink()
+	sink.RegisterHandler("createfile", func(ev SectionEvent) { names = append(names, ev.Name) })
+	sink.RegisterHandler("SUMMARY", func(ev SectionEvent) { names = append(names, ev.Name) })
+	en := NewEngineWithOptions(reg, WithOriginalNameCasing(true), WithPairing("CreateFile", "CreateFile", "id"))
+	if err := en.ProcessStream(strings.NewReader(input), sink); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[CreateFile Summary CreateFile]" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	rec := &recorderSink{}
+	if err := en.ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatal(err)
+	}
+	paired, ok := rec.events[len(rec.events)-1].(PairedEvent)
+	if !ok || paired.Open.Name != "CreateFile" || paired.Close.Name != "CreateFile" {
+		t.Fatalf("unexpected last event %+v", rec.events[len(rec.events)-1])
+	}
+
+	// By default events carry the canonical name.
+	names = nil
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(input), sink); err != nil || fmt.Sprint(names) != "[createfile summary createfile]" {
+		t.Fatalf("got %v, %v", err, names)
+	}
+}
+
+func Test_Engine_Should_Apply_The_Registry_Sync_Policy(t *testing.T) {
+	input := `<think>a</think><plan/>`
+	run := func(en *Engine) ([]string, error) {
+		rec := &recorderSink{}
+		err := en.ProcessStream(strings.NewReader(input), rec)
+		var names []string
+		for _, ev := range rec.events {
+			names = append(names, ev.(SectionEvent).Name)
+		}
+		return names, err
+	}
+
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	gen := reg.Generation()
+	strict := NewEngineWithOptions(reg, WithRegistrySync(RegistryFailOnChange))
+	live := NewEngine(reg)
+	if names, err := run(strict); err != nil || fmt.Sprint(names) != "[think]" {
+		t.Fatalf("got %v, %v", names, err)
+	}
+
+	reg.Register(SectionPlugin{Name: "plan"})
+	if reg.Generation() != gen+1 {
+		t.Fatalf("generation %d after %d", reg.Generation(), gen)
+	}
+	if _, err := run(strict); !errors.Is(err, ErrRegistryModified) {
+		t.Fatalf("expected ErrRegistryModified, got %v", err)
+	}
+	if names, err := run(live); err != nil || fmt.Sprint(names) != "[think plan]" {
+		t.Fatalf("got %v, %v", names, err)
+	}
+
+	// A snapshot is taken per call: a registration made mid-stream applies to the next one.
+	snap := NewEngineWithOptions(reg, WithRegistrySync(RegistrySnapshot))
+	sink := NewHandlerSink()
+	var names []string
+	sink.RegisterFallbackHandler(func(ev Event) {
+		sev := ev.(SectionEvent)
+		names = append(names, sev.Name)
+		if sev.Name == "think" {
+			reg.Register(SectionPlugin{Name: "summary"})
+		}
+	})
+	input = `<think>a</think><summary/>`
+	if err := snap.ProcessStream(strings.NewReader(input), sink); err != nil || fmt.Sprint(names) != "[think]" {
+		t.Fatalf("got %v, %v", names, err)
+	}
+	names = nil
+	if err := snap.ProcessStream(strings.NewReader(input), sink); err != nil || fmt.Sprint(names) != "[think summary]" {
+		t.Fatalf("got %v, %v", names, err)
+	}
+}
@@ -0,0 +1,94 @@
+package promptweaver
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteEvents writes events back as canonical text, one per line: sections as
+// SectionEvent.Render writes them and code blocks as fences. Parsing the output with the
+// same engine yields the same sections and code blocks, apart from positions. Other event
+// kinds are derived from those and are skipped. PlainText sections (see
+// EngineOptions.PlainText) are written verbatim; when there are any, they carry the layout
+// and sections are not put on lines of their own. It returns the number of bytes written.
+func WriteEvents(w io.Writer, events []Event) (int64, error) {
+	sep := "\n"
+	for _, ev := range events {
+		if sev, ok := ev.(SectionEvent); ok && sev.Name == SectionPlainText {
+			sep = ""
+			break
+		}
+	}
+	var n int64
+	for i, ev := range events {
+		var s string
+		switch ev := ev.(type) {
+		case SectionEvent:
+			if ev.Name == SectionPlainText {
+				s = ev.Content
+			} else {
+				s = ev.Render() + sep
+			}
+		case CodeBlockEvent:
+			s = renderCodeBlock(ev)
+			if next, ok := nextWritten(events[i+1:]).(SectionEvent); ok && next.Name == SectionPlainText && strings.HasPrefix(next.Content, "\n") {
+				// The text after the fence starts with the newline that ends its line.
+				s = strings.TrimSuffix(s, "\n")
+			}
+		default:
+			continue
+		}
+		m, err := io.WriteString(w, s)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// nextWritten returns the first of events that WriteEvents writes, or nil.
+func nextWritten(events []Event) Event {
+	for _, ev := range events {
+		switch ev.(type) {
+		case SectionEvent, CodeBlockEvent:
+			return ev
+		}
+	}
+	return nil
+}
+
+// renderCodeBlock writes a fence longer than any backtick run starting a content line, so
+// the content cannot close it early.
+func renderCodeBlock(ev CodeBlockEvent) string {
+	width := 3
+	for _, line := range strings.Split(ev.Content, "\n") {
+		line = strings.TrimLeft(line, " ")
+		width = max(width, len(line)-len(strings.TrimLeft(line, "`"))+1)
+	}
+	fence := strings.Repeat("`", width)
+
+	var b strings.Builder
+	b.WriteString(fence + ev.Lang)
+	keys := make([]string, 0, len(ev.Meta))
+	for k := range ev.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := ev.Meta[k]
+		switch {
+		case strings.Contains(v, `"`):
+			b.WriteString(" " + k + "='" + v + "'")
+		default:
+			b.WriteString(" " + k + `="` + v + `"`)
+		}
+	}
+	b.WriteString("\n" + ev.Content)
+	if ev.Content != "" && !strings.HasSuffix(ev.Content, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(fence + "\n")
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_WriteEvents_Should_Round_Trip_Sections_And_Code_Blocks(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "note"})
+	en := NewEngineWithOptions(reg, WithCodeBlocks())
+	input := "<note id=\"1\" q='say \"hi\"'>body</note>\n<note/>\n" +
+		"````md file=\"a b.md\" draft\n```go\nx := 1\n```\n````\n"
+
+	parse := func(s string) []Event {
+		rec := &recorderSink{}
+		if err := en.ProcessStream(strings.NewReader(s), rec); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		return rec.events
+	}
+	strip := func(events []Event) string {
+		var out []string
+		for _, ev := range events {
+			switch e := ev.(type) {
+			case SectionEvent:
+				e.EventBase = EventBase{}
+				ev = e
+			case CodeBlockEvent:
+				e.EventBase = EventBase{}
+				ev = e
+			}
+			b, _ := json.Marshal(ev)
+			out = append(out, string(b))
+		}
+		return strings.Join(out, "\n")
+	}
+
+	first := parse(input)
+	var b strings.Builder
+	n, err := WriteEvents(&b, first)
+	if err != nil || n != int64(b.Len()) {
+		t.Fatalf("WriteEvents = %d, %v for %d bytes", n, err, b.Len())
+	}
+	if got, want := strip(parse(b.String())), strip(first); got != want || len(first) != 3 {
+		t.Fatalf("round trip changed events:\n%s\nwant\n%s\ntext:\n%s", got, want, b.String())
+	}
+}
+
+func Test_WriteEvents_Should_Round_Trip_Plain_Text(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	en := NewEngineWithOptions(reg, WithCodeBlocks(), WithPlainText(PlainTextOptions{}))
+	input := "hello <think>x</think> bye\n```go\nx := 1\n```\nafter"
+
+	parse := func(s string) []Event {
+		rec := &recorderSink{}
+		if err := en.ProcessStream(strings.NewReader(s), rec); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		return rec.events
+	}
+	var b strings.Builder
+	if _, err := WriteEvents(&b, parse(input)); err != nil {
+		t.Fatalf("WriteEvents error: %v", err)
+	}
+	if b.String() != input {
+		t.Fatalf("round trip changed the text:\n%q\nwant\n%q", b.String(), input)
+	}
+}
@@ -0,0 +1,184 @@
+package promptweaver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ChunkTiming describes one read of a captured stream: how many bytes arrived and how long
+// after the previous read (or the start of the stream) they did.
+type ChunkTiming struct {
+	Size  int           `json:"size"`
+	Delay time.Duration `json:"delay_ns"`
+}
+
+// capture tees the raw stream into the capture writers and the digest, as it arrives.
+type capture struct {
+	data    io.Writer     // raw bytes; may be nil
+	timings io.Writer     // one ChunkTiming per line; may be nil
+	digest  *streamDigest // may be nil
+	now     func() time.Time
+	last    time.Time
+
+	mu      sync.Mutex // a read abandoned at a section timeout may record concurrently
+	stopped bool       // the stream is over; later reads are not recorded
+}
+
+// newCapture returns nil when options ask for neither capture nor a digest.
+func newCapture(options EngineOptions, now func() time.Time) *capture {
+	digest := newStreamDigest(options)
+	if options.RawCapture == nil && options.TimingCapture == nil && digest == nil {
+		return nil
+	}
+	return &capture{data: options.RawCapture, timings: options.TimingCapture, digest: digest, now: now, last: now()}
+}
+
+// record captures one chunk of the stream.
+func (c *capture) record(b []byte) error {
+	if c == nil || len(b) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	if c.digest != nil {
+		c.digest.Write(b)
+	}
+	if c.data != nil {
+		if _, err := c.data.Write(b); err != nil {
+			return fmt.Errorf("raw capture: %w", err)
+		}
+	}
+	if c.timings != nil {
+		at := c.now()
+		line, _ := json.Marshal(ChunkTiming{Size: len(b), Delay: at.Sub(c.last)})
+		c.last = at
+		if _, err := c.timings.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("timing capture: %w", err)
+		}
+	}
+	return nil
+}
+
+// stop makes record drop everything from now on.
+func (c *capture) stop() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+}
+
+func (c *capture) digestOf() *streamDigest {
+	if c == nil {
+		return nil
+	}
+	return c.digest
+}
+
+// reader returns r with every read recorded.
+func (c *capture) reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &captureReader{r: r, c: c}
+}
+
+// captureReader records every read of the underlying reader.
+type captureReader struct {
+	r io.Reader
+	c *capture
+}
+
+func (cr *captureReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if cerr := cr.c.record(p[:n]); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+// ReadChunkTimings parses a timing capture written by WithTimingCapture.
+func ReadChunkTimings(r io.Reader) ([]ChunkTiming, error) {
+	var out []ChunkTiming
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var t ChunkTiming
+		if err := json.Unmarshal(sc.Bytes(), &t); err != nil {
+			return nil, fmt.Errorf("chunk timings line %d: %w", line, err)
+		}
+		out = append(out, t)
+	}
+	return out, sc.Err()
+}
+
+// TimedReader delivers r in the chunk sizes of a schedule, waiting each chunk's Delay first.
+// Reads past the end of the schedule pass straight through to r.
+type TimedReader struct {
+	r        io.Reader
+	schedule []ChunkTiming
+	left     int // bytes of the current chunk not yet delivered
+	sleep    func(time.Duration)
+}
+
+// NewTimedReader returns a reader that replays r with the given pacing.
+func NewTimedReader(r io.Reader, schedule []ChunkTiming) *TimedReader {
+	return &TimedReader{r: r, schedule: schedule, sleep: time.Sleep}
+}
+
+// Read implements io.Reader. A chunk larger than p is delivered over several reads,
+// without waiting again.
+func (t *TimedReader) Read(p []byte) (int, error) {
+	if t.left == 0 {
+		if len(t.schedule) == 0 {
+			return t.r.Read(p)
+		}
+		next := t.schedule[0]
+		t.schedule = t.schedule[1:]
+		if next.Delay > 0 {
+			t.sleep(next.Delay)
+		}
+		t.left = next.Size
+		if t.left <= 0 {
+			return 0, nil
+		}
+	}
+	n, err := io.ReadFull(t.r, p[:min(len(p), t.left)])
+	t.left -= n
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Replay reproduces a stream captured with WithRawCapture and WithTimingCapture. speed scales
+// the pacing: 1 is real time, 2 twice as fast; zero or less replays without waiting, keeping
+// only the chunk boundaries. A malformed timing capture is reported by the first Read.
+func Replay(capture, timings io.Reader, speed float64) io.Reader {
+	schedule, err := ReadChunkTimings(timings)
+	if err != nil {
+		return &errReader{err: err}
+	}
+	for i := range schedule {
+		if speed > 0 {
+			schedule[i].Delay = time.Duration(float64(schedule[i].Delay) / speed)
+		} else {
+			schedule[i].Delay = 0
+		}
+	}
+	return NewTimedReader(capture, schedule)
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
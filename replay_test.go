@@ -0,0 +1,77 @@
+package promptweaver
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Engine_Should_Capture_And_Replay_Stream(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "hi <summary>one</summary>\r\n<summary>two</summary>!!!"
+
+	clock := time.Unix(0, 0)
+	var raw, timings bytes.Buffer
+	en := NewEngineWithOptions(reg,
+		WithClock(func() time.Time { clock = clock.Add(10 * time.Millisecond); return clock }),
+		WithRawCapture(&raw),
+		WithTimingCapture(&timings),
+	)
+	want := &recorderSink{}
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 7}, want); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if raw.String() != input {
+		t.Fatalf("capture must hold the exact bytes, got %q", raw.String())
+	}
+	schedule, err := ReadChunkTimings(bytes.NewReader(timings.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadChunkTimings error: %v", err)
+	}
+	if len(schedule) != 8 || schedule[0].Size != 7 || schedule[7].Size != 3 || schedule[1].Delay <= 0 {
+		t.Fatalf("unexpected schedule %+v", schedule)
+	}
+
+	replay := Replay(&raw, &timings, 2).(*TimedReader)
+	var slept []time.Duration
+	replay.sleep = func(d time.Duration) { slept = append(slept, d) }
+	var sizes []int
+	got := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(sizeRecorder{replay, &sizes}, got); err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if !reflect.DeepEqual(got.events, want.events) {
+		t.Fatalf("replay diverged:\ngot  %+v\nwant %+v", got.events, want.events)
+	}
+	if !reflect.DeepEqual(sizes, []int{7, 7, 7, 7, 7, 7, 7, 3}) {
+		t.Fatalf("replay must keep chunk boundaries, got %v", sizes)
+	}
+	if len(slept) != 8 || slept[1] != schedule[1].Delay/2 {
+		t.Fatalf("expected delays halved at speed 2, got %v", slept)
+	}
+}
+
+func Test_Replay_Should_Report_Malformed_Timings(t *testing.T) {
+	_, err := io.ReadAll(Replay(strings.NewReader("x"), strings.NewReader("{\"size\":1}\nnope\n"), 1))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected a line 2 error, got %v", err)
+	}
+}
+
+// sizeRecorder records the size of every non-empty read.
+type sizeRecorder struct {
+	r     io.Reader
+	sizes *[]int
+}
+
+func (s sizeRecorder) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		*s.sizes = append(*s.sizes, n)
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package promptweaver
+
+import "strings"
+
+// rescueOrphans takes the complete registered sections out of the body of el, a section
+// cut off by EOF, and emits them on their own, marked Rescued, before el itself. It is the
+// heuristic behind EngineOptions.OrphanRescue: a <think> whose closer never came should not
+// swallow the <summary>…</summary> written after it.
+func (p *parser) rescueOrphans(el *element) error {
+	if el.suppress || el.truncated() {
+		return nil
+	}
+	body := el.body.String()
+	if !strings.Contains(body, "<") {
+		return nil
+	}
+
+	var found []*element
+	inner := newParser(p.reg, EventSinkFunc(func(Event) {}), EngineOptions{
+		RecoveryMode:       ContinueMode,
+		EOFPolicy:          DropPartial,
+		IncludeRawEnvelope: p.rawEnvelope,
+	})
+	inner.rescued = &found
+	inner.feed([]byte(body))
+	if err := inner.drain(false); err != nil {
+		return err
+	}
+	if err := inner.finish(); err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	base := el.bodyStart
+	var rest strings.Builder
+	cut := 0
+	for _, r := range found {
+		rest.WriteString(body[cut:r.start.Offset])
+		cut = int(r.end.Offset)
+		r.start, r.end = rebase(r.start, base), rebase(r.end, base)
+		if r.bodyStart != (Position{}) {
+			r.bodyStart = rebase(r.bodyStart, base)
+		}
+		r.attrs = p.inheritAttrs(r.attrs)
+		r.rescued = true
+		if err := p.closeSection(r, false); err != nil {
+			return err
+		}
+	}
+	rest.WriteString(body[cut:])
+	el.body.Reset()
+	el.body.WriteString(rest.String())
+	return nil
+}
@@ -0,0 +1,53 @@
+package promptweaver
+
+import "testing"
+
+func Test_Engine_Should_Rescue_Sections_From_An_Unclosed_Section(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "<think>pondering\n<summary>done</summary>\nmore<summary>half"
+
+	run := func(opts ...Option) []Event {
+		rec := &recorderSink{}
+		if err := NewEngineWithOptions(reg, opts...).ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, rec); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		return rec.events
+	}
+
+	if evs := run(); len(evs) != 1 || evs[0].(SectionEvent).Content != "pondering\n<summary>done</summary>\nmore<summary>half" {
+		t.Fatalf("without rescue the summary must stay in think, got %+v", evs)
+	}
+
+	evs := run(WithOrphanRescue(true))
+	if len(evs) != 2 {
+		t.Fatalf("expected the rescued summary and think, got %+v", evs)
+	}
+	summary, think := evs[0].(SectionEvent), evs[1].(SectionEvent)
+	if summary.Name != "summary" || summary.Content != "done" || !summary.Rescued {
+		t.Fatalf("unexpected rescued section %+v", summary)
+	}
+	if span := input[summary.StartPos.Offset:summary.EndPos.Offset]; span != "<summary>done</summary>" ||
+		summary.StartPos != (Position{Line: 2, Column: 1, Offset: 17}) {
+		t.Fatalf("rescued section must keep its place in the stream, got %q at %v", span, summary.StartPos)
+	}
+	// The incomplete trailing summary is not rescued and stays in think.
+	if think.Name != "think" || think.Rescued || think.Content != "pondering\n\nmore<summary>half" {
+		t.Fatalf("unexpected partial think %+v", think)
+	}
+}
+
+func Test_Engine_Should_Not_Rescue_From_Closed_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	rec := &recorderSink{}
+	input := "<think>a<summary>done</summary>b</think>"
+	if err := NewEngineWithOptions(reg, WithOrphanRescue(true)).ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Content != "a<summary>done</summary>b" {
+		t.Fatalf("flat mode must be kept for closed sections, got %+v", rec.events)
+	}
+}
@@ -0,0 +1,29 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+)
+
+// Names reserved for sections the engine synthesizes rather than parses from tags. Compare
+// SectionEvent.Name against these instead of spelling the strings out. A plugin may not
+// use them as its name or an alias.
+const (
+	SectionPlainText   = "PlainText"
+	SectionCodeBlock   = "CodeBlock"
+	SectionUnknown     = "Unknown"
+	SectionFrontMatter = "FrontMatter"
+)
+
+// ErrReservedSection is returned by Registry.RegisterE for plugins that use a reserved name.
+var ErrReservedSection = errors.New("reserved section name")
+
+var reservedSections = map[string]bool{
+	strings.ToLower(SectionPlainText):   true,
+	strings.ToLower(SectionCodeBlock):   true,
+	strings.ToLower(SectionUnknown):     true,
+	strings.ToLower(SectionFrontMatter): true,
+}
+
+// IsSynthetic reports whether name, in any case, is reserved for synthesized sections.
+func IsSynthetic(name string) bool { return reservedSections[strings.ToLower(name)] }
@@ -0,0 +1,94 @@
+package promptweaver
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Registry_Should_Reject_Reserved_Section_Names(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.RegisterE(SectionPlugin{Name: "plaintext"}); !errors.Is(err, ErrReservedSection) {
+		t.Fatalf("expected ErrReservedSection, got %v", err)
+	}
+	if err := reg.RegisterE(SectionPlugin{Name: "notes", Aliases: []string{"FrontMatter"}}); !errors.Is(err, ErrReservedSection) {
+		t.Fatalf("expected ErrReservedSection for an alias, got %v", err)
+	}
+	if reg.IsAllowed("notes") || reg.IsAllowed("plaintext") {
+		t.Fatal("rejected plugins must not be registered")
+	}
+	if err := reg.RegisterE(SectionPlugin{Name: "notes"}); err != nil || !reg.IsAllowed("notes") {
+		t.Fatalf("expected notes to register, got %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), `"CodeBlock"`) {
+			t.Fatalf("expected Register to panic naming the section, got %v", r)
+		}
+	}()
+	reg.Register(SectionPlugin{Name: SectionCodeBlock})
+}
+
+func Test_IsSynthetic_Should_Ignore_Case(t *testing.T) {
+	for _, name := range []string{SectionPlainText, "codeblock", "UNKNOWN", SectionFrontMatter} {
+		if !IsSynthetic(name) {
+			t.Errorf("%q should be synthetic", name)
+		}
+	}
+	if IsSynthetic("think") {
+		t.Error("think is not synthetic")
+	}
+}
+
+func Test_HandlerSink_Should_Route_Synthetic_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	sink := NewHandlerSinkFor(reg)
+	var got string
+	sink.RegisterHandler(SectionPlainText, func(ev SectionEvent) { got = ev.Content })
+	sink.Emit(SectionEvent{Name: SectionPlainText, Content: "hello"})
+	if got != "hello" {
+		t.Fatalf("expected the PlainText handler to run, got %q", got)
+	}
+}
+
+func Test_HandlerSink_Should_Route_CodeBlocks_By_Type_Not_Name(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "code_block"})
+	reg.Register(SectionPlugin{Name: "think"})
+	sink := NewHandlerSinkFor(reg)
+	var sections, blocks, rest []string
+	sink.RegisterHandler("code_block", func(ev SectionEvent) { sections = append(sections, ev.Content) })
+	sink.RegisterCodeBlockHandler(func(ev CodeBlockEvent) { blocks = append(blocks, ev.Lang+":"+ev.Content) })
+	sink.RegisterFallbackHandler(func(ev Event) {
+		if sev, ok := ev.(SectionEvent); ok {
+			rest = append(rest, "section "+sev.Name)
+			return
+		}
+		rest = append(rest, string(ev.Kind()))
+	})
+
+	input := "<code_block>tag</code_block>\n```go\nx := 1\n```\n<think>hm</think>"
+	if err := NewEngineWithOptions(reg, WithCodeBlocks()).ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if !reflect.DeepEqual(sections, []string{"tag"}) {
+		t.Fatalf("expected only the section for the code_block handler, got %q", sections)
+	}
+	if !reflect.DeepEqual(blocks, []string{"go:x := 1\n"}) {
+		t.Fatalf("expected only the fenced block for the typed handler, got %q", blocks)
+	}
+	if want := []string{"section think"}; !reflect.DeepEqual(rest, want) {
+		t.Fatalf("expected the fallback handler to get %q, got %q", want, rest)
+	}
+
+	sink.RegisterCodeBlockHandler(nil)
+	rest = nil
+	if err := NewEngineWithOptions(reg, WithCodeBlocks()).ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if want := []string{"code_block", "section think"}; !reflect.DeepEqual(rest, want) {
+		t.Fatalf("expected the fallback handler to get %q, got %q", want, rest)
+	}
+}
@@ -0,0 +1,85 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// RetryPolicy lets the read loop retry reads that fail with a transient error, such as a
+// network timeout. Parser state is kept across retries and no byte is fed twice.
+type RetryPolicy struct {
+	// MaxAttempts is how many times one failing read is retried. A successful read starts
+	// the count again. Zero disables retries.
+	MaxAttempts int
+
+	// Backoff returns how long to wait before retry attempt (from 1). Nil retries at once.
+	Backoff func(attempt int) time.Duration
+
+	// Retryable reports whether err is worth retrying. Nil retries net.Errors that time out.
+	Retryable func(err error) bool
+
+	// OnRetry, if set, is called before each retry, e.g. to count retries in metrics.
+	OnRetry func(attempt int, err error)
+}
+
+// ReadRetryError is returned when a read still fails after RetryPolicy.MaxAttempts retries.
+type ReadRetryError struct {
+	Attempts int   // retries made
+	Err      error // the last read error
+}
+
+func (e *ReadRetryError) Error() string {
+	return fmt.Sprintf("read failed after %d retries: %v", e.Attempts, e.Err)
+}
+
+func (e *ReadRetryError) Unwrap() error { return e.Err }
+
+// ExponentialBackoff returns a Backoff that doubles from base up to max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+func (rp RetryPolicy) retryable(err error) bool {
+	if rp.Retryable != nil {
+		return rp.Retryable(err)
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// retry decides what to do after the attempt-th consecutive failed read: it returns nil
+// once the backoff has passed and the read should be tried again, or the error to stop with.
+func (rp RetryPolicy) retry(ctx context.Context, attempt int, err error) error {
+	if rp.MaxAttempts <= 0 || !rp.retryable(err) {
+		return err
+	}
+	if attempt > rp.MaxAttempts {
+		return &ReadRetryError{Attempts: rp.MaxAttempts, Err: err}
+	}
+	if rp.OnRetry != nil {
+		rp.OnRetry(attempt, err)
+	}
+	if rp.Backoff == nil {
+		return nil
+	}
+	timer := time.NewTimer(rp.Backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
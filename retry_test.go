@@ -0,0 +1,159 @@
+package promptweaver
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// flakyReader serves data in chunks, failing before every nth chunk (fails times in a row).
+type flakyReader struct {
+	data  []byte
+	chunk int
+	every int
+	fails int
+	err   error
+
+	reads, failed int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if r.reads++; r.reads%r.every == 0 && r.failed < r.fails {
+		r.failed++
+		r.reads--
+		return 0, r.err
+	}
+	r.failed = 0
+	n := copy(p[:min(len(p), r.chunk)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func Test_Engine_Should_Retry_Transient_Read_Errors(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "<think>a long thought</think><summary>done</summary>"
+
+	var retries []int
+	en := NewEngineWithOptions(reg, WithReadRetry(RetryPolicy{
+		MaxAttempts: 2,
+		OnRetry:     func(attempt int, err error) { retries = append(retries, attempt) },
+	}))
+	rec := &recorderSink{}
+	r := &flakyReader{data: []byte(input), chunk: 5, every: 3, fails: 2, err: timeoutErr{}}
+	if err := en.ProcessStream(r, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 2 || rec.events[0].(SectionEvent).Content != "a long thought" ||
+		rec.events[1].(SectionEvent).Content != "done" {
+		t.Fatalf("bytes must be fed exactly once, got %+v", rec.events)
+	}
+	if len(retries) == 0 || retries[0] != 1 || retries[1] != 2 {
+		t.Fatalf("expected retries counted per failing read, got %v", retries)
+	}
+}
+
+func Test_Engine_Should_Give_Up_After_MaxAttempts(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	r := &flakyReader{data: []byte("<think>x</think>"), chunk: 4, every: 2, fails: 5, err: timeoutErr{}}
+	en := NewEngineWithOptions(reg, WithReadRetry(RetryPolicy{MaxAttempts: 3, Backoff: ExponentialBackoff(time.Microsecond, time.Millisecond)}))
+
+	err := en.ProcessStream(r, NewHandlerSink())
+	var retryErr *ReadRetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || !errors.Is(err, timeoutErr{}) {
+		t.Fatalf("expected a ReadRetryError wrapping the timeout, got %v", err)
+	}
+
+	// Errors the policy does not classify as transient fail at once.
+	boom := errors.New("connection reset")
+	r = &flakyReader{data: []byte("<think>x</think>"), chunk: 4, every: 2, fails: 1, err: boom}
+	if err := en.ProcessStream(r, NewHandlerSink()); err != boom {
+		t.Fatalf("expected the raw error, got %v", err)
+	}
+}
+
+func Test_ExponentialBackoff_Should_Double_Up_To_Max(t *testing.T) {
+	b := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 4: 50 * time.Millisecond} {
+		if got := b(attempt); got != want {
+			t.Errorf("attempt %d: got %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+// stallingReader returns (0, nil) stalls times before each chunk of data, and forever once
+// the data is gone if stuck is set.
+type stallingReader struct {
+	data   []byte
+	chunk  int
+	stalls int
+	stuck  bool
+
+	stalled, empty int
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 && !r.stuck {
+		return 0, io.EOF
+	}
+	if len(r.data) == 0 || r.stalled < r.stalls {
+		r.stalled++
+		r.empty++
+		return 0, nil
+	}
+	r.stalled = 0
+	n := copy(p[:min(len(p), r.chunk)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func Test_Engine_Should_Tolerate_Occasional_Empty_Reads(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	r := &stallingReader{data: []byte("<summary>all done</summary>"), chunk: 4, stalls: 50}
+
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(r, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Content != "all done" {
+		t.Fatalf("unexpected events %+v", rec.events)
+	}
+}
+
+func Test_Engine_Should_Fail_On_A_Reader_That_Never_Makes_Progress(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	r := &stallingReader{data: []byte("<summary>x"), chunk: 64, stuck: true}
+
+	err := NewEngineWithOptions(reg, WithMaxEmptyReads(10)).ProcessStream(r, &recorderSink{})
+	if !errors.Is(err, io.ErrNoProgress) || r.empty != 11 {
+		t.Fatalf("expected io.ErrNoProgress after 11 empty reads, got %v after %d", err, r.empty)
+	}
+
+	// With a retry policy that accepts it, the engine backs off before reading again.
+	r = &stallingReader{data: []byte("<summary>x"), chunk: 64, stuck: true}
+	var retries int
+	policy := RetryPolicy{
+		MaxAttempts: 2,
+		Retryable:   func(err error) bool { return errors.Is(err, io.ErrNoProgress) },
+		OnRetry:     func(int, error) { retries++ },
+	}
+	err = NewEngineWithOptions(reg, WithMaxEmptyReads(10), WithReadRetry(policy)).ProcessStream(r, &recorderSink{})
+	var retryErr *ReadRetryError
+	if !errors.As(err, &retryErr) || !errors.Is(err, io.ErrNoProgress) || retries != 2 || r.empty != 33 {
+		t.Fatalf("expected a ReadRetryError after 2 retries, got %v after %d retries, %d reads", err, retries, r.empty)
+	}
+}
@@ -0,0 +1,80 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// SupersededEvent follows a section that revises an earlier one of the stream (see
+// EngineOptions.RevisionAttr), so that consumers can undo or replace what the earlier one
+// did. It spans the revision.
+type SupersededEvent struct {
+	EventBase
+	Superseded int64        `json:"superseded"` // Seq of the earlier section
+	Name       string       `json:"name"`       // name of the earlier section
+	By         SectionEvent `json:"by"`         // the revision
+}
+
+// Kind implements Event.
+func (SupersededEvent) Kind() EventKind { return KindSuperseded }
+
+func (ev SupersededEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// MarshalJSON adds the "kind" field so that serialized events are self-describing.
+func (ev SupersededEvent) MarshalJSON() ([]byte, error) {
+	type plain SupersededEvent
+	return json.Marshal(struct {
+		Kind EventKind `json:"kind"`
+		plain
+	}{ev.Kind(), plain(ev)})
+}
+
+// AsSuperseded returns ev as a SupersededEvent, if it is one.
+func AsSuperseded(ev Event) (SupersededEvent, bool) {
+	sev, ok := ev.(SupersededEvent)
+	return sev, ok
+}
+
+// revisions tracks the sections emitted so far, by Seq, for EngineOptions.RevisionAttr.
+type revisions struct {
+	attr     string
+	sections map[int64]string // Seq -> canonical name
+	warn     func(*ReferenceError)
+}
+
+func newRevisions(options EngineOptions) *revisions {
+	if options.RevisionAttr == "" {
+		return nil
+	}
+	return &revisions{attr: options.RevisionAttr, sections: map[int64]string{}, warn: options.RevisionWarnings}
+}
+
+// revise records ev, which has its Seq, and sets Supersedes if it revises an earlier
+// section. A revision of anything else is reported to the RevisionWarnings handler and
+// otherwise ignored.
+func (p *parser) revise(ev SectionEvent) SectionEvent {
+	rv := p.revisions
+	rv.sections[ev.Seq] = ev.Name
+	v, ok := ev.Attr(rv.attr)
+	if !ok {
+		return ev
+	}
+	seq, err := strconv.ParseInt(v, 10, 64)
+	var why string
+	switch _, known := rv.sections[seq]; {
+	case err != nil:
+		why = "is not an event number"
+	case seq >= ev.Seq:
+		why = "refers to a later event"
+	case !known:
+		why = "refers to no earlier section"
+	default:
+		ev.Supersedes = seq
+		return ev
+	}
+	if rv.warn != nil {
+		rv.warn(newReferenceError(ev, rv.attr, v, fmt.Sprintf("<%s %s=%q> %s", ev.Name, rv.attr, v, why)))
+	}
+	return ev
+}
@@ -0,0 +1,62 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Mark_Revisions_And_Emit_Superseded_Events(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := `<write-file path="a">v1</write-file>` +
+		`<summary>s</summary>` +
+		`<write-file path="a" revises="1">v2</write-file>` +
+		`<write-file revises="9">ahead</write-file>` +
+		`<summary revises="x">y</summary>`
+
+	var warnings []string
+	en := NewEngineWithOptions(reg, WithRevisions("revises", func(err *ReferenceError) {
+		warnings = append(warnings, err.Message)
+	}))
+	rec := &recorderSink{}
+	if err := en.ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range rec.events {
+		switch e := ev.(type) {
+		case SectionEvent:
+			got = append(got, fmt.Sprintf("%d:%s:%s:%d", e.Seq, e.Name, e.Content, e.Supersedes))
+		case SupersededEvent:
+			got = append(got, fmt.Sprintf("%d:superseded %d %s by %d", e.Seq, e.Superseded, e.Name, e.By.Seq))
+		}
+	}
+	want := []string{"1:write-file:v1:0", "2:summary:s:0", "3:write-file:v2:1", "4:superseded 1 write-file by 3", "5:write-file:ahead:0", "6:summary:y:0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "refers to a later event") || !strings.Contains(warnings[1], "is not an event number") {
+		t.Fatalf("unexpected warnings %q", warnings)
+	}
+
+	b, _ := json.Marshal(rec.events[3])
+	if ev, err := UnmarshalEvent(b); err != nil || ev.(SupersededEvent).By.Content != "v2" {
+		t.Fatalf("round trip: %v, %s", err, b)
+	}
+
+	buf := NewBufferSink(0)
+	buf.SetApplyRevisions(true)
+	if err := en.ProcessStream(strings.NewReader(input), buf); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, ev := range buf.Events() {
+		kept = append(kept, ev.(SectionEvent).Content)
+	}
+	if fmt.Sprint(kept) != "[s v2 ahead y]" {
+		t.Fatalf("unexpected buffered sections %q", kept)
+	}
+}
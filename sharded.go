@@ -0,0 +1,161 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// ErrShardedSinkDrained is recorded for events that reach a ShardedSink after Drain.
+var ErrShardedSinkDrained = errors.New("event after Drain")
+
+// ShardError is an error a ShardedSink worker's sink returned for an event with Key.
+type ShardError struct {
+	Key string
+	Seq int64
+	Err error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard key %q (event %d): %v", e.Key, e.Seq, e.Err)
+}
+
+func (e *ShardError) Unwrap() error { return e.Err }
+
+// ShardedSink hands events to a fixed set of workers, each with its own sink, choosing the
+// worker by a key such as the path attribute. Events with the same key reach the same sink
+// in stream order; events with different keys may be handled in parallel. Events other
+// than sections have the key "". OnEvent blocks while the chosen worker's queue is full.
+// Stream start and end are queued to every worker, so a worker sink that is a
+// StreamStartSink or StreamEndSink is told of them in order with its events: the end
+// only once it has handled the events queued before it.
+//
+//	sink := promptweaver.NewShardedSink(func(int) promptweaver.EventSink { return writer },
+//		func(ev promptweaver.SectionEvent) string { return ev.Attrs["path"] }, 8)
+//	err := engine.ProcessStream(r, sink)
+//	err = errors.Join(err, sink.Drain())
+type ShardedSink struct {
+	key    func(SectionEvent) string
+	queues []chan shardedEvent
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex // held for reading while queueing, for writing by Drain
+	drained bool
+
+	errMu sync.Mutex
+	errs  []error
+}
+
+type shardedEvent struct {
+	key    string
+	ev     Event
+	notify func(EventSink) // run on the worker's sink instead of delivering ev; for stream start and end
+}
+
+// shardQueueSize is how many events each worker may have waiting.
+const shardQueueSize = 64
+
+// NewShardedSink starts workers goroutines (at least one), each delivering to the sink
+// next returns for its index, asked once. Call Drain when the stream is over.
+func NewShardedSink(next func(worker int) EventSink, key func(SectionEvent) string, workers int) *ShardedSink {
+	workers = max(workers, 1)
+	s := &ShardedSink{key: key, queues: make([]chan shardedEvent, workers)}
+	for i := range s.queues {
+		q := make(chan shardedEvent, shardQueueSize)
+		s.queues[i] = q
+		s.wg.Add(1)
+		go s.work(next(i), q)
+	}
+	return s
+}
+
+// OnEvent implements EventSink.
+func (s *ShardedSink) OnEvent(ev Event) {
+	var key string
+	if sev, ok := ev.(SectionEvent); ok && s.key != nil {
+		key = s.key(sev)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	s.mu.RLock()
+	if s.drained {
+		s.mu.RUnlock()
+		s.fail(&ShardError{Key: key, Seq: ev.Base().Seq, Err: ErrShardedSinkDrained})
+		return
+	}
+	s.queues[h.Sum32()%uint32(len(s.queues))] <- shardedEvent{key: key, ev: ev}
+	s.mu.RUnlock()
+}
+
+// OnStreamStart implements StreamStartSink.
+func (s *ShardedSink) OnStreamStart(meta StreamMeta) {
+	s.notifyAll(func(sink EventSink) {
+		if ss, ok := sink.(StreamStartSink); ok {
+			ss.OnStreamStart(meta)
+		}
+	})
+}
+
+// OnStreamEnd implements StreamEndSink.
+func (s *ShardedSink) OnStreamEnd(err error) {
+	s.notifyAll(func(sink EventSink) {
+		if es, ok := sink.(StreamEndSink); ok {
+			es.OnStreamEnd(err)
+		}
+	})
+}
+
+// notifyAll queues fn to every worker, behind the events already queued. After Drain
+// there is nobody left to tell.
+func (s *ShardedSink) notifyAll(fn func(EventSink)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.drained {
+		return
+	}
+	for _, q := range s.queues {
+		q <- shardedEvent{notify: fn}
+	}
+}
+
+// Drain waits until every queued event has been handled, stops the workers and returns the
+// errors the worker sinks reported, as ShardErrors, joined. Drain may be called more than
+// once; later events are dropped and reported by the next Drain.
+func (s *ShardedSink) Drain() error {
+	s.mu.Lock()
+	if !s.drained {
+		s.drained = true
+		for _, q := range s.queues {
+			close(q)
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	err := errors.Join(s.errs...)
+	s.errs = nil
+	return err
+}
+
+func (s *ShardedSink) work(sink EventSink, q <-chan shardedEvent) {
+	defer s.wg.Done()
+	for se := range q {
+		if se.notify != nil {
+			se.notify(sink)
+			continue
+		}
+		if err := deliver(context.Background(), sink, se.ev); err != nil {
+			s.fail(&ShardError{Key: se.key, Seq: se.ev.Base().Seq, Err: err})
+		}
+	}
+}
+
+func (s *ShardedSink) fail(err error) {
+	s.errMu.Lock()
+	s.errs = append(s.errs, err)
+	s.errMu.Unlock()
+}
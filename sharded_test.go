@@ -0,0 +1,139 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_ShardedSink_Should_Keep_Order_Within_Each_Key(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	const keys, perKey = 17, 200
+	var in strings.Builder
+	want := map[string]uint64{}
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			path := fmt.Sprintf("f%d.go", k)
+			fmt.Fprintf(&in, `<create-file path="%s">%d</create-file>`, path, i)
+			want[path] = want[path]*31 + uint64(i)
+		}
+	}
+
+	var mu sync.Mutex
+	got := map[string]uint64{}
+	sink := NewShardedSink(func(worker int) EventSink {
+		return EventSinkFunc(func(ev Event) {
+			sev := ev.(SectionEvent)
+			if sev.Seq%(int64(worker)+2) == 0 {
+				runtime.Gosched()
+			}
+			var n uint64
+			fmt.Sscan(sev.Content, &n)
+			mu.Lock()
+			got[sev.Attrs["path"]] = got[sev.Attrs["path"]]*31 + n
+			mu.Unlock()
+		})
+	}, func(ev SectionEvent) string { return ev.Attrs["path"] }, 4)
+
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(in.String()), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if err := sink.Drain(); err != nil {
+		t.Fatalf("Drain error: %v", err)
+	}
+	for path, sum := range want {
+		if got[path] != sum {
+			t.Fatalf("events for %s arrived out of order", path)
+		}
+	}
+}
+
+type failingContextSink struct{}
+
+func (failingContextSink) OnEvent(Event) {}
+
+func (failingContextSink) OnEventContext(_ context.Context, ev Event) error {
+	if ev.(SectionEvent).Content == "bad" {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func Test_ShardedSink_Should_Aggregate_Errors_On_Drain(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	sink := NewShardedSink(func(int) EventSink { return failingContextSink{} },
+		func(ev SectionEvent) string { return ev.Attrs["path"] }, 2)
+	input := `<create-file path="a">ok</create-file><create-file path="b">bad</create-file>`
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	err := sink.Drain()
+	var se *ShardError
+	if !errors.As(err, &se) || se.Key != "b" || se.Seq != 2 || se.Err.Error() != "disk full" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	sink.OnEvent(SectionEvent{Name: "create-file", Attrs: map[string]string{"path": "c"}})
+	if err := sink.Drain(); !errors.Is(err, ErrShardedSinkDrained) {
+		t.Fatalf("expected ErrShardedSinkDrained, got %v", err)
+	}
+}
+
+// streamLog records, for one worker, its events and the stream boundaries around them.
+type streamLog struct {
+	mu  *sync.Mutex
+	log *[]string
+	id  int
+}
+
+func (l streamLog) add(s string) {
+	l.mu.Lock()
+	*l.log = append(*l.log, fmt.Sprintf("%d:%s", l.id, s))
+	l.mu.Unlock()
+}
+
+func (l streamLog) OnEvent(ev Event)              { l.add(ev.(SectionEvent).Content) }
+func (l streamLog) OnStreamStart(meta StreamMeta) { l.add("start " + meta["id"]) }
+func (l streamLog) OnStreamEnd(err error)         { l.add(fmt.Sprintf("end %v", err)) }
+
+func Test_ShardedSink_Should_Tell_Every_Worker_Of_Stream_Start_And_End(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	var mu sync.Mutex
+	var log []string
+	sink := NewShardedSink(func(worker int) EventSink { return streamLog{&mu, &log, worker} },
+		func(ev SectionEvent) string { return ev.Attrs["path"] }, 2)
+	var in strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&in, `<create-file path="f%d">%d</create-file>`, i, i)
+	}
+	if err := NewEngineWithOptions(reg, WithStreamMeta(StreamMeta{"id": "s1"})).ProcessStream(strings.NewReader(in.String()), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if err := sink.Drain(); err != nil {
+		t.Fatalf("Drain error: %v", err)
+	}
+
+	for worker := 0; worker < 2; worker++ {
+		var got []string
+		for _, l := range log {
+			if id, rest, _ := strings.Cut(l, ":"); id == fmt.Sprint(worker) {
+				got = append(got, rest)
+			}
+		}
+		if len(got) < 3 || got[0] != "start s1" || got[len(got)-1] != "end <nil>" {
+			t.Fatalf("worker %d saw %v", worker, got)
+		}
+		for _, l := range got[1 : len(got)-1] {
+			if strings.HasPrefix(l, "start") || strings.HasPrefix(l, "end") {
+				t.Fatalf("worker %d saw a boundary among its events: %v", worker, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package promptweaver
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentKind is a guess at what a section body holds, from its first bytes. It is advisory
+// metadata: parsing never depends on it.
+type ContentKind string
+
+const (
+	ContentJSON     ContentKind = "json"
+	ContentDiff     ContentKind = "diff"
+	ContentMarkdown ContentKind = "markdown"
+	ContentCode     ContentKind = "code"
+	ContentText     ContentKind = "text"
+	ContentBinary   ContentKind = "binary" // NUL bytes or invalid UTF-8
+)
+
+// sniffPrefix is how many bytes of a body SniffContent looks at.
+const sniffPrefix = 512
+
+// codeStarts are line starts that mark source code when a body begins with them.
+var codeStarts = []string{
+	"package ", "import ", "func ", "def ", "class ", "#include", "#define", "use ", "using ",
+	"const ", "let ", "var ", "function ", "export ", "module ", "fn ", "pub ", "<?php", "//", "/*",
+}
+
+// SniffContent guesses the kind of content from at most its first 512 bytes, looking at the
+// first non-blank characters, fence markers, diff headers and shebangs. Empty content has
+// no kind.
+func SniffContent(content string) ContentKind {
+	head := content
+	if len(head) > sniffPrefix {
+		head = head[:sniffPrefix]
+	}
+	if strings.IndexByte(head, 0) >= 0 || !validPrefix(head, len(content) > sniffPrefix) {
+		return ContentBinary
+	}
+	head = strings.TrimLeft(head, " \t\r\n")
+	if head == "" {
+		return ""
+	}
+	switch head[0] {
+	case '{', '[':
+		return ContentJSON
+	}
+	switch {
+	case strings.HasPrefix(head, "diff --git "), strings.HasPrefix(head, "@@ "),
+		strings.HasPrefix(head, "--- ") && strings.Contains(head, "\n+++ "):
+		return ContentDiff
+	case strings.HasPrefix(head, "#!"):
+		return ContentCode
+	case strings.HasPrefix(head, "```"), strings.HasPrefix(head, "~~~"),
+		strings.HasPrefix(head, "# "), strings.HasPrefix(head, "## "),
+		strings.HasPrefix(head, "- "), strings.HasPrefix(head, "* "), strings.HasPrefix(head, "> "),
+		strings.Contains(head, "\n```"), strings.Contains(head, "\n~~~"):
+		return ContentMarkdown
+	}
+	for _, s := range codeStarts {
+		if strings.HasPrefix(head, s) {
+			return ContentCode
+		}
+	}
+	return ContentText
+}
+
+// validPrefix reports whether s is valid UTF-8, allowing a sequence cut at the end of a
+// prefix of longer content.
+func validPrefix(s string, cut bool) bool {
+	if utf8.ValidString(s) {
+		return true
+	}
+	if !cut {
+		return false
+	}
+	for i := 1; i < utf8.UTFMax && i <= len(s); i++ {
+		if utf8.RuneStart(s[len(s)-i]) {
+			return utf8.ValidString(s[:len(s)-i])
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_SniffContent_Should_Guess_From_The_Prefix(t *testing.T) {
+	cut := strings.Repeat("a", sniffPrefix-1) + "é" // the rune straddles the prefix
+	for content, want := range map[string]ContentKind{
+		"":                                "",
+		"  \n":                            "",
+		` {"a": 1}`:                       ContentJSON,
+		"[1, 2]":                          ContentJSON,
+		"--- a.go\n+++ b.go\n@@ -1 +1 @@": ContentDiff,
+		"diff --git a/x b/x\n":            ContentDiff,
+		"#!/bin/sh\necho hi":              ContentCode,
+		"package main\n\nfunc main() {}":  ContentCode,
+		"# Title\n\ntext":                 ContentMarkdown,
+		"Intro:\n```go\nx := 1\n```":      ContentMarkdown,
+		"- one\n- two":                    ContentMarkdown,
+		"Just a sentence.":                ContentText,
+		"--- not a diff":                  ContentText,
+		"a\x00b":                          ContentBinary,
+		"\xff\xfe":                        ContentBinary,
+		cut:                               ContentText,
+		strings.Repeat("x", 600) + "\x00": ContentText, // past the prefix
+	} {
+		if got := SniffContent(content); got != want {
+			t.Errorf("SniffContent(%.30q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func Test_Engine_Should_Attach_Content_Kind_When_Sniffing(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "result"})
+	input := `<result>{"ok": true}</result><result>--- a
++++ b
+</result><result>done</result>`
+
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(reg, WithContentSniffing(true)).ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	var kinds []string
+	for _, ev := range rec.events {
+		kinds = append(kinds, string(ev.(SectionEvent).ContentKind))
+	}
+	if got := strings.Join(kinds, " "); got != "json diff text" {
+		t.Fatalf("unexpected kinds %q", got)
+	}
+	b, _ := json.Marshal(rec.events[0])
+	if !strings.Contains(string(b), `"content_kind":"json"`) {
+		t.Fatalf("kind missing from JSON: %s", b)
+	}
+
+	rec = &recorderSink{}
+	_ = NewEngine(reg).ProcessStream(strings.NewReader(input), rec)
+	if rec.events[0].(SectionEvent).ContentKind != "" {
+		t.Fatalf("sniffing must be opt-in")
+	}
+}
@@ -0,0 +1,108 @@
+package promptweaver
+
+import "context"
+
+// stream is one parse in progress, fed by the read loop of run or by pushes such as Demux.Feed.
+type stream struct {
+	p         *parser
+	sink      EventSink
+	options   EngineOptions
+	capture   *capture  // nil unless the stream is captured or digested
+	progress  *progress // nil unless the expected length is known
+	bytesRead int64
+	abandoned bool // a read was left running when a section timed out
+}
+
+func (e *Engine) startStream(ctx context.Context, sink EventSink, options EngineOptions, validators *ValidatorRegistry) *stream {
+	reg := e.reg
+	if options.RegistrySync == RegistrySnapshot {
+		reg = reg.clone()
+	}
+	p := newParser(reg, sink, options)
+	p.ctx = ctx
+	p.validators = validators
+	if ss, ok := sink.(StreamStartSink); ok {
+		ss.OnStreamStart(options.StreamMeta)
+	}
+	return &stream{p: p, sink: sink, options: options, capture: newCapture(options, p.now), progress: newProgress(options)}
+}
+
+// write parses b, which has already been captured, then checks the section timeout.
+func (s *stream) write(b []byte) error {
+	p := s.p
+	if len(b) > 0 {
+		read := int64(len(b))
+		overLimit := false
+		if max := s.options.MaxStreamBytes; max > 0 && s.bytesRead+int64(len(b)) > max {
+			// Parse what fits under the cap, then stop.
+			b = b[:max-s.bytesRead]
+			overLimit = true
+		}
+		s.bytesRead += int64(len(b))
+		p.feed(b)
+		// drain already consulted the ErrorHandler / RecoveryMode; anything it returns is fatal.
+		if err := p.drain(false); err != nil {
+			return err
+		}
+		if err := s.checkMemory(); err != nil {
+			return err
+		}
+		if overLimit {
+			return NewStreamLimitError(p.pos, "bytes", s.options.MaxStreamBytes, p.tz.lastContent)
+		}
+		if err := p.reportProgress(s.progress, read); err != nil {
+			return err
+		}
+	}
+	if err := p.flushStaleText(); err != nil {
+		return err
+	}
+	return p.checkTimeout()
+}
+
+// push captures b and parses it, for streams that are fed rather than read.
+func (s *stream) push(b []byte) error {
+	if err := s.p.ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.capture.record(b); err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// close ends the input: sections still open are cut off and end-of-stream events emitted.
+func (s *stream) close() error {
+	if err := s.p.finish(); err != nil {
+		return err
+	}
+	if err := s.p.checkUnclosed(); err != nil {
+		return err
+	}
+	if err := s.p.finishProgress(s.progress); err != nil {
+		return err
+	}
+	s.p.reportUnpaired()
+	s.p.reportDangling()
+	s.p.reportHandlerStats()
+	return s.p.emitDigest(s.capture.digestOf())
+}
+
+// end completes the stream with its final error, which it returns: the capture of a read
+// left in flight is stopped, the error gets its snippets and a StreamEndSink is told.
+func (s *stream) end(err error) error {
+	if s.abandoned {
+		// The read may still return; what it read must not reach the capture.
+		s.capture.stop()
+	}
+	s.p.locate(err)
+	s.p.reportByteUsage()
+	s.p.reportTruncatedSections()
+	if g := s.options.MemoryGauge; g != nil {
+		g.n.Store(0)
+	}
+	if es, ok := s.sink.(StreamEndSink); ok {
+		es.OnStreamEnd(err)
+	}
+	return err
+}
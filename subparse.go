@@ -0,0 +1,146 @@
+package promptweaver
+
+import (
+	"context"
+	"strings"
+)
+
+// SubParser re-parses a section's content with another engine. Inner positions point into
+// the outer stream, unless the content was rewritten (see SectionPlugin.ContentTransforms
+// and EngineOptions.Variables), in which case they are relative to the content.
+type SubParser struct {
+	Engine *Engine   // engine used for the inner layer; its registry decides what is recognized
+	Sink   EventSink // receives the inner events
+}
+
+// resolveSubParsers keys sub-parsers by canonical section name.
+func resolveSubParsers(reg *Registry, subs map[string]SubParser) map[string]SubParser {
+	if len(subs) == 0 {
+		return nil
+	}
+	out := make(map[string]SubParser, len(subs))
+	for name, sub := range subs {
+		if c, ok := reg.Canonical(name); ok {
+			name = c
+		}
+		out[strings.ToLower(name)] = sub
+	}
+	return out
+}
+
+// subParse feeds the content of a just-emitted section through its sub-engine, if any.
+// Inner errors go through this parser's error handling, and inner positions are rebased so
+// they point into the outer stream. Content rewritten by variable expansion or
+// ContentTransforms no longer lines up with the stream, so its positions are left relative
+// to the content.
+func (p *parser) subParse(el *element, content string) error {
+	sub, ok := p.subParsers[el.canon]
+	if !ok || sub.Engine == nil || sub.Sink == nil {
+		return nil
+	}
+
+	base := el.bodyStart
+	if content != el.body.String() {
+		base = Position{Line: 1, Column: 1}
+	}
+	// The handler and the return path can both see an error. Errors are keyed by their
+	// ParseError, since an arbitrary error may not be comparable.
+	rebased := map[*ParseError]bool{}
+	rebaseOnce := func(err error) {
+		pe, ok := err.(interface{ parseError() *ParseError })
+		if !ok || rebased[pe.parseError()] {
+			return
+		}
+		rebased[pe.parseError()] = true
+		rebaseError(err, base)
+	}
+
+	options := sub.Engine.options
+	options.ErrorHandler = func(err error) bool {
+		rebaseOnce(err)
+		return p.recover(err) == nil
+	}
+	sink := &rebaseSink{sink: sub.Sink, base: base}
+	err := sub.Engine.run(p.ctx, strings.NewReader(content), sink, options, sub.Engine.validators)
+	if err != nil {
+		rebaseOnce(err)
+	}
+	return err
+}
+
+// rebaseSink forwards inner events with their positions rebased into the outer stream.
+type rebaseSink struct {
+	sink EventSink
+	base Position
+}
+
+func (s *rebaseSink) OnEvent(ev Event) { s.sink.OnEvent(s.rebase(ev)) }
+
+func (s *rebaseSink) OnEventContext(ctx context.Context, ev Event) error {
+	return deliver(ctx, s.sink, s.rebase(ev))
+}
+
+// OnStreamStart and OnStreamEnd tell the sub-parser's sink that each sub-parse is a stream
+// of its own, so per-stream state such as a HandlerSink's first and last handlers resets.
+func (s *rebaseSink) OnStreamStart(meta StreamMeta) {
+	if ss, ok := s.sink.(StreamStartSink); ok {
+		ss.OnStreamStart(meta)
+	}
+}
+
+func (s *rebaseSink) OnStreamEnd(err error) {
+	if es, ok := s.sink.(StreamEndSink); ok {
+		es.OnStreamEnd(err)
+	}
+}
+
+func (s *rebaseSink) rebase(ev Event) Event {
+	b := ev.Base()
+	b.StartPos, b.EndPos = rebase(b.StartPos, s.base), rebase(b.EndPos, s.base)
+	ev = ev.withBase(b)
+	switch e := ev.(type) {
+	case PairedEvent:
+		e.Open = s.rebase(e.Open).(SectionEvent)
+		e.Close = s.rebase(e.Close).(SectionEvent)
+		return e
+	case SupersededEvent:
+		e.By = s.rebase(e.By).(SectionEvent)
+		return e
+	}
+	return ev
+}
+
+// rebase converts a position relative to a section body into a position in the outer stream.
+func rebase(pos, base Position) Position {
+	out := Position{Line: base.Line + pos.Line - 1, Column: pos.Column, Offset: base.Offset + pos.Offset}
+	if pos.Line == 1 {
+		out.Column = base.Column + pos.Column - 1
+	}
+	return out
+}
+
+// rebaseError rewrites the positions carried by a promptweaver error in place.
+func rebaseError(err error, base Position) {
+	pe, ok := err.(interface{ parseError() *ParseError })
+	if !ok {
+		return
+	}
+	perr := pe.parseError()
+	perr.Pos = rebase(perr.Pos, base)
+	switch e := err.(type) {
+	case *UnclosedSectionError:
+		e.Start = rebase(e.Start, base)
+	case *SectionTimeoutError:
+		e.Start = rebase(e.Start, base)
+	case *ByteBudgetError:
+		e.Start = rebase(e.Start, base)
+	case *UnexpectedClosingTagError:
+		e.Start = rebase(e.Start, base)
+	case *AttributeParsingError:
+		e.AttrPos = rebase(e.AttrPos, base)
+	case *AttributeValidationError:
+		for i, pos := range e.AttrPos {
+			e.AttrPos[i] = rebase(pos, base)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newBatchEngines(opts ...Option) (*Engine, *recorderSink) {
+	inner := NewRegistry()
+	inner.Register(SectionPlugin{Name: "create-file"})
+	innerEngine := NewEngine(inner)
+
+	outer := NewRegistry()
+	outer.Register(SectionPlugin{Name: "batch", Aliases: []string{"bulk"}})
+	rec := &recorderSink{}
+	opts = append(opts, WithSubParser("bulk", innerEngine, rec))
+	return NewEngineWithOptions(outer, opts...), rec
+}
+
+func Test_SubParser_Should_Deliver_Inner_Events_With_Outer_Positions(t *testing.T) {
+	en, inner := newBatchEngines()
+	outerSink, outer := newSinkCatcher("batch")
+
+	input := "x<batch>\n<create-file path=\"a\">A</create-file><create-file path=\"b\">B</create-file>\n</batch>"
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, outerSink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*outer) != 1 {
+		t.Fatalf("expected the outer section to be emitted, got %d", len(*outer))
+	}
+	if len(inner.events) != 2 {
+		t.Fatalf("expected 2 inner events, got %d", len(inner.events))
+	}
+	for i, want := range []string{"A", "B"} {
+		ev := inner.events[i].(SectionEvent)
+		if ev.Content != want {
+			t.Fatalf("inner event %d: content %q, want %q", i, ev.Content, want)
+		}
+		span := input[ev.StartPos.Offset:ev.EndPos.Offset]
+		if span != `<create-file path="`+string(rune('a'+i))+`">`+want+`</create-file>` {
+			t.Fatalf("inner event %d: offsets do not point into the outer stream: %q", i, span)
+		}
+	}
+	if first := inner.events[0].(SectionEvent).StartPos; first.Line != 2 || first.Column != 1 {
+		t.Fatalf("expected first inner section at line 2, column 1, got %s", first)
+	}
+}
+
+func Test_SubParser_Should_Route_Inner_Errors_Through_Outer_Handling(t *testing.T) {
+	input := "<batch>ab</bogus><create-file>A</create-file></batch>"
+
+	en, _ := newBatchEngines()
+	err := en.ProcessStream(ReaderFromString(input), NewHandlerSink())
+	var unmatched *UnmatchedTagError
+	if !errors.As(err, &unmatched) {
+		t.Fatalf("expected UnmatchedTagError in strict mode, got %v", err)
+	}
+	if unmatched.Pos.Offset != 9 || unmatched.Pos.Column != 10 {
+		t.Fatalf("expected error rebased to offset 9, column 10, got %s", unmatched.Pos)
+	}
+
+	var seen []error
+	en, inner := newBatchEngines(WithErrorHandler(func(err error) bool {
+		seen = append(seen, err)
+		return true
+	}))
+	if err := en.ProcessStream(ReaderFromString(input), NewHandlerSink()); err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+	if len(seen) != 1 || len(inner.events) != 1 {
+		t.Fatalf("expected 1 handled error and 1 inner event, got %v and %d events", seen, len(inner.events))
+	}
+	if pos := seen[0].(*UnmatchedTagError).Pos; pos.Offset != 9 {
+		t.Fatalf("expected the handler to see the rebased position, got %s", pos)
+	}
+}
+
+func Test_SubParser_Should_Treat_Each_Sub_Parse_As_A_Stream(t *testing.T) {
+	inner := NewRegistry()
+	inner.Register(SectionPlugin{Name: "create-file"})
+	outer := NewRegistry()
+	outer.Register(SectionPlugin{Name: "batch"})
+
+	var first []string
+	var ends int
+	sink := NewHandlerSink()
+	sink.RegisterFirstHandler("create-file", func(ev SectionEvent) { first = append(first, ev.Content) })
+	sink.RegisterStreamEndHandler(func(error) { ends++ })
+	en := NewEngineWithOptions(outer, WithSubParser("batch", NewEngine(inner), sink))
+
+	input := "<batch><create-file>A</create-file><create-file>B</create-file></batch>" +
+		"<batch><create-file>C</create-file><create-file>D</create-file></batch>"
+	if err := en.ProcessStream(ReaderFromString(input), NewHandlerSink()); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if ends != 2 || len(first) != 2 || first[0] != "A" || first[1] != "C" {
+		t.Fatalf("got %d stream ends and first sections %v, want 2 and [A C]", ends, first)
+	}
+}
+
+// sliceError is not comparable, so it cannot be a map key.
+type sliceError struct{ names []string }
+
+func (e sliceError) Error() string { return "bad names" }
+
+func Test_SubParser_Should_Accept_Uncomparable_Errors(t *testing.T) {
+	inner := NewRegistry()
+	inner.Register(SectionPlugin{Name: "create-file"})
+	outer := NewRegistry()
+	outer.Register(SectionPlugin{Name: "batch"})
+	sink := NewHandlerSink()
+	sink.RegisterHandlerCtx("create-file", func(context.Context, SectionEvent) error {
+		return sliceError{names: []string{"a"}}
+	})
+
+	input := "<batch><create-file>A</create-file></batch>"
+	for _, opts := range [][]Option{nil, {WithContinueMode()}} {
+		en := NewEngineWithOptions(outer, append(opts, WithSubParser("batch", NewEngine(inner), sink))...)
+		err := en.ProcessStream(ReaderFromString(input), NewHandlerSink())
+		if opts == nil && !errors.As(err, new(sliceError)) {
+			t.Fatalf("expected the handler error, got %v", err)
+		}
+	}
+}
+
+func Test_SubParser_Should_Rebase_Attribute_Positions(t *testing.T) {
+	input := "<batch>\n<create-file path=\"a\" bad>A</create-file></batch>"
+
+	en, _ := newBatchEngines()
+	err := en.ProcessStream(ReaderFromString(input), NewHandlerSink())
+	var ape *AttributeParsingError
+	if !errors.As(err, &ape) {
+		t.Fatalf("expected AttributeParsingError, got %v", err)
+	}
+	if want := int64(len("<batch>\n<create-file path=\"a\" ")); ape.AttrPos.Offset != want || ape.AttrPos.Line != 2 {
+		t.Fatalf("expected the attribute rebased to offset %d on line 2, got %s", want, ape.AttrPos)
+	}
+}
+
+func Test_SubParser_Should_Rebase_Unknown_Attribute_Positions(t *testing.T) {
+	inner := NewRegistry()
+	inner.Register(SectionPlugin{Name: "create-file", KnownAttrs: []string{"path"}, UnknownAttrs: ErrorUnknownAttrs})
+	outer := NewRegistry()
+	outer.Register(SectionPlugin{Name: "batch"})
+	en := NewEngineWithOptions(outer, WithSubParser("batch", NewEngine(inner), &recorderSink{}))
+
+	input := "<batch>\n<create-file path=\"a\" mode=\"1\">A</create-file></batch>"
+	err := en.ProcessStream(ReaderFromString(input), NewHandlerSink())
+	var ave *AttributeValidationError
+	if !errors.As(err, &ave) || len(ave.AttrPos) != 1 {
+		t.Fatalf("expected AttributeValidationError, got %v", err)
+	}
+	if want := int64(len("<batch>\n<create-file path=\"a\" ")); ave.AttrPos[0].Offset != want || ave.AttrPos[0].Line != 2 {
+		t.Fatalf("expected the attribute rebased to offset %d on line 2, got %s", want, ave.AttrPos[0])
+	}
+}
+
+func Test_SubParser_Should_Rebase_Every_Inner_Event(t *testing.T) {
+	inner := NewRegistry()
+	inner.Register(SectionPlugin{Name: "create-file"})
+	outer := NewRegistry()
+	outer.Register(SectionPlugin{Name: "batch"})
+	rec := &recorderSink{}
+	en := NewEngineWithOptions(outer, WithSubParser("batch", NewEngineWithOptions(inner, WithCodeBlocks()), rec))
+
+	input := "<batch>\n```go\nx\n```\n</batch>"
+	if err := en.ProcessStream(ReaderFromString(input), NewHandlerSink()); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("expected 1 inner event, got %d", len(rec.events))
+	}
+	cb := rec.events[0].(CodeBlockEvent)
+	if span := input[cb.StartPos.Offset:cb.EndPos.Offset]; cb.StartPos.Line != 2 || span != "```go\nx\n```" {
+		t.Fatalf("code block not rebased into the outer stream: %s, %q", cb.StartPos, span)
+	}
+}
+
+func Test_SubParser_Should_Not_Rebase_Rewritten_Content(t *testing.T) {
+	en, inner := newBatchEngines(WithVariables(map[string]string{"dir": "some/long/dir"}))
+	input := "<batch>{{dir}}<create-file>A</create-file></batch>"
+	if err := en.ProcessStream(ReaderFromString(input), NewHandlerSink()); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(inner.events) != 1 {
+		t.Fatalf("expected 1 inner event, got %d", len(inner.events))
+	}
+	// The content is "some/long/dir<create-file>A</create-file>"; positions stay in it.
+	if pos := inner.events[0].(SectionEvent).StartPos; pos.Offset != int64(len("some/long/dir")) {
+		t.Fatalf("expected a position relative to the expanded content, got %s", pos)
+	}
+}
@@ -0,0 +1,140 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SuppressedSection reports a section that was parsed but, being suppressed, neither buffered
+// nor emitted.
+type SuppressedSection struct {
+	Name              string            // canonical section name
+	Attrs             map[string]string // attributes of the opening tag
+	StartPos          Position          // position of the opening tag's '<'
+	EndPos            Position          // position just past the closing tag (or the cut-off point)
+	Bytes             int               // content bytes read and discarded
+	Duration          time.Duration     // how long the section was open
+	SkippedValidators int               // validators registered for the section that did not run
+	Partial           bool              // cut off by EOF or a section timeout rather than closed
+}
+
+// SuppressHandler observes suppressed sections, e.g. to record metrics.
+type SuppressHandler func(SuppressedSection)
+
+// SectionClosedEvent signals the end of a section that has no SectionEvent: a suppressed
+// one, or one aborted because its OnOpen hook failed, with Err set. It comes exactly once
+// per such section, in stream order, when EngineOptions.SectionClosedEvents is set.
+type SectionClosedEvent struct {
+	EventBase
+	Name         string            `json:"name"`            // canonical section name
+	Attrs        map[string]string `json:"attrs,omitempty"` // attributes of the opening tag
+	BytesWritten int               `json:"bytes_written"`   // content bytes read and discarded
+	Err          error             `json:"-"`               // the OnOpen error that aborted the section
+	Duration     time.Duration     `json:"duration"`        // how long the section was open
+	Partial      bool              `json:"partial,omitempty"`
+}
+
+// Kind implements Event.
+func (SectionClosedEvent) Kind() EventKind { return KindSectionClosed }
+
+func (ev SectionClosedEvent) withBase(b EventBase) Event { ev.EventBase = b; return ev }
+
+// MarshalJSON adds the "kind" field, and Err as the "error" message.
+func (ev SectionClosedEvent) MarshalJSON() ([]byte, error) {
+	type plain SectionClosedEvent
+	var msg string
+	if ev.Err != nil {
+		msg = ev.Err.Error()
+	}
+	return json.Marshal(struct {
+		Kind  EventKind `json:"kind"`
+		Error string    `json:"error,omitempty"`
+		plain
+	}{ev.Kind(), msg, plain(ev)})
+}
+
+// UnmarshalJSON restores Err from the "error" message.
+func (ev *SectionClosedEvent) UnmarshalJSON(data []byte) error {
+	type plain SectionClosedEvent
+	var v struct {
+		Error string `json:"error"`
+		plain
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*ev = SectionClosedEvent(v.plain)
+	if v.Error != "" {
+		ev.Err = errors.New(v.Error)
+	}
+	return nil
+}
+
+// AsSectionClosed returns ev as a SectionClosedEvent, if it is one.
+func AsSectionClosed(ev Event) (SectionClosedEvent, bool) {
+	cev, ok := ev.(SectionClosedEvent)
+	return cev, ok
+}
+
+// resolveSuppressed keys suppressed section names by canonical name.
+func resolveSuppressed(reg *Registry, names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	out := make(map[string]bool, len(names))
+	for _, name := range names {
+		if c, ok := reg.Canonical(name); ok {
+			name = c
+		}
+		out[strings.ToLower(name)] = true
+	}
+	return out
+}
+
+// suppressed reports whether sections named canon are counted instead of delivered.
+func (p *parser) suppressed(canon string, plugin SectionPlugin) bool {
+	return plugin.Suppress || p.suppress[canon]
+}
+
+// closed emits the SectionClosedEvent of el, which ended at end, if they are wanted.
+func (p *parser) closed(el *element, end Position, partial bool, err error) error {
+	if !p.closedEvents {
+		return nil
+	}
+	ev := SectionClosedEvent{
+		EventBase:    EventBase{StartPos: el.start, EndPos: end},
+		Name:         el.canon,
+		Attrs:        el.attrs,
+		BytesWritten: el.size(),
+		Err:          err,
+		Partial:      partial,
+	}
+	if !el.openedAt.IsZero() {
+		ev.Duration = p.now().Sub(el.openedAt)
+	}
+	return p.emit(ev)
+}
+
+// reportSuppressed hands a finished suppressed section to the SuppressHandler.
+func (p *parser) reportSuppressed(el *element, end Position, partial bool) {
+	if p.onSuppressed == nil {
+		return
+	}
+	info := SuppressedSection{
+		Name:     el.canon,
+		Attrs:    el.attrs,
+		StartPos: el.start,
+		EndPos:   end,
+		Bytes:    el.size(),
+		Partial:  partial,
+	}
+	if !el.openedAt.IsZero() {
+		info.Duration = p.now().Sub(el.openedAt)
+	}
+	if p.validators != nil {
+		info.SkippedValidators = p.validators.count(el.canon)
+	}
+	p.onSuppressed(info)
+}
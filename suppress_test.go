@@ -0,0 +1,103 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Engine_Should_Suppress_Sections_Without_Emitting(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "thinking", Aliases: []string{"think"}})
+	reg.Register(SectionPlugin{Name: "scratch", Suppress: true})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	clock := time.Unix(0, 0)
+	var seen []SuppressedSection
+	en := NewEngineWithOptions(reg,
+		WithSuppressedSections("think"),
+		WithClock(func() time.Time { clock = clock.Add(time.Second); return clock }),
+		WithSuppressHandler(func(s SuppressedSection) { seen = append(seen, s) }),
+	)
+	validated := false
+	en.RegisterFuncValidator("thinking", func(section, content string, pos Position) error {
+		validated = true
+		return nil
+	})
+
+	input := "<think>let me see</think><scratch/><summary>done</summary><thinking>cut"
+	rec := &recorderSink{}
+	if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 2}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Name != "summary" {
+		t.Fatalf("expected only the summary event, got %+v", rec.events)
+	}
+	if validated {
+		t.Fatal("validators of a suppressed section must not run")
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected 3 suppressed sections, got %+v", seen)
+	}
+	first := seen[0]
+	if first.Name != "thinking" || first.Bytes != len("let me see") || first.SkippedValidators != 1 || first.Partial {
+		t.Fatalf("unexpected first report %+v", first)
+	}
+	if span := input[first.StartPos.Offset:first.EndPos.Offset]; span != "<think>let me see</think>" {
+		t.Fatalf("unexpected span %q", span)
+	}
+	if first.Duration <= 0 {
+		t.Fatalf("expected a duration, got %v", first.Duration)
+	}
+	if seen[1].Name != "scratch" || seen[1].Bytes != 0 {
+		t.Fatalf("unexpected self-closing report %+v", seen[1])
+	}
+	if last := seen[2]; !last.Partial || last.Bytes != len("cut") {
+		t.Fatalf("unexpected partial report %+v", last)
+	}
+}
+
+func Test_Engine_Should_Signal_Sections_That_Emit_No_Event(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "scratch", Suppress: true})
+	reg.Register(SectionPlugin{Name: "write-file", OnOpen: func(name string, attrs map[string]string, pos Position) error {
+		if attrs["path"] == "" {
+			return errors.New("no path")
+		}
+		return nil
+	}})
+	input := "<scratch>abc</scratch><write-file>x</write-file><write-file path=\"a\">y</write-file><scratch>cut"
+
+	for _, policy := range []EOFPolicy{EmitPartial, DropPartial} {
+		var closed []string
+		var order []EventKind
+		sink := NewHandlerSink()
+		sink.RegisterHandler("write-file", func(SectionEvent) { order = append(order, KindSection) })
+		sink.RegisterSectionClosedHandler(func(ev SectionClosedEvent) {
+			order = append(order, ev.Kind())
+			closed = append(closed, fmt.Sprintf("%s %d partial=%v err=%v", ev.Name, ev.BytesWritten, ev.Partial, ev.Err))
+		})
+		en := NewEngineWithOptions(reg, WithSectionClosedEvents(true), WithContinueMode(), WithEOFPolicy(policy))
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 3}, sink); err != nil {
+			t.Fatalf("policy %v: ProcessStream error: %v", policy, err)
+		}
+		want := []string{"scratch 3 partial=false err=<nil>", "write-file 0 partial=false err=no path", "scratch 3 partial=true err=<nil>"}
+		if fmt.Sprint(closed) != fmt.Sprint(want) {
+			t.Fatalf("policy %v: expected %q, got %q", policy, want, closed)
+		}
+		if fmt.Sprint(order) != "[section_closed section_closed section section_closed]" {
+			t.Fatalf("policy %v: events out of order: %v", policy, order)
+		}
+	}
+
+	b, err := json.Marshal(SectionClosedEvent{Name: "write-file", Err: errors.New("disk full")})
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	ev, err := UnmarshalEvent(b)
+	if cev, ok := AsSectionClosed(ev); err != nil || !ok || cev.Name != "write-file" || cev.Err == nil || cev.Err.Error() != "disk full" {
+		t.Fatalf("expected the event back from %s, got %+v, %v", b, ev, err)
+	}
+}
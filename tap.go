@@ -0,0 +1,66 @@
+package promptweaver
+
+// TagDisposition is what the parser did with a tag (see TagTokenInfo).
+type TagDisposition int
+
+const (
+	TagStartedSection   TagDisposition = iota // opened a registered section; a self-closing one is also closed at once
+	TagIgnoredUnknown                         // an unregistered tag outside sections, skipped
+	TagTreatedAsContent                       // kept as text, such as an incomplete tag at the end of the input
+	TagClosedSection                          // closed the open section
+	TagContext                                // opened or closed a context section (see EngineOptions.ContextSections)
+	TagError                                  // reported as an error, such as an unmatched closer, whether recovered or not
+)
+
+func (d TagDisposition) String() string {
+	switch d {
+	case TagStartedSection:
+		return "started-section"
+	case TagIgnoredUnknown:
+		return "ignored-unknown"
+	case TagTreatedAsContent:
+		return "treated-as-content"
+	case TagClosedSection:
+		return "closed-section"
+	case TagContext:
+		return "context"
+	case TagError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// TagTokenInfo describes a tag the parser has read and handled, for EngineOptions.TokenTap.
+type TagTokenInfo struct {
+	Kind        TokenKind         // TokenOpen, TokenClose or TokenSelfClose
+	Raw         string            // the tag as written
+	Name        string            // tag name as written
+	Attrs       map[string]string // attributes as written, before defaults and inheritance
+	Start       Position
+	End         Position
+	Disposition TagDisposition
+}
+
+// isTagKind reports whether tokens of kind k are tags.
+func isTagKind(k TokenKind) bool {
+	return k == TokenOpen || k == TokenClose || k == TokenSelfClose
+}
+
+// tapTag tells the TokenTap what became of tok, whose attributes were attrs before it was
+// handled; err is what handling it returned.
+func (p *parser) tapTag(tok Token, attrs map[string]string, err error) {
+	d := p.tagged
+	if err != nil {
+		d = TagError
+	}
+	p.tap(TagTokenInfo{
+		Kind:        tok.Kind,
+		Raw:         tok.Text,
+		Name:        tok.Name,
+		Attrs:       attrs,
+		Start:       tok.Start,
+		End:         tok.End,
+		Disposition: d,
+	})
+}
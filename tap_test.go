@@ -0,0 +1,56 @@
+package promptweaver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_TokenTap_Should_Report_Each_Tag_With_Its_Disposition(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think", AttrDefaults: map[string]string{"mode": "fast"}})
+	reg.Register(SectionPlugin{Name: "summary"})
+	reg.Register(SectionPlugin{Name: "project"})
+
+	input := `<div><think id="1">a <b>x</b></think></div><summary/></bogus>` +
+		`<project>p</project><think>cut</thi`
+
+	for _, n := range []int{1, 3, len(input)} {
+		var got []TagTokenInfo
+		en := NewEngineWithOptions(reg,
+			WithTokenTap(func(info TagTokenInfo) { got = append(got, info) }),
+			WithContextSection("project"),
+			WithErrorHandler(func(error) bool { return true }),
+		)
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: n}, &recorderSink{}); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", n, err)
+		}
+
+		var lines []string
+		for _, info := range got {
+			lines = append(lines, info.Kind.String()+" "+info.Raw+" "+info.Disposition.String())
+		}
+		want := []string{
+			"open <div> ignored-unknown",
+			`open <think id="1"> started-section`,
+			"close </think> closed-section",
+			"close </div> error",
+			"self-close <summary/> started-section",
+			"close </bogus> error",
+			"open <project> context",
+			"close </project> context",
+			"open <think> started-section",
+			"close </thi treated-as-content",
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Fatalf("chunk %d: got\n%s\nwant\n%s", n, strings.Join(lines, "\n"), strings.Join(want, "\n"))
+		}
+		// Attributes are as written, without the plugin's defaults.
+		if !reflect.DeepEqual(got[1].Attrs, map[string]string{"id": "1"}) {
+			t.Fatalf("chunk %d: attrs = %v", n, got[1].Attrs)
+		}
+		if got[1].Name != "think" || got[1].Start.Column != 6 || got[1].End.Column != 20 {
+			t.Fatalf("chunk %d: unexpected info %+v", n, got[1])
+		}
+	}
+}
@@ -0,0 +1,723 @@
+package promptweaver
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxContextRunes caps the recent input kept for error context, to bound memory.
+const maxContextRunes = 1000
+
+// TokenKind classifies a Token.
+type TokenKind int
+
+const (
+	TokenText       TokenKind = iota // plain text, including section bodies
+	TokenOpen                        // <name attr="...">
+	TokenClose                       // </name>
+	TokenSelfClose                   // <name .../>
+	TokenFenceStart                  // opening fence line of a code block, including its line ending
+	TokenFenceEnd                    // closing fence line, excluding its line ending
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenText:
+		return "text"
+	case TokenOpen:
+		return "open"
+	case TokenClose:
+		return "close"
+	case TokenSelfClose:
+		return "self-close"
+	case TokenFenceStart:
+		return "fence-start"
+	case TokenFenceEnd:
+		return "fence-end"
+	default:
+		return "unknown"
+	}
+}
+
+// Token is one lexical element of the raw stream. Concatenating the Text of all tokens
+// reproduces the input exactly.
+type Token struct {
+	Kind  TokenKind
+	Name  string            // tag name as written; the language for TokenFenceStart
+	Attrs map[string]string // tag attributes; the info-string metadata for TokenFenceStart
+	Text  string            // raw bytes of the token
+	Start Position
+	End   Position
+
+	// Incomplete is set on a final tag token cut short by the end of the input,
+	// such as `<create-file path="a`.
+	Incomplete bool
+
+	// Escape is set on the one-byte text token of an escape prefix (see
+	// EngineOptions.EscapePrefix). It stands for nothing; the byte after it is text.
+	Escape bool
+
+	dupAttr string // first attribute key the tag repeats, for EngineOptions.WellFormed
+}
+
+// TokenizerOptions configures a Tokenizer.
+type TokenizerOptions struct {
+	// Registry makes the bodies of registered sections raw, as the engine does: inside them
+	// only the matching closing tag is a tag. Without a registry every tag is a token.
+	Registry *Registry
+
+	// Fences reports code fences as TokenFenceStart/TokenFenceEnd; tags inside a fence are text.
+	// Fences inside a section body are reported only if its plugin sets ParseFencesInBody.
+	Fences bool
+
+	// LenientFences accepts deeply indented and blockquoted fences (see EngineOptions).
+	LenientFences bool
+
+	// InlineCode keeps tags inside inline code spans as text (see
+	// EngineOptions.InlineCodeAwareness).
+	InlineCode bool
+
+	// EscapePrefix makes the '<' after it text outside raw bodies (see
+	// EngineOptions.EscapePrefix). Zero disables escapes.
+	EscapePrefix byte
+
+	// BalancedSameName nests same-name tags in section bodies (see
+	// EngineOptions.BalancedSameName).
+	BalancedSameName bool
+
+	// MaxLookahead bounds the bytes buffered while deciding what they are (see
+	// EngineOptions.MaxLookahead). Zero means DefaultMaxLookahead; negative is unlimited.
+	MaxLookahead int
+}
+
+// lexMode decides which tags the Tokenizer recognizes.
+type lexMode int
+
+const (
+	lexTags lexMode = iota // every tag
+	lexRaw                 // only a closing tag accepted by closes
+	lexText                // none
+)
+
+// Tokenizer splits a stream into tokens with byte ranges. It is the lexer the Engine runs
+// on: the Engine pushes bytes into it and steers it into raw mode for section bodies, while
+// Next pulls from a reader and applies the same rules on its own.
+type Tokenizer struct {
+	r    io.Reader
+	reg  *Registry
+	rbuf []byte
+	eof  bool
+
+	buf          bytes.Buffer // unconsumed input
+	pos          Position     // position of buf[0]
+	lastContent  string       // recent input for error context, up to maxContextRunes runes
+	contextRunes int          // runes in lastContent
+	tag          tagScanner   // progress through a tag at the start of buf
+
+	mode      lexMode
+	closes    func(name string) bool // in lexRaw, whether a closing tag ends the body
+	until     string                 // in lexRaw, a terminator that ends the body instead of a closing tag
+	untilName string                 // name given to the close token of until
+	ownLine   bool                   // in lexRaw, closing tags count only on a line of their own
+	fences    bool                   // fence detection in the current mode
+	outFences bool                   // fence detection outside raw bodies
+	lenient   bool
+	lineStart bool        // buf starts a line that has not been classified yet
+	fence     *fenceState // the open fence, if any
+	skip      int         // bytes to hand out as text after an error
+
+	inlineCode bool // track inline code spans outside raw bodies
+	span       int  // length of the backtick run that opened the current span; 0 outside one
+	escape     byte // escape prefix outside raw bodies; 0 without escapes
+	literal    bool // the next byte follows an escape prefix
+
+	balanced bool                   // nest same-name tags in raw bodies
+	nests    func(name string) bool // in lexRaw, whether an opening tag nests in the body
+	depth    int                    // nested openers not closed yet
+	raw      rawScan                // progress through a tag at the start of buf in lexRaw
+}
+
+// rawScan is how far rawTag or nestedOpener got through a tag in a body before the chunk
+// ran out: up to i, with the name at [start:end] once those are known (zero until then),
+// inside a quoted value if quote is set. It lets a long tag be scanned once, not once per
+// chunk.
+type rawScan struct {
+	at            int64 // offset of the tag's '<'
+	i, start, end int
+	quote         byte
+}
+
+// resumeRaw returns the progress saved by pauseRaw through the tag at the start of buf, or
+// a new scan from i.
+func (t *Tokenizer) resumeRaw(i int) rawScan {
+	c := t.raw
+	t.raw = rawScan{}
+	if c.at != t.pos.Offset || c.i == 0 {
+		return rawScan{i: i}
+	}
+	return c
+}
+
+// pauseRaw saves c until more input arrives.
+func (t *Tokenizer) pauseRaw(c rawScan) {
+	c.at = t.pos.Offset
+	t.raw = c
+}
+
+type fenceState struct {
+	char byte
+	n    int
+}
+
+// NewTokenizer returns a Tokenizer reading from r.
+func NewTokenizer(r io.Reader, opts TokenizerOptions) *Tokenizer {
+	t := newTokenizer(opts.Fences, opts.LenientFences)
+	t.r, t.reg, t.inlineCode, t.escape = r, opts.Registry, opts.InlineCode, opts.EscapePrefix
+	t.balanced = opts.BalancedSameName
+	t.tag.maxLen = limitOrDefault(opts.MaxLookahead, DefaultMaxLookahead)
+	return t
+}
+
+func newTokenizer(fences, lenient bool) *Tokenizer {
+	return &Tokenizer{
+		pos:       Position{Line: 1, Column: 1},
+		fences:    fences,
+		outFences: fences,
+		lenient:   lenient,
+		lineStart: true,
+	}
+}
+
+// Next returns the next token, or io.EOF after the last one. Text may be split across
+// several tokens. A malformed tag is reported as an error; calling Next again continues
+// after it, returning the bytes up to the problem as text.
+func (t *Tokenizer) Next() (Token, error) {
+	if t.r == nil {
+		return Token{}, ErrNilReader
+	}
+	for {
+		tok, ok, err := t.next(t.eof)
+		if err != nil {
+			return Token{}, err
+		}
+		if ok {
+			t.follow(tok)
+			return tok, nil
+		}
+		if t.eof {
+			return Token{}, io.EOF
+		}
+		if t.rbuf == nil {
+			t.rbuf = make([]byte, 4096)
+		}
+		n, err := t.r.Read(t.rbuf)
+		t.feed(t.rbuf[:n])
+		if err == io.EOF {
+			t.eof = true
+		} else if err != nil {
+			return Token{}, err
+		}
+	}
+}
+
+// follow enters and leaves raw mode for registered sections, as the engine does.
+func (t *Tokenizer) follow(tok Token) {
+	if t.reg == nil || tok.Incomplete {
+		return
+	}
+	switch {
+	case tok.Kind == TokenOpen && t.mode == lexTags:
+		if canon, ok := t.reg.Canonical(tok.Name); ok {
+			plugin, _ := t.reg.Plugin(canon)
+			t.enterRaw(closesSection(t.reg, canon, tok.Name), t.outFences && plugin.ParseFencesInBody)
+			t.opaque(plugin, tok)
+			t.nest(closesSection(t.reg, canon, tok.Name))
+		}
+	case tok.Kind == TokenClose && t.mode == lexRaw:
+		t.exitRaw()
+	}
+}
+
+// closesSection returns the closing-tag test for a section opened as openName:
+// any alias of the same canonical name, or the literal open name if the closer is unregistered.
+func closesSection(reg *Registry, canon, openName string) func(string) bool {
+	return func(name string) bool {
+		if c, ok := reg.Canonical(name); ok {
+			return c == canon
+		}
+		return strings.EqualFold(name, openName)
+	}
+}
+
+// closesOrStrays extends closes to the closers of every registered section, for bodies
+// that report mismatched closing tags instead of treating them as text.
+func closesOrStrays(reg *Registry, closes func(string) bool) func(string) bool {
+	return func(name string) bool { return closes(name) || reg.IsAllowed(name) }
+}
+
+func (t *Tokenizer) feed(b []byte) { t.buf.Write(b) }
+
+// enterRaw treats everything up to a closing tag accepted by closes as text.
+// With fences, the body is scanned for code fences as a document of its own.
+func (t *Tokenizer) enterRaw(closes func(string) bool, fences bool) {
+	t.mode, t.closes = lexRaw, closes
+	t.fences, t.fence, t.lineStart = fences, nil, true
+}
+
+// opaque applies the plugin's RawUntil and RawDelimiter to the body opened by open,
+// right after enterRaw. Either one turns fence detection off: the body is not looked into.
+func (t *Tokenizer) opaque(plugin SectionPlugin, open Token) {
+	if plugin.RawUntil != "" {
+		if v, _ := lookupAttr(open.Attrs, plugin.RawUntil); v != "" {
+			t.until, t.untilName, t.fences = v, open.Name, false
+			return
+		}
+	}
+	if plugin.RawDelimiter {
+		t.ownLine, t.fences = true, false
+	}
+}
+
+// nest makes opening tags accepted by same deepen the body, when same-name tags are
+// balanced, right after opaque. Opaque bodies do not nest.
+func (t *Tokenizer) nest(same func(string) bool) {
+	if t.balanced && t.until == "" && !t.ownLine {
+		t.nests, t.depth = same, 0
+	}
+}
+
+// exitRaw returns to recognizing every tag. The rest of the line cannot open a fence.
+func (t *Tokenizer) exitRaw() {
+	t.mode, t.closes, t.nests = lexTags, nil, nil
+	t.until, t.untilName, t.ownLine = "", "", false
+	t.fences, t.fence, t.lineStart = t.outFences, nil, false
+}
+
+// next returns the next token from the buffered input. ok is false when more input is
+// needed; with atEOF, whatever is buffered is returned instead. After an error, the next
+// call returns the offending bytes as text.
+func (t *Tokenizer) next(atEOF bool) (tok Token, ok bool, err error) {
+	if t.skip > 0 {
+		n := t.skip
+		t.skip = 0
+		return t.emit(TokenText, n), true, nil
+	}
+	data := t.buf.Bytes()
+	if len(data) == 0 {
+		return Token{}, false, nil
+	}
+
+	if t.fences && t.lineStart {
+		// A line longer than the lookahead limit is no fence.
+		line, capped := t.lookahead(data)
+		nl := bytes.IndexByte(line, '\n')
+		if nl < 0 && !atEOF && !capped && fenceCandidate(line, t.lenient, t.fence) {
+			return Token{}, false, nil
+		}
+		if nl >= 0 || atEOF && !capped {
+			if nl >= 0 {
+				line = data[:nl+1]
+			}
+			if tok, ok := t.fenceLine(line); ok {
+				return tok, true, nil
+			}
+		}
+		t.lineStart = false
+	}
+
+	end := len(data)
+	if t.fences {
+		// Stop at the end of the line so that the next one can be classified.
+		if nl := bytes.IndexByte(data, '\n'); nl >= 0 {
+			end = nl + 1
+		}
+	}
+	if t.mode == lexText || t.mode == lexTags && t.fence != nil {
+		return t.emit(TokenText, end), true, nil
+	}
+	if t.until != "" {
+		return t.untilTerminator(data, atEOF)
+	}
+	if (t.inlineCode || t.escape != 0) && t.mode == lexTags {
+		if t.literal {
+			t.literal = false
+			return t.emit(TokenText, 1), true, nil
+		}
+		n, wait := t.inlineText(data[:end], atEOF)
+		if n > 0 {
+			return t.emit(TokenText, n), true, nil
+		}
+		if wait {
+			return Token{}, false, nil
+		}
+		if data[0] == t.escape && t.escape != 0 {
+			return t.escaped(data[:end], atEOF)
+		}
+	}
+	if lt := bytes.IndexByte(data[:end], '<'); lt != 0 {
+		if lt > 0 {
+			end = lt
+		}
+		return t.emit(TokenText, end), true, nil
+	}
+
+	if t.mode == lexRaw {
+		return t.rawTag(data, atEOF)
+	}
+	n, tag, ok, err := t.tag.scan(data, t.pos, t.lastContent)
+	if err != nil {
+		t.skip = n
+		return Token{}, false, err
+	}
+	if !ok {
+		if atEOF {
+			return t.incomplete(len(data)), true, nil
+		}
+		return Token{}, false, nil
+	}
+	kind := TokenOpen
+	switch tag.kind {
+	case tokenClose:
+		kind = TokenClose
+	case tokenSelfClose:
+		kind = TokenSelfClose
+	}
+	tok = t.emit(kind, n)
+	tok.Name, tok.Attrs, tok.dupAttr = tag.name, tag.attrs, tag.dup
+	return tok, true, nil
+}
+
+// inlineText returns how many bytes at the start of data are text, stopping at a '<' or
+// an escape prefix outside an inline code span, and follows the spans opened and closed on
+// the way. A span is closed by a backtick run as long as the one that opened it, or by the
+// end of the line. wait reports that data ends in a backtick run the next chunk may extend.
+func (t *Tokenizer) inlineText(data []byte, atEOF bool) (n int, wait bool) {
+	for n < len(data) {
+		if data[n] == t.escape && t.escape != 0 && t.span == 0 {
+			return n, false
+		}
+		switch data[n] {
+		case '<':
+			if t.span == 0 {
+				return n, false
+			}
+		case '\n':
+			t.span = 0
+		case '`':
+			if !t.inlineCode {
+				break
+			}
+			// Runs are cut at the lookahead limit, so that a long one is not waited on.
+			run, limit := n, t.tag.maxLen
+			for run < len(data) && data[run] == '`' && (limit <= 0 || run-n < limit) {
+				run++
+			}
+			if run == len(data) && !atEOF && (limit <= 0 || run-n < limit) {
+				return n, true
+			}
+			switch {
+			case t.span == 0:
+				t.span = run - n
+			case t.span == run-n:
+				t.span = 0
+			}
+			n = run
+			continue
+		}
+		n++
+	}
+	return n, false
+}
+
+// escaped handles an escape prefix at the start of data. Before '<', or before a second
+// prefix and then '<', it is an Escape token and the byte after it is text: `\<` is a
+// literal '<' and `\\<` a literal backslash before a tag. Anywhere else it is text.
+func (t *Tokenizer) escaped(data []byte, atEOF bool) (Token, bool, error) {
+	if (len(data) < 2 || len(data) < 3 && data[1] == t.escape) && !atEOF {
+		return Token{}, false, nil
+	}
+	if len(data) >= 2 && data[1] == '<' || len(data) >= 3 && data[1] == t.escape && data[2] == '<' {
+		t.literal = true
+		tok := t.emit(TokenText, 1)
+		tok.Escape = true
+		return tok, true, nil
+	}
+	return t.emit(TokenText, 1), true, nil
+}
+
+// fenceLine classifies a complete line that starts at buf[0] as a fence opener or closer.
+func (t *Tokenizer) fenceLine(line []byte) (Token, bool) {
+	trimmed := strings.TrimRight(string(line), "\r\n")
+	if t.fence != nil {
+		if !isFenceCloser(trimmed, t.fence.char, t.fence.n, t.lenient) {
+			return Token{}, false
+		}
+		t.fence = nil
+		return t.emit(TokenFenceEnd, len(trimmed)), true
+	}
+	char, n, _, info, ok := parseFenceOpener(trimmed, t.lenient)
+	if !ok {
+		return Token{}, false
+	}
+	t.fence = &fenceState{char: char, n: n}
+	tok := t.emit(TokenFenceStart, len(line))
+	tok.Name, tok.Attrs = ParseFenceMeta(info)
+	return tok, true
+}
+
+// rawTag handles a '<' inside a raw body: only a closing tag accepted by t.closes is a tag.
+// Spaces are tolerated after "</". Anything else is text.
+func (t *Tokenizer) rawTag(data []byte, atEOF bool) (Token, bool, error) {
+	data, capped := t.lookahead(data)
+	literal := func() (Token, bool, error) {
+		if len(data) >= 2 && data[1] == '/' {
+			return t.emit(TokenText, 2), true, nil
+		}
+		return t.emit(TokenText, 1), true, nil
+	}
+	wait := func() (Token, bool, error) {
+		if capped {
+			return literal()
+		}
+		if atEOF {
+			return t.incomplete(len(data)), true, nil
+		}
+		return Token{}, false, nil
+	}
+
+	if len(data) == 1 {
+		// A lone '<' at the end of a chunk may still become "</"
+		return wait()
+	}
+	if data[1] != '/' && t.nests != nil {
+		return t.nestedOpener(data, atEOF)
+	}
+	if data[1] != '/' || t.ownLine && !t.lineStart {
+		return literal()
+	}
+	c := t.resumeRaw(2)
+	pause := func() (Token, bool, error) {
+		if !capped && !atEOF {
+			t.pauseRaw(c)
+		}
+		return wait()
+	}
+	if c.start == 0 {
+		if c.i = skipSpace(data, c.i); c.i == len(data) {
+			return pause()
+		}
+		c.start = c.i
+	}
+	if c.end == 0 {
+		for c.i < len(data) && isNameChar(data[c.i]) {
+			c.i++
+		}
+		if c.i == c.start { // no name
+			t.skip = 2
+			return Token{}, false, NewMalformedTagError(t.pos, "", "missing tag name after '</'", t.lastContent)
+		}
+		if c.i == len(data) { // incomplete closer across chunk
+			return pause()
+		}
+		c.end = c.i
+	}
+	name := string(data[c.start:c.end])
+	if !t.closes(strings.ToLower(name)) {
+		return literal()
+	}
+	i := skipSpace(data, c.i)
+	if c.i = i; i == len(data) {
+		return pause()
+	}
+	if data[i] != '>' {
+		if t.ownLine {
+			return literal()
+		}
+		t.skip = 2
+		return Token{}, false, NewMalformedTagError(
+			t.pos, strings.ToLower(name), "expected '>' after closing tag name", t.lastContent)
+	}
+	if t.depth > 0 && t.nests(strings.ToLower(name)) {
+		t.depth--
+		return t.emit(TokenText, i+1), true, nil
+	}
+	if t.ownLine {
+		switch rest := data[i+1:]; {
+		case (len(rest) == 0 || len(rest) == 1 && rest[0] == '\r') && !atEOF:
+			return wait() // the end of the line is not here yet
+		case len(rest) > 0 && rest[0] != '\n' && !bytes.HasPrefix(rest, []byte("\r\n")) && !(len(rest) == 1 && rest[0] == '\r'):
+			return literal()
+		}
+	}
+	tok := t.emit(TokenClose, i+1)
+	tok.Name = name
+	return tok, true, nil
+}
+
+// nestedOpener handles a '<' that does not start a closer in a body with nesting: an
+// opening tag accepted by t.nests is text that deepens the body, so that the next closer
+// of the name ends it instead of the section. A self-closing tag does not. Anything else
+// is text.
+func (t *Tokenizer) nestedOpener(data []byte, atEOF bool) (Token, bool, error) {
+	data, capped := t.lookahead(data)
+	atEOF = atEOF || capped // past the limit, an unfinished opener is text
+	c := t.resumeRaw(1)
+	if c.end == 0 {
+		for c.i < len(data) && isNameChar(data[c.i]) {
+			c.i++
+		}
+		i := c.i
+		if i == len(data) && !atEOF {
+			t.pauseRaw(c)
+			return Token{}, false, nil
+		}
+		if i == 1 || i == len(data) || !isSpace(data[i]) && data[i] != '>' && data[i] != '/' || !t.nests(strings.ToLower(string(data[1:i]))) {
+			return t.emit(TokenText, 1), true, nil
+		}
+		c.start, c.end = 1, i
+	}
+	// Find the end of the tag, skipping quoted attribute values.
+	for ; c.i < len(data); c.i++ {
+		switch b := data[c.i]; {
+		case c.quote != 0:
+			if b == c.quote {
+				c.quote = 0
+			}
+		case b == '"' || b == '\'':
+			c.quote = b
+		case b == '<':
+			return t.emit(TokenText, 1), true, nil // not a tag after all
+		case b == '>':
+			if data[c.i-1] != '/' {
+				t.depth++
+			}
+			return t.emit(TokenText, c.i+1), true, nil
+		}
+	}
+	if !atEOF {
+		t.pauseRaw(c)
+		return Token{}, false, nil
+	}
+	return t.emit(TokenText, 1), true, nil
+}
+
+// untilTerminator scans a body that ends at the terminator t.until rather than at a closing
+// tag. The terminator comes out as the section's closing token. Bytes that may be the start
+// of the terminator are held back until more input shows whether they are.
+func (t *Tokenizer) untilTerminator(data []byte, atEOF bool) (Token, bool, error) {
+	at := bytes.Index(data, []byte(t.until))
+	if at == 0 {
+		tok := t.emit(TokenClose, len(t.until))
+		tok.Name = t.untilName
+		return tok, true, nil
+	}
+	n := at
+	if at < 0 {
+		n = len(data)
+		if !atEOF {
+			n = len(data) - partialSuffix(data, t.until)
+		}
+	}
+	if n == 0 {
+		return Token{}, false, nil
+	}
+	return t.emit(TokenText, n), true, nil
+}
+
+// partialSuffix is the length of the longest suffix of data that is a proper prefix of s.
+func partialSuffix(data []byte, s string) int {
+	for n := min(len(data), len(s)-1); n > 0; n-- {
+		if string(data[len(data)-n:]) == s[:n] {
+			return n
+		}
+	}
+	return 0
+}
+
+// lookahead cuts data to the bytes that may be buffered while deciding what they are (see
+// EngineOptions.MaxLookahead), and reports whether it cut any.
+func (t *Tokenizer) lookahead(data []byte) ([]byte, bool) {
+	if max := t.tag.maxLen; max > 0 && len(data) > max {
+		return data[:max], true
+	}
+	return data, false
+}
+
+// incomplete returns the n buffered bytes of an unfinished tag as a final token.
+func (t *Tokenizer) incomplete(n int) Token {
+	kind := TokenOpen
+	if len(t.buf.Bytes()) > 1 && t.buf.Bytes()[1] == '/' {
+		kind = TokenClose
+	}
+	name := t.tag.name
+	t.tag.reset()
+	tok := t.emit(kind, n)
+	tok.Name, tok.Incomplete = name, true
+	return tok
+}
+
+// emit consumes n bytes as a token of the given kind.
+func (t *Tokenizer) emit(kind TokenKind, n int) Token {
+	text := t.buf.Next(n)
+	tok := Token{Kind: kind, Text: string(text), Start: t.pos}
+	t.pos = advance(t.pos, text)
+	tok.End = t.pos
+	t.lineStart = text[len(text)-1] == '\n'
+
+	// Maintain a sliding window of recent content for error context, cut between runes
+	t.lastContent += tok.Text
+	t.contextRunes += runeStarts(text)
+	if excess := t.contextRunes - maxContextRunes; excess > 0 {
+		t.lastContent = dropRunes(t.lastContent, excess)
+		t.contextRunes = maxContextRunes
+	}
+	return tok
+}
+
+// runeStarts counts the bytes of b that start a rune, or are invalid on their own.
+func runeStarts(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if utf8.RuneStart(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// dropRunes removes the first n runes of s, with whatever continuation bytes follow them.
+func dropRunes(s string, n int) string {
+	i := 0
+	for ; n > 0 && i < len(s); n-- {
+		for i++; i < len(s) && !utf8.RuneStart(s[i]); i++ {
+		}
+	}
+	return s[i:]
+}
+
+// fenceCandidate reports whether data, the incomplete start of a line, may still turn out
+// to open a fence, or to close f when f is set, once the line is complete.
+func fenceCandidate(data []byte, lenient bool, f *fenceState) bool {
+	_, rest, ok := splitFenceIndent(string(data), lenient)
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		return true
+	}
+	c := rest[0]
+	if f != nil && c != f.char || f == nil && c != '`' && c != '~' {
+		return false
+	}
+	run := 0
+	for run < len(rest) && rest[run] == c {
+		run++
+	}
+	min := 3
+	if f != nil {
+		min = f.n
+	}
+	return run == len(rest) || run >= min
+}
@@ -0,0 +1,391 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func collectTokens(t *testing.T, tz *Tokenizer) ([]Token, []error) {
+	t.Helper()
+	var toks []Token
+	var errs []error
+	for {
+		tok, err := tz.Next()
+		if err == io.EOF {
+			return toks, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if len(errs) > 10 {
+				t.Fatalf("tokenizer does not make progress: %v", errs)
+			}
+			continue
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func Test_Tokenizer_Should_Report_Tokens_With_Ranges(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	input := "Hi <create-file path=\"a.go\">x <b>y</b></create-file>\n" +
+		"```go\n<i>not a tag</i>\n```\n<note/> </end> <create-file path=\"b"
+
+	tz := NewTokenizer(&chunkedReader{data: []byte(input), chunk: 2}, TokenizerOptions{Registry: reg, Fences: true})
+	toks, errs := collectTokens(t, tz)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var rebuilt strings.Builder
+	var kinds []string
+	for _, tok := range toks {
+		if input[tok.Start.Offset:tok.End.Offset] != tok.Text {
+			t.Fatalf("token %+v does not match its range", tok)
+		}
+		rebuilt.WriteString(tok.Text)
+		if tok.Kind != TokenText {
+			kinds = append(kinds, tok.Kind.String()+":"+tok.Name)
+		}
+	}
+	if rebuilt.String() != input {
+		t.Fatalf("tokens do not reproduce the input:\n%q", rebuilt.String())
+	}
+	want := []string{"open:create-file", "close:create-file", "fence-start:go", "fence-end:", "self-close:note", "close:end", "open:create-file"}
+	if strings.Join(kinds, " ") != strings.Join(want, " ") {
+		t.Fatalf("got kinds %v\nwant %v", kinds, want)
+	}
+	last := toks[len(toks)-1]
+	if !last.Incomplete || last.Text != `<create-file path="b` {
+		t.Fatalf("expected an incomplete final tag, got %+v", last)
+	}
+}
+
+func Test_Engine_Should_Bound_Tag_Lookahead(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := `<summary a="` + strings.Repeat("x", 100) + `<summary>y</summary>`
+
+	err := NewEngineWithOptions(reg, WithMaxLookahead(32)).ProcessStream(strings.NewReader(input), NewHandlerSink())
+	var merr *MalformedTagError
+	if !errors.As(err, &merr) || !strings.Contains(err.Error(), "tag longer than 32 bytes") {
+		t.Fatalf("expected a tag longer than 32 bytes, got %v", err)
+	}
+
+	// Recovered, parsing resumes past the cap, the same way whatever the chunking.
+	var want []Event
+	for _, chunk := range []int{1, 7, len(input)} {
+		rec := &recorderSink{}
+		en := NewEngineWithOptions(reg, WithMaxLookahead(32), WithContinueMode())
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		last, ok := AsSection(rec.events[len(rec.events)-1])
+		if !ok || last.Content != "y" {
+			t.Fatalf("chunk %d: unexpected events %+v", chunk, rec.events)
+		}
+		if want == nil {
+			want = rec.events
+		} else if !reflect.DeepEqual(rec.events, want) {
+			t.Fatalf("chunk %d: events %+v, want %+v", chunk, rec.events, want)
+		}
+	}
+}
+
+func Test_Engine_Should_Parse_Tags_Past_64KiB_By_Default(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	data := strings.Repeat("x", 200<<10)
+	input := `<create-file data="` + data + `"><create-file n="` + data + `">in</create-file></ create-file` +
+		strings.Repeat(" ", 100<<10) + `>`
+	rec := &recorderSink{}
+	if err := NewEngineWithOptions(reg, WithBalancedSameName(true)).ProcessStream(&chunkedReader{data: []byte(input), chunk: 4096}, rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("got %d events", len(rec.events))
+	}
+	if sev := rec.events[0].(SectionEvent); sev.Attrs["data"] != data || !strings.HasSuffix(sev.Content, ">in</create-file>") {
+		t.Fatalf("unexpected section: %d attribute bytes, content ending %q", len(sev.Attrs["data"]), sev.Content[len(sev.Content)-20:])
+	}
+}
+
+func Test_Tokenizer_Should_Not_Buffer_Past_The_Lookahead(t *testing.T) {
+	input := `<a b="` + strings.Repeat("x", 1<<20)
+	tz := NewTokenizer(ReaderFromString(input), TokenizerOptions{MaxLookahead: 1024})
+	for {
+		_, err := tz.Next()
+		if err == io.EOF {
+			break
+		}
+		if tz.buf.Len() > 64<<10 {
+			t.Fatalf("buffered %d bytes", tz.buf.Len())
+		}
+	}
+
+	// A fence line longer than the lookahead stays text.
+	tz = NewTokenizer(ReaderFromString("```"+strings.Repeat("`", 2000)+"\n"), TokenizerOptions{MaxLookahead: 1024})
+	toks, _ := collectTokens(t, tz)
+	var text strings.Builder
+	for _, tok := range toks {
+		text.WriteString(tok.Text)
+	}
+	if got := text.String(); got != "```"+strings.Repeat("`", 2000)+"\n" {
+		t.Fatalf("lost content: %d bytes", len(got))
+	}
+}
+
+func Test_Tokenizer_Should_Continue_After_Malformed_Tag(t *testing.T) {
+	tz := NewTokenizer(ReaderFromString(`a < x> <b x=1> <c>`), TokenizerOptions{})
+	toks, errs := collectTokens(t, tz)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	var malformed *MalformedTagError
+	var attr *AttributeParsingError
+	if !errors.As(errs[0], &malformed) || !errors.As(errs[1], &attr) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if last := toks[len(toks)-1]; last.Kind != TokenOpen || last.Name != "c" {
+		t.Fatalf("expected <c> after recovery, got %+v", last)
+	}
+}
+
+func Test_Tokenizer_Should_Require_Reader(t *testing.T) {
+	if _, err := NewTokenizer(nil, TokenizerOptions{}).Next(); !errors.Is(err, ErrNilReader) {
+		t.Fatalf("expected ErrNilReader, got %v", err)
+	}
+}
+
+func Test_Engine_Should_End_RawUntil_Bodies_At_Their_Terminator(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "shell", RawUntil: "eof"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "<shell eof=\"END_7f\">cat <<X\n</shell> <summary>\nEND_7 END\nX\nEND_7f<summary>ok</summary>" +
+		"<shell>echo</shell>"
+
+	for chunk := 1; chunk <= len(input); chunk++ {
+		rec := &recorderSink{}
+		if err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 3 {
+			t.Fatalf("chunk %d: expected 3 events, got %+v", chunk, rec.events)
+		}
+		shell := rec.events[0].(SectionEvent)
+		if shell.Content != "cat <<X\n</shell> <summary>\nEND_7 END\nX\n" ||
+			input[shell.StartPos.Offset:shell.EndPos.Offset] != input[:strings.Index(input, "END_7f<")+6] {
+			t.Fatalf("chunk %d: unexpected shell %+v", chunk, shell)
+		}
+		if ev := rec.events[1].(SectionEvent); ev.Name != "summary" || ev.Content != "ok" {
+			t.Fatalf("chunk %d: unexpected summary %+v", chunk, ev)
+		}
+		// Without the attribute the usual closing tag applies.
+		if ev := rec.events[2].(SectionEvent); ev.Name != "shell" || ev.Content != "echo" {
+			t.Fatalf("chunk %d: unexpected fallback shell %+v", chunk, ev)
+		}
+	}
+}
+
+func Test_Engine_Should_Close_RawDelimiter_Bodies_Only_On_Their_Own_Line(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "regex", RawDelimiter: true})
+	input := "<regex>\na</regex>b\n</regex> x\n</regex>\r\n<regex>last\n</regex>"
+
+	for chunk := 1; chunk <= len(input); chunk++ {
+		rec := &recorderSink{}
+		if err := NewEngine(reg).ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 2 {
+			t.Fatalf("chunk %d: expected 2 events, got %+v", chunk, rec.events)
+		}
+		if ev := rec.events[0].(SectionEvent); ev.Content != "\na</regex>b\n</regex> x\n" {
+			t.Fatalf("chunk %d: unexpected first body %q", chunk, ev.Content)
+		}
+		if ev := rec.events[1].(SectionEvent); ev.Content != "last\n" {
+			t.Fatalf("chunk %d: unexpected last body %q", chunk, ev.Content)
+		}
+	}
+}
+
+func Test_Engine_Should_Limit_Attribute_Count_And_Name_Length(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+	many := `<summary a="1" b="1" c="1" d="1" e="1">x</summary>`
+	long := `<summary ` + strings.Repeat("n", 40) + `="1">x</summary>`
+
+	for input, want := range map[string]string{
+		many: "more than 4 attributes",
+		long: "attribute name longer than 32 bytes",
+	} {
+		en := NewEngineWithOptions(reg, WithMaxAttrs(4), WithMaxAttrNameLen(32))
+		err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: 5}, NewHandlerSink())
+		var merr *MalformedTagError
+		if !errors.As(err, &merr) || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	}
+
+	// Recovered, the tag is skipped; a negative limit lifts the cap.
+	rec := &recorderSink{}
+	en := NewEngineWithOptions(reg, WithMaxAttrs(4), WithContinueMode())
+	if err := en.ProcessStream(strings.NewReader(many+`<summary b="2">y</summary>`), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Content != "y" {
+		t.Fatalf("unexpected events %+v", rec.events)
+	}
+	var wide strings.Builder
+	wide.WriteString("<summary")
+	for i := 0; i < DefaultMaxAttrs+6; i++ {
+		wide.WriteString(" a" + strconv.Itoa(i) + `="1"`)
+	}
+	wide.WriteString("/>")
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(wide.String()), NewHandlerSink()); err == nil {
+		t.Fatalf("the default limit must apply")
+	}
+	if err := NewEngineWithOptions(reg, WithMaxAttrs(-1)).ProcessStream(strings.NewReader(wide.String()), NewHandlerSink()); err != nil {
+		t.Fatalf("unlimited attributes: %v", err)
+	}
+}
+
+func FuzzTagScanner_Should_Stay_Within_Attribute_Limits(f *testing.F) {
+	f.Add([]byte(`<a x="1" y='2' z={ {"k": "}"} }/>`), 3)
+	f.Add([]byte(`<a `+strings.Repeat(`k="v" `, 20)+`>`), 7)
+	f.Add([]byte(`<a `+strings.Repeat("k", 300)+`="v">`), 1)
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		if chunk <= 0 || chunk > len(data) {
+			chunk = len(data) + 1
+		}
+		s := tagScanner{maxAttrs: 8, maxKeyLen: 16}
+		for end := chunk; ; end += chunk {
+			if end > len(data) {
+				end = len(data)
+			}
+			_, tok, ok, err := s.scan(data[:end], Position{Line: 1, Column: 1}, "")
+			if len(s.attrs) > 8 || len(tok.attrs) > 8 {
+				t.Fatalf("more than 8 attributes collected")
+			}
+			for k := range tok.attrs {
+				if len(k) > 16 {
+					t.Fatalf("attribute name %q over the limit", k)
+				}
+			}
+			if ok || err != nil || end == len(data) {
+				return
+			}
+		}
+	})
+}
+
+func Test_Engine_Should_Keep_Tags_In_Inline_Code_Spans_As_Text(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "Use `<create-file>` or ``<create-file path=`x`>`` for new files.\n" +
+		"An open ` span ends with the line\n" +
+		"<summary>`<create-file>` stays in the body</summary>"
+
+	for chunk := 1; chunk <= len(input); chunk++ {
+		sink, got := newSinkCatcher("create-file", "summary")
+		err := NewEngineWithOptions(reg, WithInlineCodeAwareness(true)).
+			ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, sink)
+		if err != nil {
+			t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+		}
+		if len(*got) != 1 || (*got)[0].Name != "summary" || (*got)[0].Content != "`<create-file>` stays in the body" {
+			t.Fatalf("chunk %d: unexpected events %+v", chunk, *got)
+		}
+	}
+
+	// Without the option the first mention opens a section.
+	sink, got := newSinkCatcher("create-file", "summary")
+	_ = NewEngine(reg).ProcessStream(strings.NewReader(input), sink)
+	if len(*got) == 1 && (*got)[0].Name == "summary" {
+		t.Fatal("expected the mention to open a section without the option")
+	}
+	if !NewEngineWithOptions(reg, WithInlineCodeAwareness(true)).ContainsSections("`<summary>` <summary>x</summary>") {
+		t.Fatal("expected the probe to find the section after the span")
+	}
+	if NewEngineWithOptions(reg, WithInlineCodeAwareness(true)).CountSections("`<summary>x</summary>`")["summary"] != 0 {
+		t.Fatal("expected the probe to skip the span")
+	}
+}
+
+func Test_Engine_Should_Treat_Escaped_Tags_As_Text(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "create-file"})
+	input := `Write \<create-file> for C:\dir, then \\<create-file>a\<b</create-file>\`
+
+	for chunk := 1; chunk <= len(input); chunk++ {
+		rec := &recorderSink{}
+		err := NewEngineWithOptions(reg, WithEscapePrefix('\\'), WithPlainText(PlainTextOptions{})).
+			ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec)
+		if err != nil {
+			t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+		}
+		var got []string
+		for _, ev := range rec.events {
+			sev := ev.(SectionEvent)
+			got = append(got, sev.Name+":"+sev.Content)
+		}
+		// Bodies are literal: the escape inside create-file is kept.
+		want := []string{`PlainText:Write <create-file> for C:\dir, then \`, `create-file:a\<b`, `PlainText:\`}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("chunk %d: got %q, want %q", chunk, got, want)
+		}
+	}
+
+	// The tokens still add up to the input.
+	tz := NewTokenizer(strings.NewReader(input), TokenizerOptions{Registry: reg, EscapePrefix: '\\'})
+	var text strings.Builder
+	escapes := 0
+	for {
+		tok, err := tz.Next()
+		if err != nil {
+			break
+		}
+		text.WriteString(tok.Text)
+		if tok.Escape {
+			escapes++
+		}
+	}
+	if text.String() != input || escapes != 2 {
+		t.Fatalf("got %q with %d escapes", text.String(), escapes)
+	}
+}
+
+func Test_Engine_Should_Balance_Same_Name_Tags_In_Bodies(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think", Aliases: []string{"thinking"}})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := `<think>outer <thinking a=">">inner <think/></think> tail</think><summary>s</summary>`
+
+	for chunk := 1; chunk <= len(input); chunk++ {
+		sink, got := newSinkCatcher("think", "summary")
+		err := NewEngineWithOptions(reg, WithBalancedSameName(true)).
+			ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, sink)
+		if err != nil {
+			t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+		}
+		if len(*got) != 2 || (*got)[0].Content != `outer <thinking a=">">inner <think/></think> tail` || (*got)[1].Content != "s" {
+			t.Fatalf("chunk %d: unexpected events %+v", chunk, *got)
+		}
+	}
+
+	// By default the inner closer ends the section.
+	sink, got := newSinkCatcher("think")
+	_ = NewEngineWithOptions(reg, WithContinueMode()).ProcessStream(strings.NewReader(input), sink)
+	if len(*got) == 0 || (*got)[0].Content != `outer <thinking a=">">inner <think/>` {
+		t.Fatalf("unexpected events %+v", *got)
+	}
+	if n := NewEngineWithOptions(reg, WithBalancedSameName(true)).CountSections(input)["think"]; n != 1 {
+		t.Fatalf("expected the probe to count one think, got %d", n)
+	}
+}
@@ -0,0 +1,111 @@
+package promptweaver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// ToolMapping controls how sections are translated into OpenAI-style tool calls.
+type ToolMapping struct {
+	// Names renames sections to function names (e.g. "create-file" -> "write_file").
+	// Sections without an entry keep their name. Names and Attrs are looked up ignoring
+	// case, so they apply with EngineOptions.OriginalNameCasing too.
+	Names map[string]string
+
+	// Attrs restricts, per section, which attributes become arguments.
+	// Sections without an entry pass all attributes through.
+	Attrs map[string][]string
+
+	// ContentKey is the argument that receives the section content. Empty means "content".
+	// Content takes precedence over an attribute with the same key.
+	ContentKey string
+}
+
+type toolCall struct {
+	Type     string       `json:"type"`
+	Function toolFunction `json:"function"`
+}
+
+type toolFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToToolCall renders ev as {"type":"function","function":{"name":...,"arguments":"{...}"}}.
+// The arguments are themselves JSON, encoded into a string as the OpenAI format requires.
+// Content is escaped by encoding/json, so any text round-trips; invalid UTF-8 sequences
+// are replaced with U+FFFD since JSON strings cannot carry them.
+func ToToolCall(ev SectionEvent, mapping ToolMapping) ([]byte, error) {
+	args := map[string]string{}
+	if allowed, ok := lookupFold(mapping.Attrs, ev.Name); ok {
+		for _, k := range allowed {
+			if v, ok := ev.Attr(k); ok {
+				args[k] = v
+			}
+		}
+	} else {
+		for k, v := range ev.Attrs {
+			args[k] = v
+		}
+	}
+	contentKey := mapping.ContentKey
+	if contentKey == "" {
+		contentKey = "content"
+	}
+	args[contentKey] = ev.Content
+
+	argsJSON, err := marshalJSON(args)
+	if err != nil {
+		return nil, err
+	}
+
+	name := ev.Name
+	if renamed, ok := lookupFold(mapping.Names, ev.Name); ok {
+		name = renamed
+	}
+	return marshalJSON(toolCall{
+		Type:     "function",
+		Function: toolFunction{Name: name, Arguments: string(argsJSON)},
+	})
+}
+
+// marshalJSON is json.Marshal without HTML escaping, so code content stays readable.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// ToolCallSink writes one tool-call JSON object per line for every section event.
+type ToolCallSink struct {
+	w       io.Writer
+	mapping ToolMapping
+	err     error
+}
+
+// NewToolCallSink creates a ToolCallSink writing to w.
+func NewToolCallSink(w io.Writer, mapping ToolMapping) *ToolCallSink {
+	return &ToolCallSink{w: w, mapping: mapping}
+}
+
+// OnEvent implements EventSink. After the first write error, further events are dropped.
+func (s *ToolCallSink) OnEvent(e Event) {
+	ev, ok := e.(SectionEvent)
+	if !ok || s.err != nil {
+		return
+	}
+	b, err := ToToolCall(ev, s.mapping)
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.w.Write(append(b, '\n'))
+}
+
+// Err returns the first error encountered while writing.
+func (s *ToolCallSink) Err() error { return s.err }
@@ -0,0 +1,118 @@
+package promptweaver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_ToToolCall_Should_Render_Function_Call(t *testing.T) {
+	ev := SectionEvent{
+		Name:    "create-file",
+		Attrs:   map[string]string{"path": "a.go", "type": "page"},
+		Content: "package main\n\nfunc main() { println(\"<hi>\\t\") }\n\x01",
+	}
+	mapping := ToolMapping{
+		Names: map[string]string{"create-file": "write_file"},
+		Attrs: map[string][]string{"create-file": {"path"}},
+	}
+	b, err := ToToolCall(ev, mapping)
+	if err != nil {
+		t.Fatalf("ToToolCall error: %v", err)
+	}
+
+	var call struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(b, &call); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, b)
+	}
+	if call.Type != "function" || call.Function.Name != "write_file" {
+		t.Fatalf("unexpected call: %s", b)
+	}
+
+	var args map[string]string
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		t.Fatalf("arguments are not valid JSON: %v\n%s", err, call.Function.Arguments)
+	}
+	if len(args) != 2 || args["path"] != "a.go" || args["content"] != ev.Content {
+		t.Fatalf("unexpected arguments: %#v", args)
+	}
+}
+
+func Test_ToToolCall_Should_Use_Defaults_And_ContentKey(t *testing.T) {
+	ev := SectionEvent{Name: "summary", Attrs: map[string]string{"tone": "brief"}, Content: "done"}
+	b, err := ToToolCall(ev, ToolMapping{ContentKey: "text"})
+	if err != nil {
+		t.Fatalf("ToToolCall error: %v", err)
+	}
+	want := `{"type":"function","function":{"name":"summary","arguments":"{\"text\":\"done\",\"tone\":\"brief\"}"}}`
+	if string(b) != want {
+		t.Fatalf("got  %s\nwant %s", b, want)
+	}
+}
+
+func Test_ToolCallSink_Should_Write_One_Object_Per_Event(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var out bytes.Buffer
+	sink := NewToolCallSink(&out, ToolMapping{Names: map[string]string{"write-file": "write_file"}})
+	en := NewEngine(reg)
+	input := `<create-file path="a.txt">x</create-file><summary>ok</summary>`
+	if err := en.ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if sink.Err() != nil {
+		t.Fatalf("sink error: %v", sink.Err())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"name":"write_file"`) || !strings.Contains(lines[1], `"name":"summary"`) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("disk full")
+}
+
+func Test_ToolCallSink_Should_Keep_First_Write_Error(t *testing.T) {
+	w := &failingWriter{}
+	sink := NewToolCallSink(w, ToolMapping{})
+	sink.OnEvent(SectionEvent{Name: "a"})
+	sink.OnEvent(SectionEvent{Name: "b"})
+	if sink.Err() == nil || w.n != 1 {
+		t.Fatalf("expected one failed write and a stored error, got %d writes, err=%v", w.n, sink.Err())
+	}
+}
+
+func Test_ToolCallSink_Should_Map_Sections_Whatever_Their_Casing(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "CreateFile"})
+	mapping := ToolMapping{
+		Names: map[string]string{"createfile": "write_file"},
+		Attrs: map[string][]string{"createfile": {"path"}},
+	}
+	for _, original := range []bool{false, true} {
+		var buf bytes.Buffer
+		sink := NewToolCallSink(&buf, mapping)
+		en := NewEngineWithOptions(reg, WithOriginalNameCasing(original))
+		if err := en.ProcessStream(strings.NewReader(`<CreateFile path="a.go" mode="0644">x</CreateFile>`), sink); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		want := `{"type":"function","function":{"name":"write_file","arguments":"{\"content\":\"x\",\"path\":\"a.go\"}"}}` + "\n"
+		if buf.String() != want {
+			t.Fatalf("original casing %v: got %s", original, buf.String())
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package promptweaver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TraceOptions controls what a TraceSink writes.
+type TraceOptions struct {
+	// Color wraps the event type column in ANSI colors, for terminals.
+	Color bool
+
+	// MaxPreview is the maximum number of content bytes shown per event.
+	// Zero uses a default of 40; a negative value disables the preview.
+	MaxPreview int
+
+	// MaxAttrLen truncates each attribute value. Zero uses a default of 24.
+	MaxAttrLen int
+
+	// PlainText traces the sections holding the text outside sections (see
+	// EngineOptions.PlainText). They are left out by default, but still numbered.
+	PlainText bool
+
+	// Next, if set, receives every event after it has been traced,
+	// so tracing can be layered over the real handlers.
+	Next EventSink
+}
+
+const (
+	defaultTracePreview = 40
+	defaultTraceAttrLen = 24
+
+	ansiReset  = "\x1b[0m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+)
+
+// TraceSink writes a readable, one-line-per-event timeline of a parse, each line labeled
+// with the event's Kind.
+// It is the quickest way to answer "why didn't my tag emit?":
+//
+//	trace := NewTraceSink(os.Stderr, TraceOptions{Color: true})
+//	engine := NewEngineWithOptions(reg, EngineOptions{
+//		ErrorHandler:      trace.TraceErrors(nil),
+//		UnknownTagHandler: trace.TraceUnknownTag,
+//	})
+//	_ = engine.ProcessStream(r, trace)
+//
+// For every tag and what became of it, between the events, also set
+// EngineOptions.TokenTap to trace.TraceTag.
+type TraceSink struct {
+	w    io.Writer
+	opts TraceOptions
+	seq  int
+}
+
+// NewTraceSink creates a TraceSink writing to w.
+func NewTraceSink(w io.Writer, opts TraceOptions) *TraceSink {
+	if opts.MaxPreview == 0 {
+		opts.MaxPreview = defaultTracePreview
+	}
+	if opts.MaxAttrLen == 0 {
+		opts.MaxAttrLen = defaultTraceAttrLen
+	}
+	return &TraceSink{w: w, opts: opts}
+}
+
+// OnEvent implements EventSink.
+func (t *TraceSink) OnEvent(e Event) {
+	t.trace(e)
+	if t.opts.Next != nil {
+		t.opts.Next.OnEvent(e)
+	}
+}
+
+// OnEventContext implements ContextSink, so a context-aware Next keeps its context and its
+// errors reach the engine.
+func (t *TraceSink) OnEventContext(ctx context.Context, e Event) error {
+	t.trace(e)
+	if t.opts.Next != nil {
+		return deliver(ctx, t.opts.Next, e)
+	}
+	return nil
+}
+
+// OnStreamStart implements StreamStartSink by telling Next, if it wants to know.
+func (t *TraceSink) OnStreamStart(meta StreamMeta) {
+	if ss, ok := t.opts.Next.(StreamStartSink); ok {
+		ss.OnStreamStart(meta)
+	}
+}
+
+// OnStreamEnd implements StreamEndSink by telling Next, if it wants to know.
+func (t *TraceSink) OnStreamEnd(err error) {
+	if es, ok := t.opts.Next.(StreamEndSink); ok {
+		es.OnStreamEnd(err)
+	}
+}
+
+func (t *TraceSink) trace(e Event) {
+	t.seq++
+	var detail string
+	switch ev := e.(type) {
+	case SectionEvent:
+		if ev.Name == SectionPlainText && !t.opts.PlainText {
+			return
+		}
+		detail = ev.Name + t.attrsAndContent(ev.Attrs, ev.Content)
+	case CodeBlockEvent:
+		detail = ev.Lang + t.attrsAndContent(ev.Meta, ev.Content)
+	case PairedEvent:
+		detail = fmt.Sprintf("%s #%03d -> %s #%03d", ev.Open.Name, ev.Open.Seq, ev.Close.Name, ev.Close.Seq)
+	case DigestEvent:
+		detail = fmt.Sprintf("bytes=%d sum=%s", ev.Bytes, truncateUTF8(fmt.Sprintf("%x", ev.Sum), 16))
+	case ProgressEvent:
+		detail = fmt.Sprintf("bytes=%d/%d %.0f%%", ev.BytesRead, ev.Total, ev.Percent)
+	case SectionClosedEvent:
+		detail = ev.Name + t.withAttrs(ev.Attrs) + fmt.Sprintf(" bytes=%d", ev.BytesWritten) + traceOutcome(ev.Partial, ev.Err)
+	case SupersededEvent:
+		detail = fmt.Sprintf("%s #%03d by #%03d", ev.Name, ev.Superseded, ev.By.Seq)
+	case IntentEvent:
+		detail = ev.Name + t.withAttrs(ev.Attrs)
+	case VetoedEvent:
+		detail = ev.Name + t.withAttrs(ev.Attrs) + fmt.Sprintf(" bytes=%d", ev.Bytes) + traceOutcome(ev.Partial, ev.Err)
+	}
+	color := ansiGreen
+	switch e.Kind() {
+	case KindVetoed:
+		color = ansiRed
+	case KindSuperseded, KindSectionClosed:
+		color = ansiYellow
+	}
+	line := fmt.Sprintf("#%03d %s", t.seq, t.color(color, string(e.Kind())))
+	if detail = strings.TrimSpace(detail); detail != "" {
+		line += " " + detail
+	}
+	fmt.Fprintln(t.w, line)
+}
+
+// withAttrs renders attrs for a trace line, with a leading space if there are any.
+func (t *TraceSink) withAttrs(attrs map[string]string) string {
+	if s := t.formatAttrs(attrs); s != "" {
+		return " " + s
+	}
+	return ""
+}
+
+// attrsAndContent renders attrs, the content's length and its preview for a trace line.
+func (t *TraceSink) attrsAndContent(attrs map[string]string, content string) string {
+	s := t.withAttrs(attrs) + fmt.Sprintf(" len=%d", len(content))
+	if t.opts.MaxPreview > 0 && content != "" {
+		s += " " + strconv.Quote(truncateUTF8(content, t.opts.MaxPreview))
+	}
+	return s
+}
+
+// traceOutcome notes on a trace line that a section was cut off, and why it was dropped.
+func traceOutcome(partial bool, err error) string {
+	s := ""
+	if partial {
+		s += " partial"
+	}
+	if err != nil {
+		msg, _, _ := strings.Cut(err.Error(), "\n")
+		s += ": " + msg
+	}
+	return s
+}
+
+// TraceErrors returns an ErrorHandler that writes each error to the trace before
+// deferring to next. With a nil next, every error is recovered from.
+func (t *TraceSink) TraceErrors(next ErrorHandler) ErrorHandler {
+	return func(err error) bool {
+		cont := true
+		if next != nil {
+			cont = next(err)
+		}
+		label := "recovered"
+		if !cont {
+			label = "fatal"
+		}
+		// Only the first line; the rendered context is too noisy for a timeline.
+		msg, _, _ := strings.Cut(err.Error(), "\n")
+		fmt.Fprintf(t.w, "     %s %s: %s\n", t.color(ansiRed, "error"), label, msg)
+		return cont
+	}
+}
+
+// TraceUnknownTag is an UnknownTagHandler that writes skipped tags to the trace.
+func (t *TraceSink) TraceUnknownTag(name string, pos Position) {
+	fmt.Fprintf(t.w, "     %s <%s> at %s\n", t.color(ansiYellow, "unknown"), name, pos)
+}
+
+// TraceTag is a TokenTap (see EngineOptions.TokenTap) that writes each tag and its
+// disposition to the trace.
+func (t *TraceSink) TraceTag(info TagTokenInfo) {
+	label := t.color(ansiGreen, "tag")
+	switch info.Disposition {
+	case TagError:
+		label = t.color(ansiRed, "tag")
+	case TagIgnoredUnknown, TagTreatedAsContent:
+		label = t.color(ansiYellow, "tag")
+	}
+	raw := info.Raw
+	if t.opts.MaxPreview > 0 {
+		raw = truncateUTF8(raw, t.opts.MaxPreview)
+	}
+	fmt.Fprintf(t.w, "     %s %s %s at %s\n", label, raw, info.Disposition, info.Start)
+}
+
+func (t *TraceSink) color(code, s string) string {
+	if !t.opts.Color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// formatAttrs renders attrs in sorted key order with each value truncated.
+func (t *TraceSink) formatAttrs(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + strconv.Quote(truncateUTF8(attrs[k], t.opts.MaxAttrLen))
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a rune, marking the cut with "…".
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,175 @@
+package promptweaver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_TraceSink_Should_Write_One_Line_Per_Event(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+
+	var out bytes.Buffer
+	trace := NewTraceSink(&out, TraceOptions{MaxPreview: 5, MaxAttrLen: 4})
+	en := NewEngine(reg)
+	input := `<think>hello world</think><create-file path="app/page.tsx"/>`
+	if err := en.ProcessStream(ReaderFromString(input), trace); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, got %d:\n%s", len(lines), out.String())
+	}
+	if lines[0] != `#001 section think len=11 "hello…"` {
+		t.Fatalf("unexpected first line: %q", lines[0])
+	}
+	if lines[1] != `#002 section write-file {path="app/…"} len=0` {
+		t.Fatalf("unexpected second line: %q", lines[1])
+	}
+}
+
+func Test_TraceSink_Should_Trace_Errors_And_Unknown_Tags(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var out bytes.Buffer
+	var got []SectionEvent
+	next := NewHandlerSink()
+	next.RegisterHandler("summary", func(ev SectionEvent) { got = append(got, ev) })
+
+	trace := NewTraceSink(&out, TraceOptions{Color: true, MaxPreview: -1, Next: next})
+	en := NewEngineWithOptions(reg, EngineOptions{
+		ErrorHandler:      trace.TraceErrors(nil),
+		UnknownTagHandler: trace.TraceUnknownTag,
+	})
+	input := `<div></bogus><summary>done</summary>`
+	if err := en.ProcessStream(ReaderFromString(input), trace); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	text := out.String()
+	for _, want := range []string{
+		ansiYellow + "unknown" + ansiReset + " <div> at line 1, column 1",
+		ansiRed + "error" + ansiReset + " recovered: unmatched closing tag </bogus>",
+		ansiGreen + "section" + ansiReset + " summary len=4\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("trace missing %q:\n%s", want, text)
+		}
+	}
+	if len(got) != 1 || got[0].Content != "done" {
+		t.Fatalf("events not forwarded to Next: %+v", got)
+	}
+}
+
+func Test_TraceSink_TraceErrors_Should_Defer_To_Next_Handler(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var out bytes.Buffer
+	trace := NewTraceSink(&out, TraceOptions{})
+	stop := func(error) bool { return false }
+	en := NewEngineWithOptions(reg, EngineOptions{ErrorHandler: trace.TraceErrors(stop)})
+	if err := en.ProcessStream(ReaderFromString(`</bogus>`), trace); err == nil {
+		t.Fatal("expected error to stop parsing")
+	}
+	if !strings.Contains(out.String(), "error fatal: unmatched closing tag </bogus>") {
+		t.Fatalf("unexpected trace: %s", out.String())
+	}
+}
+
+func Test_TraceSink_TraceTag_Should_Interleave_Tags_With_Events(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var out bytes.Buffer
+	trace := NewTraceSink(&out, TraceOptions{MaxPreview: -1})
+	en := NewEngineWithOptions(reg, WithTokenTap(trace.TraceTag))
+	if err := en.ProcessStream(ReaderFromString(`<div><summary>done</summary>`), trace); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	want := "     tag <div> ignored-unknown at line 1, column 1 (offset 0)\n" +
+		"     tag <summary> started-section at line 1, column 6 (offset 5)\n" +
+		"#001 section summary len=4\n" +
+		"     tag </summary> closed-section at line 1, column 19 (offset 18)\n"
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func Test_TraceSink_Should_Forward_Context_Errors_And_Stream_End(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var last []string
+	next := NewHandlerSink()
+	next.RegisterLastHandler("summary", func(ev SectionEvent) { last = append(last, ev.Content) })
+	var out bytes.Buffer
+	trace := NewTraceSink(&out, TraceOptions{Next: next})
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(`<summary>a</summary><summary>b</summary>`), trace); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(last) != 1 || last[0] != "b" {
+		t.Fatalf("last handler got %v, want [b]", last)
+	}
+
+	failing := errors.New("handler failed")
+	next = NewHandlerSink()
+	next.RegisterHandlerCtx("summary", func(context.Context, SectionEvent) error { return failing })
+	trace = NewTraceSink(&out, TraceOptions{Next: next})
+	if err := NewEngine(reg).ProcessStream(ReaderFromString(`<summary>a</summary>`), trace); !errors.Is(err, failing) {
+		t.Fatalf("expected the handler error through the trace, got %v", err)
+	}
+}
+
+func Test_TraceSink_Should_Show_PlainText_Only_When_Asked(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	en := NewEngineWithOptions(reg, WithPlainText(PlainTextOptions{}))
+	input := `intro <think>plan</think> outro`
+
+	var out bytes.Buffer
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString(input), NewTraceSink(&out, TraceOptions{Next: rec})); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != `#002 section think len=4 "plan"` || len(rec.events) != 3 {
+		t.Fatalf("plain text should be left out but forwarded, got %q and %d events", got, len(rec.events))
+	}
+
+	out.Reset()
+	if err := en.ProcessStream(ReaderFromString(input), NewTraceSink(&out, TraceOptions{PlainText: true})); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[0], SectionPlainText) {
+		t.Fatalf("plain text should be traced, got:\n%s", out.String())
+	}
+}
+
+func Test_TraceSink_Should_Write_Every_Event_Kind(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think", Suppress: true})
+	reg.Register(SectionPlugin{Name: "summary"})
+
+	var out bytes.Buffer
+	trace := NewTraceSink(&out, TraceOptions{MaxPreview: 5})
+	en := NewEngineWithOptions(reg, WithCodeBlocks(), WithSectionClosedEvents(true))
+	input := "```go file=\"a.go\"\nx := 1\n```\n<think>hmm</think><summary>ok</summary>"
+	if err := en.ProcessStream(ReaderFromString(input), trace); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	want := `#001 code_block go {file="a.go"} len=7 "x := …"
+#002 section_closed think bytes=3
+#003 section summary len=2 "ok"
+`
+	if out.String() != want {
+		t.Fatalf("unexpected trace:\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,16 @@
+package promptweaver
+
+import "fmt"
+
+// transformContent runs the plugin's ContentTransforms over content, in order. A failing
+// transform is a ValidationError at the section's opening tag.
+func (p *parser) transformContent(plugin SectionPlugin, el *element, content string) (string, error) {
+	for i, fn := range plugin.ContentTransforms {
+		out, err := fn(content)
+		if err != nil {
+			return content, NewValidationError(el.start, el.canon, fmt.Sprintf("content transform %d: %v", i+1, err), p.tz.lastContent)
+		}
+		content = out
+	}
+	return content, nil
+}
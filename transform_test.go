@@ -0,0 +1,78 @@
+package promptweaver
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_ContentTransforms_Should_Run_In_Order_Before_Validators(t *testing.T) {
+	ansi := regexp.MustCompile("\x1b\\[[0-9;]*m")
+	var calls []string
+	transforms := []func(string) (string, error){
+		func(s string) (string, error) {
+			calls = append(calls, "ansi")
+			return ansi.ReplaceAllString(s, ""), nil
+		},
+		func(s string) (string, error) {
+			calls = append(calls, "quotes")
+			return strings.NewReplacer("“", `"`, "”", `"`, "‘", "'", "’", "'").Replace(s), nil
+		},
+		func(s string) (string, error) {
+			calls = append(calls, "trim")
+			return strings.TrimSpace(s), nil
+		},
+	}
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "output", ContentTransforms: transforms})
+	reg.Register(SectionPlugin{Name: "quiet", ContentTransforms: transforms, Suppress: true})
+	reg.Register(SectionPlugin{Name: "write", ContentTransforms: transforms, EmitIntent: true})
+
+	en := NewEngineWithOptions(reg, WithErrorHandler(func(error) bool { return true }))
+	var validated string
+	en.RegisterFuncValidator("output", func(_, content string, _ Position) error {
+		validated = content
+		return nil
+	})
+	sink := NewHandlerSink()
+	var got []SectionEvent
+	sink.RegisterHandler("output", func(ev SectionEvent) { got = append(got, ev) })
+	sink.RegisterIntentHandler(func(IntentEvent) error { return errors.New("no") })
+
+	input := "<quiet> a </quiet><write> b </write>" +
+		"<output> \x1b[32m“ok”\x1b[0m </output><output>plain</output>"
+	if err := en.ProcessStream(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+
+	if strings.Join(calls, ",") != "ansi,quotes,trim,ansi,quotes,trim" {
+		t.Fatalf("transforms ran as %v; suppressed and vetoed sections must skip them", calls)
+	}
+	if len(got) != 2 || got[0].Content != `"ok"` || got[0].RawContent != " \x1b[32m“ok”\x1b[0m " {
+		t.Fatalf("unexpected first event %+v", got)
+	}
+	if got[1].Content != "plain" || got[1].RawContent != "" {
+		t.Fatalf("unchanged content must leave RawContent empty: %+v", got[1])
+	}
+	if validated != "plain" {
+		t.Fatalf("validator saw %q", validated)
+	}
+}
+
+func Test_ContentTransforms_Error_Should_Be_A_ValidationError_At_The_Section(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "config", ContentTransforms: []func(string) (string, error){
+		func(s string) (string, error) { return s, nil },
+		func(string) (string, error) { return "", errors.New("not ASCII") },
+	}})
+
+	err := NewEngine(reg).ProcessStream(ReaderFromString("x\n<config>é</config>"), NewHandlerSink())
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	if verr.SectionName != "config" || verr.Pos.Line != 2 || verr.Pos.Column != 1 || verr.Message != "content transform 2: not ASCII" {
+		t.Fatalf("unexpected error %+v", verr)
+	}
+}
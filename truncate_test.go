@@ -0,0 +1,114 @@
+package promptweaver
+
+import (
+	"strings"
+	"testing"
+)
+
+type truncationRecorder struct {
+	got      []string
+	original []int
+}
+
+func (v *truncationRecorder) Validate(section, content string, pos Position) error {
+	v.got, v.original = append(v.got, content), append(v.original, 0)
+	return nil
+}
+
+func (v *truncationRecorder) ValidateTruncated(section, content string, attrs map[string]string, originalSize int, pos Position) error {
+	v.got, v.original = append(v.got, content), append(v.original, originalSize)
+	return nil
+}
+
+func Test_Engine_Should_Truncate_Oversized_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "log", TruncateAt: 8, TruncationMarker: "…[truncated]"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	input := "<log>0123456789abcdef</log><log>short</log><summary>done</summary>"
+
+	for _, chunk := range []int{1, 3, len(input)} {
+		en := NewEngine(reg)
+		v := &truncationRecorder{}
+		en.RegisterValidator("log", v)
+		rec := &recorderSink{}
+		if err := en.ProcessStream(&chunkedReader{data: []byte(input), chunk: chunk}, rec); err != nil {
+			t.Fatalf("chunk %d: ProcessStream error: %v", chunk, err)
+		}
+		if len(rec.events) != 3 {
+			t.Fatalf("chunk %d: expected 3 events, got %+v", chunk, rec.events)
+		}
+		first := rec.events[0].(SectionEvent)
+		if first.Content != "01234567…[truncated]" || !first.Truncated || first.OriginalSize != 16 {
+			t.Fatalf("chunk %d: unexpected truncated event %+v", chunk, first)
+		}
+		if span := input[first.StartPos.Offset:first.EndPos.Offset]; span != "<log>0123456789abcdef</log>" {
+			t.Fatalf("chunk %d: the closer must still be found, span %q", chunk, span)
+		}
+		if second := rec.events[1].(SectionEvent); second.Content != "short" || second.Truncated || second.OriginalSize != 0 {
+			t.Fatalf("chunk %d: short section must be untouched, got %+v", chunk, second)
+		}
+		if strings.Join(v.got, "|") != "01234567|short" || v.original[0] != 16 || v.original[1] != 0 {
+			t.Fatalf("chunk %d: validators must see truncated content and its size, got %q %v", chunk, v.got, v.original)
+		}
+	}
+}
+
+func Test_Engine_Should_Truncate_On_Rune_Boundaries(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "note", TruncateAt: 4})
+	rec := &recorderSink{}
+	if err := NewEngine(reg).ProcessStream(ReaderFromString("<note>aéé</note>"), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	ev := rec.events[0].(SectionEvent)
+	if ev.Content != "aé" || !ev.Truncated || ev.OriginalSize != 5 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func Test_Engine_Should_Count_Truncated_Sections_Per_Name(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "Log", Aliases: []string{"output"}, TruncateAt: 4})
+	reg.Register(SectionPlugin{Name: "note"})
+	input := "<log>0123456789</log><output>abcdef</output><log>ok</log><note>0123456789</note>"
+
+	var counts []map[string]int
+	en := NewEngineWithOptions(reg, WithTruncatedSectionsHandler(func(m map[string]int) { counts = append(counts, m) }))
+	for range 2 {
+		if err := en.ProcessStream(ReaderFromString(input), &recorderSink{}); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+	}
+	if len(counts) != 2 || len(counts[1]) != 1 || counts[1]["log"] != 2 {
+		t.Fatalf("every stream should report its own counts, got %v", counts)
+	}
+
+	counts = nil
+	budget := NewEngineWithOptions(reg, WithByteBudget(map[string]int{"note": 5}, nil),
+		WithTruncatedSectionsHandler(func(m map[string]int) { counts = append(counts, m) }))
+	if err := budget.ProcessStream(ReaderFromString(input), &recorderSink{}); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(counts) != 1 || counts[0]["log"] != 2 || counts[0]["note"] != 1 {
+		t.Fatalf("budget truncations should be counted too, got %v", counts)
+	}
+}
+
+func Test_Engine_Should_Not_Count_Truncated_Sections_That_Fail_Validation(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "log", TruncateAt: 4})
+	input := "<log>bad-0123456789</log><log>0123456789</log>"
+
+	var counts map[string]int
+	en := NewEngineWithOptions(reg, WithContinueMode(), WithTruncatedSectionsHandler(func(m map[string]int) { counts = m }))
+	if err := en.RegisterRegexValidator("log", `^\d+$`, "digits only"); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorderSink{}
+	if err := en.ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || counts["log"] != 1 {
+		t.Fatalf("only the emitted section should be counted, got %d events and %v", len(rec.events), counts)
+	}
+}
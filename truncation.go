@@ -0,0 +1,55 @@
+package promptweaver
+
+import "strings"
+
+// OutputTruncation describes what a stream ended inside of, a sign that the output was cut
+// off, e.g. by a token limit, rather than finished. Only the innermost construct is named.
+type OutputTruncation struct {
+	Construct string   // "tag" (an incomplete tag), "code_block" or "section"
+	Name      string   // the tag or section name as far as it got, or the code block's language
+	OpenedAt  Position // where the construct started
+	Pos       Position // the end of the stream
+}
+
+// TruncationHandler is told, at EOF, that the stream ended inside a tag, code block or
+// section. Clean endings, with or without a trailing newline, are not reported.
+type TruncationHandler func(OutputTruncation)
+
+// reportTruncation tells the TruncationHandler what the stream ended inside of, if anything.
+// It runs at EOF once the input has been drained.
+func (p *parser) reportTruncation() {
+	if p.onTruncation == nil {
+		return
+	}
+	t := OutputTruncation{Pos: p.pos}
+	var block *codeBlock
+	if p.active != nil {
+		block = p.active.block
+	}
+	if block == nil {
+		block = p.block
+	}
+	switch {
+	case p.cutTag != nil:
+		t.Construct, t.Name, t.OpenedAt = "tag", p.cutTag.Name, p.cutTag.Start
+		if t.Name == "" {
+			name := strings.TrimLeft(p.cutTag.Text, "</ ")
+			t.Name = name[:strings.IndexFunc(name+" ", func(r rune) bool { return r >= 0x80 || !isNameChar(byte(r)) })]
+		}
+	case block != nil:
+		t.Construct, t.Name, t.OpenedAt = "code_block", block.lang, block.start
+	case p.active != nil:
+		t.Construct, t.Name, t.OpenedAt = "section", p.active.canon, p.active.start
+	default:
+		return
+	}
+	p.onTruncation(t)
+}
+
+// reportTruncatedSections hands the truncated sections per name to the
+// TruncatedSectionsHandler.
+func (p *parser) reportTruncatedSections() {
+	if p.onTruncations != nil {
+		p.onTruncations(p.truncations)
+	}
+}
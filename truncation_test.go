@@ -0,0 +1,36 @@
+package promptweaver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Engine_Should_Report_Likely_Truncated_Output(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file"})
+	reg.Register(SectionPlugin{Name: "plan", ParseFencesInBody: true})
+
+	for input, want := range map[string]string{
+		"<plan>ok</plan>":                     "",
+		"<plan>ok</plan>\n```go\nx := 1\n```": "", // no trailing newline
+		"text only":                           "",
+		"<plan>ok</plan><write-file path=\"a": "tag write-file 1:16",
+		"<write-file path=\"a\">package a\n":  "section write-file 1:1",
+		"<write-file path=\"a\">x</write-fi":  "tag write-fi 1:23",
+		"intro\n```go\nfunc main() {\n":       "code_block go 2:1",
+		"<plan>\n```sh\nmake":                 "code_block sh 2:1",
+	} {
+		var got []string
+		en := NewEngineWithOptions(reg, WithCodeBlocks(), WithContinueMode(), WithTruncationHandler(func(tr OutputTruncation) {
+			got = append(got, fmt.Sprintf("%s %s %d:%d", tr.Construct, tr.Name, tr.OpenedAt.Line, tr.OpenedAt.Column))
+			if tr.Pos.Offset != int64(len(input)) {
+				t.Errorf("%q: reported at %s", input, tr.Pos)
+			}
+		}))
+		_ = en.ProcessStream(strings.NewReader(input), &recorderSink{})
+		if strings.Join(got, "|") != want {
+			t.Errorf("%q: got %q, want %q", input, got, want)
+		}
+	}
+}
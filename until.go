@@ -0,0 +1,86 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// StopReason is why ProcessStreamUntil stopped reading.
+type StopReason string
+
+const (
+	StopEOF      StopReason = "eof"      // the input ended
+	StopDeadline StopReason = "deadline" // the context's deadline passed
+	StopCanceled StopReason = "canceled" // the context was canceled
+	StopError    StopReason = "error"    // the stream failed, such as on a parse or read error
+)
+
+// OpenSection is the section that was open when a stream stopped.
+type OpenSection struct {
+	Name    string            `json:"name"` // canonical section name
+	Attrs   map[string]string `json:"attrs,omitempty"`
+	Start   Position          `json:"start"`             // the opening tag
+	Bytes   int               `json:"bytes"`             // body bytes read so far, buffered or not
+	Content string            `json:"content,omitempty"` // the body buffered so far; empty for suppressed sections
+}
+
+// PartialResult describes how far ProcessStreamUntil got.
+type PartialResult struct {
+	Events        int          `json:"events"`         // events delivered to the sink, finalized ones included
+	BytesConsumed int64        `json:"bytes_consumed"` // input bytes parsed
+	Open          *OpenSection `json:"open,omitempty"` // the section open when the stream stopped, if any
+	Reason        StopReason   `json:"reason"`
+}
+
+// ProcessStreamUntil is ProcessStreamContext for callers that want what was parsed by the
+// time ctx is done. Instead of dropping it, a section open at that point is finalized as at
+// EOF, per the EOFPolicy, and the end-of-stream events are emitted. ContextSinks then get
+// ctx's values without its cancellation. The error is ctx.Err(), or the error finalizing
+// failed with, such as an UnclosedSectionError under ErrorPartial. The PartialResult
+// reports the events, the bytes parsed, the section that was open when the stream stopped,
+// and why it stopped; it is filled in whatever the error, so a stream stopped by a parse
+// error still reports its open section, though the section is not finalized. ctx is
+// checked between reads and deliveries, so a reader that blocks must honor ctx itself.
+func (e *Engine) ProcessStreamUntil(ctx context.Context, r io.Reader, sink EventSink) (res PartialResult, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := e.checkInputs(r, sink); err != nil {
+		return PartialResult{Reason: StopError}, err
+	}
+	s := e.startStream(ctx, sink, e.options, e.validators)
+	defer func() {
+		err = s.end(err)
+		res.Events, res.BytesConsumed = s.p.events, s.bytesRead
+	}()
+
+	err = s.read(ctx, r)
+	res.Open = s.p.openSection()
+	switch {
+	case err == nil:
+		res.Reason = StopEOF
+		return res, nil
+	case ctx.Err() == nil || !errors.Is(err, ctx.Err()):
+		res.Reason = StopError
+		return res, err
+	case errors.Is(err, context.DeadlineExceeded):
+		res.Reason = StopDeadline
+	default:
+		res.Reason = StopCanceled
+	}
+	s.p.ctx = context.WithoutCancel(ctx)
+	if cerr := s.close(); cerr != nil {
+		return res, cerr
+	}
+	return res, err
+}
+
+// openSection describes the section open now, or returns nil.
+func (p *parser) openSection() *OpenSection {
+	el := p.active
+	if el == nil || el.canon == "" {
+		return nil
+	}
+	return &OpenSection{Name: el.canon, Attrs: el.attrs, Start: el.start, Bytes: el.size(), Content: el.body.String()}
+}
@@ -0,0 +1,143 @@
+package promptweaver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallReader returns head, then blocks until ctx is done.
+type stallReader struct {
+	ctx  context.Context
+	head string
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	if r.head != "" {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func untilRegistry() *Registry {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "create-file"})
+	return reg
+}
+
+func Test_ProcessStreamUntil_Should_Report_EOF(t *testing.T) {
+	input := `<think>a</think> tail`
+	rec := &recorderSink{}
+	res, err := NewEngine(untilRegistry()).ProcessStreamUntil(context.Background(), strings.NewReader(input), rec)
+	if err != nil {
+		t.Fatalf("ProcessStreamUntil error: %v", err)
+	}
+	want := PartialResult{Events: len(rec.events), BytesConsumed: int64(len(input)), Reason: StopEOF}
+	if res != want || res.Events != 1 {
+		t.Fatalf("result = %+v, want %+v", res, want)
+	}
+}
+
+func Test_ProcessStreamUntil_Should_Finalize_The_Open_Section_At_The_Deadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	head := `<think>plan</think><create-file path="a.go">package a` + "\n"
+	var got []SectionEvent
+	var handlerCtx []error
+	sink := NewHandlerSink()
+	sink.RegisterHandler("think", func(ev SectionEvent) { got = append(got, ev) })
+	sink.RegisterHandlerCtx("create-file", func(ctx context.Context, ev SectionEvent) error {
+		got, handlerCtx = append(got, ev), append(handlerCtx, ctx.Err())
+		return nil
+	})
+	res, err := NewEngine(untilRegistry()).ProcessStreamUntil(ctx, &stallReader{ctx: ctx, head: head}, sink)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the deadline", err)
+	}
+	if res.Reason != StopDeadline || res.BytesConsumed != int64(len(head)) || res.Events != 2 {
+		t.Fatalf("result = %+v", res)
+	}
+	open := res.Open
+	if open == nil || open.Name != "create-file" || open.Attrs["path"] != "a.go" || open.Content != "package a\n" ||
+		open.Bytes != len("package a\n") || open.Start.Offset != int64(len("<think>plan</think>")) {
+		t.Fatalf("open = %+v", open)
+	}
+	if len(got) != 2 || got[1].Name != "create-file" || got[1].Content != "package a\n" {
+		t.Fatalf("sections = %+v", got)
+	}
+	if len(handlerCtx) != 1 || handlerCtx[0] != nil {
+		t.Fatalf("handlers should get an uncanceled context while finalizing, got %v", handlerCtx)
+	}
+}
+
+func Test_ProcessStreamUntil_Should_Follow_The_EOF_Policy_On_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &recorderSink{}
+	sink := EventSinkFunc(func(ev Event) {
+		rec.OnEvent(ev)
+		cancel() // stop after the first section
+	})
+	r := &chunkedReader{data: []byte("<think>a</think><create-file>part of it</create-file>"), chunk: 30}
+	en := NewEngineWithOptions(untilRegistry(), WithEOFPolicy(DropPartial))
+	res, err := en.ProcessStreamUntil(ctx, r, sink)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if res.Reason != StopCanceled || res.Events != 1 || res.BytesConsumed != 30 {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.Open == nil || res.Open.Name != "create-file" || res.Open.Content != "p" {
+		t.Fatalf("open = %+v", res.Open)
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("DropPartial should drop the open section, got %v", rec.events)
+	}
+}
+
+func Test_ProcessStreamUntil_Should_Report_Errors_Without_Finalizing(t *testing.T) {
+	rec := &recorderSink{}
+	input := `<think>a</think></think><create-file>x`
+	res, err := NewEngine(untilRegistry()).ProcessStreamUntil(context.Background(), strings.NewReader(input), rec)
+	var ute *UnmatchedTagError
+	if !errors.As(err, &ute) {
+		t.Fatalf("err = %v, want an UnmatchedTagError", err)
+	}
+	if res.Reason != StopError || res.Open != nil || res.Events != 1 {
+		t.Fatalf("result = %+v", res)
+	}
+
+	rec = &recorderSink{}
+	input = `<create-file path="a.go">package a` + strings.Repeat("\n// filler", 100)
+	en := NewEngineWithOptions(untilRegistry(), WithMaxStreamBytes(64))
+	res, err = en.ProcessStreamUntil(context.Background(), &chunkedReader{data: []byte(input), chunk: 16}, rec)
+	var sle *StreamLimitError
+	if !errors.As(err, &sle) || res.Reason != StopError {
+		t.Fatalf("result = %+v, err = %v", res, err)
+	}
+	if res.Open == nil || res.Open.Name != "create-file" || res.Open.Attrs["path"] != "a.go" || len(rec.events) != 0 {
+		t.Fatalf("the open section should be reported, not finalized: %+v, %+v", res.Open, rec.events)
+	}
+
+	res, err = NewEngine(untilRegistry()).ProcessStreamUntil(context.Background(), nil, rec)
+	if !errors.Is(err, ErrNilReader) || res.Reason != StopError {
+		t.Fatalf("nil reader: %+v, %v", res, err)
+	}
+}
+
+func Test_ProcessStreamUntil_Should_Return_The_Finalizing_Error(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	en := NewEngineWithOptions(untilRegistry(), WithEOFPolicy(ErrorPartial))
+	res, err := en.ProcessStreamUntil(ctx, &stallReader{ctx: ctx, head: "<think>half"}, &recorderSink{})
+	var use *UnclosedSectionError
+	if !errors.As(err, &use) || res.Reason != StopDeadline || res.Open == nil {
+		t.Fatalf("result = %+v, err = %v", res, err)
+	}
+}
@@ -2,7 +2,9 @@ package promptweaver
 
 import (
 	"fmt"
+	"maps"
 	"regexp"
+	"strings"
 )
 
 // Validator is an interface for validating section content.
@@ -12,6 +14,23 @@ type Validator interface {
 	Validate(sectionName string, content string, pos Position) error
 }
 
+// TruncationValidator is a Validator that wants to know when it sees content cut at
+// SectionPlugin.TruncateAt, e.g. to skip size or completeness checks. The engine calls
+// ValidateTruncated instead of Validate (or ValidateAttrs) for truncated sections.
+type TruncationValidator interface {
+	Validator
+	ValidateTruncated(sectionName, content string, attrs map[string]string, originalSize int, pos Position) error
+}
+
+// EventValidator is a Validator that checks the whole section event about to be emitted:
+// attributes, positions and the rest, all but Seq. The content of a truncated section does
+// not have its TruncationMarker yet. The engine calls ValidateEvent instead of Validate or
+// ValidateAttrs; ValidateTruncated still takes precedence for truncated sections.
+type EventValidator interface {
+	Validator
+	ValidateEvent(ev SectionEvent) error
+}
+
 // RegexValidator validates content against a regular expression.
 type RegexValidator struct {
 	Pattern     *regexp.Regexp
@@ -41,9 +60,23 @@ func (v *FuncValidator) Validate(sectionName string, content string, pos Positio
 	return v.ValidateFunc(sectionName, content, pos)
 }
 
+// EventFuncValidator uses a custom function to validate section events.
+type EventFuncValidator struct {
+	ValidateFunc func(ev SectionEvent) error
+}
+
+// Validate implements Validator with an event carrying only the name, content and position.
+func (v *EventFuncValidator) Validate(sectionName string, content string, pos Position) error {
+	return v.ValidateFunc(SectionEvent{EventBase: EventBase{EndPos: pos}, Name: sectionName, Content: content})
+}
+
+// ValidateEvent implements EventValidator.
+func (v *EventFuncValidator) ValidateEvent(ev SectionEvent) error { return v.ValidateFunc(ev) }
+
 // ValidatorRegistry manages validators for different section types.
 type ValidatorRegistry struct {
 	validators map[string][]Validator
+	reg        *Registry // optional; resolves aliases at registration time
 }
 
 // NewValidatorRegistry creates a new validator registry.
@@ -53,16 +86,34 @@ func NewValidatorRegistry() *ValidatorRegistry {
 	}
 }
 
+// NewValidatorRegistryFor creates a validator registry that resolves section names through reg,
+// so validators registered under an alias apply to the canonical section.
+func NewValidatorRegistryFor(reg *Registry) *ValidatorRegistry {
+	r := NewValidatorRegistry()
+	r.reg = reg
+	return r
+}
+
 // Register adds a validator for a section type.
 // Multiple validators can be registered for the same section type.
 func (r *ValidatorRegistry) Register(sectionName string, validator Validator) {
 	if validator == nil {
 		return
 	}
-	sectionName = canonicalName(sectionName)
+	sectionName = r.canonicalName(sectionName)
 	r.validators[sectionName] = append(r.validators[sectionName], validator)
 }
 
+// RegisterStrict is like Register but fails with ErrUnknownSection when the registry
+// was built with NewValidatorRegistryFor and does not know sectionName.
+func (r *ValidatorRegistry) RegisterStrict(sectionName string, validator Validator) error {
+	if r.reg != nil && !r.reg.IsAllowed(sectionName) {
+		return fmt.Errorf("%w: no plugin registered for validator %q", ErrUnknownSection, sectionName)
+	}
+	r.Register(sectionName, validator)
+	return nil
+}
+
 // RegisterRegex creates and registers a RegexValidator.
 func (r *ValidatorRegistry) RegisterRegex(sectionName, pattern, description string) error {
 	re, err := regexp.Compile(pattern)
@@ -84,26 +135,121 @@ func (r *ValidatorRegistry) RegisterFunc(sectionName string, validateFunc func(s
 	})
 }
 
+// RegisterEventFunc creates and registers an EventFuncValidator.
+func (r *ValidatorRegistry) RegisterEventFunc(sectionName string, validateFunc func(SectionEvent) error) {
+	r.Register(sectionName, &EventFuncValidator{
+		ValidateFunc: validateFunc,
+	})
+}
+
+// RegisterJSONSchema compiles schema and registers a JSONSchemaValidator.
+func (r *ValidatorRegistry) RegisterJSONSchema(sectionName string, schema []byte) error {
+	v, err := NewJSONSchemaValidator(schema)
+	if err != nil {
+		return fmt.Errorf("section %s: %w", sectionName, err)
+	}
+	r.Register(sectionName, v)
+	return nil
+}
+
 // ValidateSection validates content for a section type.
 // Returns nil if valid, or an error if any validator fails.
 func (r *ValidatorRegistry) ValidateSection(sectionName string, content string, pos Position) error {
-	sectionName = canonicalName(sectionName)
-	validators, ok := r.validators[sectionName]
-	if !ok {
-		// No validators registered for this section type
-		return nil
-	}
+	return r.validate(SectionEvent{EventBase: EventBase{EndPos: pos}, Name: sectionName, Content: content}, pos)
+}
 
-	for _, validator := range validators {
-		if err := validator.Validate(sectionName, content, pos); err != nil {
+// validate runs the validators of ev's section over it, reporting failures at pos.
+func (r *ValidatorRegistry) validate(ev SectionEvent, pos Position) error {
+	ev.Name = r.canonicalName(ev.Name)
+	for _, validator := range r.validators[ev.Name] {
+		if err := runValidator(validator, ev, pos); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-// Helper function to normalize section names
-func canonicalName(name string) string {
-	return name // For now, just return as is; could add case normalization if needed
+// runValidator calls the most specific of v's methods: ValidateTruncated for truncated
+// sections, then ValidateEvent, ValidateAttrs and Validate.
+func runValidator(v Validator, ev SectionEvent, pos Position) error {
+	if tv, ok := v.(TruncationValidator); ok && ev.Truncated {
+		return tv.ValidateTruncated(ev.Name, ev.Content, ev.Attrs, ev.OriginalSize, pos)
+	}
+	switch v := v.(type) {
+	case EventValidator:
+		return v.ValidateEvent(ev)
+	case AttrValidator:
+		return v.ValidateAttrs(ev.Name, ev.Content, ev.Attrs, pos)
+	}
+	return v.Validate(ev.Name, ev.Content, pos)
+}
+
+// count returns the number of validators registered for a section.
+func (r *ValidatorRegistry) count(sectionName string) int {
+	return len(r.validators[r.canonicalName(sectionName)])
+}
+
+// canonicalName normalizes section names: through the registry when one is attached,
+// otherwise by lowercasing, matching the names events are emitted under.
+func (r *ValidatorRegistry) canonicalName(name string) string {
+	if r.reg != nil {
+		if c, ok := r.reg.Canonical(name); ok {
+			return c
+		}
+	}
+	return strings.ToLower(name)
+}
+
+// StreamOptions adjusts validation and variables for a single stream (see
+// Engine.ProcessStreamWith).
+//
+// A section is checked by its plugin's rules (RejectEmpty) first, then by the engine's
+// validators unless the section is listed in DisableValidators, then by ExtraValidators.
+// The first failure stops the chain.
+type StreamOptions struct {
+	// ExtraValidators run after the engine's validators, keyed by section name or alias.
+	ExtraValidators map[string][]Validator
+
+	// DisableValidators lists sections (names or aliases) whose engine-level validators are
+	// skipped for this stream. Plugin rules and ExtraValidators still apply.
+	DisableValidators []string
+
+	// Variables are substituted for {{name}} in this stream's sections (see
+	// EngineOptions.Variables), on top of the engine's: a name in both takes this value.
+	// They turn expansion on even if the engine has no variables.
+	Variables map[string]string
+}
+
+// engineOptions returns base with o's variables added, or base itself when o has none.
+func (o StreamOptions) engineOptions(base EngineOptions) EngineOptions {
+	if o.Variables == nil {
+		return base
+	}
+	vars := maps.Clone(base.Variables)
+	if vars == nil {
+		vars = make(map[string]string, len(o.Variables))
+	}
+	maps.Copy(vars, o.Variables)
+	base.Variables = vars
+	return base
+}
+
+// validators returns base adjusted by o, or base itself when o changes nothing.
+func (o StreamOptions) validators(base *ValidatorRegistry) *ValidatorRegistry {
+	if len(o.ExtraValidators) == 0 && len(o.DisableValidators) == 0 {
+		return base
+	}
+	out := &ValidatorRegistry{validators: map[string][]Validator{}, reg: base.reg}
+	for name, vs := range base.validators {
+		out.validators[name] = vs[:len(vs):len(vs)] // appends below must not reach base
+	}
+	for _, name := range o.DisableValidators {
+		delete(out.validators, out.canonicalName(name))
+	}
+	for name, vs := range o.ExtraValidators {
+		for _, v := range vs {
+			out.Register(name, v)
+		}
+	}
+	return out
 }
@@ -0,0 +1,95 @@
+package promptweaver
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"strings"
+)
+
+// RequiredAttrsValidator rejects sections that lack any of the named attributes, or carry
+// them empty. Build with RequiredAttrs.
+type RequiredAttrsValidator struct {
+	Names []string
+
+	// Written requires the attributes to be written in the tag: values filled in from
+	// defaults (SectionEvent.DefaultedAttrs) do not count. By default they do.
+	Written bool
+}
+
+// RequiredAttrs returns a validator requiring the named attributes (matched ignoring case).
+func RequiredAttrs(names ...string) *RequiredAttrsValidator {
+	return &RequiredAttrsValidator{Names: names}
+}
+
+// Validate implements Validator. Without attributes every required one is missing.
+func (v *RequiredAttrsValidator) Validate(sectionName, content string, pos Position) error {
+	return v.ValidateAttrs(sectionName, content, nil, pos)
+}
+
+// ValidateAttrs implements AttrValidator.
+func (v *RequiredAttrsValidator) ValidateAttrs(sectionName, content string, attrs map[string]string, pos Position) error {
+	for _, name := range v.Names {
+		if val, ok := lookupAttr(attrs, name); !ok || strings.TrimSpace(val) == "" {
+			return NewValidationError(pos, sectionName, fmt.Sprintf("missing required attribute %q", name), "")
+		}
+	}
+	return nil
+}
+
+// ValidateEvent implements EventValidator, leaving out defaulted attributes if Written.
+func (v *RequiredAttrsValidator) ValidateEvent(ev SectionEvent) error {
+	attrs := ev.Attrs
+	if v.Written && len(ev.DefaultedAttrs) > 0 {
+		attrs = maps.Clone(attrs)
+		for _, k := range ev.DefaultedAttrs {
+			delete(attrs, k)
+		}
+	}
+	return v.ValidateAttrs(ev.Name, ev.Content, attrs, ev.EndPos)
+}
+
+// SafePathValidator rejects a path attribute that could escape the working directory:
+// absolute paths (including Windows drive and UNC forms), paths with a ".." element, and
+// paths containing NUL. Sections without the attribute pass; combine with RequiredAttrs
+// to demand it. Build with SafePath.
+type SafePathValidator struct {
+	Attr string
+}
+
+// SafePath returns a validator checking the path in attribute attr.
+func SafePath(attr string) *SafePathValidator { return &SafePathValidator{Attr: attr} }
+
+// Validate implements Validator; there is no attribute to check.
+func (v *SafePathValidator) Validate(sectionName, content string, pos Position) error {
+	return nil
+}
+
+// ValidateAttrs implements AttrValidator.
+func (v *SafePathValidator) ValidateAttrs(sectionName, content string, attrs map[string]string, pos Position) error {
+	p, ok := lookupAttr(attrs, v.Attr)
+	if !ok {
+		return nil
+	}
+	if reason := unsafePath(p); reason != "" {
+		return NewValidationError(pos, sectionName, fmt.Sprintf("unsafe %s %q: %s", v.Attr, p, reason), "")
+	}
+	return nil
+}
+
+// unsafePath says why p is not a safe relative path, or returns "".
+func unsafePath(p string) string {
+	if strings.ContainsRune(p, 0) {
+		return "contains NUL"
+	}
+	slashed := strings.ReplaceAll(p, `\`, "/")
+	if path.IsAbs(slashed) || (len(slashed) >= 2 && slashed[1] == ':') {
+		return "absolute path"
+	}
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "parent directory reference"
+		}
+	}
+	return ""
+}
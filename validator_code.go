@@ -0,0 +1,153 @@
+package promptweaver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AttrValidator is a Validator that also looks at the section's attributes, e.g. to decide
+// from a path or lang attribute whether the content is in its language. The engine calls
+// ValidateAttrs instead of Validate when a validator implements it.
+type AttrValidator interface {
+	Validator
+	ValidateAttrs(sectionName, content string, attrs map[string]string, pos Position) error
+}
+
+// ContentSyntaxError points at a place inside a section's content. Pos is relative to the
+// content (line and column from 1, offset from 0). The engine reports it as a
+// ValidationError positioned in the stream.
+type ContentSyntaxError struct {
+	Pos     Position
+	Message string
+}
+
+func (e *ContentSyntaxError) Error() string {
+	return fmt.Sprintf("%s at content %s", e.Message, e.Pos)
+}
+
+// BracesValidator checks that (), [] and {} are balanced, skipping strings and comments.
+// It is a cheap stand-in for a parser; regular expression literals are not recognized.
+type BracesValidator struct{}
+
+// TSXBalancedBracesValidator returns a validator for JavaScript and TypeScript (including
+// JSX/TSX). Sections with a path or lang attribute are only checked when it names one of them.
+func TSXBalancedBracesValidator() *BracesValidator { return &BracesValidator{} }
+
+// Validate implements Validator.
+func (v *BracesValidator) Validate(sectionName, content string, pos Position) error {
+	return v.ValidateAttrs(sectionName, content, nil, pos)
+}
+
+// ValidateAttrs implements AttrValidator.
+func (v *BracesValidator) ValidateAttrs(sectionName, content string, attrs map[string]string, pos Position) error {
+	langs := []string{"js", "jsx", "ts", "tsx", "javascript", "typescript"}
+	exts := []string{".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".mts", ".cts"}
+	if !InLanguage(attrs, langs, exts) {
+		return nil
+	}
+	return checkBraces(content)
+}
+
+func checkBraces(s string) error {
+	type open struct {
+		c   byte
+		pos Position
+	}
+	var stack []open
+	at := Position{Line: 1, Column: 1}
+	fail := func(pos Position, format string, args ...any) error {
+		return &ContentSyntaxError{Pos: pos, Message: fmt.Sprintf(format, args...)}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		n := 1
+		switch {
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			n = strings.IndexByte(s[i:], '\n')
+			if n < 0 {
+				n = len(s) - i
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			if end := strings.Index(s[i+2:], "*/"); end >= 0 {
+				n = end + 4
+			} else {
+				n = len(s) - i
+			}
+		case c == '`':
+			n = quotedLen(s[i:], '`', true)
+		case c == '"' || c == '\'':
+			// Only a quote closed on the same line starts a string; JSX text is full of
+			// apostrophes ("Don't") that are not.
+			n = max(quotedLen(s[i:], c, false), 1)
+		case c == '(' || c == '[' || c == '{':
+			stack = append(stack, open{c, at})
+		case c == ')' || c == ']' || c == '}':
+			want := map[byte]byte{')': '(', ']': '[', '}': '{'}[c]
+			if len(stack) == 0 {
+				return fail(at, "unexpected %q", c)
+			}
+			if top := stack[len(stack)-1]; top.c != want {
+				return fail(at, "unexpected %q, %q opened at %s is still open", c, top.c, top.pos)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		at = advance(at, []byte(s[i:i+n]))
+		i += n
+	}
+	if len(stack) > 0 {
+		top := stack[len(stack)-1]
+		return fail(top.pos, "%q is never closed", top.c)
+	}
+	return nil
+}
+
+// quotedLen returns the length of the string literal at the start of s, quotes included.
+// Without multiline, a literal that reaches a newline is not one and 0 is returned; with it,
+// an unterminated literal runs to the end of s.
+func quotedLen(s string, quote byte, multiline bool) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			if !multiline {
+				return 0
+			}
+		}
+	}
+	if multiline {
+		return len(s)
+	}
+	return 0
+}
+
+// InLanguage reports whether a section with attrs is in one of the given languages, by its
+// lang attribute or the extension of its path or file attribute. A section with neither is
+// assumed to be. Exts include the dot, as in ".go"; both lists are lowercase.
+func InLanguage(attrs map[string]string, langs, exts []string) bool {
+	lang, _ := lookupAttr(attrs, "lang")
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	file, _ := lookupAttr(attrs, "path")
+	if file == "" {
+		file, _ = lookupAttr(attrs, "file")
+	}
+	if lang == "" && file == "" {
+		return true
+	}
+	for _, l := range langs {
+		if lang == l {
+			return true
+		}
+	}
+	ext := strings.ToLower(path.Ext(file))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
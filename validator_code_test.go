@@ -0,0 +1,47 @@
+package promptweaver
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_TSXBalancedBracesValidator_Should_Check_Balance(t *testing.T) {
+	v := TSXBalancedBracesValidator()
+	good := "export const A = () => {\n  const s = \"}\"; // )\n  return <p>Don't {`${s}]`}</p>;\n};\n/* { */"
+	if err := v.Validate("tsx", good, Position{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := []struct {
+		content string
+		line    int
+		column  int
+	}{
+		{"f(a, [b);", 1, 8},
+		{"if (x) {\n  y()\n", 1, 8},
+		{"}", 1, 1},
+	}
+	for _, c := range cases {
+		err := v.Validate("tsx", c.content, Position{})
+		var cse *ContentSyntaxError
+		if !errors.As(err, &cse) || cse.Pos.Line != c.line || cse.Pos.Column != c.column {
+			t.Errorf("%q: expected error at %d:%d, got %v", c.content, c.line, c.column, err)
+		}
+	}
+	if err := v.ValidateAttrs("create-file", "{", map[string]string{"path": "a.py"}, Position{}); err != nil {
+		t.Fatalf("non-JS files must be skipped, got %v", err)
+	}
+}
+
+func Test_InLanguage_Should_Match_Lang_Or_Extension(t *testing.T) {
+	langs, exts := []string{"go", "golang"}, []string{".go"}
+	for _, attrs := range []map[string]string{nil, {"LANG": " Go "}, {"path": "cmd/Main.GO"}, {"file": "a.go"}} {
+		if !InLanguage(attrs, langs, exts) {
+			t.Errorf("%v should be Go", attrs)
+		}
+	}
+	for _, attrs := range []map[string]string{{"lang": "python"}, {"path": "README.md"}} {
+		if InLanguage(attrs, langs, exts) {
+			t.Errorf("%v should not be Go", attrs)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package promptweaver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_EventValidator_Should_See_The_Whole_Section(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}, TruncateAt: 8, TruncationMarker: "…"})
+	en := NewEngine(reg)
+	var seen []string
+	mentionsPath := func(ev SectionEvent) error {
+		seen = append(seen, fmt.Sprintf("%s %s %d:%d-%d truncated=%v", ev.Name, ev.AliasUsed, ev.StartPos.Offset, ev.EndPos.Offset, ev.OriginalSize, ev.Truncated))
+		if p := ev.Attrs["path"]; !strings.Contains(ev.Content, p) {
+			return NewValidationError(ev.EndPos, ev.Name, fmt.Sprintf("content does not mention %q", p), "")
+		}
+		return nil
+	}
+	en.RegisterValidator("create-file", &EventFuncValidator{ValidateFunc: mentionsPath})
+	var old int
+	en.RegisterValidator("write-file", &FuncValidator{ValidateFunc: func(string, string, Position) error { old++; return nil }})
+
+	err := en.ProcessStream(ReaderFromString(`<create-file path="a.go">// a.go</create-file><write-file path="b.go">// a.go, not b</write-file>`), NewHandlerSink())
+	var ve *ValidationError
+	if !errors.As(err, &ve) || !strings.Contains(ve.Error(), `mention "b.go"`) {
+		t.Fatalf("expected the second section to fail, got %v", err)
+	}
+	want := []string{"write-file create-file 0:46-0 truncated=false", "write-file write-file 46:97-14 truncated=true"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Fatalf("expected the validator to see %q, got %q", want, seen)
+	}
+	if old != 1 {
+		t.Fatalf("expected the plain validator to run once before the failure, got %d", old)
+	}
+}
+
+func Test_RegisterWhere_Should_Pass_Events_To_EventValidators(t *testing.T) {
+	vr := NewValidatorRegistry()
+	var got []string
+	vr.RegisterWhere("action", map[string]string{"type": "delete"}, &EventFuncValidator{ValidateFunc: func(ev SectionEvent) error {
+		got = append(got, ev.Attrs["path"])
+		return nil
+	}})
+	for _, attrs := range []map[string]string{{"type": "delete", "path": "a"}, {"type": "create", "path": "b"}} {
+		if err := vr.validate(SectionEvent{Name: "action", Attrs: attrs}, Position{}); err != nil {
+			t.Fatalf("validate error: %v", err)
+		}
+	}
+	if err := vr.ValidateSection("action", "x", Position{}); err != nil {
+		t.Fatalf("ValidateSection error: %v", err)
+	}
+	if fmt.Sprint(got) != "[a]" {
+		t.Fatalf("expected only the matching section, got %q", got)
+	}
+}
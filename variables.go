@@ -0,0 +1,102 @@
+package promptweaver
+
+import "strings"
+
+// UnknownVariablePolicy decides what happens to a {{name}} placeholder that has no value.
+type UnknownVariablePolicy int
+
+const (
+	// KeepUnknownVariables leaves the placeholder as written. This is the default.
+	KeepUnknownVariables UnknownVariablePolicy = iota
+
+	// EmptyUnknownVariables replaces the placeholder with "".
+	EmptyUnknownVariables
+
+	// ErrorUnknownVariables reports a ValidationError for the section, subject to the
+	// RecoveryMode and ErrorHandler like any other failed validation.
+	ErrorUnknownVariables
+)
+
+// expandVariables replaces {{name}} in s with vars[name]. Spaces around the name are ignored,
+// and "{{{{" stands for a literal "{{". Braces around anything but a name (letters, digits,
+// '_', '-', '.'), such as inline JSON, are kept as is.
+// It returns the first unknown name when the policy is ErrorUnknownVariables.
+func expandVariables(s string, vars map[string]string, policy UnknownVariablePolicy) (string, string, bool) {
+	if !strings.Contains(s, "{{") {
+		return s, "", true
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "{{")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), "", true
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		if strings.HasPrefix(s, "{{{{") {
+			b.WriteString("{{")
+			s = s[4:]
+			continue
+		}
+		end := strings.Index(s, "}}")
+		if end < 0 {
+			b.WriteString(s)
+			return b.String(), "", true
+		}
+		placeholder := s[:end+2]
+		name := strings.TrimSpace(placeholder[2:end])
+		if !isVariableName(name) {
+			b.WriteString("{{")
+			s = s[2:]
+			continue
+		}
+		s = s[end+2:]
+
+		if v, ok := vars[name]; ok {
+			b.WriteString(v)
+			continue
+		}
+		switch policy {
+		case EmptyUnknownVariables:
+		case ErrorUnknownVariables:
+			return "", name, false
+		default:
+			b.WriteString(placeholder)
+		}
+	}
+}
+
+func isVariableName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// expandSection substitutes the stream's variables into a section's content and attribute
+// values. Sections whose plugin sets NoVariables are left alone.
+func (p *parser) expandSection(plugin SectionPlugin, el *element, content string) (string, error) {
+	if p.variables == nil || plugin.NoVariables {
+		return content, nil
+	}
+	unknown := func(name string) error {
+		return NewValidationError(p.pos, el.canon, "unknown variable "+name, p.tz.lastContent)
+	}
+	for k, v := range el.attrs {
+		out, name, ok := expandVariables(v, p.variables, p.unknownVars)
+		if !ok {
+			return content, unknown(name)
+		}
+		el.attrs[k] = out
+	}
+	out, name, ok := expandVariables(content, p.variables, p.unknownVars)
+	if !ok {
+		return content, unknown(name)
+	}
+	return out, nil
+}
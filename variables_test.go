@@ -0,0 +1,132 @@
+package promptweaver
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_ExpandVariables_Should_Substitute_And_Escape(t *testing.T) {
+	vars := map[string]string{"root": "/srv/app", "user": "ana"}
+	cases := []struct {
+		in     string
+		policy UnknownVariablePolicy
+		want   string
+	}{
+		{"cd {{root}} && whoami # {{ user }}", KeepUnknownVariables, "cd /srv/app && whoami # ana"},
+		{"{{{{root}} is literal", KeepUnknownVariables, "{{root}} is literal"},
+		{"keep {{missing}} here", KeepUnknownVariables, "keep {{missing}} here"},
+		{"drop {{missing}} here", EmptyUnknownVariables, "drop  here"},
+		{"open {{root", ErrorUnknownVariables, "open {{root"},
+		{"no placeholders", ErrorUnknownVariables, "no placeholders"},
+		{`cfg={{"replicas":3}} in {{root}}`, ErrorUnknownVariables, `cfg={{"replicas":3}} in /srv/app`},
+	}
+	for _, c := range cases {
+		got, _, ok := expandVariables(c.in, vars, c.policy)
+		if !ok || got != c.want {
+			t.Errorf("expandVariables(%q) = %q, %v; want %q", c.in, got, ok, c.want)
+		}
+	}
+	if _, name, ok := expandVariables("a {{x}} b", vars, ErrorUnknownVariables); ok || name != "x" {
+		t.Fatalf("expected unknown variable x, got %q, %v", name, ok)
+	}
+}
+
+func Test_Engine_Should_Expand_Variables_Before_Validation(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "run"})
+	reg.Register(SectionPlugin{Name: "create-file", NoVariables: true})
+
+	en := NewEngineWithOptions(reg, WithVariables(map[string]string{"project_root": "/srv/app"}))
+	var validated string
+	en.RegisterFuncValidator("run", func(section, content string, pos Position) error {
+		validated = content
+		return nil
+	})
+
+	rec := &recorderSink{}
+	input := `<run dir="{{project_root}}/cmd">ls {{project_root}}</run>` +
+		`<create-file path="{{project_root}}/t.tmpl">Hello {{name}}</create-file>`
+	if err := en.ProcessStream(ReaderFromString(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	run := rec.events[0].(SectionEvent)
+	if run.Content != "ls /srv/app" || run.Attrs["dir"] != "/srv/app/cmd" || validated != "ls /srv/app" {
+		t.Fatalf("unexpected expansion %+v (validated %q)", run, validated)
+	}
+	file := rec.events[1].(SectionEvent)
+	if file.Content != "Hello {{name}}" || file.Attrs["path"] != "{{project_root}}/t.tmpl" {
+		t.Fatalf("NoVariables section must stay verbatim, got %+v", file)
+	}
+}
+
+func Test_Engine_Should_Report_Unknown_Variables_Per_Policy(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "run"})
+
+	en := NewEngineWithOptions(reg, WithVariables(nil), WithUnknownVariables(ErrorUnknownVariables))
+	err := en.ProcessStream(ReaderFromString("<run>{{nope}}</run>"), &recorderSink{})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.SectionName != "run" {
+		t.Fatalf("expected ValidationError for run, got %v", err)
+	}
+
+	rec := &recorderSink{}
+	en = NewEngineWithOptions(reg, WithVariables(nil), WithUnknownVariables(ErrorUnknownVariables), WithContinueMode())
+	if err := en.ProcessStream(ReaderFromString("<run>{{nope}}</run><run>ok</run>"), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(rec.events) != 1 || rec.events[0].(SectionEvent).Content != "ok" {
+		t.Fatalf("expected only the valid section, got %+v", rec.events)
+	}
+}
+
+func Test_ProcessStreamWith_Should_Expand_Per_Stream_Variables(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "run"})
+	engineVars := map[string]string{"root": "/srv/app", "user": "ana"}
+	en := NewEngineWithOptions(reg, WithVariables(engineVars))
+	input := "<run>cd {{root}} as {{user}}</run>"
+
+	for _, c := range []struct {
+		vars map[string]string
+		want string
+	}{
+		{map[string]string{"user": "bo"}, "cd /srv/app as bo"},
+		{map[string]string{"root": "/tmp"}, "cd /tmp as ana"},
+		{nil, "cd /srv/app as ana"},
+	} {
+		sink, got := newSinkCatcher("run")
+		if err := en.ProcessStreamWith(ReaderFromString(input), sink, StreamOptions{Variables: c.vars}); err != nil {
+			t.Fatalf("ProcessStreamWith error: %v", err)
+		}
+		if len(*got) != 1 || (*got)[0].Content != c.want {
+			t.Fatalf("vars %v: got %+v, want %q", c.vars, *got, c.want)
+		}
+	}
+	if len(engineVars) != 2 || engineVars["user"] != "ana" {
+		t.Fatalf("the engine's variables must be unchanged, got %v", engineVars)
+	}
+
+	// Without engine variables, the stream's turn expansion on.
+	sink, got := newSinkCatcher("run")
+	err := NewEngine(reg).ProcessStreamWith(ReaderFromString(input), sink, StreamOptions{Variables: map[string]string{"root": "/x"}})
+	if err != nil || len(*got) != 1 || (*got)[0].Content != "cd /x as {{user}}" {
+		t.Fatalf("got %+v, %v", *got, err)
+	}
+}
+
+func Test_WithVariables_Should_Copy_The_Map(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "run"})
+	vars := map[string]string{"user": "ana"}
+	en := NewEngineWithOptions(reg, WithVariables(vars))
+	vars["user"] = "bo"
+
+	sink, got := newSinkCatcher("run")
+	if err := en.ProcessStream(ReaderFromString("<run>{{user}}</run>"), sink); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	if len(*got) != 1 || (*got)[0].Content != "ana" {
+		t.Fatalf("expected the variables as passed, got %+v", *got)
+	}
+}
@@ -0,0 +1,171 @@
+package promptweaver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WellFormednessProblem names what a WellFormednessError found.
+type WellFormednessProblem string
+
+const (
+	WellFormedUnclosed      WellFormednessProblem = "unclosed"            // an element still open at its parent's end or EOF
+	WellFormedCrossing      WellFormednessProblem = "crossing"            // a closing tag for an element that is not the innermost open one
+	WellFormedDuplicateAttr WellFormednessProblem = "duplicate_attribute" // an attribute given twice in one tag
+)
+
+// WellFormednessError reports markup that is not well-formed XML, found with
+// EngineOptions.WellFormed. It goes through the engine's error handling like any parse error.
+type WellFormednessError struct {
+	ParseError
+	Problem  WellFormednessProblem
+	TagName  string
+	Attr     string    // the repeated attribute, for WellFormedDuplicateAttr
+	OpenedAt *Position // opening tag of the element left open or crossed
+}
+
+// ErrorDetails returns the error as structured data.
+func (e *WellFormednessError) ErrorDetails() ErrorInfo {
+	info := e.info("well_formedness")
+	info.Tag, info.Attribute, info.Start = e.TagName, e.Attr, e.OpenedAt
+	info.Problem = string(e.Problem)
+	return info
+}
+
+// openTag is an element on a well-formedness stack.
+type openTag struct {
+	name string // lowercased
+	pos  Position
+}
+
+// wellFormed is the stack of elements open outside sections. Section bodies, which are
+// raw to the parser, are checked on their own when the section closes.
+type wellFormed struct {
+	stack []openTag
+}
+
+// checkTag reports a repeated attribute in tok.
+func (p *parser) checkTag(tok Token) error {
+	if p.wellFormed == nil || tok.dupAttr == "" {
+		return nil
+	}
+	return p.recover(&WellFormednessError{
+		ParseError: ParseError{Pos: tok.Start, Message: fmt.Sprintf("duplicate attribute %q in <%s>", tok.dupAttr, tok.Name)},
+		Problem:    WellFormedDuplicateAttr,
+		TagName:    strings.ToLower(tok.Name),
+		Attr:       tok.dupAttr,
+	})
+}
+
+// openOutside pushes an unregistered element opened outside sections.
+func (p *parser) openOutside(tok Token) {
+	if p.wellFormed != nil {
+		p.wellFormed.stack = append(p.wellFormed.stack, openTag{name: strings.ToLower(tok.Name), pos: tok.Start})
+	}
+}
+
+// closeOutside matches a closing tag outside sections against the open elements. It
+// reports false when no open element has the name, leaving the tag unmatched.
+func (p *parser) closeOutside(tok Token) (bool, error) {
+	if p.wellFormed == nil {
+		return false, nil
+	}
+	stack, found, err := p.closeTag(p.wellFormed.stack, tok)
+	p.wellFormed.stack = stack
+	return found, err
+}
+
+// closeTag pops the element tok closes off stack, reporting false if there is none. An
+// element closed over open inner ones is a crossing error; once recovered from, the inner
+// ones are closed with it.
+func (p *parser) closeTag(stack []openTag, tok Token) ([]openTag, bool, error) {
+	name := strings.ToLower(tok.Name)
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].name != name {
+			continue
+		}
+		if i < len(stack)-1 {
+			inner := stack[len(stack)-1]
+			err := p.recover(&WellFormednessError{
+				ParseError: ParseError{Pos: tok.Start, Message: fmt.Sprintf("</%s> closes <%s> while <%s> is open", name, name, inner.name)},
+				Problem:    WellFormedCrossing,
+				TagName:    name,
+				OpenedAt:   &inner.pos,
+			})
+			if err != nil {
+				return stack, true, err
+			}
+		}
+		return stack[:i], true, nil
+	}
+	return stack, false, nil
+}
+
+// checkBody checks the markup inside the body of el, which has just been closed at end.
+func (p *parser) checkBody(el *element, end Position) error {
+	if p.wellFormed == nil || el.cutOff || el.suppress || el.truncated() || el.bodyStart.Line == 0 {
+		return nil
+	}
+	t := newTokenizer(true, false)
+	t.pos = el.bodyStart
+	t.feed([]byte(el.body.String()))
+	var stack []openTag
+	for {
+		tok, ok, err := t.next(true)
+		if err != nil {
+			continue // a stray '<' in a body is text
+		}
+		if !ok {
+			break
+		}
+		if tok.Incomplete {
+			continue
+		}
+		switch tok.Kind {
+		case TokenOpen, TokenSelfClose:
+			if err := p.checkTag(tok); err != nil {
+				return err
+			}
+			if tok.Kind == TokenOpen {
+				stack = append(stack, openTag{name: strings.ToLower(tok.Name), pos: tok.Start})
+			}
+		case TokenClose:
+			var found bool
+			if stack, found, err = p.closeTag(stack, tok); err != nil {
+				return err
+			}
+			if !found {
+				if err := p.recover(NewUnmatchedTagError(tok.Start, strings.ToLower(tok.Name), "")); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return p.reportUnclosed(stack, end)
+}
+
+// reportUnclosed reports the elements of stack, outermost first, as left open at pos.
+func (p *parser) reportUnclosed(stack []openTag, pos Position) error {
+	for _, open := range stack {
+		err := p.recover(&WellFormednessError{
+			ParseError: ParseError{Pos: pos, Message: fmt.Sprintf("<%s> is not closed", open.name)},
+			Problem:    WellFormedUnclosed,
+			TagName:    open.name,
+			OpenedAt:   &open.pos,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnclosed reports the elements still open outside sections at EOF.
+func (p *parser) checkUnclosed() error {
+	if p.wellFormed == nil {
+		return nil
+	}
+	stack := p.wellFormed.stack
+	p.wellFormed.stack = nil
+	return p.reportUnclosed(stack, p.pos)
+}
@@ -0,0 +1,83 @@
+package promptweaver
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func wellFormedProblems(t *testing.T, input string) ([]string, []Event) {
+	t.Helper()
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	var problems []string
+	en := NewEngineWithOptions(reg, WithWellFormed(true), WithErrorHandler(func(err error) bool {
+		var wf *WellFormednessError
+		var um *UnmatchedTagError
+		switch {
+		case errors.As(err, &wf):
+			problems = append(problems, string(wf.Problem)+" "+wf.TagName+wf.Attr+" "+wf.Pos.String())
+		case errors.As(err, &um):
+			problems = append(problems, "unmatched "+um.TagName)
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+		return true
+	}))
+	rec := &recorderSink{}
+	if err := en.ProcessStream(strings.NewReader(input), rec); err != nil {
+		t.Fatalf("ProcessStream error: %v", err)
+	}
+	return problems, rec.events
+}
+
+func Test_WellFormed_Should_Accept_Nested_Markup(t *testing.T) {
+	problems, events := wellFormedProblems(t, `<div class="x"><think>a <b>bold</b> and <br/> 1 < 2</think></div>`)
+	if len(problems) != 0 || len(events) != 1 {
+		t.Fatalf("problems %q, %d events", problems, len(events))
+	}
+	if got := events[0].(SectionEvent).Content; got != "a <b>bold</b> and <br/> 1 < 2" {
+		t.Fatalf("content changed: %q", got)
+	}
+}
+
+func Test_WellFormed_Should_Report_Problems_With_Positions(t *testing.T) {
+	for input, want := range map[string]string{
+		"<div><span></div></span>":                     "crossing div line 1, column 12 (offset 11)|unmatched span",
+		"<div>\n<think>x</think>":                      "unclosed div line 2, column 17 (offset 22)",
+		"<think><b><i>x</b></i></think>":               "crossing b line 1, column 15 (offset 14)|unmatched i",
+		"<think>\n<b>x</think>":                        "unclosed b line 2, column 5 (offset 12)",
+		`<think a="1" A="2">x</think>`:                 "duplicate_attribute thinka line 1, column 1 (offset 0)",
+		`<think><img src="a" src="b"/></think><p></p>`: "duplicate_attribute imgsrc line 1, column 8 (offset 7)",
+	} {
+		problems, events := wellFormedProblems(t, input)
+		if got := strings.Join(problems, "|"); got != want {
+			t.Errorf("%q: got %q, want %q", input, got, want)
+		}
+		if strings.Contains(input, "think>") && len(events) != 1 {
+			t.Errorf("%q: section not emitted", input)
+		}
+	}
+}
+
+func Test_WellFormed_Should_Describe_Errors_As_JSON(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	err := NewEngineWithOptions(reg, WithWellFormed(true)).ProcessStream(strings.NewReader("<think><b>x</think>"), &recorderSink{})
+	b, ok := ErrorToJSON(err)
+	if !ok || !strings.Contains(string(b), `"kind":"well_formedness"`) || !strings.Contains(string(b), `"start":{"line":1,"column":8,"offset":7}`) {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+	var wf *WellFormednessError
+	if !errors.As(err, &wf) {
+		t.Fatalf("expected a WellFormednessError, got %v", err)
+	}
+	if direct, _ := json.Marshal(wf); string(direct) != string(b) || !strings.Contains(string(b), `"problem":"unclosed"`) {
+		t.Fatalf("MarshalJSON should match ErrorToJSON and name the problem:\n%s\n%s", direct, b)
+	}
+
+	if err := NewEngine(reg).ProcessStream(strings.NewReader(`<think a="1" a="2"><b>x</think>`), &recorderSink{}); err != nil {
+		t.Fatalf("checks must be opt-in, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package promptweaver
+
+import "context"
+
+// whereHandler is a handler that runs only for sections whose attributes match.
+type whereHandler struct {
+	match map[string]string
+	fn    ContextHandler
+}
+
+// RegisterHandlerWhere registers a handler that runs only for sections whose attributes
+// include every pair in match: keys compare case-insensitively, values exactly. Where
+// handlers run before the section's generic handler, and several matching ones run in
+// registration order. Whether the generic handler still runs after a match is set by
+// SetWhereExclusive; by default it does.
+func (s *HandlerSink) RegisterHandlerWhere(section string, match map[string]string, fn func(SectionEvent)) {
+	if fn == nil {
+		return
+	}
+	s.RegisterHandlerWhereCtx(section, match, func(_ context.Context, ev SectionEvent) error {
+		fn(ev)
+		return nil
+	})
+}
+
+// RegisterHandlerWhereCtx is the context-aware form of RegisterHandlerWhere.
+func (s *HandlerSink) RegisterHandlerWhereCtx(section string, match map[string]string, fn ContextHandler) {
+	if section == "" || fn == nil {
+		return
+	}
+	if s.where == nil {
+		s.where = map[string][]whereHandler{}
+	}
+	key := s.resolve(section)
+	s.where[key] = append(s.where[key], whereHandler{match: copyAttrs(match), fn: fn})
+}
+
+// SetWhereExclusive makes a section that matched at least one Where handler skip its
+// generic handler (exclusive true), or reach it as well (false, the default).
+func (s *HandlerSink) SetWhereExclusive(exclusive bool) { s.whereExclusive = exclusive }
+
+// emitWhere runs the Where handlers of key that match ev and reports whether any did.
+func (s *HandlerSink) emitWhere(ctx context.Context, key string, ev SectionEvent) (bool, error) {
+	matched := false
+	for _, h := range s.where[key] {
+		if !attrsMatch(ev.Attrs, h.match) {
+			continue
+		}
+		matched = true
+		if err := h.fn(ctx, ev); err != nil {
+			return true, err
+		}
+	}
+	return matched, nil
+}
+
+// RegisterWhere adds a validator that only checks sections whose attributes include every
+// pair in match, compared as in HandlerSink.RegisterHandlerWhere. Other sections pass.
+func (r *ValidatorRegistry) RegisterWhere(sectionName string, match map[string]string, validator Validator) {
+	if validator == nil {
+		return
+	}
+	r.Register(sectionName, &whereValidator{match: copyAttrs(match), inner: validator})
+}
+
+// RegisterValidatorWhere registers a validator for the sections of sectionName whose
+// attributes match (see ValidatorRegistry.RegisterWhere).
+func (e *Engine) RegisterValidatorWhere(sectionName string, match map[string]string, validator Validator) {
+	e.validators.RegisterWhere(sectionName, match, validator)
+}
+
+// whereValidator runs inner only for sections whose attributes match.
+type whereValidator struct {
+	match map[string]string
+	inner Validator
+}
+
+// Validate implements Validator. Without attributes only an empty match applies.
+func (v *whereValidator) Validate(sectionName, content string, pos Position) error {
+	return v.ValidateAttrs(sectionName, content, nil, pos)
+}
+
+// ValidateEvent implements EventValidator, so the inner validator sees the whole event.
+func (v *whereValidator) ValidateEvent(ev SectionEvent) error {
+	if !attrsMatch(ev.Attrs, v.match) {
+		return nil
+	}
+	return runValidator(v.inner, ev, ev.EndPos)
+}
+
+// ValidateAttrs implements AttrValidator.
+func (v *whereValidator) ValidateAttrs(sectionName, content string, attrs map[string]string, pos Position) error {
+	if !attrsMatch(attrs, v.match) {
+		return nil
+	}
+	if av, ok := v.inner.(AttrValidator); ok {
+		return av.ValidateAttrs(sectionName, content, attrs, pos)
+	}
+	return v.inner.Validate(sectionName, content, pos)
+}
+
+// ValidateTruncated implements TruncationValidator, passing truncated sections on to inner
+// the way the registry would.
+func (v *whereValidator) ValidateTruncated(sectionName, content string, attrs map[string]string, originalSize int, pos Position) error {
+	if tv, ok := v.inner.(TruncationValidator); ok {
+		if !attrsMatch(attrs, v.match) {
+			return nil
+		}
+		return tv.ValidateTruncated(sectionName, content, attrs, originalSize, pos)
+	}
+	return v.ValidateAttrs(sectionName, content, attrs, pos)
+}
+
+// attrsMatch reports whether attrs has every pair of match, keys compared case-insensitively.
+func attrsMatch(attrs, match map[string]string) bool {
+	for k, want := range match {
+		if got, ok := lookupAttr(attrs, k); !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+func copyAttrs(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package promptweaver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_HandlerSink_Should_Route_By_Attributes(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "action"})
+	input := `<action type="create" path="a">1</action><action TYPE="delete">2</action>` +
+		`<action type="Delete">3</action><action>4</action>`
+
+	for _, exclusive := range []bool{false, true} {
+		var got []string
+		sink := NewHandlerSink()
+		sink.SetWhereExclusive(exclusive)
+		sink.RegisterHandler("action", func(ev SectionEvent) { got = append(got, "generic:"+ev.Content) })
+		sink.RegisterHandlerWhere("action", map[string]string{"type": "create"}, func(ev SectionEvent) {
+			got = append(got, "create:"+ev.Content)
+		})
+		sink.RegisterHandlerWhere("Action", map[string]string{"Type": "create", "path": "a"}, func(ev SectionEvent) {
+			got = append(got, "create-a:"+ev.Content)
+		})
+		sink.RegisterHandlerWhere("action", map[string]string{"type": "delete"}, func(ev SectionEvent) {
+			got = append(got, "delete:"+ev.Content)
+		})
+
+		if err := NewEngine(reg).ProcessStream(strings.NewReader(input), sink); err != nil {
+			t.Fatalf("ProcessStream error: %v", err)
+		}
+		want := "create:1 create-a:1 generic:1 delete:2 generic:2 generic:3 generic:4"
+		if exclusive {
+			want = "create:1 create-a:1 delete:2 generic:3 generic:4"
+		}
+		if s := strings.Join(got, " "); s != want {
+			t.Fatalf("exclusive=%v: got %q, want %q", exclusive, s, want)
+		}
+	}
+}
+
+func Test_Engine_Should_Validate_Only_Matching_Sections(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "action"})
+	en := NewEngine(reg)
+	en.RegisterValidatorWhere("action", map[string]string{"type": "delete"}, &FuncValidator{
+		ValidateFunc: func(_, content string, _ Position) error {
+			if content == "" {
+				return errors.New("delete needs a target")
+			}
+			return nil
+		},
+	})
+
+	if err := en.ProcessStream(strings.NewReader(`<action type="create"></action><action type="delete">x</action>`), NewHandlerSink()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := en.ProcessStream(strings.NewReader(`<action Type="delete"></action>`), NewHandlerSink())
+	if err == nil || !strings.Contains(err.Error(), "delete needs a target") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package promptweaver
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ProcessXML parses r with encoding/xml instead of the streaming tokenizer.
+// Use it when the input is well-formed XML and you want XML semantics: entities are decoded
+// and namespaces are understood. Registered elements produce the same SectionEvents as
+// ProcessStream (canonical Name, lowercased attribute keys, inner markup kept as content),
+// so sinks are interchangeable. Unlike ProcessStream, malformed input is fatal and
+// unclosed sections are not emitted at EOF, since the decoder cannot recover from either.
+func (e *Engine) ProcessXML(r io.Reader, sink EventSink) error {
+	if err := e.checkInputs(r, sink); err != nil {
+		return err
+	}
+
+	raw := &rawRecorder{r: r}
+	dec := xml.NewDecoder(raw)
+
+	p := newParser(e.reg, sink, e.options)
+	p.validators = e.validators
+
+	for {
+		line, col := dec.InputPos()
+		tokStart := Position{Line: line, Column: col, Offset: dec.InputOffset()}
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return xmlParseError(dec, err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			raw.discard(dec.InputOffset())
+			continue
+		}
+		canon, ok := e.reg.Canonical(start.Name.Local)
+		if !ok {
+			// Unknown elements are transparent, as in the streaming parser.
+			p.unknownTag(start.Name.Local, tokStart)
+			continue
+		}
+
+		content, err := readInnerText(dec, start, raw)
+		if err != nil {
+			return xmlParseError(dec, err)
+		}
+		line, col = dec.InputPos()
+		p.pos = Position{Line: line, Column: col, Offset: dec.InputOffset()}
+		plugin, _ := e.reg.Plugin(canon)
+		el := &element{name: start.Name.Local, canon: canon, start: tokStart}
+		el.attrs, el.defaulted = p.sectionAttrs(plugin, xmlAttrs(start))
+		el.body.WriteString(content)
+		if err := p.closeSection(el, false); err != nil {
+			return err
+		}
+		raw.discard(dec.InputOffset())
+	}
+}
+
+// readInnerText consumes tokens from dec until the EndElement matching start, returning the
+// element's inner content. Character data is entity-decoded; nested elements, comments and
+// other markup are copied verbatim from the raw input.
+func readInnerText(dec *xml.Decoder, start xml.StartElement, raw *rawRecorder) (string, error) {
+	var b strings.Builder
+	depth := 0
+	for {
+		from := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("unexpected EOF inside <%s>", start.Name.Local)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+			continue
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return b.String(), nil
+			}
+			depth--
+		}
+		b.Write(raw.slice(from, dec.InputOffset()))
+	}
+}
+
+// xmlAttrs converts decoded attributes to the engine's shape: lowercased local names.
+func xmlAttrs(start xml.StartElement) map[string]string {
+	attrs := map[string]string{}
+	for _, a := range start.Attr {
+		attrs[strings.ToLower(a.Name.Local)] = a.Value
+	}
+	return attrs
+}
+
+// xmlParseError wraps decoder failures in a ParseError carrying the decoder's position.
+func xmlParseError(dec *xml.Decoder, err error) error {
+	line, col := dec.InputPos()
+	msg := err.Error()
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		msg = syntaxErr.Msg
+		line = syntaxErr.Line
+	}
+	return &ParseError{
+		Pos:     Position{Line: line, Column: col, Offset: dec.InputOffset()},
+		Message: "invalid XML: " + msg,
+	}
+}
+
+// rawRecorder keeps the bytes the decoder has read so that token spans can be copied
+// verbatim. Bytes before the last discard point are released.
+type rawRecorder struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // stream offset of buf[0]
+}
+
+func (rr *rawRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+// slice returns the raw bytes in the stream range [from, to).
+func (rr *rawRecorder) slice(from, to int64) []byte {
+	return rr.buf[from-rr.base : to-rr.base]
+}
+
+// discard releases bytes before stream offset upto.
+func (rr *rawRecorder) discard(upto int64) {
+	n := upto - rr.base
+	if n <= 0 {
+		return
+	}
+	rr.buf = append(rr.buf[:0], rr.buf[n:]...)
+	rr.base = upto
+}
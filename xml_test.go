@@ -0,0 +1,89 @@
+package promptweaver
+
+import (
+	"reflect"
+	"testing"
+)
+
+// xmlCorpus holds inputs that are valid XML fragments and free of entities,
+// so the streaming parser and the XML decoder must agree on them.
+var xmlCorpus = []string{
+	`<think>plan</think><summary>done</summary>`,
+	`<think a="1" B='two'>hello</think>`,
+	"<create-file path=\"a.html\">\n<div class=\"x\"><b>bold</b> text</div>\n</create-file>",
+	`<response><think>inside a wrapper</think></response>`,
+	`<summary/><think></think>`,
+	`<?xml version="1.0"?><write-file path="b.txt">plain</write-file>`,
+}
+
+func newXMLCorpusRegistry() *Registry {
+	reg := NewRegistry()
+	reg.Register(SectionPlugin{Name: "think"})
+	reg.Register(SectionPlugin{Name: "summary"})
+	reg.Register(SectionPlugin{Name: "write-file", Aliases: []string{"create-file"}})
+	return reg
+}
+
+func Test_ProcessXML_Should_Match_ProcessStream_On_Corpus(t *testing.T) {
+	for _, input := range xmlCorpus {
+		reg := newXMLCorpusRegistry()
+		// The wrapper closer is a stray closing tag to the streaming parser.
+		en := NewEngineWithOptions(reg, WithContinueMode())
+
+		streamed := &recorderSink{}
+		if err := en.ProcessStream(ReaderFromString(input), streamed); err != nil {
+			t.Fatalf("ProcessStream(%q) error: %v", input, err)
+		}
+		decoded := &recorderSink{}
+		if err := en.ProcessXML(ReaderFromString(input), decoded); err != nil {
+			t.Fatalf("ProcessXML(%q) error: %v", input, err)
+		}
+		if !reflect.DeepEqual(streamed.events, decoded.events) {
+			t.Fatalf("parsers disagree on %q:\nstream: %#v\nxml:    %#v", input, streamed.events, decoded.events)
+		}
+	}
+}
+
+func Test_ProcessXML_Should_Decode_Entities(t *testing.T) {
+	reg := newXMLCorpusRegistry()
+	sink, got := newSinkCatcher("think")
+
+	en := NewEngine(reg)
+	input := `<think note="a &amp; b">x &lt; y <i>&amp;</i></think>`
+	if err := en.ProcessXML(ReaderFromString(input), sink); err != nil {
+		t.Fatalf("ProcessXML error: %v", err)
+	}
+	if len(*got) != 1 {
+		t.Fatalf("want 1 event, got %d", len(*got))
+	}
+	ev := (*got)[0]
+	if ev.Content != "x < y <i>&</i>" || ev.Attrs["note"] != "a & b" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+}
+
+func Test_ProcessXML_Should_Report_Malformed_Input(t *testing.T) {
+	reg := newXMLCorpusRegistry()
+	en := NewEngine(reg)
+
+	err := en.ProcessXML(ReaderFromString("<think>\n<b></think>"), NewHandlerSink())
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError, got %T: %v", err, err)
+	}
+	if parseErr.Pos.Line != 2 {
+		t.Fatalf("expected error on line 2, got %s", parseErr.Pos)
+	}
+}
+
+func Test_ProcessXML_Should_Run_Validators(t *testing.T) {
+	reg := newXMLCorpusRegistry()
+	en := NewEngine(reg)
+	if err := en.RegisterRegexValidator("summary", "done", "must say done"); err != nil {
+		t.Fatalf("failed to register validator: %v", err)
+	}
+	err := en.ProcessXML(ReaderFromString(`<summary>pending</summary>`), NewHandlerSink())
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("expected ValidationError, got %T: %v", err, err)
+	}
+}